@@ -1,65 +1,242 @@
 package main
 
 import (
-	"log"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"filevault/internal/config"
 	"filevault/internal/handlers"
+	"filevault/internal/jobs"
+	"filevault/internal/logging"
+	"filevault/internal/metrics"
+	"filevault/internal/s3"
 	"filevault/internal/services"
+	"filevault/internal/sftpd"
+	"filevault/internal/tracing"
 	"filevault/internal/utils"
+	"filevault/migrations"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 func main() {
+	// Load configuration
+	cfg, err := config.Load(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		os.Exit(1)
+	}
+
+	// Structured logger; also used by anything still writing through package log
+	logger := logging.New(cfg.Logging, os.Stdout)
+	slog.SetDefault(logger)
+
+	if cfg.Auth.JWTSecret == config.DefaultJWTSecret {
+		logger.Warn("Using the default JWT secret, set JWT_SECRET in production")
+	}
+
+	// Set up tracing before anything that issues queries
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		fatal(logger, "Failed to set up tracing", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("Failed to flush traces", "error", err)
+		}
+	}()
+
 	// Connect to database
-	db, err := utils.ConnectDB()
+	db, err := utils.ConnectDB(cfg.Database, logger)
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		fatal(logger, "Failed to connect to database", err)
 	}
 	defer db.Close()
 
 	// Run migrations
-	err = utils.RunMigrations(db)
+	all, err := migrations.Load()
 	if err != nil {
-		log.Fatal("Failed to run migrations:", err)
+		fatal(logger, "Failed to load migrations", err)
+	}
+	runner := migrations.NewRunner(db, all)
+	applied, err := runner.Up(context.Background())
+	if err != nil {
+		fatal(logger, "Failed to run migrations", err)
+	}
+	for _, m := range applied {
+		logger.Info("Applied migration", "version", m.Version, "name", m.Name)
 	}
 
-	// Get upload directory from environment
-	uploadDir := os.Getenv("UPLOAD_DIR")
-	if uploadDir == "" {
-		uploadDir = "./uploads"
+	// Demo accounts are only loaded when explicitly asked for
+	if cfg.Server.SeedDevData {
+		if err := migrations.Seed(context.Background(), db); err != nil {
+			fatal(logger, "Failed to seed development data", err)
+		}
+		logger.Info("Loaded development seed data")
 	}
 
 	// Ensure upload directory exists
-	err = utils.EnsureDir(uploadDir)
+	err = utils.EnsureDir(cfg.Storage.UploadDir)
 	if err != nil {
-		log.Fatal("Failed to create upload directory:", err)
+		fatal(logger, "Failed to create upload directory", err)
 	}
 
 	// Initialize services
-	userService := services.NewUserService(db)
-	fileService := services.NewFileService(db, uploadDir)
+	userService := services.NewUserService(db, cfg)
+	fileService := services.NewFileService(db, cfg.Storage.UploadDir)
+	fileService.SetCompression(cfg.Storage.Compression)
+	fileService.SetChunking(cfg.Storage.Dedup == "chunk")
 	folderService := services.NewFolderService(db)
+	savedSearchService := services.NewSavedSearchService(db, fileService)
+	tagService := services.NewTagService(db)
+	metadataService := services.NewMetadataService(db)
+	bulkService := services.NewBulkService(db, cfg.Storage.UploadDir)
 	adminService := services.NewAdminService(db)
+	accessKeyService := services.NewAccessKeyService(db)
+	multipartService := services.NewMultipartService(db)
+	sshKeyService := services.NewSSHKeyService(db)
+	gcService := services.NewGCService(db, cfg.Storage.UploadDir)
+	scrubService := services.NewScrubService(db, cfg.Storage.UploadDir)
+	thumbnailService := services.NewThumbnailService(db, cfg.Storage.UploadDir)
+	indexService := services.NewIndexService(db, cfg.Storage.UploadDir)
+
+	jwtManager := utils.NewJWTManager(cfg.Auth.JWTSecret, time.Duration(cfg.Auth.TokenTTL))
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(userService)
-	fileHandler := handlers.NewFileHandler(fileService)
+	authHandler := handlers.NewAuthHandler(userService, jwtManager)
+	fileHandler := handlers.NewFileHandler(fileService, cfg)
 	folderHandler := handlers.NewFolderHandler(folderService)
+	savedSearchHandler := handlers.NewSavedSearchHandler(savedSearchService)
+	tagHandler := handlers.NewTagHandler(tagService)
+	metadataHandler := handlers.NewMetadataHandler(metadataService)
+	bulkHandler := handlers.NewBulkHandler(bulkService)
 	adminHandler := handlers.NewAdminHandler(adminService, fileService, userService, folderService)
+	accessKeyHandler := handlers.NewAccessKeyHandler(accessKeyService)
+	sshKeyHandler := handlers.NewSSHKeyHandler(sshKeyService)
+	configHandler := handlers.NewConfigHandler(cfg)
+	gcHandler := handlers.NewGCHandler(gcService, cfg)
+	scrubHandler := handlers.NewScrubHandler(scrubService)
+	thumbnailHandler := handlers.NewThumbnailHandler(thumbnailService)
+	healthHandler := handlers.NewHealthHandler(db, runner, cfg.Storage.UploadDir)
+
+	handlers.WSManager = handlers.NewWebSocketManager(cfg.CORS.AllowedOrigins, logger)
+	go handlers.WSManager.Run()
+
+	// Background maintenance
+	scheduler := jobs.NewScheduler(logger)
+	scheduler.Add("s3_multipart_cleanup", time.Hour, func(ctx context.Context) error {
+		// Abandoned multipart uploads would otherwise keep their parts forever
+		aborted, err := multipartService.AbortStaleUploads(ctx, 24*time.Hour)
+		if aborted > 0 {
+			logger.Info("S3 multipart cleanup aborted stale uploads", "count", aborted)
+		}
+		return err
+	})
+	if cfg.GC.Enabled {
+		scheduler.Add("blob_gc", time.Duration(cfg.GC.Interval), func(ctx context.Context) error {
+			report, err := gcService.Run(ctx, time.Duration(cfg.GC.GracePeriod), false)
+			if err != nil {
+				return err
+			}
+			if len(report.Blobs) > 0 || len(report.Files) > 0 {
+				logger.Info("Garbage collection deleted orphaned blobs",
+					"blobs", len(report.Blobs), "files", len(report.Files), "freed_mb", report.FreedMB)
+			}
+			return nil
+		})
+	}
+	if cfg.Scrub.Enabled {
+		scrubOptions := services.ScrubOptions{
+			BatchSize:      cfg.Scrub.BatchSize,
+			ReverifyAfter:  time.Duration(cfg.Scrub.ReverifyAfter),
+			BytesPerSecond: int64(cfg.Scrub.RateMBPerSecond) << 20,
+		}
+		scheduler.Add("blob_scrub", time.Duration(cfg.Scrub.Interval), func(ctx context.Context) error {
+			result, err := scrubService.ScrubBatch(ctx, scrubOptions)
+			if result != nil && result.Corrupt > 0 {
+				logger.Error("Scrubber found corrupt blobs", "corrupt", result.Corrupt, "checked", result.Checked)
+			}
+			return err
+		})
+	}
+	if cfg.Thumbnail.Enabled {
+		scheduler.Add("thumbnails", time.Duration(cfg.Thumbnail.Interval), func(ctx context.Context) error {
+			result, err := thumbnailService.GenerateBatch(ctx, cfg.Thumbnail.BatchSize)
+			if result != nil && result.Failed > 0 {
+				logger.Warn("Thumbnail generator could not render some blobs", "failed", result.Failed, "generated", result.Generated)
+			}
+			return err
+		})
+	}
+	if cfg.Index.Enabled {
+		scheduler.Add("content_index", time.Duration(cfg.Index.Interval), func(ctx context.Context) error {
+			_, err := indexService.IndexBatch(ctx, cfg.Index.BatchSize)
+			return err
+		})
+	}
+	scheduler.Start()
 
-	// Setup Gin router
-	r := gin.Default()
+	// Setup Gin router; requests are logged by logging.Middleware instead of Gin's logger
+	r := gin.New()
+	r.Use(gin.Recovery())
 
-	// Set file size limits (100MB max for production)
-	r.MaxMultipartMemory = 100 << 20 // 100 MB
+	// Set file size limits
+	r.MaxMultipartMemory = cfg.Storage.MaxFileSize()
+
+	// Start a server span per request, continuing any incoming traceparent
+	if cfg.Tracing.Enabled {
+		r.Use(otelgin.Middleware(cfg.Tracing.ServiceName))
+	}
+
+	// Request IDs and access logs; after tracing so log lines carry the trace ID
+	r.Use(logging.Middleware(logger))
+
+	// Per-route deadlines; services stop querying once a request's context ends
+	r.Use(handlers.TimeoutMiddleware(cfg.Timeouts))
+
+	// Every listener is shut down together when a signal arrives
+	var servers []*http.Server
+
+	// Prometheus metrics, on the API port unless a separate port is set
+	if cfg.Metrics.Enabled {
+		metrics.RegisterDB(db)
+		metrics.RegisterGauge("websocket_clients", "Connected WebSocket clients.", func() float64 {
+			return float64(handlers.WSManager.ClientCount())
+		})
+		r.Use(metrics.Middleware())
+
+		if cfg.Metrics.Port == "" {
+			r.GET("/metrics", gin.WrapH(metrics.Handler()))
+		} else {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.Handler())
+			servers = append(servers, &http.Server{Addr: ":" + cfg.Metrics.Port, Handler: mux})
+		}
+	}
 
 	// Add CORS middleware
-	r.Use(handlers.CORSMiddleware())
+	r.Use(handlers.CORSMiddleware(cfg.CORS.AllowedOrigins))
+
+	// Probes: liveness only says the process is serving, readiness checks
+	// its dependencies and fails while draining on shutdown
+	r.GET("/livez", healthHandler.Livez)
+	r.GET("/readyz", healthHandler.Readyz)
 
-	// Health check endpoint
+	// Kept for existing monitors; it checks nothing, use /readyz instead
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"status":  "healthy",
@@ -78,20 +255,34 @@ func main() {
 
 	// Protected routes
 	api := r.Group("/api")
-	api.Use(handlers.AuthMiddleware())
-	api.Use(handlers.RateLimitMiddleware())
+	api.Use(handlers.AuthMiddleware(jwtManager))
+	api.Use(handlers.RateLimitMiddleware(cfg.RateLimit.RequestsPerSecond))
 
 	// Auth routes
 	api.GET("/auth/profile", authHandler.GetProfile)
 	api.GET("/auth/stats", authHandler.GetStats)
 	api.GET("/auth/validate", authHandler.ValidateSession)
+	api.GET("/auth/access-keys", accessKeyHandler.GetAccessKeys)
+	api.POST("/auth/access-keys", accessKeyHandler.CreateAccessKey)
+	api.DELETE("/auth/access-keys/:id", accessKeyHandler.DeleteAccessKey)
+	api.GET("/auth/ssh-keys", sshKeyHandler.GetSSHKeys)
+	api.POST("/auth/ssh-keys", sshKeyHandler.AddSSHKey)
+	api.DELETE("/auth/ssh-keys/:id", sshKeyHandler.DeleteSSHKey)
 
 	// File routes
 	api.POST("/files/upload", fileHandler.UploadFile)
 	api.GET("/files", fileHandler.GetFiles)
 	api.GET("/files/:id", fileHandler.GetFile)
 	api.DELETE("/files/:id", fileHandler.DeleteFile)
+	api.PATCH("/files/:id", fileHandler.UpdateFile)
+	api.POST("/files/:id/copy", fileHandler.CopyFile)
 	api.GET("/files/:id/download", fileHandler.DownloadFile)
+	api.GET("/files/:id/thumbnail", thumbnailHandler.GetThumbnail)
+	api.GET("/files/:id/tags", tagHandler.GetFileTags)
+	api.PATCH("/files/:id/tags", tagHandler.UpdateFileTags)
+	api.GET("/files/:id/metadata", metadataHandler.GetFileMetadata)
+	api.PUT("/files/:id/metadata", metadataHandler.ReplaceFileMetadata)
+	api.PATCH("/files/:id/metadata", metadataHandler.UpdateFileMetadata)
 	api.PUT("/files/:id/share", fileHandler.ShareFile)
 	api.GET("/files/storage/stats", fileHandler.GetStorageStats)
 	api.GET("/files/storage/deduplication", fileHandler.GetDeduplicationStats)
@@ -104,8 +295,29 @@ func main() {
 	api.DELETE("/folders/:id", folderHandler.DeleteFolder)
 	api.PUT("/folders/:id/share", folderHandler.ShareFolder)
 	api.GET("/folders/shared", folderHandler.GetSharedFolders)
+	api.GET("/folders/:id/metadata-schema", metadataHandler.GetFolderSchema)
 	api.GET("/folders/stats", folderHandler.GetFolderStats)
 
+	// Tag routes
+	api.GET("/tags", tagHandler.GetTags)
+	api.POST("/tags/bulk", tagHandler.BulkUpdateTags)
+	api.POST("/tags/rename", tagHandler.RenameTag)
+	api.POST("/tags/merge", tagHandler.MergeTags)
+	api.DELETE("/tags", tagHandler.DeleteTag)
+
+	// Bulk operation routes
+	api.POST("/bulk", bulkHandler.RunBulk)
+	api.GET("/bulk", bulkHandler.GetBulkList)
+	api.GET("/bulk/:id", bulkHandler.GetBulk)
+
+	// Saved search routes; smart folders list their search's files
+	api.GET("/searches", savedSearchHandler.GetSavedSearches)
+	api.POST("/searches", savedSearchHandler.CreateSavedSearch)
+	api.GET("/searches/:id", savedSearchHandler.GetSavedSearch)
+	api.PUT("/searches/:id", savedSearchHandler.UpdateSavedSearch)
+	api.DELETE("/searches/:id", savedSearchHandler.DeleteSavedSearch)
+	api.GET("/searches/:id/files", savedSearchHandler.RunSavedSearch)
+
 	// Admin routes
 	admin := api.Group("/admin")
 	admin.Use(handlers.AdminMiddleware())
@@ -122,13 +334,87 @@ func main() {
 	admin.PUT("/users/quota", authHandler.UpdateQuota)
 	admin.GET("/files/stats", fileHandler.GetFileStats)
 	admin.GET("/files/search", fileHandler.GlobalSearch)
+	admin.GET("/folders/:id/metadata-schema", metadataHandler.GetSchema)
+	admin.PUT("/folders/:id/metadata-schema", metadataHandler.SetSchema)
+	admin.DELETE("/folders/:id/metadata-schema", metadataHandler.DeleteSchema)
+	admin.GET("/bulk", bulkHandler.GetAllBulk)
+	admin.GET("/config", configHandler.GetConfig)
+	admin.POST("/gc", gcHandler.RunGC)
+	admin.GET("/blobs/integrity", scrubHandler.GetIntegrityReport)
 
-	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// Start the S3-compatible gateway on its own listener when configured
+	if cfg.S3.Port != "" {
+		gateway := s3.NewGateway(fileService, folderService, accessKeyService, multipartService, cfg, logger)
+		servers = append(servers, &http.Server{Addr: ":" + cfg.S3.Port, Handler: gateway.Router()})
 	}
 
-	log.Printf("Server starting on port %s", port)
-	log.Fatal(r.Run(":" + port))
+	// Start the SFTP server when configured
+	var sftpServer *sftpd.Server
+	if cfg.SFTP.Port != "" {
+		sftpServer, err = sftpd.NewServer(userService, sshKeyService, fileService, folderService, cfg, logger)
+		if err != nil {
+			fatal(logger, "Failed to start SFTP server", err)
+		}
+		go func() {
+			logger.Info("SFTP server starting", "port", cfg.SFTP.Port)
+			if err := sftpServer.ListenAndServe(":" + cfg.SFTP.Port); err != sftpd.ErrServerClosed {
+				fatal(logger, "SFTP server stopped", err)
+			}
+		}()
+	}
+
+	// Start the API server and any other HTTP listeners
+	servers = append(servers, &http.Server{Addr: ":" + cfg.Server.Port, Handler: r})
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			logger.Info("Server starting", "addr", srv.Addr)
+			if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+				fatal(logger, "Server stopped", err)
+			}
+		}(srv)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	// A second signal kills the process without waiting
+	stop()
+
+	timeout := time.Duration(cfg.Server.ShutdownTimeout)
+	logger.Info("Shutting down, draining in-flight requests", "timeout", timeout)
+	healthHandler.SetDraining()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				logger.Warn("Server did not drain in time", "addr", srv.Addr, "error", err)
+			}
+		}(srv)
+	}
+	if sftpServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := sftpServer.Shutdown(shutdownCtx); err != nil {
+				logger.Warn("SFTP sessions did not finish in time", "error", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	bulkHandler.Shutdown()
+	handlers.WSManager.Shutdown()
+	scheduler.Stop()
+	logger.Info("Shutdown complete")
+}
+
+// fatal logs err and exits; deferred cleanup does not run
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
 }
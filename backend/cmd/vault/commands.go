@@ -0,0 +1,558 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"filevault/pkg/client"
+
+	"golang.org/x/term"
+)
+
+// stringList collects a flag that may be repeated, like -tag a -tag b
+type stringList []string
+
+func (s *stringList) String() string     { return strings.Join(*s, ",") }
+func (s *stringList) Set(v string) error { *s = append(*s, v); return nil }
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: vault %s %s\n", name, commands[name].usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// remoteEntry is what a remote path resolved to: a folder or a file
+type remoteEntry struct {
+	isDir    bool
+	folderID *int // the folder itself, or the folder holding the file
+	file     *client.File
+}
+
+func resolveRemote(ctx context.Context, c *client.Client, tree *client.Tree, p string) (*remoteEntry, error) {
+	if folderID, ok := tree.Lookup(p); ok {
+		return &remoteEntry{isDir: true, folderID: folderID}, nil
+	}
+
+	parentID, ok := tree.Lookup(path.Dir(path.Clean("/" + p)))
+	if ok {
+		files, err := c.FolderFiles(ctx, parentID)
+		if err != nil {
+			return nil, err
+		}
+		name := path.Base(p)
+		for i := range files {
+			if files[i].DisplayName == name {
+				return &remoteEntry{folderID: parentID, file: &files[i]}, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%s: no such file or folder", p)
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func runLogin(ctx context.Context, args []string) error {
+	fs := newFlagSet("login")
+	server := fs.String("server", "", "server URL (default "+defaultServer+")")
+	fs.Parse(args)
+
+	creds, err := loadCredentials()
+	if err != nil {
+		return err
+	}
+	if *server != "" {
+		creds.Server = *server
+	}
+	creds.Server = serverURL(creds)
+
+	reader := bufio.NewReader(os.Stdin)
+	username := fs.Arg(0)
+	if username == "" {
+		fmt.Fprint(os.Stderr, "Username: ")
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		username = strings.TrimSpace(line)
+	}
+
+	var password string
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		data, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return err
+		}
+		password = string(data)
+	} else {
+		// Allows piping the password in from a secret store
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			return err
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	c := client.New(creds.Server)
+	user, err := c.Login(ctx, username, password)
+	if err != nil {
+		return err
+	}
+
+	creds.Username = user.Username
+	creds.Token = c.Token
+	if err := saveCredentials(creds); err != nil {
+		return err
+	}
+
+	fmt.Printf("Logged in to %s as %s\n", creds.Server, user.Username)
+	return nil
+}
+
+func runLogout(ctx context.Context, args []string) error {
+	newFlagSet("logout").Parse(args)
+
+	creds, err := loadCredentials()
+	if err != nil {
+		return err
+	}
+	creds.Token = ""
+	return saveCredentials(creds)
+}
+
+func runLs(ctx context.Context, args []string) error {
+	fs := newFlagSet("ls")
+	long := fs.Bool("l", false, "show size, modification time and ID")
+	fs.Parse(args)
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	tree, err := c.Tree(ctx)
+	if err != nil {
+		return err
+	}
+
+	target := "/"
+	if fs.NArg() > 0 {
+		target = fs.Arg(0)
+	}
+	entry, err := resolveRemote(ctx, c, tree, target)
+	if err != nil {
+		return err
+	}
+
+	var files []client.File
+	if entry.isDir {
+		if files, err = c.FolderFiles(ctx, entry.folderID); err != nil {
+			return err
+		}
+	} else {
+		files = []client.File{*entry.file}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	if entry.isDir {
+		for _, folder := range tree.Children(entry.folderID) {
+			if *long {
+				fmt.Fprintf(w, "%d\t-\t%s\t%s/\n", folder.ID, folder.UpdatedAt.Local().Format("2006-01-02 15:04"), folder.Name)
+			} else {
+				fmt.Fprintf(w, "%s/\n", folder.Name)
+			}
+		}
+	}
+	for _, file := range files {
+		if *long {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", file.ID, formatSize(file.FileSize), file.CreatedAt.Local().Format("2006-01-02 15:04"), file.DisplayName)
+		} else {
+			fmt.Fprintln(w, file.DisplayName)
+		}
+	}
+
+	return nil
+}
+
+func runGet(ctx context.Context, args []string) error {
+	fs := newFlagSet("get")
+	recursive := fs.Bool("r", false, "download folders recursively")
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	tree, err := c.Tree(ctx)
+	if err != nil {
+		return err
+	}
+
+	entry, err := resolveRemote(ctx, c, tree, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	local := fs.Arg(1)
+	if entry.isDir {
+		if !*recursive {
+			return fmt.Errorf("%s is a folder, use -r", fs.Arg(0))
+		}
+		if local == "" {
+			local = path.Base(path.Clean("/" + fs.Arg(0)))
+			if local == "/" {
+				local = "."
+			}
+		}
+		return downloadFolder(ctx, c, tree, entry.folderID, local)
+	}
+
+	if local == "" {
+		local = entry.file.DisplayName
+	} else if info, err := os.Stat(local); err == nil && info.IsDir() {
+		local = filepath.Join(local, entry.file.DisplayName)
+	}
+	return downloadFile(ctx, c, entry.file, local)
+}
+
+// downloadFile writes to a temporary file first so an interrupted download
+// never leaves a truncated file behind
+func downloadFile(ctx context.Context, c *client.Client, file *client.File, local string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(local), ".vault-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := c.Download(ctx, file.ID, tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), local); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s -> %s\n", file.DisplayName, local)
+	return nil
+}
+
+func downloadFolder(ctx context.Context, c *client.Client, tree *client.Tree, folderID *int, local string) error {
+	if err := os.MkdirAll(local, 0755); err != nil {
+		return err
+	}
+
+	files, err := c.FolderFiles(ctx, folderID)
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for i := range files {
+		// Only the newest file of each name is downloaded
+		if seen[files[i].DisplayName] {
+			continue
+		}
+		seen[files[i].DisplayName] = true
+		if err := downloadFile(ctx, c, &files[i], filepath.Join(local, files[i].DisplayName)); err != nil {
+			return err
+		}
+	}
+
+	for _, folder := range tree.Children(folderID) {
+		id := folder.ID
+		if err := downloadFolder(ctx, c, tree, &id, filepath.Join(local, folder.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runMkdir(ctx context.Context, args []string) error {
+	fs := newFlagSet("mkdir")
+	parents := fs.Bool("p", false, "create missing parent folders")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	tree, err := c.Tree(ctx)
+	if err != nil {
+		return err
+	}
+
+	target := fs.Arg(0)
+	if *parents {
+		_, err = c.MkdirAll(ctx, tree, target)
+		return err
+	}
+
+	parentID, ok := tree.Lookup(path.Dir(path.Clean("/" + target)))
+	if !ok {
+		return fmt.Errorf("%s: parent folder does not exist, use -p", target)
+	}
+	_, err = c.CreateFolder(ctx, path.Base(target), parentID)
+	return err
+}
+
+func runMv(ctx context.Context, args []string) error {
+	fs := newFlagSet("mv")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	tree, err := c.Tree(ctx)
+	if err != nil {
+		return err
+	}
+
+	src, dst := fs.Arg(0), fs.Arg(1)
+	entry, err := resolveRemote(ctx, c, tree, src)
+	if err != nil {
+		return err
+	}
+
+	// Moving onto an existing folder moves into it, like mv(1)
+	name := path.Base(path.Clean("/" + dst))
+	destID, ok := tree.Lookup(dst)
+	if ok {
+		name = path.Base(path.Clean("/" + src))
+	} else if destID, ok = tree.Lookup(path.Dir(path.Clean("/" + dst))); !ok {
+		return fmt.Errorf("%s: destination folder does not exist", dst)
+	}
+
+	if !entry.isDir {
+		return c.MoveFile(ctx, entry.file.ID, name, destID)
+	}
+
+	if entry.folderID == nil {
+		return errors.New("cannot move the root folder")
+	}
+	srcPath := tree.Path(entry.folderID)
+	if destPath := tree.Path(destID); destPath == srcPath || strings.HasPrefix(destPath, srcPath+"/") {
+		return errors.New("cannot move a folder into itself")
+	}
+
+	_, err = c.MoveFolder(ctx, tree.Folder(*entry.folderID), name, destID)
+	return err
+}
+
+func runCp(ctx context.Context, args []string) error {
+	fs := newFlagSet("cp")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	tree, err := c.Tree(ctx)
+	if err != nil {
+		return err
+	}
+
+	src, dst := fs.Arg(0), fs.Arg(1)
+	entry, err := resolveRemote(ctx, c, tree, src)
+	if err != nil {
+		return err
+	}
+	if entry.isDir {
+		return fmt.Errorf("%s: is a folder", src)
+	}
+
+	// Copying onto an existing folder copies into it, like cp(1)
+	name := path.Base(path.Clean("/" + dst))
+	destID, ok := tree.Lookup(dst)
+	if ok {
+		name = entry.file.DisplayName
+	} else if destID, ok = tree.Lookup(path.Dir(path.Clean("/" + dst))); !ok {
+		return fmt.Errorf("%s: destination folder does not exist", dst)
+	}
+
+	_, err = c.CopyFile(ctx, entry.file.ID, name, destID)
+	return err
+}
+
+func runRm(ctx context.Context, args []string) error {
+	fs := newFlagSet("rm")
+	recursive := fs.Bool("r", false, "remove folders and everything in them")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	tree, err := c.Tree(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range fs.Args() {
+		entry, err := resolveRemote(ctx, c, tree, target)
+		if err != nil {
+			return err
+		}
+
+		if !entry.isDir {
+			if err := c.DeleteFile(ctx, entry.file.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if entry.folderID == nil {
+			return errors.New("refusing to remove the root folder")
+		}
+		if !*recursive {
+			return fmt.Errorf("%s is a folder, use -r", target)
+		}
+		if err := removeFolder(ctx, c, tree, *entry.folderID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeFolder deletes a folder bottom-up since the API only removes empty ones
+func removeFolder(ctx context.Context, c *client.Client, tree *client.Tree, folderID int) error {
+	for _, child := range tree.Children(&folderID) {
+		if err := removeFolder(ctx, c, tree, child.ID); err != nil {
+			return err
+		}
+	}
+
+	files, err := c.FolderFiles(ctx, &folderID)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := c.DeleteFile(ctx, file.ID); err != nil {
+			return err
+		}
+	}
+
+	return c.DeleteFolder(ctx, folderID)
+}
+
+func runShare(ctx context.Context, args []string) error {
+	fs := newFlagSet("share")
+	public := fs.Bool("public", false, "make the file public")
+	private := fs.Bool("private", false, "make the file private")
+	var users stringList
+	fs.Var(&users, "user", "share with this user (repeatable)")
+	fs.Parse(args)
+	if fs.NArg() != 1 || (*public && *private) {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	tree, err := c.Tree(ctx)
+	if err != nil {
+		return err
+	}
+
+	entry, err := resolveRemote(ctx, c, tree, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if entry.isDir {
+		return errors.New("only files can be shared")
+	}
+
+	isPublic := entry.file.IsPublic
+	if *public {
+		isPublic = true
+	} else if *private {
+		isPublic = false
+	}
+
+	return c.ShareFile(ctx, entry.file.ID, isPublic, users)
+}
+
+func runSearch(ctx context.Context, args []string) error {
+	fs := newFlagSet("search")
+	mimeType := fs.String("type", "", "only files with this MIME type")
+	var tags stringList
+	fs.Var(&tags, "tag", "only files with this tag (repeatable)")
+	fs.Parse(args)
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	tree, err := c.Tree(ctx)
+	if err != nil {
+		return err
+	}
+
+	files, err := c.ListAllFiles(ctx, client.FileQuery{
+		Search:   strings.Join(fs.Args(), " "),
+		MimeType: *mimeType,
+		Tags:     tags,
+	})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	for _, file := range files {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", file.ID, formatSize(file.FileSize), file.MimeType,
+			path.Join(tree.Path(file.FolderID), file.DisplayName))
+	}
+
+	return nil
+}
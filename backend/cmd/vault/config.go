@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"filevault/pkg/client"
+)
+
+const defaultServer = "http://localhost:8081"
+
+// credentials are saved by login so later commands can reuse the token
+type credentials struct {
+	Server   string `json:"server"`
+	Username string `json:"username"`
+	Token    string `json:"token"`
+}
+
+func credentialsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "filevault", "credentials.json"), nil
+}
+
+func loadCredentials() (*credentials, error) {
+	path, err := credentialsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &credentials{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var creds credentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, err
+	}
+	return &creds, nil
+}
+
+func saveCredentials(creds *credentials) error {
+	path, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// serverURL picks the server from VAULT_SERVER, then the saved login
+func serverURL(creds *credentials) string {
+	if server := os.Getenv("VAULT_SERVER"); server != "" {
+		return server
+	}
+	if creds.Server != "" {
+		return creds.Server
+	}
+	return defaultServer
+}
+
+// newClient returns a client using the saved token; VAULT_TOKEN overrides it
+// for scripts
+func newClient() (*client.Client, error) {
+	creds, err := loadCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	c := client.New(serverURL(creds))
+	c.Token = creds.Token
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		c.Token = token
+	}
+	if c.Token == "" {
+		return nil, errors.New("not logged in, run 'vault login' first")
+	}
+	return c, nil
+}
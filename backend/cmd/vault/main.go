@@ -0,0 +1,83 @@
+// Command vault is a command-line client for File Vault.
+//
+//	vault login [-server URL] [username]
+//	vault ls [-l] [remote]
+//	vault put [-r] [-j N] [-public] [-tag T] <local>... <remote-dir>
+//	vault get [-r] <remote> [local]
+//	vault mkdir [-p] <remote>
+//	vault mv <remote> <remote>
+//	vault cp <remote> <remote>
+//	vault rm [-r] <remote>
+//	vault share [-public|-private] [-user U] <remote>
+//	vault search [-type MIME] [-tag T] <query>
+//	vault sync [-j N] [-delete] [-n] <local-dir> <remote-dir>
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+)
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands map[string]command
+
+// commands is filled in init because the command functions refer back to it
+// for their usage text
+func init() {
+	commands = map[string]command{
+		"login":  {"[-server URL] [username]", runLogin},
+		"logout": {"", runLogout},
+		"ls":     {"[-l] [remote]", runLs},
+		"put":    {"[-r] [-j N] [-public] [-tag T] <local>... <remote-dir>", runPut},
+		"get":    {"[-r] <remote> [local]", runGet},
+		"mkdir":  {"[-p] <remote>", runMkdir},
+		"mv":     {"<remote> <remote>", runMv},
+		"cp":     {"<remote> <remote>", runCp},
+		"rm":     {"[-r] <remote>", runRm},
+		"share":  {"[-public|-private] [-user U] <remote>", runShare},
+		"search": {"[-type MIME] [-tag T] <query>", runSearch},
+		"sync":   {"[-j N] [-delete] [-n] <local-dir> <remote-dir>", runSync},
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: vault <command> [flags] [args]")
+	fmt.Fprintln(os.Stderr)
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  vault %s %s\n", name, commands[name].usage)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "help" {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "vault: unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := cmd.run(ctx, os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "vault %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
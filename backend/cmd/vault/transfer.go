@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"filevault/internal/utils"
+	"filevault/pkg/client"
+)
+
+// uploadJob is one local file headed for a remote folder. existing holds any
+// remote files with the same name; they are replaced once the upload succeeds.
+type uploadJob struct {
+	local    string
+	remote   string
+	folderID *int
+	existing []client.File
+}
+
+// syncedFolder remembers what a local directory contained so sync -delete can
+// remove remote entries that no longer exist locally
+type syncedFolder struct {
+	folderID *int
+	files    map[string]bool
+	dirs     map[string]bool
+}
+
+type transfer struct {
+	c       *client.Client
+	tree    *client.Tree
+	opts    client.UploadOptions
+	dryRun  bool
+	jobs    []uploadJob
+	folders []syncedFolder
+	listing map[string][]client.File
+}
+
+func newTransfer(c *client.Client, tree *client.Tree) *transfer {
+	return &transfer{c: c, tree: tree, listing: map[string][]client.File{}}
+}
+
+func folderKey(folderID *int) string {
+	if folderID == nil {
+		return "root"
+	}
+	return strconv.Itoa(*folderID)
+}
+
+// folderFiles caches listings; planning runs before any uploads start so the
+// cache is never written concurrently
+func (t *transfer) folderFiles(ctx context.Context, folderID *int) ([]client.File, error) {
+	key := folderKey(folderID)
+	if files, ok := t.listing[key]; ok {
+		return files, nil
+	}
+	files, err := t.c.FolderFiles(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+	t.listing[key] = files
+	return files, nil
+}
+
+// mkdir returns the folder for remotePath, creating it unless this is a dry
+// run. In a dry run a missing folder is reported as nil with ok false.
+func (t *transfer) mkdir(ctx context.Context, remotePath string) (folderID *int, ok bool, err error) {
+	if t.dryRun {
+		folderID, ok = t.tree.Lookup(remotePath)
+		return folderID, ok, nil
+	}
+	folderID, err = t.c.MkdirAll(ctx, t.tree, remotePath)
+	return folderID, err == nil, err
+}
+
+func (t *transfer) addFile(ctx context.Context, local, remoteDir string, folderID *int, folderExists bool) error {
+	name := filepath.Base(local)
+	job := uploadJob{local: local, remote: path.Join(remoteDir, name), folderID: folderID}
+
+	if folderExists {
+		files, err := t.folderFiles(ctx, folderID)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if file.DisplayName == name {
+				job.existing = append(job.existing, file)
+			}
+		}
+	}
+
+	t.jobs = append(t.jobs, job)
+	return nil
+}
+
+// addDir plans the upload of everything inside localDir into remotePath
+func (t *transfer) addDir(ctx context.Context, localDir, remotePath string) error {
+	folderID, exists, err := t.mkdir(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(localDir)
+	if err != nil {
+		return err
+	}
+
+	synced := syncedFolder{folderID: folderID, files: map[string]bool{}, dirs: map[string]bool{}}
+	for _, entry := range entries {
+		local := filepath.Join(localDir, entry.Name())
+		switch {
+		case entry.IsDir():
+			synced.dirs[entry.Name()] = true
+			if err := t.addDir(ctx, local, path.Join(remotePath, entry.Name())); err != nil {
+				return err
+			}
+		case entry.Type().IsRegular():
+			synced.files[entry.Name()] = true
+			if err := t.addFile(ctx, local, remotePath, folderID, exists); err != nil {
+				return err
+			}
+		}
+	}
+
+	if exists {
+		t.folders = append(t.folders, synced)
+	}
+	return nil
+}
+
+// upload runs the planned jobs on a pool of workers and returns an error if
+// any of them failed
+func (t *transfer) upload(ctx context.Context, workers int) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan uploadJob)
+	var mu sync.Mutex
+	failed := 0
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if err := t.uploadOne(ctx, job); err != nil {
+					fmt.Fprintf(os.Stderr, "%s: %v\n", job.local, err)
+					mu.Lock()
+					failed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, job := range t.jobs {
+		select {
+		case jobs <- job:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d uploads failed", failed, len(t.jobs))
+	}
+	return nil
+}
+
+func (t *transfer) uploadOne(ctx context.Context, job uploadJob) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// The server deduplicates by SHA-256 too, but skipping here avoids
+	// sending the bytes at all
+	if len(job.existing) > 0 {
+		hash, err := utils.CalculateFileHash(job.local)
+		if err != nil {
+			return err
+		}
+		if job.existing[0].HashSHA256 == hash {
+			fmt.Printf("skip    %s (unchanged)\n", job.remote)
+			return nil
+		}
+	}
+
+	if t.dryRun {
+		fmt.Printf("upload  %s\n", job.remote)
+		return nil
+	}
+
+	opts := t.opts
+	opts.FolderID = job.folderID
+	file, err := t.c.UploadFile(ctx, job.local, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("upload  %s\n", job.remote)
+
+	for _, old := range job.existing {
+		if old.ID == file.ID {
+			continue
+		}
+		if err := t.c.DeleteFile(ctx, old.ID); err != nil {
+			return fmt.Errorf("uploaded, but failed to remove the previous version: %w", err)
+		}
+	}
+	return nil
+}
+
+// prune removes remote files and folders that are not in the local tree
+func (t *transfer) prune(ctx context.Context) error {
+	for _, synced := range t.folders {
+		files, err := t.folderFiles(ctx, synced.folderID)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if synced.files[file.DisplayName] {
+				continue
+			}
+			remote := path.Join(t.tree.Path(synced.folderID), file.DisplayName)
+			fmt.Printf("delete  %s\n", remote)
+			if !t.dryRun {
+				if err := t.c.DeleteFile(ctx, file.ID); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, folder := range t.tree.Children(synced.folderID) {
+			if synced.dirs[folder.Name] {
+				continue
+			}
+			id := folder.ID
+			fmt.Printf("delete  %s/\n", t.tree.Path(&id))
+			if !t.dryRun {
+				if err := removeFolder(ctx, t.c, t.tree, folder.ID); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func runPut(ctx context.Context, args []string) error {
+	fs := newFlagSet("put")
+	recursive := fs.Bool("r", false, "upload directories recursively")
+	workers := fs.Int("j", 4, "number of parallel uploads")
+	public := fs.Bool("public", false, "make uploaded files public")
+	var tags stringList
+	fs.Var(&tags, "tag", "tag uploaded files (repeatable)")
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	tree, err := c.Tree(ctx)
+	if err != nil {
+		return err
+	}
+
+	t := newTransfer(c, tree)
+	t.opts = client.UploadOptions{IsPublic: *public, Tags: tags}
+
+	locals := fs.Args()[:fs.NArg()-1]
+	remoteDir := path.Clean("/" + fs.Arg(fs.NArg()-1))
+	folderID, _, err := t.mkdir(ctx, remoteDir)
+	if err != nil {
+		return err
+	}
+
+	for _, local := range locals {
+		info, err := os.Stat(local)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if !*recursive {
+				return fmt.Errorf("%s is a directory, use -r", local)
+			}
+			if err := t.addDir(ctx, local, path.Join(remoteDir, filepath.Base(filepath.Clean(local)))); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := t.addFile(ctx, local, remoteDir, folderID, true); err != nil {
+			return err
+		}
+	}
+
+	return t.upload(ctx, *workers)
+}
+
+func runSync(ctx context.Context, args []string) error {
+	fs := newFlagSet("sync")
+	workers := fs.Int("j", 4, "number of parallel uploads")
+	deleteExtra := fs.Bool("delete", false, "delete remote files that do not exist locally")
+	dryRun := fs.Bool("n", false, "only print what would be done")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	localDir := fs.Arg(0)
+	if info, err := os.Stat(localDir); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", localDir)
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	tree, err := c.Tree(ctx)
+	if err != nil {
+		return err
+	}
+
+	t := newTransfer(c, tree)
+	t.dryRun = *dryRun
+	if err := t.addDir(ctx, localDir, path.Clean("/"+fs.Arg(1))); err != nil {
+		return err
+	}
+
+	if err := t.upload(ctx, *workers); err != nil {
+		return err
+	}
+	if *deleteExtra {
+		return t.prune(ctx)
+	}
+	return nil
+}
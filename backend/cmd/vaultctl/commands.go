@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"filevault/internal/models"
+	"filevault/internal/services"
+	"filevault/migrations"
+
+	"golang.org/x/term"
+)
+
+// readPassword prompts twice on a terminal, or reads one line from stdin so
+// passwords can be piped in from a secret store
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", errors.New("no password on stdin")
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, "New password: ")
+	first, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, "Repeat password: ")
+	second, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(first) != string(second) {
+		return "", errors.New("passwords do not match")
+	}
+	return string(first), nil
+}
+
+func lookupUser(e *env, username string) (*models.User, error) {
+	user, err := services.NewUserService(e.db, e.cfg).GetUserByUsername(context.Background(), username)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("user %q not found", username)
+	}
+	return user, err
+}
+
+type userResult struct {
+	Action string       `json:"action"`
+	DryRun bool         `json:"dry_run"`
+	User   *models.User `json:"user"`
+}
+
+func runCreateAdmin(e *env, args []string) error {
+	fs := newFlagSet("create-admin")
+	email := fs.String("email", "", "email address (default <username>@filevault.local)")
+	quota := fs.Int("quota", 1000, "storage quota in MB")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	username := fs.Arg(0)
+	if *email == "" {
+		*email = username + "@filevault.local"
+	}
+
+	userService := services.NewUserService(e.db, e.cfg)
+	if _, err := userService.GetUserByUsername(context.Background(), username); err == nil {
+		return fmt.Errorf("user %q already exists", username)
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+
+	if e.dryRun {
+		user := &models.User{Username: username, Email: *email, IsAdmin: true, StorageQuotaMB: *quota}
+		e.report(userResult{Action: "create-admin", DryRun: true, User: user},
+			"Would create admin %s <%s> with a %d MB quota", username, *email, *quota)
+		return nil
+	}
+
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+	if len(password) < 6 {
+		return errors.New("password must be at least 6 characters")
+	}
+
+	user, err := userService.CreateUserWithAdmin(context.Background(), models.UserCreateRequest{
+		Username: username,
+		Email:    *email,
+		Password: password,
+	}, *quota, true)
+	if err != nil {
+		return err
+	}
+
+	e.report(userResult{Action: "create-admin", User: user}, "Created admin %s (id %d)", user.Username, user.ID)
+	return nil
+}
+
+func runResetPassword(e *env, args []string) error {
+	fs := newFlagSet("reset-password")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	user, err := lookupUser(e, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if e.dryRun {
+		e.report(userResult{Action: "reset-password", DryRun: true, User: user},
+			"Would reset the password of %s (id %d)", user.Username, user.ID)
+		return nil
+	}
+
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+	if len(password) < 6 {
+		return errors.New("password must be at least 6 characters")
+	}
+
+	if err := services.NewUserService(e.db, e.cfg).UpdatePassword(context.Background(), user.ID, password); err != nil {
+		return err
+	}
+
+	e.report(userResult{Action: "reset-password", User: user}, "Reset the password of %s", user.Username)
+	return nil
+}
+
+func runSetQuota(e *env, args []string) error {
+	fs := newFlagSet("set-quota")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	quota, err := strconv.Atoi(fs.Arg(1))
+	if err != nil || quota < 0 {
+		return fmt.Errorf("invalid quota %q", fs.Arg(1))
+	}
+
+	user, err := lookupUser(e, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	result := struct {
+		Action     string `json:"action"`
+		DryRun     bool   `json:"dry_run"`
+		Username   string `json:"username"`
+		OldQuotaMB int    `json:"old_quota_mb"`
+		NewQuotaMB int    `json:"new_quota_mb"`
+	}{"set-quota", e.dryRun, user.Username, user.StorageQuotaMB, quota}
+
+	verb := "Changed"
+	if e.dryRun {
+		verb = "Would change"
+	} else if err := services.NewUserService(e.db, e.cfg).UpdateUserQuota(context.Background(), user.ID, quota); err != nil {
+		return err
+	}
+
+	e.report(result, "%s the quota of %s from %d MB to %d MB", verb, user.Username, user.StorageQuotaMB, quota)
+	return nil
+}
+
+type migrateResult struct {
+	Action     string              `json:"action"`
+	DryRun     bool                `json:"dry_run"`
+	Migrations []migrations.Status `json:"migrations"`
+	Seeded     bool                `json:"seeded,omitempty"`
+}
+
+func runMigrate(e *env, args []string) error {
+	fs := newFlagSet("migrate")
+	seed := fs.Bool("seed", false, "also load the development seed data (never use in production)")
+	fs.Parse(args)
+
+	direction := "up"
+	if fs.NArg() > 0 {
+		direction = fs.Arg(0)
+	}
+	steps := 1
+	switch {
+	case direction == "down" && fs.NArg() == 2:
+		n, err := strconv.Atoi(fs.Arg(1))
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid step count %q", fs.Arg(1))
+		}
+		steps = n
+	case (direction == "up" || direction == "down" || direction == "status") && fs.NArg() <= 1:
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	all, err := migrations.Load()
+	if err != nil {
+		return err
+	}
+	runner := migrations.NewRunner(e.db, all)
+	ctx := context.Background()
+
+	statuses, err := runner.Status(ctx)
+	if err != nil {
+		return err
+	}
+	result := migrateResult{Action: "migrate " + direction, DryRun: e.dryRun}
+
+	switch {
+	case direction == "status":
+		result.Migrations = statuses
+		if e.jsonOut {
+			e.report(result, "")
+			return nil
+		}
+		for _, s := range statuses {
+			state := "pending"
+			switch {
+			case s.Unknown:
+				state = "applied, unknown to this build"
+			case s.ChecksumMismatch:
+				state = "applied, modified since"
+			case s.Applied:
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-30s %s\n", s.Version, s.Name, state)
+		}
+		return nil
+
+	case e.dryRun:
+		// Report what would run from the recorded state without taking
+		// the lock or touching the schema
+		if direction == "up" {
+			for _, s := range statuses {
+				if !s.Applied {
+					result.Migrations = append(result.Migrations, s)
+				}
+			}
+		} else {
+			for i := len(statuses) - 1; i >= 0 && len(result.Migrations) < steps; i-- {
+				if statuses[i].Applied && !statuses[i].Unknown {
+					result.Migrations = append(result.Migrations, statuses[i])
+				}
+			}
+		}
+		result.Seeded = *seed
+		e.report(result, "Would %s %s", map[string]string{"up": "apply", "down": "revert"}[direction], describeMigrations(result.Migrations))
+		return nil
+	}
+
+	var done []migrations.Migration
+	if direction == "up" {
+		done, err = runner.Up(ctx)
+	} else {
+		done, err = runner.Down(ctx, steps)
+	}
+	for _, m := range done {
+		result.Migrations = append(result.Migrations, migrations.Status{Version: m.Version, Name: m.Name, Applied: direction == "up"})
+	}
+	if err != nil {
+		return err
+	}
+
+	if *seed {
+		if err := migrations.Seed(ctx, e.db); err != nil {
+			return fmt.Errorf("seeding: %w", err)
+		}
+		result.Seeded = true
+	}
+
+	verb := map[string]string{"up": "Applied", "down": "Reverted"}[direction]
+	e.report(result, "%s %s", verb, describeMigrations(result.Migrations))
+	return nil
+}
+
+func describeMigrations(statuses []migrations.Status) string {
+	if len(statuses) == 0 {
+		return "no migrations"
+	}
+	names := make([]string, len(statuses))
+	for i, s := range statuses {
+		names[i] = fmt.Sprintf("%04d_%s", s.Version, s.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func runCheckBlobs(e *env, args []string) error {
+	newFlagSet("check-blobs").Parse(args)
+
+	// Read-only, so -dry-run makes no difference here
+	report, err := services.NewFileService(e.db, e.cfg.Storage.UploadDir).CheckBlobs(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if e.jsonOut {
+		e.report(report, "")
+	} else {
+		fmt.Printf("Checked %d blobs (%.1f MB), %d with problems\n", report.Checked, report.CheckedMB, report.IssueCount)
+		for _, issue := range report.Issues {
+			fmt.Printf("  blob %d %s: %s (%d references)\n", issue.HashID, issue.HashSHA256, issue.Problem, issue.References)
+		}
+	}
+
+	// A non-zero exit lets cron jobs and monitoring notice problems
+	if report.IssueCount > 0 {
+		return errSilent
+	}
+	return nil
+}
+
+func runGC(e *env, args []string) error {
+	fs := newFlagSet("gc")
+	grace := fs.Duration("grace", time.Duration(e.cfg.GC.GracePeriod), "only delete orphans older than this")
+	fs.Parse(args)
+	if *grace < 0 {
+		return fmt.Errorf("invalid grace period %s", *grace)
+	}
+
+	report, err := services.NewGCService(e.db, e.cfg.Storage.UploadDir).Run(context.Background(), *grace, e.dryRun)
+	if err != nil {
+		return err
+	}
+
+	if e.jsonOut {
+		e.report(report, "")
+		return nil
+	}
+	verb := "Deleted"
+	if e.dryRun {
+		verb = "Would delete"
+	}
+	fmt.Printf("%s %d orphaned blobs and %d orphaned files on disk (%.1f MB)\n", verb, len(report.Blobs), len(report.Files), report.FreedMB)
+	for _, blob := range report.Blobs {
+		fmt.Printf("  blob %d %s (%d bytes, created %s)\n", blob.HashID, blob.HashSHA256, blob.Size, blob.CreatedAt.Format(time.RFC3339))
+	}
+	for _, file := range report.Files {
+		fmt.Printf("  file %s (%d bytes)\n", file.Path, file.Size)
+	}
+	return nil
+}
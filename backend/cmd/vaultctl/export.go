@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"filevault/internal/models"
+	"filevault/internal/services"
+)
+
+// exportManifest is written as manifest.json next to the exported files
+type exportManifest struct {
+	User    models.UserResponse `json:"user"`
+	Folders []models.Folder     `json:"folders"`
+	Files   []exportedFile      `json:"files"`
+}
+
+type exportedFile struct {
+	models.File
+	ExportPath string `json:"export_path"`
+}
+
+type exportResult struct {
+	Action     string `json:"action"`
+	DryRun     bool   `json:"dry_run"`
+	Username   string `json:"username"`
+	OutDir     string `json:"out_dir"`
+	Files      int    `json:"files"`
+	Folders    int    `json:"folders"`
+	TotalBytes int64  `json:"total_bytes"`
+}
+
+func runExportUser(e *env, args []string) error {
+	fs := newFlagSet("export-user")
+	outDir := fs.String("out", "", "directory to export into (default ./export-<username>)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	user, err := lookupUser(e, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *outDir == "" {
+		*outDir = "export-" + user.Username
+	}
+
+	ctx := context.Background()
+	fileService := services.NewFileService(e.db, e.cfg.Storage.UploadDir)
+	folderService := services.NewFolderService(e.db)
+
+	folders, err := folderService.GetUserFolders(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	list, err := fileService.GetFiles(ctx, user.ID, models.FileSearchRequest{})
+	if err != nil {
+		return err
+	}
+	files := list.Files
+
+	manifest := exportManifest{
+		User: models.UserResponse{
+			ID:             user.ID,
+			Username:       user.Username,
+			Email:          user.Email,
+			IsAdmin:        user.IsAdmin,
+			StorageQuotaMB: user.StorageQuotaMB,
+			CreatedAt:      user.CreatedAt,
+		},
+		Folders: folders,
+	}
+	result := exportResult{
+		Action:   "export-user",
+		DryRun:   e.dryRun,
+		Username: user.Username,
+		OutDir:   *outDir,
+		Folders:  len(folders),
+	}
+
+	paths := folderPaths(folders)
+	used := map[string]bool{}
+	for _, file := range files {
+		dir := "files"
+		if file.FolderID != nil {
+			dir = filepath.Join(dir, paths[*file.FolderID])
+		}
+
+		// Several files can share a name in one folder, so later ones get
+		// their ID appended
+		exportPath := filepath.Join(dir, file.DisplayName)
+		if used[exportPath] {
+			ext := filepath.Ext(file.DisplayName)
+			exportPath = filepath.Join(dir, file.DisplayName[:len(file.DisplayName)-len(ext)]+"-"+strconv.Itoa(file.ID)+ext)
+		}
+		used[exportPath] = true
+
+		manifest.Files = append(manifest.Files, exportedFile{File: file, ExportPath: exportPath})
+		result.Files++
+		result.TotalBytes += file.FileSize
+
+		if e.dryRun {
+			if !e.jsonOut {
+				fmt.Printf("would export %s (%d bytes)\n", exportPath, file.FileSize)
+			}
+			continue
+		}
+
+		data, err := fileService.GetFileContent(ctx, file.ID)
+		if err != nil {
+			return fmt.Errorf("reading file %d: %w", file.ID, err)
+		}
+		target := filepath.Join(*outDir, exportPath)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, data, 0644); err != nil {
+			return err
+		}
+	}
+
+	if !e.dryRun {
+		data, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(*outDir, 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(*outDir, "manifest.json"), data, 0644); err != nil {
+			return err
+		}
+	}
+
+	verb := "Exported"
+	if e.dryRun {
+		verb = "Would export"
+	}
+	e.report(result, "%s %d files (%d bytes) in %d folders for %s to %s",
+		verb, result.Files, result.TotalBytes, result.Folders, user.Username, *outDir)
+	return nil
+}
+
+// folderPaths maps folder IDs to their slash-separated path from the top level
+func folderPaths(folders []models.Folder) map[int]string {
+	byID := map[int]*models.Folder{}
+	for i := range folders {
+		byID[folders[i].ID] = &folders[i]
+	}
+
+	paths := map[int]string{}
+	var resolve func(id int, depth int) string
+	resolve = func(id int, depth int) string {
+		if p, ok := paths[id]; ok {
+			return p
+		}
+		folder, ok := byID[id]
+		if !ok || depth > len(folders) {
+			return ""
+		}
+		p := folder.Name
+		if folder.ParentID != nil {
+			p = filepath.Join(resolve(*folder.ParentID, depth+1), folder.Name)
+		}
+		paths[id] = p
+		return p
+	}
+
+	for id := range byID {
+		resolve(id, 0)
+	}
+	return paths
+}
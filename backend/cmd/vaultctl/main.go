@@ -0,0 +1,137 @@
+// Command vaultctl runs administrative tasks directly against the vault
+// database given by DATABASE_URL, for use when the API is unavailable or
+// before the first admin exists.
+//
+//	vaultctl [-json] [-dry-run] <command> [flags] [args]
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"filevault/internal/config"
+	"filevault/internal/logging"
+	"filevault/internal/utils"
+)
+
+type command struct {
+	usage string
+	run   func(env *env, args []string) error
+}
+
+var commands map[string]command
+
+// commands is filled in init because the command functions refer back to it
+// for their usage text
+func init() {
+	commands = map[string]command{
+		"create-admin":   {"[-email E] [-quota MB] <username>", runCreateAdmin},
+		"reset-password": {"<username>", runResetPassword},
+		"set-quota":      {"<username> <quota-mb>", runSetQuota},
+		"migrate":        {"[-seed] [up | down [N] | status]", runMigrate},
+		"check-blobs":    {"", runCheckBlobs},
+		"gc":             {"[-grace DURATION]", runGC},
+		"export-user":    {"[-out DIR] <username>", runExportUser},
+	}
+}
+
+// env carries the global flags and the database connection to each command
+type env struct {
+	cfg     *config.Config
+	db      *sql.DB
+	jsonOut bool
+	dryRun  bool
+}
+
+// errSilent signals a failure that has already been reported in the output
+var errSilent = errors.New("")
+
+// report prints a command result as JSON with -json, or as text otherwise
+func (e *env) report(result interface{}, format string, args ...interface{}) {
+	if e.jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(result)
+		return
+	}
+	fmt.Printf(format+"\n", args...)
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: vaultctl [-json] [-dry-run] %s %s\n", name, commands[name].usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: vaultctl [-json] [-dry-run] <command> [flags] [args]")
+	fmt.Fprintln(os.Stderr)
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  vaultctl %s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Settings are read from FILEVAULT_CONFIG and the same environment variables as the server.")
+}
+
+func main() {
+	e := &env{}
+	flag.BoolVar(&e.jsonOut, "json", false, "print results as JSON")
+	flag.BoolVar(&e.dryRun, "dry-run", false, "show what would change without changing anything")
+	verbose := flag.Bool("v", false, "log database connection progress")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "vaultctl: unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	// Only the file and environment apply here; vaultctl's own flags are
+	// not server settings
+	cfg, err := config.Load(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "vaultctl: invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+	e.cfg = cfg
+
+	var logOutput io.Writer = io.Discard
+	if *verbose {
+		logOutput = os.Stderr
+	}
+	db, err := utils.ConnectDB(cfg.Database, logging.New(cfg.Logging, logOutput))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "vaultctl: %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+	e.db = db
+
+	if err := cmd.run(e, flag.Args()[1:]); err != nil {
+		if err != errSilent {
+			fmt.Fprintf(os.Stderr, "vaultctl %s: %v\n", flag.Arg(0), err)
+		}
+		db.Close()
+		os.Exit(1)
+	}
+}
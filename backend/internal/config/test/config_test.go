@@ -0,0 +1,114 @@
+package test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/internal/config"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "filevault.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  port: "9090"
+storage:
+  upload_dir: /srv/vault
+  max_file_size_mb: 50
+auth:
+  token_ttl: 2h
+rate_limit:
+  requests_per_second: 5
+`)
+	t.Setenv("FILEVAULT_CONFIG", path)
+	t.Setenv("RATE_LIMIT_RPS", "8")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://a.example, https://b.example")
+
+	cfg, err := config.Load([]string{"-port", "7070"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "7070", cfg.Server.Port, "flags override the file")
+	assert.Equal(t, 8, cfg.RateLimit.RequestsPerSecond, "env overrides the file")
+	assert.Equal(t, int64(50), cfg.Storage.MaxFileSizeMB, "file overrides defaults")
+	assert.Equal(t, 2*time.Hour, time.Duration(cfg.Auth.TokenTTL))
+	assert.Equal(t, []string{"https://a.example", "https://b.example"}, cfg.CORS.AllowedOrigins)
+	assert.Equal(t, 10, cfg.Storage.MaxFilesPerUpload, "unset values keep their defaults")
+	assert.Equal(t, filepath.Join("/srv/vault", "ssh_host_ed25519_key"), cfg.SFTP.HostKeyPath)
+}
+
+func TestLoad_RejectsUnknownFileKeys(t *testing.T) {
+	t.Setenv("FILEVAULT_CONFIG", writeConfig(t, "storage:\n  max_file_size: 50\n"))
+
+	_, err := config.Load(nil)
+	assert.ErrorContains(t, err, "max_file_size")
+}
+
+func TestValidate(t *testing.T) {
+	cfg := config.Default()
+	require.NoError(t, cfg.Validate())
+
+	cfg.Auth.JWTSecret = "short"
+	cfg.Auth.BcryptCost = 40
+	cfg.Database.MaxIdleConns = 100
+	cfg.CORS.AllowedOrigins = []string{"localhost:3000"}
+	cfg.Timeouts.Routes["/api/files"] = config.Duration(time.Second)
+	cfg.Storage.Compression = "gzip"
+	cfg.Storage.Dedup = "block"
+	cfg.Thumbnail.BatchSize = 0
+	cfg.Index.Interval = 0
+
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "jwt_secret")
+	assert.Contains(t, err.Error(), "bcrypt_cost")
+	assert.Contains(t, err.Error(), "max_idle_conns")
+	assert.Contains(t, err.Error(), "localhost:3000")
+	assert.Contains(t, err.Error(), "/api/files")
+	assert.Contains(t, err.Error(), "storage.compression")
+	assert.Contains(t, err.Error(), "storage.dedup")
+	assert.Contains(t, err.Error(), "thumbnail.batch_size")
+	assert.Contains(t, err.Error(), "index.interval")
+}
+
+func TestLoad_RouteTimeouts(t *testing.T) {
+	t.Setenv("FILEVAULT_CONFIG", writeConfig(t, `
+timeouts:
+  routes:
+    GET /api/files/storage/stats: 5s
+    GET /api/admin/files/search: 0s
+`))
+	t.Setenv("REQUEST_TIMEOUT", "20s")
+
+	cfg, err := config.Load(nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, 5*time.Second, cfg.Timeouts.For("GET", "/api/files/storage/stats"))
+	assert.Equal(t, time.Duration(0), cfg.Timeouts.For("GET", "/api/admin/files/search"), "0 disables the deadline")
+	assert.Equal(t, time.Duration(0), cfg.Timeouts.For("POST", "/api/files/upload"), "file entries merge with the defaults")
+	assert.Equal(t, 20*time.Second, cfg.Timeouts.For("GET", "/api/files"), "env sets the default")
+	assert.Equal(t, time.Duration(0), cfg.Timeouts.For("GET", ""), "unmatched requests get no deadline")
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := config.Default()
+	cfg.Auth.JWTSecret = "a-very-secret-signing-key"
+
+	data, err := json.Marshal(cfg.Redacted())
+	require.NoError(t, err)
+
+	assert.NotContains(t, string(data), "a-very-secret-signing-key")
+	assert.NotContains(t, string(data), "filevault123")
+	assert.Contains(t, string(data), `"token_ttl":"24h0m0s"`)
+	assert.Equal(t, "a-very-secret-signing-key", cfg.Auth.JWTSecret, "the original is left untouched")
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"filevault/internal/models"
+	"filevault/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AccessKeyHandler struct {
+	accessKeyService *services.AccessKeyService
+}
+
+func NewAccessKeyHandler(accessKeyService *services.AccessKeyService) *AccessKeyHandler {
+	return &AccessKeyHandler{accessKeyService: accessKeyService}
+}
+
+// GetAccessKeys lists the user's S3 access keys without their secrets
+func (h *AccessKeyHandler) GetAccessKeys(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	keys, err := h.accessKeyService.GetAccessKeys(c.Request.Context(), userID.(int))
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"access_keys": keys,
+		"total":       len(keys),
+	})
+}
+
+// CreateAccessKey issues a new S3 key pair; the secret is only shown once
+func (h *AccessKeyHandler) CreateAccessKey(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	// The body is optional; an empty one just means no description
+	var req models.CreateAccessKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, err := h.accessKeyService.CreateAccessKey(c.Request.Context(), userID.(int), req.Description)
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "Access key created successfully",
+		"access_key": key,
+	})
+}
+
+func (h *AccessKeyHandler) DeleteAccessKey(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	err := h.accessKeyService.DeleteAccessKey(c.Request.Context(), userID.(int), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Access key deleted successfully"})
+}
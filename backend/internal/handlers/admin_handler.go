@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"filevault/internal/models"
 	"filevault/internal/services"
 
 	"github.com/gin-gonic/gin"
@@ -28,22 +29,29 @@ func NewAdminHandler(adminService *services.AdminService, fileService *services.
 // GetAllFiles returns all files in the system with uploader details
 func (h *AdminHandler) GetAllFiles(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
-	search := c.Query("search")
-	sortBy := c.Query("sort_by")
-	sortOrder := c.Query("sort_order")
+	req := models.FileSearchRequest{
+		Query:     c.Query("search"),
+		SortBy:    c.Query("sort_by"),
+		SortOrder: c.Query("sort_order"),
+		Cursor:    c.Query("cursor"),
+		Page:      page,
+		Limit:     listLimit(c, 20),
+	}
 
-	files, total, err := h.adminService.GetAllFilesForAdmin(page, limit, search, sortBy, sortOrder)
+	list, err := h.adminService.GetAllFilesForAdmin(c.Request.Context(), req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		listError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"files": files,
-		"total": total,
-		"page":  page,
-		"limit": limit,
+		"files":       list.Files,
+		"total":       list.Total,
+		"total_pages": totalPages(list.Total, req.Limit),
+		"has_more":    list.HasMore,
+		"next_cursor": list.NextCursor,
+		"page":        req.Page,
+		"limit":       req.Limit,
 	})
 }
 
@@ -55,7 +63,7 @@ func (h *AdminHandler) GetFileDetails(c *gin.Context) {
 		return
 	}
 
-	file, err := h.fileService.GetFileDetailsForAdmin(fileID)
+	file, err := h.fileService.GetFileDetailsForAdmin(c.Request.Context(), fileID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
@@ -66,9 +74,9 @@ func (h *AdminHandler) GetFileDetails(c *gin.Context) {
 
 // GetSystemStats returns comprehensive system statistics
 func (h *AdminHandler) GetSystemStats(c *gin.Context) {
-	stats, err := h.adminService.GetSystemStats()
+	stats, err := h.adminService.GetSystemStats(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		serverError(c, err)
 		return
 	}
 
@@ -77,9 +85,9 @@ func (h *AdminHandler) GetSystemStats(c *gin.Context) {
 
 // GetUserStats returns statistics for all users
 func (h *AdminHandler) GetUserStats(c *gin.Context) {
-	stats, err := h.adminService.GetAllUserStats()
+	stats, err := h.adminService.GetAllUserStats(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		serverError(c, err)
 		return
 	}
 
@@ -90,9 +98,9 @@ func (h *AdminHandler) GetUserStats(c *gin.Context) {
 func (h *AdminHandler) GetTopFiles(c *gin.Context) {
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
 
-	files, err := h.adminService.GetTopDownloadedFiles(limit)
+	files, err := h.adminService.GetTopDownloadedFiles(c.Request.Context(), limit)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		serverError(c, err)
 		return
 	}
 
@@ -103,9 +111,9 @@ func (h *AdminHandler) GetTopFiles(c *gin.Context) {
 func (h *AdminHandler) GetRecentActivity(c *gin.Context) {
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
 
-	activity, err := h.adminService.GetRecentActivity(limit)
+	activity, err := h.adminService.GetRecentActivity(c.Request.Context(), limit)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		serverError(c, err)
 		return
 	}
 
@@ -120,9 +128,9 @@ func (h *AdminHandler) DeleteFile(c *gin.Context) {
 		return
 	}
 
-	err = h.fileService.DeleteFileAsAdmin(fileID)
+	err = h.fileService.DeleteFileAsAdmin(c.Request.Context(), fileID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		serverError(c, err)
 		return
 	}
 
@@ -147,9 +155,9 @@ func (h *AdminHandler) ShareFileWithUser(c *gin.Context) {
 		return
 	}
 
-	err = h.fileService.ShareFileWithUser(fileID, req.Username, req.Permission)
+	err = h.fileService.ShareFileWithUser(c.Request.Context(), fileID, req.Username, req.Permission)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		serverError(c, err)
 		return
 	}
 
@@ -164,9 +172,9 @@ func (h *AdminHandler) GetFileShares(c *gin.Context) {
 		return
 	}
 
-	shares, err := h.fileService.GetFileShares(fileID)
+	shares, err := h.fileService.GetFileShares(c.Request.Context(), fileID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		serverError(c, err)
 		return
 	}
 
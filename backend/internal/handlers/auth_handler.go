@@ -12,10 +12,11 @@ import (
 
 type AuthHandler struct {
 	userService *services.UserService
+	jwt         *utils.JWTManager
 }
 
-func NewAuthHandler(userService *services.UserService) *AuthHandler {
-	return &AuthHandler{userService: userService}
+func NewAuthHandler(userService *services.UserService, jwt *utils.JWTManager) *AuthHandler {
+	return &AuthHandler{userService: userService, jwt: jwt}
 }
 
 func (h *AuthHandler) Register(c *gin.Context) {
@@ -25,14 +26,14 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	user, err := h.userService.CreateUser(req)
+	user, err := h.userService.CreateUser(c.Request.Context(), req)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Generate JWT token
-	token, err := utils.GenerateJWT(user.ID, user.Username, user.IsAdmin)
+	token, err := h.jwt.Generate(user.ID, user.Username, user.IsAdmin)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
@@ -59,14 +60,14 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	user, err := h.userService.AuthenticateUser(req.Username, req.Password)
+	user, err := h.userService.AuthenticateUser(c.Request.Context(), req.Username, req.Password)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
 	// Generate JWT token
-	token, err := utils.GenerateJWT(user.ID, user.Username, user.IsAdmin)
+	token, err := h.jwt.Generate(user.ID, user.Username, user.IsAdmin)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
@@ -93,7 +94,7 @@ func (h *AuthHandler) GetProfile(c *gin.Context) {
 		return
 	}
 
-	user, err := h.userService.GetUserByID(userID.(int))
+	user, err := h.userService.GetUserByID(c.Request.Context(), userID.(int))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
@@ -116,9 +117,9 @@ func (h *AuthHandler) GetStats(c *gin.Context) {
 		return
 	}
 
-	stats, err := h.userService.GetUserStats(userID.(int))
+	stats, err := h.userService.GetUserStats(c.Request.Context(), userID.(int))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		serverError(c, err)
 		return
 	}
 
@@ -143,9 +144,9 @@ func (h *AuthHandler) UpdateQuota(c *gin.Context) {
 		return
 	}
 
-	err := h.userService.UpdateUserQuota(req.UserID, req.QuotaMB)
+	err := h.userService.UpdateUserQuota(c.Request.Context(), req.UserID, req.QuotaMB)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		serverError(c, err)
 		return
 	}
 
@@ -160,9 +161,9 @@ func (h *AuthHandler) GetAllUsers(c *gin.Context) {
 		return
 	}
 
-	users, err := h.userService.GetAllUsers()
+	users, err := h.userService.GetAllUsers(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		serverError(c, err)
 		return
 	}
 
@@ -189,7 +190,7 @@ func (h *AuthHandler) ValidateSession(c *gin.Context) {
 		return
 	}
 
-	user, err := h.userService.GetUserByID(userID.(int))
+	user, err := h.userService.GetUserByID(c.Request.Context(), userID.(int))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
@@ -217,9 +218,9 @@ func (h *AuthHandler) CreateAdminUser(c *gin.Context) {
 	}
 
 	// Create admin user with extended properties
-	user, err := h.userService.CreateUserWithAdmin(req, 1000, true)
+	user, err := h.userService.CreateUserWithAdmin(c.Request.Context(), req, 1000, true)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		serverError(c, err)
 		return
 	}
 
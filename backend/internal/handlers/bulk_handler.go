@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"filevault/internal/logging"
+	"filevault/internal/models"
+	"filevault/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type BulkHandler struct {
+	bulkService *services.BulkService
+	ctx         context.Context // Cancelled by Shutdown to stop background batches
+	stop        context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+func NewBulkHandler(bulkService *services.BulkService) *BulkHandler {
+	ctx, stop := context.WithCancel(context.Background())
+	return &BulkHandler{bulkService: bulkService, ctx: ctx, stop: stop}
+}
+
+// Shutdown stops the batches running in the background and waits for them
+// to record how far they got
+func (h *BulkHandler) Shutdown() {
+	h.stop()
+	h.wg.Wait()
+}
+
+// bulkError answers a failed bulk operation request
+func bulkError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidBulkOperation), errors.Is(err, services.ErrInvalidTag):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrBulkOperationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		serverError(c, err)
+	}
+}
+
+// broadcastBulk tells clients how a user's batch is going
+func broadcastBulk(messageType string, job *models.BulkJob) {
+	if WSManager == nil {
+		return
+	}
+	WSManager.Broadcast(WebSocketMessage{Type: messageType, Data: gin.H{
+		"user_id":   job.UserID,
+		"job_id":    job.ID,
+		"status":    job.Status,
+		"total":     job.Total,
+		"done":      job.Done,
+		"succeeded": job.Succeeded,
+		"failed":    job.Failed,
+	}})
+}
+
+// run runs a started batch, logs it as one operation for the audit trail
+// and tells clients it has finished
+func (h *BulkHandler) run(ctx context.Context, job *models.BulkJob, progress func(job *models.BulkJob)) error {
+	err := h.bulkService.Run(ctx, job, progress)
+
+	logger := logging.FromContext(ctx)
+	attrs := []interface{}{"bulk_id", job.ID, "user_id", job.UserID, "operations", len(job.Operations), "atomic", job.Atomic,
+		"status", job.Status, "succeeded", job.Succeeded, "failed", job.Failed}
+	if err != nil {
+		logger.Error("Bulk operation stopped", append(attrs, "error", err)...)
+	} else {
+		logger.Info("Bulk operation run", attrs...)
+	}
+
+	broadcastBulk("bulk_completed", job)
+	return err
+}
+
+// RunBulk applies a batch of operations to the user's files and folders.
+// A small batch answers with the result of every item. A large one, or one
+// asking to be async, answers 202 at once and runs in the background,
+// reporting progress over WebSocket; GET /bulk/:id then shows how it went.
+func (h *BulkHandler) RunBulk(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req models.BulkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	job, err := h.bulkService.Start(c.Request.Context(), userID.(int), req)
+	if err != nil {
+		bulkError(c, err)
+		return
+	}
+
+	if req.Async || job.Total > services.BulkSyncItems {
+		// The batch outlives the request, keeping its logger, until Shutdown.
+		// Answer with the job as it started.
+		ctx, cancel := context.WithCancel(context.WithoutCancel(c.Request.Context()))
+		stopOnShutdown := context.AfterFunc(h.ctx, cancel)
+		started := *job
+		h.wg.Add(1)
+		go func() {
+			defer h.wg.Done()
+			defer stopOnShutdown()
+			defer cancel()
+			h.run(ctx, job, func(job *models.BulkJob) {
+				broadcastBulk("bulk_progress", job)
+			})
+		}()
+		c.JSON(http.StatusAccepted, started)
+		return
+	}
+
+	if err := h.run(c.Request.Context(), job, nil); err != nil {
+		serverError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// GetBulk returns one of the user's batches with the result of each item
+func (h *BulkHandler) GetBulk(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	jobID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bulk operation ID"})
+		return
+	}
+
+	job, err := h.bulkService.GetJob(c.Request.Context(), jobID, userID.(int))
+	if err != nil {
+		bulkError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// GetBulkList lists the user's recent batches
+func (h *BulkHandler) GetBulkList(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	jobs, err := h.bulkService.ListJobs(c.Request.Context(), userID.(int), listLimit(c, 20))
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"operations": jobs, "total": len(jobs)})
+}
+
+// GetAllBulk lists recent batches of every user, or of ?user_id=, for
+// admins auditing them
+func (h *BulkHandler) GetAllBulk(c *gin.Context) {
+	userID, err := strconv.Atoi(c.DefaultQuery("user_id", "0"))
+	if err != nil || userID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	jobs, err := h.bulkService.ListJobs(c.Request.Context(), userID, listLimit(c, 50))
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"operations": jobs, "total": len(jobs)})
+}
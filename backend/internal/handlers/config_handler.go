@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"net/http"
+
+	"filevault/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ConfigHandler struct {
+	cfg *config.Config
+}
+
+func NewConfigHandler(cfg *config.Config) *ConfigHandler {
+	return &ConfigHandler{cfg: cfg}
+}
+
+// GetConfig shows the effective settings with secrets removed
+func (h *ConfigHandler) GetConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, h.cfg.Redacted())
+}
@@ -1,24 +1,29 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"filevault/internal/config"
+	"filevault/internal/logging"
 	"filevault/internal/models"
 	"filevault/internal/services"
+	"filevault/internal/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
 type FileHandler struct {
 	fileService *services.FileService
+	cfg         *config.Config
 }
 
-func NewFileHandler(fileService *services.FileService) *FileHandler {
-	return &FileHandler{fileService: fileService}
+func NewFileHandler(fileService *services.FileService, cfg *config.Config) *FileHandler {
+	return &FileHandler{fileService: fileService, cfg: cfg}
 }
 
 func (h *FileHandler) UploadFile(c *gin.Context) {
@@ -31,7 +36,7 @@ func (h *FileHandler) UploadFile(c *gin.Context) {
 	// Parse form data
 	form, err := c.MultipartForm()
 	if err != nil {
-		log.Printf("Multipart form error: %v", err)
+		logging.FromContext(c.Request.Context()).Warn("Invalid multipart form", "error", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid form data: " + err.Error()})
 		return
 	}
@@ -43,8 +48,8 @@ func (h *FileHandler) UploadFile(c *gin.Context) {
 	}
 
 	// Validate file count
-	if len(files) > 10 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Maximum 10 files allowed per upload"})
+	if len(files) > h.cfg.Storage.MaxFilesPerUpload {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Maximum %d files allowed per upload", h.cfg.Storage.MaxFilesPerUpload)})
 		return
 	}
 
@@ -68,15 +73,16 @@ func (h *FileHandler) UploadFile(c *gin.Context) {
 		}
 	}
 
-	log.Printf("Uploading %d files for user %d", len(files), userID)
+	logger := logging.FromContext(c.Request.Context())
+	logger.Debug("Uploading files", "count", len(files))
 
 	var uploadedFiles []models.File
 	var errors []string
 
 	for _, fileHeader := range files {
-		// Validate file size (100MB max)
-		if fileHeader.Size > 100*1024*1024 {
-			errors = append(errors, "File '"+fileHeader.Filename+"' exceeds 100MB limit")
+		// Validate file size
+		if fileHeader.Size > h.cfg.Storage.MaxFileSize() {
+			errors = append(errors, fmt.Sprintf("File '%s' exceeds %dMB limit", fileHeader.Filename, h.cfg.Storage.MaxFileSizeMB))
 			continue
 		}
 
@@ -86,9 +92,14 @@ func (h *FileHandler) UploadFile(c *gin.Context) {
 			continue
 		}
 
-		file, err := h.fileService.UploadFile(userID.(int), fileHeader, uploadReq)
+		file, err := h.fileService.UploadFile(c.Request.Context(), userID.(int), fileHeader, uploadReq)
+		if folderError(err) {
+			// Every file of the upload goes to the same folder
+			fileChangeError(c, err)
+			return
+		}
 		if err != nil {
-			log.Printf("Upload error for file %s: %v", fileHeader.Filename, err)
+			logger.Error("Upload failed", "filename", fileHeader.Filename, "error", err)
 			errors = append(errors, "Failed to upload '"+fileHeader.Filename+"': "+err.Error())
 			continue
 		}
@@ -140,17 +151,15 @@ func (h *FileHandler) GetFiles(c *gin.Context) {
 	if searchReq.Query == "" {
 		searchReq.Query = c.Query("query") // Fallback for backward compatibility
 	}
-	fmt.Printf("DEBUG: search query = '%s'\n", searchReq.Query)
 	searchReq.MimeType = c.Query("mime_type")
 	searchReq.Uploader = c.Query("uploader")
+	searchReq.Username = c.Query("username")
 	searchReq.StartDate = c.Query("start_date")
 	searchReq.EndDate = c.Query("end_date")
+	searchReq.DateRange = c.Query("date_range")
 	searchReq.SortBy = c.Query("sort_by")
 	searchReq.SortOrder = c.Query("sort_order")
 
-	// Debug all query parameters
-	fmt.Printf("DEBUG: All query parameters: %+v\n", c.Request.URL.Query())
-
 	if minSizeStr := c.Query("min_size"); minSizeStr != "" {
 		if minSize, err := strconv.ParseInt(minSizeStr, 10, 64); err == nil {
 			searchReq.MinSize = &minSize
@@ -169,15 +178,10 @@ func (h *FileHandler) GetFiles(c *gin.Context) {
 		}
 	}
 
-	if limitStr := c.Query("limit"); limitStr != "" {
-		if limit, err := strconv.Atoi(limitStr); err == nil {
-			searchReq.Limit = limit
-		} else {
-			searchReq.Limit = 20 // Default limit
-		}
-	} else {
-		searchReq.Limit = 20
-	}
+	searchReq.Limit = listLimit(c, 20)
+	searchReq.Cursor = c.Query("cursor")
+	searchReq.Facets = c.QueryArray("facets")
+	searchReq.Metadata = c.QueryArray("metadata")
 
 	if tags := c.QueryArray("tags"); len(tags) > 0 {
 		searchReq.Tags = tags
@@ -186,26 +190,28 @@ func (h *FileHandler) GetFiles(c *gin.Context) {
 	if folderIDStr := c.Query("folder_id"); folderIDStr != "" {
 		if folderID, err := strconv.Atoi(folderIDStr); err == nil {
 			searchReq.FolderID = &folderID
-			fmt.Printf("DEBUG: folder_id parameter received: %s, parsed: %d\n", folderIDStr, folderID)
-		} else {
-			fmt.Printf("DEBUG: failed to parse folder_id: %s, error: %v\n", folderIDStr, err)
 		}
-	} else {
-		fmt.Printf("DEBUG: no folder_id parameter received\n")
 	}
 
-	files, err := h.fileService.GetFiles(userID.(int), searchReq)
+	list, err := h.fileService.GetFiles(c.Request.Context(), userID.(int), searchReq)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		listError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"files": files,
-		"total": len(files),
-		"page":  searchReq.Page,
-		"limit": searchReq.Limit,
-	})
+	response := gin.H{
+		"files":       list.Files,
+		"total":       list.Total,
+		"total_pages": totalPages(list.Total, searchReq.Limit),
+		"has_more":    list.HasMore,
+		"next_cursor": list.NextCursor,
+		"page":        searchReq.Page,
+		"limit":       searchReq.Limit,
+	}
+	if list.Facets != nil {
+		response["facets"] = list.Facets
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 func (h *FileHandler) GetFile(c *gin.Context) {
@@ -216,7 +222,7 @@ func (h *FileHandler) GetFile(c *gin.Context) {
 		return
 	}
 
-	file, err := h.fileService.GetFileByID(fileID)
+	file, err := h.fileService.GetFileByID(c.Request.Context(), fileID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
@@ -239,7 +245,7 @@ func (h *FileHandler) DeleteFile(c *gin.Context) {
 		return
 	}
 
-	err = h.fileService.DeleteFile(fileID, userID.(int))
+	err = h.fileService.DeleteFile(c.Request.Context(), fileID, userID.(int))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -283,7 +289,7 @@ func (h *FileHandler) ShareFile(c *gin.Context) {
 		return
 	}
 
-	err = h.fileService.ShareFile(fileID, userID.(int), req.IsPublic, req.SharedUsers)
+	err = h.fileService.ShareFile(c.Request.Context(), fileID, userID.(int), req.IsPublic, req.SharedUsers)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -292,6 +298,109 @@ func (h *FileHandler) ShareFile(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "File sharing updated successfully"})
 }
 
+// folderError reports whether err rejects the folder a file was to go in,
+// rather than the file itself
+func folderError(err error) bool {
+	return errors.Is(err, services.ErrSmartFolder) || errors.Is(err, services.ErrFolderNotWritable) ||
+		errors.Is(err, services.ErrFolderNotFound)
+}
+
+// fileChangeError answers a failed upload into a folder, rename, move or copy
+func fileChangeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidFileName), errors.Is(err, services.ErrSmartFolder):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrFolderNotWritable):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrFilesNotFound), errors.Is(err, services.ErrFolderNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrQuotaExceeded):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	default:
+		serverError(c, err)
+	}
+}
+
+// UpdateFile renames a file or moves it to another folder
+func (h *FileHandler) UpdateFile(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	fileID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		return
+	}
+
+	var req models.UpdateFileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	file, err := h.fileService.UpdateFile(c.Request.Context(), fileID, userID.(int), req)
+	if err != nil {
+		fileChangeError(c, err)
+		return
+	}
+
+	if WSManager != nil {
+		WSManager.Broadcast(WebSocketMessage{
+			Type: "file_updated",
+			Data: gin.H{
+				"user_id": userID,
+				"file":    file,
+			},
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "File updated successfully", "file": file})
+}
+
+// CopyFile copies a file into a folder as a new file of the user's
+func (h *FileHandler) CopyFile(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	fileID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		return
+	}
+
+	// Without a body the copy goes outside any folder under the same name
+	var req models.CopyFileRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	file, err := h.fileService.CopyFile(c.Request.Context(), fileID, userID.(int), req)
+	if err != nil {
+		fileChangeError(c, err)
+		return
+	}
+
+	if WSManager != nil {
+		WSManager.Broadcast(WebSocketMessage{
+			Type: "file_copied",
+			Data: gin.H{
+				"user_id": userID,
+				"file":    file,
+				"from":    fileID,
+			},
+		})
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "File copied successfully", "file": file})
+}
+
 func (h *FileHandler) DownloadFile(c *gin.Context) {
 	fileIDStr := c.Param("id")
 	fileID, err := strconv.Atoi(fileIDStr)
@@ -300,26 +409,19 @@ func (h *FileHandler) DownloadFile(c *gin.Context) {
 		return
 	}
 
-	fileData, originalName, err := h.fileService.DownloadFile(fileID)
+	download, err := h.fileService.OpenDownload(c.Request.Context(), fileID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		downloadError(c, fileID, err)
 		return
 	}
 
 	// Get file info for headers
-	file, err := h.fileService.GetFileByID(fileID)
+	file, err := h.fileService.GetFileByID(c.Request.Context(), fileID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get file info"})
 		return
 	}
 
-	// Set headers for file download
-	c.Header("Content-Description", "File Transfer")
-	c.Header("Content-Transfer-Encoding", "binary")
-	c.Header("Content-Disposition", "attachment; filename="+originalName)
-	c.Header("Content-Type", file.MimeType)
-	c.Header("Content-Length", strconv.Itoa(len(fileData)))
-
 	// Broadcast real-time update for download
 	if WSManager != nil {
 		WSManager.Broadcast(WebSocketMessage{
@@ -331,14 +433,13 @@ func (h *FileHandler) DownloadFile(c *gin.Context) {
 		})
 	}
 
-	// Write file data to response
-	c.Data(http.StatusOK, file.MimeType, fileData)
+	writeDownload(c, download, file.MimeType)
 }
 
 func (h *FileHandler) GetPublicFiles(c *gin.Context) {
-	files, err := h.fileService.GetPublicFiles()
+	files, err := h.fileService.GetPublicFiles(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		serverError(c, err)
 		return
 	}
 
@@ -357,7 +458,7 @@ func (h *FileHandler) DownloadPublicFile(c *gin.Context) {
 	}
 
 	// Get file info first to check if it's public
-	file, err := h.fileService.GetFileByID(fileID)
+	file, err := h.fileService.GetFileByID(c.Request.Context(), fileID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
@@ -369,19 +470,12 @@ func (h *FileHandler) DownloadPublicFile(c *gin.Context) {
 		return
 	}
 
-	fileData, originalName, err := h.fileService.DownloadFile(fileID)
+	download, err := h.fileService.OpenDownload(c.Request.Context(), fileID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		downloadError(c, fileID, err)
 		return
 	}
 
-	// Set headers for file download
-	c.Header("Content-Description", "File Transfer")
-	c.Header("Content-Transfer-Encoding", "binary")
-	c.Header("Content-Disposition", "attachment; filename="+originalName)
-	c.Header("Content-Type", file.MimeType)
-	c.Header("Content-Length", strconv.Itoa(len(fileData)))
-
 	// Broadcast real-time update for download
 	if WSManager != nil {
 		WSManager.Broadcast(WebSocketMessage{
@@ -393,8 +487,7 @@ func (h *FileHandler) DownloadPublicFile(c *gin.Context) {
 		})
 	}
 
-	// Write file data to response
-	c.Data(http.StatusOK, file.MimeType, fileData)
+	writeDownload(c, download, file.MimeType)
 }
 
 func (h *FileHandler) GetFileStats(c *gin.Context) {
@@ -406,9 +499,9 @@ func (h *FileHandler) GetFileStats(c *gin.Context) {
 	}
 
 	// Get all files with download counts
-	files, err := h.fileService.GetPublicFiles()
+	files, err := h.fileService.GetPublicFiles(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		serverError(c, err)
 		return
 	}
 
@@ -434,9 +527,9 @@ func (h *FileHandler) GetStorageStats(c *gin.Context) {
 		return
 	}
 
-	stats, err := h.fileService.GetStorageStats(userID.(int))
+	stats, err := h.fileService.GetStorageStats(c.Request.Context(), userID.(int))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		serverError(c, err)
 		return
 	}
 
@@ -450,9 +543,9 @@ func (h *FileHandler) GetDeduplicationStats(c *gin.Context) {
 		return
 	}
 
-	stats, err := h.fileService.GetDeduplicationStats(userID.(int))
+	stats, err := h.fileService.GetDeduplicationStats(c.Request.Context(), userID.(int))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		serverError(c, err)
 		return
 	}
 
@@ -474,23 +567,94 @@ func (h *FileHandler) GlobalSearch(c *gin.Context) {
 	}
 
 	// Set default pagination
-	if searchReq.Limit == 0 {
-		searchReq.Limit = 50
-	}
+	searchReq.Limit = listLimit(c, 50)
 	if searchReq.Page == 0 {
 		searchReq.Page = 1
 	}
 
-	files, err := h.fileService.GlobalSearch(searchReq)
+	list, err := h.fileService.GlobalSearch(c.Request.Context(), searchReq)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		listError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"files": files,
-		"total": len(files),
-		"page":  searchReq.Page,
-		"limit": searchReq.Limit,
-	})
+	response := gin.H{
+		"files":       list.Files,
+		"total":       list.Total,
+		"total_pages": totalPages(list.Total, searchReq.Limit),
+		"has_more":    list.HasMore,
+		"next_cursor": list.NextCursor,
+		"page":        searchReq.Page,
+		"limit":       searchReq.Limit,
+	}
+	if list.Facets != nil {
+		response["facets"] = list.Facets
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// writeDownload sends a file's content. A compressed blob goes out as stored
+// to clients that accept its encoding; everything else is decompressed or
+// reassembled from its chunks while it is written.
+func writeDownload(c *gin.Context, download *services.Download, mimeType string) {
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Content-Disposition", "attachment; filename="+download.Name)
+
+	if download.Compression != utils.CompressionNone {
+		c.Header("Vary", "Accept-Encoding")
+		if acceptsEncoding(c.GetHeader("Accept-Encoding"), download.Compression) {
+			c.Header("Content-Encoding", download.Compression)
+			c.Header("Content-Length", strconv.Itoa(len(download.Stored)))
+			c.Data(http.StatusOK, mimeType, download.Stored)
+			return
+		}
+	}
+
+	reader, err := download.Open()
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+	defer reader.Close()
+	c.DataFromReader(http.StatusOK, download.Size, mimeType, reader, nil)
+	if err := c.Errors.Last(); err != nil {
+		// The status is already sent, so all that is left is to cut the
+		// response short and say why
+		logging.FromContext(c.Request.Context()).Error("Download failed while streaming", "file", download.Name, "error", err.Err)
+	}
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows encoding
+// with a non-zero quality. An entry naming encoding outranks a wildcard.
+func acceptsEncoding(header, encoding string) bool {
+	wildcard := false
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.TrimSpace(name)
+		accepted := true
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if weight, err := strconv.ParseFloat(value, 64); err == nil && weight == 0 {
+				accepted = false
+			}
+		}
+		if strings.EqualFold(name, encoding) {
+			return accepted
+		}
+		if name == "*" {
+			wildcard = accepted
+		}
+	}
+	return wildcard
+}
+
+// downloadError answers a failed download. Corrupt content is an error on
+// our side, logged loudly and never hidden behind a 404.
+func downloadError(c *gin.Context, fileID int, err error) {
+	if errors.Is(err, services.ErrBlobCorrupt) {
+		logging.FromContext(c.Request.Context()).Error("Refused to serve corrupt file", "file_id", fileID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 }
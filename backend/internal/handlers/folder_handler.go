@@ -31,7 +31,7 @@ func (h *FolderHandler) CreateFolder(c *gin.Context) {
 		return
 	}
 
-	folder, err := h.folderService.CreateFolder(userID.(int), req)
+	folder, err := h.folderService.CreateFolder(c.Request.Context(), userID.(int), req)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -51,31 +51,38 @@ func (h *FolderHandler) GetFolders(c *gin.Context) {
 	}
 
 	// Get search parameters
-	search := c.Query("search")
-	sortBy := c.Query("sort_by")
-	sortOrder := c.Query("sort_order")
+	page, _ := strconv.Atoi(c.Query("page"))
+	req := models.FolderSearchRequest{
+		Search:    c.Query("search"),
+		SortBy:    c.Query("sort_by"),
+		SortOrder: c.Query("sort_order"),
+		Cursor:    c.Query("cursor"),
+		Page:      page,
+		Limit:     listLimit(c, 0), // every folder unless a page is asked for
+	}
 	isPublicStr := c.Query("is_public")
 
-	var isPublic *bool
 	if isPublicStr != "" {
 		if isPublicStr == "true" {
 			val := true
-			isPublic = &val
+			req.IsPublic = &val
 		} else if isPublicStr == "false" {
 			val := false
-			isPublic = &val
+			req.IsPublic = &val
 		}
 	}
 
-	folders, err := h.folderService.GetUserFoldersWithSearch(userID.(int), search, sortBy, sortOrder, isPublic)
+	list, err := h.folderService.GetUserFoldersWithSearch(c.Request.Context(), userID.(int), req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		listError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"folders": folders,
-		"total":   len(folders),
+		"folders":     list.Folders,
+		"total":       list.Total,
+		"has_more":    list.HasMore,
+		"next_cursor": list.NextCursor,
 	})
 }
 
@@ -92,7 +99,7 @@ func (h *FolderHandler) GetFolder(c *gin.Context) {
 		return
 	}
 
-	folder, err := h.folderService.GetFolder(folderID, userID.(int))
+	folder, err := h.folderService.GetFolder(c.Request.Context(), folderID, userID.(int))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
@@ -120,7 +127,7 @@ func (h *FolderHandler) UpdateFolder(c *gin.Context) {
 		return
 	}
 
-	folder, err := h.folderService.UpdateFolder(folderID, userID.(int), req)
+	folder, err := h.folderService.UpdateFolder(c.Request.Context(), folderID, userID.(int), req)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -145,7 +152,7 @@ func (h *FolderHandler) DeleteFolder(c *gin.Context) {
 		return
 	}
 
-	err = h.folderService.DeleteFolder(folderID, userID.(int))
+	err = h.folderService.DeleteFolder(c.Request.Context(), folderID, userID.(int))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -175,7 +182,7 @@ func (h *FolderHandler) ShareFolder(c *gin.Context) {
 		return
 	}
 
-	err = h.folderService.ShareFolder(folderID, userID.(int), req)
+	err = h.folderService.ShareFolder(c.Request.Context(), folderID, userID.(int), req)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -193,9 +200,9 @@ func (h *FolderHandler) GetSharedFolders(c *gin.Context) {
 		return
 	}
 
-	folders, err := h.folderService.GetSharedFolders(userID.(int))
+	folders, err := h.folderService.GetSharedFolders(c.Request.Context(), userID.(int))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		serverError(c, err)
 		return
 	}
 
@@ -212,9 +219,9 @@ func (h *FolderHandler) GetFolderStats(c *gin.Context) {
 		return
 	}
 
-	stats, err := h.folderService.GetFolderStats(userID.(int))
+	stats, err := h.folderService.GetFolderStats(c.Request.Context(), userID.(int))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		serverError(c, err)
 		return
 	}
 
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"filevault/internal/config"
+	"filevault/internal/logging"
+	"filevault/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type GCHandler struct {
+	gcService *services.GCService
+	cfg       *config.Config
+}
+
+func NewGCHandler(gcService *services.GCService, cfg *config.Config) *GCHandler {
+	return &GCHandler{gcService: gcService, cfg: cfg}
+}
+
+// RunGC deletes orphaned blobs past the configured grace period. With
+// ?dry_run=true it only reports what it would delete.
+func (h *GCHandler) RunGC(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "true"
+
+	report, err := h.gcService.Run(c.Request.Context(), time.Duration(h.cfg.GC.GracePeriod), dryRun)
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	if !dryRun {
+		logging.FromContext(c.Request.Context()).Info("Garbage collection run by admin",
+			"blobs", len(report.Blobs), "files", len(report.Files), "freed_mb", report.FreedMB)
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"filevault/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// listError answers a failed listing. A sort, cursor, facet, date range or
+// metadata filter the listing doesn't accept is the client's mistake.
+func listError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrInvalidSort) || errors.Is(err, services.ErrInvalidCursor) ||
+		errors.Is(err, services.ErrInvalidFacet) || errors.Is(err, services.ErrInvalidDateRange) ||
+		errors.Is(err, services.ErrInvalidMetadataFilter) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	serverError(c, err)
+}
+
+// listLimit reads a page size, falling back to def and capped at
+// services.MaxListLimit
+func listLimit(c *gin.Context, def int) int {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit <= 0 {
+		return def
+	}
+	return min(limit, services.MaxListLimit)
+}
+
+// totalPages is how many pages of limit items total items fill
+func totalPages(total, limit int) int {
+	if limit <= 0 {
+		return 1
+	}
+	return (total + limit - 1) / limit
+}
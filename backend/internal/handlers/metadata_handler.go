@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"filevault/internal/models"
+	"filevault/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MetadataHandler struct {
+	metadataService *services.MetadataService
+}
+
+func NewMetadataHandler(metadataService *services.MetadataService) *MetadataHandler {
+	return &MetadataHandler{metadataService: metadataService}
+}
+
+// metadataError answers a failed metadata or metadata schema request
+func metadataError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidMetadata), errors.Is(err, services.ErrInvalidMetadataSchema):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrFilesNotFound), errors.Is(err, services.ErrFolderNotFound),
+		errors.Is(err, services.ErrMetadataSchemaNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		serverError(c, err)
+	}
+}
+
+func (h *MetadataHandler) GetFileMetadata(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	fileID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		return
+	}
+
+	metadata, err := h.metadataService.GetFileMetadata(c.Request.Context(), userID.(int), fileID)
+	if err != nil {
+		metadataError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"file_id": fileID, "metadata": metadata})
+}
+
+// ReplaceFileMetadata sets a file's metadata to the request body
+func (h *MetadataHandler) ReplaceFileMetadata(c *gin.Context) {
+	h.writeFileMetadata(c, true)
+}
+
+// UpdateFileMetadata merges the request body into a file's metadata. A
+// null value removes its field.
+func (h *MetadataHandler) UpdateFileMetadata(c *gin.Context) {
+	h.writeFileMetadata(c, false)
+}
+
+func (h *MetadataHandler) writeFileMetadata(c *gin.Context, replace bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	fileID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		return
+	}
+
+	var values models.Metadata
+	if err := c.ShouldBindJSON(&values); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	metadata, err := h.metadataService.UpdateFileMetadata(c.Request.Context(), userID.(int), fileID, values, replace)
+	if err != nil {
+		metadataError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"file_id": fileID, "metadata": metadata})
+}
+
+// GetFolderSchema returns the metadata schema of a folder the user can see
+func (h *MetadataHandler) GetFolderSchema(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	folderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid folder ID"})
+		return
+	}
+
+	schema, err := h.metadataService.GetSchema(c.Request.Context(), folderID, userID.(int))
+	if err != nil {
+		metadataError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, schema)
+}
+
+// GetSchema returns the metadata schema of any folder, for admins
+func (h *MetadataHandler) GetSchema(c *gin.Context) {
+	folderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid folder ID"})
+		return
+	}
+
+	schema, err := h.metadataService.GetFolderSchema(c.Request.Context(), folderID)
+	if err != nil {
+		metadataError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, schema)
+}
+
+// SetSchema sets the metadata schema of a folder
+func (h *MetadataHandler) SetSchema(c *gin.Context) {
+	folderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid folder ID"})
+		return
+	}
+
+	var req models.UpdateMetadataSchemaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	schema, err := h.metadataService.SetSchema(c.Request.Context(), folderID, req)
+	if err != nil {
+		metadataError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, schema)
+}
+
+func (h *MetadataHandler) DeleteSchema(c *gin.Context) {
+	folderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid folder ID"})
+		return
+	}
+
+	if err := h.metadataService.DeleteSchema(c.Request.Context(), folderID); err != nil {
+		metadataError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Metadata schema deleted successfully"})
+}
@@ -1,17 +1,20 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strconv"
 	"sync"
 	"time"
 
+	"filevault/internal/config"
 	"filevault/internal/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+func AuthMiddleware(jwt *utils.JWTManager) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := c.GetHeader("Authorization")
 		if token == "" {
@@ -25,7 +28,7 @@ func AuthMiddleware() gin.HandlerFunc {
 			token = token[7:]
 		}
 
-		claims, err := utils.ValidateJWT(token)
+		claims, err := jwt.Validate(token)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
@@ -39,7 +42,7 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
-func OptionalAuthMiddleware() gin.HandlerFunc {
+func OptionalAuthMiddleware(jwt *utils.JWTManager) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := c.GetHeader("Authorization")
 		if token == "" {
@@ -52,7 +55,7 @@ func OptionalAuthMiddleware() gin.HandlerFunc {
 			token = token[7:]
 		}
 
-		claims, err := utils.ValidateJWT(token)
+		claims, err := jwt.Validate(token)
 		if err != nil {
 			c.Next()
 			return
@@ -77,7 +80,7 @@ func AdminMiddleware() gin.HandlerFunc {
 	}
 }
 
-func RateLimitMiddleware() gin.HandlerFunc {
+func RateLimitMiddleware(requestsPerSecond int) gin.HandlerFunc {
 	// Simple in-memory rate limiter with proper synchronization
 	// In production, use Redis or similar
 	var (
@@ -110,8 +113,8 @@ func RateLimitMiddleware() gin.HandlerFunc {
 			windowMap[userIDStr][endpoint] = now
 		}
 
-		// Check rate limit (2 requests per second)
-		if rateLimitMap[userIDStr][endpoint] >= 2 {
+		// Check rate limit
+		if rateLimitMap[userIDStr][endpoint] >= requestsPerSecond {
 			mutex.Unlock()
 			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
 			c.Abort()
@@ -126,36 +129,14 @@ func RateLimitMiddleware() gin.HandlerFunc {
 	}
 }
 
-func CORSMiddleware() gin.HandlerFunc {
+func CORSMiddleware(allowedOrigins []string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		origin := c.Request.Header.Get("Origin")
-		
-		// Allow specific origins for production
-		allowedOrigins := []string{
-			"https://secure-file-vault-frontend.onrender.com",
-			"http://localhost:3000",
-			"http://localhost:5173",
-			"http://127.0.0.1:3000",
-			"http://127.0.0.1:5173",
-		}
-		
-		// Check if origin is allowed
-		allowed := false
-		for _, allowedOrigin := range allowedOrigins {
-			if origin == allowedOrigin {
-				allowed = true
-				break
-			}
-		}
-		
-		// If origin is allowed or it's a direct request (no origin header), set CORS headers
-		if allowed || origin == "" {
+		if originAllowed(origin, allowedOrigins) {
 			c.Header("Access-Control-Allow-Origin", origin)
-		} else {
-			// Fallback to wildcard for development/testing
-			c.Header("Access-Control-Allow-Origin", "*")
+			c.Header("Vary", "Origin")
 		}
-		
+
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
 		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
 		c.Header("Access-Control-Allow-Credentials", "true")
@@ -169,3 +150,45 @@ func CORSMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// originAllowed reports whether a browser origin may use the API. Requests
+// without an Origin header do not come from a browser and are always allowed.
+func originAllowed(origin string, allowedOrigins []string) bool {
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// TimeoutMiddleware gives each request the deadline configured for its route.
+// Services pass the request context to every query, so when the deadline
+// passes or the client disconnects the database stops working on it too.
+func TimeoutMiddleware(cfg config.TimeoutsConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout := cfg.For(c.Request.Method, c.FullPath())
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// serverError responds 504 when the request ran out of time and 500 with the
+// error message otherwise
+func serverError(c *gin.Context, err error) {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(c.Request.Context().Err(), context.DeadlineExceeded) {
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "request timed out"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
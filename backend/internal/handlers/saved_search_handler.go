@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"filevault/internal/models"
+	"filevault/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SavedSearchHandler struct {
+	savedSearchService *services.SavedSearchService
+}
+
+func NewSavedSearchHandler(savedSearchService *services.SavedSearchService) *SavedSearchHandler {
+	return &SavedSearchHandler{savedSearchService: savedSearchService}
+}
+
+// savedSearchError answers a failed saved search request
+func savedSearchError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrSavedSearchNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrSavedSearchExists), errors.Is(err, services.ErrFolderNameTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrParentFolderNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		listError(c, err)
+	}
+}
+
+func (h *SavedSearchHandler) GetSavedSearches(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	searches, err := h.savedSearchService.GetSavedSearches(c.Request.Context(), userID.(int))
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"searches": searches,
+		"total":    len(searches),
+	})
+}
+
+func (h *SavedSearchHandler) CreateSavedSearch(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req models.CreateSavedSearchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	search, err := h.savedSearchService.CreateSavedSearch(c.Request.Context(), userID.(int), req)
+	if err != nil {
+		savedSearchError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Search saved successfully",
+		"search":  search,
+	})
+}
+
+func (h *SavedSearchHandler) GetSavedSearch(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	searchID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid saved search ID"})
+		return
+	}
+
+	search, err := h.savedSearchService.GetSavedSearch(c.Request.Context(), searchID, userID.(int))
+	if err != nil {
+		savedSearchError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, search)
+}
+
+func (h *SavedSearchHandler) UpdateSavedSearch(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	searchID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid saved search ID"})
+		return
+	}
+
+	var req models.UpdateSavedSearchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	search, err := h.savedSearchService.UpdateSavedSearch(c.Request.Context(), searchID, userID.(int), req)
+	if err != nil {
+		savedSearchError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Saved search updated successfully",
+		"search":  search,
+	})
+}
+
+func (h *SavedSearchHandler) DeleteSavedSearch(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	searchID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid saved search ID"})
+		return
+	}
+
+	if err := h.savedSearchService.DeleteSavedSearch(c.Request.Context(), searchID, userID.(int)); err != nil {
+		savedSearchError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Saved search deleted successfully"})
+}
+
+// RunSavedSearch lists the files a saved search matches, paged like
+// GET /api/files
+func (h *SavedSearchHandler) RunSavedSearch(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	searchID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid saved search ID"})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.Query("page"))
+	req := models.FileSearchRequest{
+		SortBy:    c.Query("sort_by"),
+		SortOrder: c.Query("sort_order"),
+		Cursor:    c.Query("cursor"),
+		Facets:    c.QueryArray("facets"),
+		Page:      page,
+		Limit:     listLimit(c, 20),
+	}
+
+	list, err := h.savedSearchService.RunSavedSearch(c.Request.Context(), searchID, userID.(int), req)
+	if err != nil {
+		savedSearchError(c, err)
+		return
+	}
+
+	response := gin.H{
+		"files":       list.Files,
+		"total":       list.Total,
+		"total_pages": totalPages(list.Total, req.Limit),
+		"has_more":    list.HasMore,
+		"next_cursor": list.NextCursor,
+		"page":        req.Page,
+		"limit":       req.Limit,
+	}
+	if list.Facets != nil {
+		response["facets"] = list.Facets
+	}
+	c.JSON(http.StatusOK, response)
+}
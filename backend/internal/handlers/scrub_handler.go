@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"net/http"
+
+	"filevault/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ScrubHandler struct {
+	scrubService *services.ScrubService
+}
+
+func NewScrubHandler(scrubService *services.ScrubService) *ScrubHandler {
+	return &ScrubHandler{scrubService: scrubService}
+}
+
+// GetIntegrityReport shows the scrubber's progress and every blob it has
+// flagged as corrupt, with the files affected
+func (h *ScrubHandler) GetIntegrityReport(c *gin.Context) {
+	report, err := h.scrubService.Report(c.Request.Context())
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"filevault/internal/models"
+	"filevault/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SSHKeyHandler struct {
+	sshKeyService *services.SSHKeyService
+}
+
+func NewSSHKeyHandler(sshKeyService *services.SSHKeyService) *SSHKeyHandler {
+	return &SSHKeyHandler{sshKeyService: sshKeyService}
+}
+
+// GetSSHKeys lists the public keys the user can log in to SFTP with
+func (h *SSHKeyHandler) GetSSHKeys(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	keys, err := h.sshKeyService.GetSSHKeys(c.Request.Context(), userID.(int))
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ssh_keys": keys,
+		"total":    len(keys),
+	})
+}
+
+func (h *SSHKeyHandler) AddSSHKey(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req models.CreateSSHKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, err := h.sshKeyService.AddSSHKey(c.Request.Context(), userID.(int), req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "SSH key added successfully",
+		"ssh_key": key,
+	})
+}
+
+func (h *SSHKeyHandler) DeleteSSHKey(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	keyID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid SSH key ID"})
+		return
+	}
+
+	err = h.sshKeyService.DeleteSSHKey(c.Request.Context(), userID.(int), keyID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "SSH key deleted successfully"})
+}
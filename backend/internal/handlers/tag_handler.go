@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"filevault/internal/models"
+	"filevault/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TagHandler struct {
+	tagService *services.TagService
+}
+
+func NewTagHandler(tagService *services.TagService) *TagHandler {
+	return &TagHandler{tagService: tagService}
+}
+
+// tagError answers a failed tag request
+func tagError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidTag):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTagNotFound), errors.Is(err, services.ErrFilesNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		serverError(c, err)
+	}
+}
+
+// broadcastTags tells clients that tags changed on a user's files, so file
+// lists, facets and tag lists are fetched again
+func broadcastTags(userID interface{}, data gin.H) {
+	if WSManager == nil {
+		return
+	}
+	data["user_id"] = userID
+	WSManager.Broadcast(WebSocketMessage{Type: "tags_updated", Data: data})
+}
+
+// GetTags lists the user's tags with how many files have each. With prefix
+// it autocompletes.
+func (h *TagHandler) GetTags(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	tags, err := h.tagService.GetTags(c.Request.Context(), userID.(int), c.Query("prefix"), listLimit(c, 0))
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tags":  tags,
+		"total": len(tags),
+	})
+}
+
+func (h *TagHandler) GetFileTags(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	fileID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		return
+	}
+
+	tags, err := h.tagService.GetFileTags(c.Request.Context(), userID.(int), fileID)
+	if err != nil {
+		tagError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"file_id": fileID, "tags": tags})
+}
+
+// UpdateFileTags adds and removes tags on one file
+func (h *TagHandler) UpdateFileTags(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	fileID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		return
+	}
+
+	var req struct {
+		Add    []string `json:"add"`
+		Remove []string `json:"remove"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	err = h.tagService.UpdateFileTags(ctx, userID.(int), models.UpdateFileTagsRequest{
+		FileIDs: []int{fileID},
+		Add:     req.Add,
+		Remove:  req.Remove,
+	})
+	if err != nil {
+		tagError(c, err)
+		return
+	}
+	tags, err := h.tagService.GetFileTags(ctx, userID.(int), fileID)
+	if err != nil {
+		tagError(c, err)
+		return
+	}
+
+	broadcastTags(userID, gin.H{"file_ids": []int{fileID}})
+	c.JSON(http.StatusOK, gin.H{"file_id": fileID, "tags": tags})
+}
+
+// BulkUpdateTags adds and removes tags on a selection of files
+func (h *TagHandler) BulkUpdateTags(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req models.UpdateFileTagsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.tagService.UpdateFileTags(c.Request.Context(), userID.(int), req); err != nil {
+		tagError(c, err)
+		return
+	}
+
+	broadcastTags(userID, gin.H{"file_ids": req.FileIDs})
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Tags updated successfully",
+		"files":   len(req.FileIDs),
+	})
+}
+
+// RenameTag renames a tag on all the user's files. Renaming to a tag already
+// in use merges the two.
+func (h *TagHandler) RenameTag(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req models.RenameTagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	retagged, err := h.tagService.MergeTags(c.Request.Context(), userID.(int), []string{req.From}, req.To)
+	if err != nil {
+		tagError(c, err)
+		return
+	}
+
+	broadcastTags(userID, gin.H{"tags": []string{req.From}, "into": req.To})
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Tag renamed successfully",
+		"files":   retagged,
+	})
+}
+
+// MergeTags replaces several tags with one on all the user's files
+func (h *TagHandler) MergeTags(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req models.MergeTagsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	retagged, err := h.tagService.MergeTags(c.Request.Context(), userID.(int), req.Tags, req.Into)
+	if err != nil {
+		tagError(c, err)
+		return
+	}
+
+	broadcastTags(userID, gin.H{"tags": req.Tags, "into": req.Into})
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Tags merged successfully",
+		"files":   retagged,
+	})
+}
+
+// DeleteTag removes the tag in the tag query parameter from all the user's
+// files. Tags may hold slashes, so it isn't part of the path.
+func (h *TagHandler) DeleteTag(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	tag := c.Query("tag")
+	if tag == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tag is required"})
+		return
+	}
+
+	untagged, err := h.tagService.DeleteTag(c.Request.Context(), userID.(int), tag)
+	if err != nil {
+		tagError(c, err)
+		return
+	}
+
+	broadcastTags(userID, gin.H{"tags": []string{tag}})
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Tag deleted successfully",
+		"files":   untagged,
+	})
+}
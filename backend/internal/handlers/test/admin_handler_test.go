@@ -11,6 +11,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"filevault/internal/config"
 	"filevault/internal/handlers"
 	"filevault/internal/services"
 )
@@ -74,7 +75,7 @@ func TestAdminHandler_GetSystemStats(t *testing.T) {
 
 			adminService := services.NewAdminService(db)
 			fileService := services.NewFileService(db, "/tmp")
-			userService := services.NewUserService(db)
+			userService := services.NewUserService(db, config.Default())
 			folderService := services.NewFolderService(db)
 			adminHandler := handlers.NewAdminHandler(adminService, fileService, userService, folderService)
 
@@ -164,7 +165,7 @@ func TestAdminHandler_GetAllFiles(t *testing.T) {
 
 			adminService := services.NewAdminService(db)
 			fileService := services.NewFileService(db, "/tmp")
-			userService := services.NewUserService(db)
+			userService := services.NewUserService(db, config.Default())
 			folderService := services.NewFolderService(db)
 			adminHandler := handlers.NewAdminHandler(adminService, fileService, userService, folderService)
 
@@ -14,6 +14,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/bcrypt"
 
+	"filevault/internal/config"
 	"filevault/internal/handlers"
 	"filevault/internal/services"
 )
@@ -87,8 +88,8 @@ func TestAuthHandler_Register(t *testing.T) {
 
 			tt.mockSetup(mock)
 
-			userService := services.NewUserService(db)
-			authHandler := handlers.NewAuthHandler(userService)
+			userService := services.NewUserService(db, config.Default())
+			authHandler := handlers.NewAuthHandler(userService, testJWT)
 
 			router := gin.New()
 			router.POST("/register", authHandler.Register)
@@ -186,8 +187,8 @@ func TestAuthHandler_Login(t *testing.T) {
 
 			tt.mockSetup(mock)
 
-			userService := services.NewUserService(db)
-			authHandler := handlers.NewAuthHandler(userService)
+			userService := services.NewUserService(db, config.Default())
+			authHandler := handlers.NewAuthHandler(userService, testJWT)
 
 			router := gin.New()
 			router.POST("/login", authHandler.Login)
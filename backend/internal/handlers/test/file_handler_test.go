@@ -6,15 +6,19 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"filevault/internal/config"
 	"filevault/internal/handlers"
 	"filevault/internal/services"
+	"filevault/internal/utils"
 )
 
 func TestFileHandler_UploadFile(t *testing.T) {
@@ -82,7 +86,7 @@ func TestFileHandler_UploadFile(t *testing.T) {
 			// Create temporary upload directory
 			uploadDir := t.TempDir()
 			fileService := services.NewFileService(db, uploadDir)
-			fileHandler := handlers.NewFileHandler(fileService)
+			fileHandler := handlers.NewFileHandler(fileService, config.Default())
 
 			router := gin.New()
 			router.POST("/upload", fileHandler.UploadFile)
@@ -180,7 +184,7 @@ func TestFileHandler_GetFiles(t *testing.T) {
 
 			uploadDir := t.TempDir()
 			fileService := services.NewFileService(db, uploadDir)
-			fileHandler := handlers.NewFileHandler(fileService)
+			fileHandler := handlers.NewFileHandler(fileService, config.Default())
 
 			router := gin.New()
 			router.GET("/files", fileHandler.GetFiles)
@@ -209,6 +213,30 @@ func TestFileHandler_GetFiles(t *testing.T) {
 	}
 }
 
+func TestFileHandler_GetFilesRejectsBadListings(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, query := range []string{"?sort_by=name,password_hash", "?sort_order=sideways", "?cursor=bm90IGpzb24", "?facets=type,password_hash"} {
+		t.Run(query, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			fileHandler := handlers.NewFileHandler(services.NewFileService(db, t.TempDir()), config.Default())
+			router := gin.New()
+			router.GET("/files", func(c *gin.Context) { c.Set("user_id", 1) }, fileHandler.GetFiles)
+
+			req, _ := http.NewRequest("GET", "/files"+query, nil)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			assert.Equal(t, http.StatusBadRequest, recorder.Code)
+			assert.Contains(t, recorder.Body.String(), "error")
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
 func TestFileHandler_DownloadFile(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -267,7 +295,7 @@ func TestFileHandler_DownloadFile(t *testing.T) {
 
 			uploadDir := t.TempDir()
 			fileService := services.NewFileService(db, uploadDir)
-			fileHandler := handlers.NewFileHandler(fileService)
+			fileHandler := handlers.NewFileHandler(fileService, config.Default())
 
 			router := gin.New()
 			router.GET("/files/:id/download", fileHandler.DownloadFile)
@@ -340,7 +368,7 @@ func TestFileHandler_DownloadPublicFile(t *testing.T) {
 
 			uploadDir := t.TempDir()
 			fileService := services.NewFileService(db, uploadDir)
-			fileHandler := handlers.NewFileHandler(fileService)
+			fileHandler := handlers.NewFileHandler(fileService, config.Default())
 
 			router := gin.New()
 			router.GET("/files/public/:id/download", fileHandler.DownloadPublicFile)
@@ -362,3 +390,58 @@ func TestFileHandler_DownloadPublicFile(t *testing.T) {
 		})
 	}
 }
+
+func TestFileHandler_DownloadCompressedFile(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	data := []byte(strings.Repeat("timestamp,level,message\n2024-01-01T00:00:00Z,info,started\n", 200))
+	blob, compression := utils.CompressBlob(data, "text/csv", utils.CompressionZstd)
+	require.Equal(t, utils.CompressionZstd, compression)
+
+	tests := []struct {
+		name            string
+		acceptEncoding  string
+		contentEncoding string
+		body            []byte
+	}{
+		{"client accepts zstd", "gzip, zstd", "zstd", blob},
+		{"client without zstd", "gzip, br", "", data},
+		{"client refusing zstd", "zstd;q=0, *", "", data},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			now := time.Now()
+			mock.ExpectQuery("SELECT fh.file_data, fh.compression, fh.file_size, f.original_name").WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"file_data", "compression", "file_size", "original_name", "corrupt", "id", "chunked"}).
+					AddRow(blob, compression, len(data), "app.csv", false, 4, false))
+			mock.ExpectExec("UPDATE files SET download_count").WithArgs(1).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery("SELECT f.id, f.user_id, f.hash_id").WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "hash_id", "original_name", "display_name", "folder_id",
+					"is_public", "download_count", "created_at", "updated_at", "hash_sha256", "file_size", "mime_type", "username", "folder_name", "metadata"}).
+					AddRow(1, 1, 4, "app.csv", "app.csv", nil, false, 1, now, now, "hash", len(data), "text/csv", "user1", nil, nil))
+			mock.ExpectQuery("SELECT tag FROM file_tags").WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"tag"}))
+
+			fileHandler := handlers.NewFileHandler(services.NewFileService(db, t.TempDir()), config.Default())
+			router := gin.New()
+			router.GET("/files/:id/download", fileHandler.DownloadFile)
+
+			req, _ := http.NewRequest("GET", "/files/1/download", nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			assert.Equal(t, http.StatusOK, recorder.Code)
+			assert.Equal(t, tt.contentEncoding, recorder.Header().Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", recorder.Header().Get("Vary"))
+			assert.Equal(t, tt.body, recorder.Body.Bytes())
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/internal/handlers"
+	"filevault/migrations"
+)
+
+var testMigrations = []migrations.Migration{
+	{Version: 1, Name: "initial_schema", Checksum: "aaa"},
+	{Version: 2, Name: "s3_gateway", Checksum: "bbb"},
+}
+
+func expectMigrationRows(mock sqlmock.Sqlmock, rows *sqlmock.Rows) {
+	mock.ExpectQuery("SELECT to_regclass").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT version, name, checksum, applied_at FROM schema_migrations").
+		WillReturnRows(rows)
+}
+
+func TestHealthHandler_Readyz(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Now()
+
+	tests := []struct {
+		name           string
+		mockSetup      func(sqlmock.Sqlmock)
+		uploadDir      string
+		expectedStatus int
+		failedChecks   []string
+	}{
+		{
+			name: "all checks pass",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectPing()
+				expectMigrationRows(mock, sqlmock.NewRows([]string{"version", "name", "checksum", "applied_at"}).
+					AddRow(1, "initial_schema", "aaa", now).
+					AddRow(2, "s3_gateway", "bbb", now).
+					AddRow(3, "from_a_newer_build", "ccc", now))
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "database down",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+				mock.ExpectQuery("SELECT to_regclass").WillReturnError(errors.New("connection refused"))
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			failedChecks:   []string{"database", "migrations"},
+		},
+		{
+			name: "pending migration",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectPing()
+				expectMigrationRows(mock, sqlmock.NewRows([]string{"version", "name", "checksum", "applied_at"}).
+					AddRow(1, "initial_schema", "aaa", now))
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			failedChecks:   []string{"migrations"},
+		},
+		{
+			name: "edited migration",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectPing()
+				expectMigrationRows(mock, sqlmock.NewRows([]string{"version", "name", "checksum", "applied_at"}).
+					AddRow(1, "initial_schema", "aaa", now).
+					AddRow(2, "s3_gateway", "changed", now))
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			failedChecks:   []string{"migrations"},
+		},
+		{
+			name: "upload directory missing",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectPing()
+				expectMigrationRows(mock, sqlmock.NewRows([]string{"version", "name", "checksum", "applied_at"}).
+					AddRow(1, "initial_schema", "aaa", now).
+					AddRow(2, "s3_gateway", "bbb", now))
+			},
+			uploadDir:      "/nonexistent/uploads",
+			expectedStatus: http.StatusServiceUnavailable,
+			failedChecks:   []string{"blob_store"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+			require.NoError(t, err)
+			defer db.Close()
+			tt.mockSetup(mock)
+
+			uploadDir := tt.uploadDir
+			if uploadDir == "" {
+				uploadDir = t.TempDir()
+			}
+			handler := handlers.NewHealthHandler(db, migrations.NewRunner(db, testMigrations), uploadDir)
+
+			router := gin.New()
+			router.GET("/readyz", handler.Readyz)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			var body struct {
+				Status string            `json:"status"`
+				Checks map[string]string `json:"checks"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			for name, result := range body.Checks {
+				if slices.Contains(tt.failedChecks, name) {
+					assert.NotEqual(t, "ok", result, name)
+				} else {
+					assert.Equal(t, "ok", result, name)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthHandler_DrainingAndLiveness(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// No expectations: neither probe may touch the database here
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	require.NoError(t, err)
+	defer db.Close()
+
+	handler := handlers.NewHealthHandler(db, migrations.NewRunner(db, testMigrations), t.TempDir())
+	handler.SetDraining()
+
+	router := gin.New()
+	router.GET("/livez", handler.Livez)
+	router.GET("/readyz", handler.Readyz)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/livez", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWebSocketManager_ShutdownSendsCloseFrame(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	manager := handlers.NewWebSocketManager([]string{"*"}, slog.Default())
+	go manager.Run()
+
+	router := gin.New()
+	router.GET("/ws", manager.HandleWebSocket)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.Eventually(t, func() bool { return manager.ClientCount() == 1 }, time.Second, 10*time.Millisecond)
+	manager.Shutdown()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "got %v", err)
+	assert.Equal(t, 0, manager.ClientCount())
+}
@@ -3,6 +3,7 @@ package test
 import (
 	"bytes"
 	"encoding/json"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -11,6 +12,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"filevault/internal/config"
 	"filevault/internal/handlers"
 	"filevault/internal/services"
 	"filevault/internal/utils"
@@ -20,14 +22,14 @@ func TestAuthHandler_RegisterIntegration(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
 	// Create a real database connection for integration test
-	db, err := utils.ConnectDB()
+	db, err := utils.ConnectDB(testConfig(t).Database, slog.Default())
 	if err != nil {
 		t.Skip("Database not available for integration test")
 	}
 	defer db.Close()
 
-	userService := services.NewUserService(db)
-	authHandler := handlers.NewAuthHandler(userService)
+	userService := services.NewUserService(db, config.Default())
+	authHandler := handlers.NewAuthHandler(userService, testJWT)
 
 	router := gin.New()
 	router.POST("/register", authHandler.Register)
@@ -82,14 +84,14 @@ func TestAuthHandler_LoginIntegration(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
 	// Create a real database connection for integration test
-	db, err := utils.ConnectDB()
+	db, err := utils.ConnectDB(testConfig(t).Database, slog.Default())
 	if err != nil {
 		t.Skip("Database not available for integration test")
 	}
 	defer db.Close()
 
-	userService := services.NewUserService(db)
-	authHandler := handlers.NewAuthHandler(userService)
+	userService := services.NewUserService(db, config.Default())
+	authHandler := handlers.NewAuthHandler(userService, testJWT)
 
 	router := gin.New()
 	router.POST("/login", authHandler.Login)
@@ -152,14 +154,14 @@ func TestFileHandler_GetPublicFilesIntegration(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
 	// Create a real database connection for integration test
-	db, err := utils.ConnectDB()
+	db, err := utils.ConnectDB(testConfig(t).Database, slog.Default())
 	if err != nil {
 		t.Skip("Database not available for integration test")
 	}
 	defer db.Close()
 
 	fileService := services.NewFileService(db, "/tmp")
-	fileHandler := handlers.NewFileHandler(fileService)
+	fileHandler := handlers.NewFileHandler(fileService, config.Default())
 
 	router := gin.New()
 	router.GET("/files/public", fileHandler.GetPublicFiles)
@@ -182,7 +184,7 @@ func TestMiddleware_RateLimitIntegration(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
 	router := gin.New()
-	router.Use(handlers.RateLimitMiddleware())
+	router.Use(handlers.RateLimitMiddleware(config.Default().RateLimit.RequestsPerSecond))
 	router.GET("/test", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"message": "success"})
 	})
@@ -204,7 +206,7 @@ func TestMiddleware_AuthIntegration(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
 	router := gin.New()
-	router.Use(handlers.AuthMiddleware())
+	router.Use(handlers.AuthMiddleware(testJWT))
 	router.GET("/protected", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"message": "success"})
 	})
@@ -12,9 +12,23 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"filevault/internal/config"
 	"filevault/internal/handlers"
+	"filevault/internal/services"
+	"filevault/internal/utils"
 )
 
+// testJWT signs and checks tokens in handler tests
+var testJWT = utils.NewJWTManager("test-secret-for-handler-tests", time.Hour)
+
+// testConfig resolves settings from the environment like the server does, so
+// integration tests honour DATABASE_URL
+func testConfig(t *testing.T) *config.Config {
+	cfg, err := config.Load(nil)
+	require.NoError(t, err)
+	return cfg
+}
+
 func TestRateLimitMiddleware(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -48,7 +62,7 @@ func TestRateLimitMiddleware(t *testing.T) {
 			mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 
 			router := gin.New()
-			router.Use(handlers.RateLimitMiddleware())
+			router.Use(handlers.RateLimitMiddleware(config.Default().RateLimit.RequestsPerSecond))
 			router.GET("/test", func(c *gin.Context) {
 				c.JSON(http.StatusOK, gin.H{"message": "success"})
 			})
@@ -137,7 +151,7 @@ func TestAuthMiddleware(t *testing.T) {
 			tt.mockSetup(mock)
 
 			router := gin.New()
-			router.Use(handlers.AuthMiddleware())
+			router.Use(handlers.AuthMiddleware(testJWT))
 			router.GET("/protected", func(c *gin.Context) {
 				c.JSON(http.StatusOK, gin.H{"message": "success"})
 			})
@@ -212,7 +226,7 @@ func TestAdminMiddleware(t *testing.T) {
 			tt.mockSetup(mock)
 
 			router := gin.New()
-			router.Use(handlers.AuthMiddleware())
+			router.Use(handlers.AuthMiddleware(testJWT))
 			router.Use(handlers.AdminMiddleware())
 			router.GET("/admin", func(c *gin.Context) {
 				c.JSON(http.StatusOK, gin.H{"message": "admin success"})
@@ -239,3 +253,79 @@ func TestAdminMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestTimeoutMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	timeouts := config.TimeoutsConfig{
+		Default: config.Duration(time.Minute),
+		Routes: map[string]config.Duration{
+			"GET /api/admin/users/stats": config.Duration(50 * time.Millisecond),
+			"POST /api/files/upload":     0,
+		},
+	}
+
+	tests := []struct {
+		method   string
+		path     string
+		route    string
+		deadline time.Duration
+	}{
+		{"GET", "/api/files/7", "/api/files/:id", time.Minute},
+		{"GET", "/api/admin/users/stats", "/api/admin/users/stats", 50 * time.Millisecond},
+		{"POST", "/api/files/upload", "/api/files/upload", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.route, func(t *testing.T) {
+			router := gin.New()
+			router.Use(handlers.TimeoutMiddleware(timeouts))
+
+			var remaining time.Duration
+			var hasDeadline bool
+			router.Handle(tt.method, tt.route, func(c *gin.Context) {
+				var deadline time.Time
+				deadline, hasDeadline = c.Request.Context().Deadline()
+				remaining = time.Until(deadline)
+				c.Status(http.StatusOK)
+			})
+
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+
+			if tt.deadline == 0 {
+				assert.False(t, hasDeadline)
+				return
+			}
+			require.True(t, hasDeadline)
+			assert.InDelta(t, tt.deadline, remaining, float64(50*time.Millisecond))
+		})
+	}
+}
+
+func TestTimeoutMiddleware_SlowQueryReturns504(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT").
+		WillDelayFor(2 * time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	adminHandler := handlers.NewAdminHandler(services.NewAdminService(db), nil, nil, nil)
+	router := gin.New()
+	router.Use(handlers.TimeoutMiddleware(config.TimeoutsConfig{Default: config.Duration(50 * time.Millisecond)}))
+	router.GET("/api/admin/users/stats", adminHandler.GetUserStats)
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/admin/users/stats", nil))
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Less(t, time.Since(start), time.Second)
+
+	var response map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "request timed out", response["error"])
+}
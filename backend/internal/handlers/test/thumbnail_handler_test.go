@@ -0,0 +1,125 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/internal/handlers"
+	"filevault/internal/services"
+)
+
+func TestThumbnailHandler_GetThumbnail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	hash := strings.Repeat("ab", 32)
+	etag := `"` + hash + `-small"`
+	thumbnail := []byte("\xff\xd8\xff thumbnail")
+
+	tests := []struct {
+		name         string
+		url          string
+		ifNoneMatch  string
+		setupMock    func(sqlmock.Sqlmock)
+		userID       int
+		expectedCode int
+		expectedBody []byte
+	}{
+		{
+			name: "serves the thumbnail",
+			url:  "/files/1/thumbnail?size=small",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT fh.hash_sha256, t.mime_type, t.data").WithArgs(1, "small", 1).
+					WillReturnRows(sqlmock.NewRows([]string{"hash_sha256", "mime_type", "data"}).AddRow(hash, "image/jpeg", thumbnail))
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: thumbnail,
+		},
+		{
+			name:        "client copy is current",
+			url:         "/files/1/thumbnail?size=small",
+			ifNoneMatch: `W/"other", ` + etag,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT fh.hash_sha256, t.mime_type, t.data").WithArgs(1, "small", 1).
+					WillReturnRows(sqlmock.NewRows([]string{"hash_sha256", "mime_type", "data"}).AddRow(hash, "image/jpeg", thumbnail))
+			},
+			expectedCode: http.StatusNotModified,
+		},
+		{
+			name: "not generated yet",
+			url:  "/files/1/thumbnail",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT fh.hash_sha256, t.mime_type, t.data").WithArgs(1, "medium", 1).
+					WillReturnRows(sqlmock.NewRows([]string{"hash_sha256", "mime_type", "data"}).AddRow(hash, nil, nil))
+			},
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name: "file not found",
+			url:  "/files/1/thumbnail",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT fh.hash_sha256, t.mime_type, t.data").WithArgs(1, "medium", 1).
+					WillReturnRows(sqlmock.NewRows([]string{"hash_sha256", "mime_type", "data"}))
+			},
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name: "another user's private file",
+			url:  "/files/1/thumbnail?size=small",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("WHERE f.id = \\$1 AND \\(f.user_id = \\$3 OR f.is_public").WithArgs(1, "small", 2).
+					WillReturnRows(sqlmock.NewRows([]string{"hash_sha256", "mime_type", "data"}))
+			},
+			userID:       2,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "unknown size",
+			url:          "/files/1/thumbnail?size=huge",
+			setupMock:    func(sqlmock.Sqlmock) {},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+			tt.setupMock(mock)
+
+			thumbnailHandler := handlers.NewThumbnailHandler(services.NewThumbnailService(db, t.TempDir()))
+			userID := tt.userID
+			if userID == 0 {
+				userID = 1
+			}
+			router := gin.New()
+			router.Use(func(c *gin.Context) { c.Set("user_id", userID) })
+			router.GET("/files/:id/thumbnail", thumbnailHandler.GetThumbnail)
+
+			req, _ := http.NewRequest("GET", tt.url, nil)
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			assert.Equal(t, tt.expectedCode, recorder.Code)
+			if tt.expectedCode == http.StatusOK || tt.expectedCode == http.StatusNotModified {
+				assert.Equal(t, etag, recorder.Header().Get("ETag"))
+				assert.Contains(t, recorder.Header().Get("Cache-Control"), "private")
+			}
+			if tt.expectedBody != nil {
+				assert.Equal(t, "image/jpeg", recorder.Header().Get("Content-Type"))
+				assert.Equal(t, tt.expectedBody, recorder.Body.Bytes())
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"filevault/internal/services"
+	"filevault/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// thumbnailMaxAge is how long clients may reuse a thumbnail without asking.
+// Thumbnails never change for a file, whose content is fixed at upload.
+const thumbnailMaxAge = 7 * 24 * 3600
+
+type ThumbnailHandler struct {
+	thumbnailService *services.ThumbnailService
+}
+
+func NewThumbnailHandler(thumbnailService *services.ThumbnailService) *ThumbnailHandler {
+	return &ThumbnailHandler{thumbnailService: thumbnailService}
+}
+
+// GetThumbnail serves a preview of a file the user can see at ?size=small,
+// medium or large, answering 304 when the client's copy is current
+func (h *ThumbnailHandler) GetThumbnail(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	fileID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		return
+	}
+	size := c.DefaultQuery("size", utils.DefaultThumbnailSize)
+	if _, ok := utils.ThumbnailSizes[size]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "size must be small, medium or large"})
+		return
+	}
+
+	thumbnail, err := h.thumbnailService.GetThumbnail(c.Request.Context(), fileID, userID.(int), size)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	} else if errors.Is(err, services.ErrNoThumbnail) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No thumbnail available for this file"})
+		return
+	} else if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	etag := fmt.Sprintf(`"%s-%s"`, thumbnail.HashSHA256, thumbnail.Size)
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", thumbnailMaxAge))
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, thumbnail.MimeType, thumbnail.Data)
+}
+
+// etagMatches reports whether an If-None-Match header lists etag
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -2,9 +2,12 @@ package handlers
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 	"sync"
+	"time"
+
+	"filevault/internal/logging"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -16,6 +19,8 @@ type WebSocketManager struct {
 	unregister chan *websocket.Conn
 	broadcast  chan []byte
 	mutex      sync.RWMutex
+	upgrader   websocket.Upgrader
+	logger     *slog.Logger
 }
 
 type WebSocketMessage struct {
@@ -23,37 +28,18 @@ type WebSocketMessage struct {
 	Data interface{} `json:"data"`
 }
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		origin := r.Header.Get("Origin")
-		
-		// Allow specific origins for production
-		allowedOrigins := []string{
-			"https://secure-file-vault-frontend.onrender.com",
-			"http://localhost:3000",
-			"http://localhost:5173",
-			"http://127.0.0.1:3000",
-			"http://127.0.0.1:5173",
-		}
-		
-		// Check if origin is allowed
-		for _, allowedOrigin := range allowedOrigins {
-			if origin == allowedOrigin {
-				return true
-			}
-		}
-		
-		// Allow requests without origin header (direct connections)
-		return origin == ""
-	},
-}
-
-func NewWebSocketManager() *WebSocketManager {
+func NewWebSocketManager(allowedOrigins []string, logger *slog.Logger) *WebSocketManager {
 	return &WebSocketManager{
 		clients:    make(map[*websocket.Conn]bool),
 		register:   make(chan *websocket.Conn),
 		unregister: make(chan *websocket.Conn),
 		broadcast:  make(chan []byte),
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				return originAllowed(r.Header.Get("Origin"), allowedOrigins)
+			},
+		},
+		logger: logger,
 	}
 }
 
@@ -63,8 +49,9 @@ func (ws *WebSocketManager) Run() {
 		case conn := <-ws.register:
 			ws.mutex.Lock()
 			ws.clients[conn] = true
+			count := len(ws.clients)
 			ws.mutex.Unlock()
-			log.Printf("WebSocket client connected. Total clients: %d", len(ws.clients))
+			ws.logger.Debug("WebSocket client connected", "clients", count)
 
 		case conn := <-ws.unregister:
 			ws.mutex.Lock()
@@ -72,15 +59,16 @@ func (ws *WebSocketManager) Run() {
 				delete(ws.clients, conn)
 				conn.Close()
 			}
+			count := len(ws.clients)
 			ws.mutex.Unlock()
-			log.Printf("WebSocket client disconnected. Total clients: %d", len(ws.clients))
+			ws.logger.Debug("WebSocket client disconnected", "clients", count)
 
 		case message := <-ws.broadcast:
 			ws.mutex.RLock()
 			for conn := range ws.clients {
 				err := conn.WriteMessage(websocket.TextMessage, message)
 				if err != nil {
-					log.Printf("WebSocket write error: %v", err)
+					ws.logger.Warn("WebSocket write failed", "error", err)
 					conn.Close()
 					delete(ws.clients, conn)
 				}
@@ -90,19 +78,45 @@ func (ws *WebSocketManager) Run() {
 	}
 }
 
+// Shutdown sends every client a going-away close frame and closes its
+// connection, so browsers reconnect elsewhere instead of seeing an abnormal
+// closure. Connections are hijacked from the HTTP server, so
+// http.Server.Shutdown does not wait for or close them.
+func (ws *WebSocketManager) Shutdown() {
+	message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	deadline := time.Now().Add(time.Second)
+
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	for conn := range ws.clients {
+		if err := conn.WriteControl(websocket.CloseMessage, message, deadline); err != nil {
+			ws.logger.Debug("WebSocket close frame not sent", "error", err)
+		}
+		conn.Close()
+		delete(ws.clients, conn)
+	}
+}
+
+// ClientCount returns the number of connected clients
+func (ws *WebSocketManager) ClientCount() int {
+	ws.mutex.RLock()
+	defer ws.mutex.RUnlock()
+	return len(ws.clients)
+}
+
 func (ws *WebSocketManager) Broadcast(message WebSocketMessage) {
 	data, err := json.Marshal(message)
 	if err != nil {
-		log.Printf("Error marshaling WebSocket message: %v", err)
+		ws.logger.Error("Failed to marshal WebSocket message", "error", err)
 		return
 	}
 	ws.broadcast <- data
 }
 
 func (ws *WebSocketManager) HandleWebSocket(c *gin.Context) {
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	conn, err := ws.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
+		logging.FromContext(c.Request.Context()).Warn("WebSocket upgrade failed", "error", err)
 		return
 	}
 
@@ -118,17 +132,13 @@ func (ws *WebSocketManager) HandleWebSocket(c *gin.Context) {
 		_, _, err := conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
+				logging.FromContext(c.Request.Context()).Warn("WebSocket closed unexpectedly", "error", err)
 			}
 			break
 		}
 	}
 }
 
-// Global WebSocket manager instance
+// WSManager broadcasts file events to connected clients. It is set up by
+// main; handlers skip broadcasting while it is nil.
 var WSManager *WebSocketManager
-
-func init() {
-	WSManager = NewWebSocketManager()
-	go WSManager.Run()
-}
@@ -0,0 +1,94 @@
+// Package jobs runs periodic background maintenance such as cleaning up
+// abandoned S3 multipart uploads, and reports each run to the metrics package.
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"filevault/internal/metrics"
+)
+
+type job struct {
+	name     string
+	interval time.Duration
+	run      func(ctx context.Context) error
+}
+
+// Scheduler runs each added job on its own ticker. A job never overlaps
+// with itself; a run that takes longer than the interval delays the next one.
+type Scheduler struct {
+	logger *slog.Logger
+	jobs   []job
+	ctx    context.Context
+	stop   context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewScheduler(logger *slog.Logger) *Scheduler {
+	ctx, stop := context.WithCancel(context.Background())
+	return &Scheduler{logger: logger, ctx: ctx, stop: stop}
+}
+
+// Add registers a job; it must be called before Start. The context passed to
+// run is cancelled by Stop, so long runs can end early on shutdown.
+func (s *Scheduler) Add(name string, interval time.Duration, run func(ctx context.Context) error) {
+	s.jobs = append(s.jobs, job{name: name, interval: interval, run: run})
+	metrics.JobRunning.WithLabelValues(name).Set(0)
+}
+
+// Start launches every job. The first run of each happens after one interval.
+func (s *Scheduler) Start() {
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(j)
+	}
+}
+
+// Stop prevents further runs, cancels running ones and waits for them to
+// return
+func (s *Scheduler) Stop() {
+	s.stop()
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(j job) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.runOnce(j)
+		}
+	}
+}
+
+func (s *Scheduler) runOnce(j job) {
+	metrics.JobRunning.WithLabelValues(j.name).Set(1)
+	defer metrics.JobRunning.WithLabelValues(j.name).Set(0)
+
+	start := time.Now()
+	err := j.run(s.ctx)
+	elapsed := time.Since(start)
+	metrics.JobDuration.WithLabelValues(j.name).Observe(elapsed.Seconds())
+
+	if err != nil && s.ctx.Err() != nil {
+		// Cut short by Stop; the next start picks the work up again
+		s.logger.Info("Job interrupted by shutdown", "job", j.name, "duration", elapsed)
+		return
+	}
+	if err != nil {
+		s.logger.Error("Job failed", "job", j.name, "duration", elapsed, "error", err)
+		metrics.JobRuns.WithLabelValues(j.name, "failure").Inc()
+		return
+	}
+	s.logger.Debug("Job finished", "job", j.name, "duration", elapsed)
+	metrics.JobRuns.WithLabelValues(j.name, "success").Inc()
+	metrics.JobLastSuccess.WithLabelValues(j.name).SetToCurrentTime()
+}
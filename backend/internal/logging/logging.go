@@ -0,0 +1,70 @@
+// Package logging builds the structured logger used throughout the server
+// and the Gin middleware that gives every request an ID and an access log line.
+//
+// Components receive a *slog.Logger when they are constructed. Code running
+// inside a request should use FromContext instead, so its lines carry the
+// request ID and trace ID of the request that caused them.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+
+	"filevault/internal/config"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// redactedKeys are attribute keys, or parts of keys, whose values are never written
+var redactedKeys = []string{"password", "token", "secret", "authorization", "cookie", "signature"}
+
+const redacted = "[REDACTED]"
+
+// New returns a logger writing to w in the configured level and format
+func New(cfg config.LoggingConfig, w io.Writer) *slog.Logger {
+	var level slog.Level
+	// Validate has already rejected unknown levels
+	level.UnmarshalText([]byte(cfg.Level))
+
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+	var handler slog.Handler
+	if cfg.Format == "json" {
+		handler = slog.NewJSONHandler(w, opts)
+	} else {
+		handler = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(handler)
+}
+
+func redact(_ []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	for _, k := range redactedKeys {
+		if strings.Contains(key, k) {
+			return slog.String(a.Key, redacted)
+		}
+	}
+	return a
+}
+
+type contextKey struct{}
+
+// WithContext returns a copy of ctx carrying logger
+func WithContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the request logger stored by Middleware, or the
+// default logger outside a request. Lines from a traced request also get
+// its trace ID so they can be matched with the spans.
+func FromContext(ctx context.Context) *slog.Logger {
+	logger, ok := ctx.Value(contextKey{}).(*slog.Logger)
+	if !ok {
+		logger = slog.Default()
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		logger = logger.With("trace_id", sc.TraceID().String())
+	}
+	return logger
+}
@@ -0,0 +1,137 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/internal/config"
+	"filevault/internal/logging"
+)
+
+func newRouter(buf *bytes.Buffer) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	logger := logging.New(config.LoggingConfig{Level: "debug", Format: "json"}, buf)
+
+	router := gin.New()
+	router.Use(logging.Middleware(logger))
+	router.GET("/api/files/:id", func(c *gin.Context) {
+		c.Set("user_id", 42)
+		logging.FromContext(c.Request.Context()).Info("handler ran")
+		c.String(http.StatusOK, "hello")
+	})
+	return router
+}
+
+// lines decodes each JSON log line written to buf
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var out []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		out = append(out, entry)
+	}
+	return out
+}
+
+func TestMiddleware_GeneratesRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	w := httptest.NewRecorder()
+	newRouter(&buf).ServeHTTP(w, httptest.NewRequest("GET", "/api/files/7?token=abc", nil))
+
+	requestID := w.Header().Get(logging.RequestIDHeader)
+	assert.Len(t, requestID, 32)
+
+	entries := lines(t, &buf)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "handler ran", entries[0]["msg"])
+	assert.Equal(t, requestID, entries[0]["request_id"])
+
+	access := entries[1]
+	assert.Equal(t, requestID, access["request_id"])
+	assert.Equal(t, "/api/files/:id", access["route"])
+	assert.Equal(t, "/api/files/7", access["path"])
+	assert.Equal(t, 200.0, access["status"])
+	assert.Equal(t, 5.0, access["bytes"])
+	assert.Equal(t, 42.0, access["user_id"])
+	assert.Contains(t, access, "latency")
+	assert.NotContains(t, buf.String(), "abc")
+}
+
+func TestMiddleware_EchoesRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		kept     bool
+	}{
+		{"valid id", "req-123_abc.def:1", true},
+		{"too long", strings.Repeat("a", 129), false},
+		{"unsafe characters", "id\nforged=1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			req := httptest.NewRequest("GET", "/api/files/1", nil)
+			req.Header.Set(logging.RequestIDHeader, tt.incoming)
+			w := httptest.NewRecorder()
+			newRouter(&buf).ServeHTTP(w, req)
+
+			got := w.Header().Get(logging.RequestIDHeader)
+			if tt.kept {
+				assert.Equal(t, tt.incoming, got)
+			} else {
+				assert.NotEqual(t, tt.incoming, got)
+				assert.Len(t, got, 32)
+			}
+		})
+	}
+}
+
+func TestMiddleware_LevelFollowsStatus(t *testing.T) {
+	var buf bytes.Buffer
+	newRouter(&buf).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/nowhere", nil))
+
+	entries := lines(t, &buf)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "WARN", entries[0]["level"])
+	assert.Equal(t, "unmatched", entries[0]["route"])
+	assert.NotContains(t, entries[0], "user_id")
+}
+
+func TestNew_RedactsSecrets(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(config.LoggingConfig{Level: "info", Format: "text"}, &buf)
+
+	logger.Info("login",
+		"username", "alice",
+		"password", "hunter2",
+		"access_token", "eyJhbGciOi",
+		"Authorization", "Bearer xyz",
+		"jwt_secret", "s3cr3t")
+
+	out := buf.String()
+	assert.Contains(t, out, "username=alice")
+	for _, secret := range []string{"hunter2", "eyJhbGciOi", "Bearer xyz", "s3cr3t"} {
+		assert.NotContains(t, out, secret)
+	}
+	assert.Contains(t, out, "password=[REDACTED]")
+}
+
+func TestNew_RespectsLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(config.LoggingConfig{Level: "warn", Format: "text"}, &buf)
+
+	logger.Info("hidden")
+	logger.Warn("shown")
+
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.Contains(t, buf.String(), "shown")
+}
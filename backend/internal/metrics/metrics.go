@@ -0,0 +1,195 @@
+// Package metrics defines the Prometheus metrics exported at /metrics.
+//
+// All collectors live in one Registry rather than the global default so the
+// endpoint only shows what the vault itself registers, plus the standard Go
+// runtime and process metrics.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "filevault"
+
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	UploadedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploaded_bytes_total",
+		Help:      "Bytes of file content accepted, including deduplicated uploads.",
+	})
+
+	DownloadedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloaded_bytes_total",
+		Help:      "Bytes of file content served to clients.",
+	})
+
+	DedupLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dedup_lookups_total",
+		Help:      "Uploads whose content was already stored (hit) or new (miss).",
+	}, []string{"result"})
+
+	QuotaRejections = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "quota_rejections_total",
+		Help:      "Uploads refused because they would exceed the user's storage quota.",
+	})
+
+	GCReclaimedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "gc_reclaimed_bytes_total",
+		Help:      "Bytes of orphaned blobs deleted by garbage collection, by store.",
+	}, []string{"store"})
+
+	ScrubbedBlobs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scrubbed_blobs_total",
+		Help:      "Blobs re-hashed by the integrity scrubber, by result (ok or corrupt).",
+	}, []string{"result"})
+
+	ScrubbedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scrubbed_bytes_total",
+		Help:      "Bytes of blob content re-read by the integrity scrubber.",
+	})
+
+	ThumbnailBlobs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "thumbnail_blobs_total",
+		Help:      "Blobs the thumbnail generator has processed, by result (generated or failed).",
+	}, []string{"result"})
+
+	IndexedBlobs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "indexed_blobs_total",
+		Help:      "Blobs the content indexer has processed, by result (indexed or skipped).",
+	}, []string{"result"})
+
+	CorruptBlobs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "corrupt_blobs",
+		Help:      "Blobs currently flagged as not matching their SHA-256 hash.",
+	})
+
+	JobRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "job_runs_total",
+		Help:      "Background job runs by job and result.",
+	}, []string{"job", "result"})
+
+	JobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_duration_seconds",
+		Help:      "Background job run time.",
+		Buckets:   []float64{.01, .1, 1, 10, 60, 300, 1800},
+	}, []string{"job"})
+
+	JobLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "job_last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful run of each background job.",
+	}, []string{"job"})
+
+	JobRunning = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "job_running",
+		Help:      "Whether each background job is currently running.",
+	}, []string{"job"})
+)
+
+// dedupHits and dedupMisses mirror DedupLookups so the hit ratio can be
+// served directly, without a PromQL expression
+var dedupHits, dedupMisses atomic.Int64
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests, HTTPDuration,
+		UploadedBytes, DownloadedBytes, DedupLookups, QuotaRejections, GCReclaimedBytes,
+		ScrubbedBlobs, ScrubbedBytes, CorruptBlobs, ThumbnailBlobs, IndexedBlobs,
+		JobRuns, JobDuration, JobLastSuccess, JobRunning,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "dedup_hit_ratio",
+			Help:      "Share of uploads since startup whose content was already stored.",
+		}, func() float64 {
+			hits, misses := dedupHits.Load(), dedupMisses.Load()
+			if hits+misses == 0 {
+				return 0
+			}
+			return float64(hits) / float64(hits+misses)
+		}),
+	)
+}
+
+// RecordDedup counts one upload as a deduplication hit or miss
+func RecordDedup(hit bool) {
+	if hit {
+		dedupHits.Add(1)
+		DedupLookups.WithLabelValues("hit").Inc()
+	} else {
+		dedupMisses.Add(1)
+		DedupLookups.WithLabelValues("miss").Inc()
+	}
+}
+
+// RegisterDB exports the connection pool statistics of db
+func RegisterDB(db *sql.DB) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, namespace))
+}
+
+// RegisterGauge exports a value computed on every scrape
+func RegisterGauge(name, help string, fn func() float64) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, fn))
+}
+
+// Handler serves the registry in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Middleware records request counts and latency. Routes are labelled by
+// their pattern, such as /api/files/:id, so IDs don't create new series.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		HTTPRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		HTTPDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,81 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/internal/jobs"
+	"filevault/internal/metrics"
+)
+
+func TestMiddleware_LabelsByRoutePattern(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(metrics.Middleware())
+	router.GET("/api/files/:id", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	for _, path := range []string{"/api/files/1", "/api/files/2", "/nowhere"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("GET", "/api/files/:id", "204")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("GET", "unmatched", "404")))
+}
+
+func TestHandler_ServesDedupRatio(t *testing.T) {
+	metrics.RecordDedup(true)
+	metrics.RecordDedup(true)
+	metrics.RecordDedup(true)
+	metrics.RecordDedup(false)
+
+	server := httptest.NewServer(metrics.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	assert.Contains(t, string(body), "filevault_dedup_hit_ratio 0.75")
+	assert.Contains(t, string(body), `filevault_dedup_lookups_total{result="hit"} 3`)
+}
+
+func TestScheduler_RecordsJobStatus(t *testing.T) {
+	ran := make(chan struct{}, 10)
+	scheduler := jobs.NewScheduler(slog.Default())
+	scheduler.Add("test_ok", 10*time.Millisecond, func(context.Context) error {
+		ran <- struct{}{}
+		return nil
+	})
+	scheduler.Add("test_failing", 10*time.Millisecond, func(context.Context) error {
+		return errors.New("boom")
+	})
+	scheduler.Start()
+
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("job did not run")
+	}
+	time.Sleep(20 * time.Millisecond)
+	scheduler.Stop()
+
+	assert.GreaterOrEqual(t, testutil.ToFloat64(metrics.JobRuns.WithLabelValues("test_ok", "success")), 1.0)
+	assert.GreaterOrEqual(t, testutil.ToFloat64(metrics.JobRuns.WithLabelValues("test_failing", "failure")), 1.0)
+	assert.Greater(t, testutil.ToFloat64(metrics.JobLastSuccess.WithLabelValues("test_ok")), 0.0)
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.JobRunning.WithLabelValues("test_ok")))
+}
@@ -32,8 +32,33 @@ type File struct {
 	UserEmail      string   `json:"user_email,omitempty"`
 	FolderName     string   `json:"folder_name,omitempty"`
 	Tags           []string `json:"tags,omitempty"`
+	Metadata       Metadata `json:"metadata,omitempty"`
 	ReferenceCount int      `json:"reference_count,omitempty"` // Number of files sharing this content
 	IsDuplicate    bool     `json:"is_duplicate,omitempty"`    // True if reference_count > 1
+	HashMD5        string   `json:"hash_md5,omitempty"`        // Only set for blobs uploaded after MD5 tracking was added
+	Path           string   `json:"path,omitempty"`            // Slash-separated path relative to a root folder
+	Snippet        string   `json:"snippet,omitempty"`         // Matching content in search results, HTML-escaped with <mark> around matches
+	Score          float64  `json:"score,omitempty"`           // Relevance to the search query
+}
+
+// FileList is one page of a file listing. Total counts every match, not
+// just this page; NextCursor continues after the last file when HasMore.
+type FileList struct {
+	Files      []File `json:"files"`
+	Total      int    `json:"total"`
+	HasMore    bool   `json:"has_more"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	// Facets counts the matches by each facet asked for, keyed by facet name
+	Facets map[string][]FacetValue `json:"facets,omitempty"`
+}
+
+// FacetValue is one value of a facet and how many matches have it. Filter
+// holds the search parameters that narrow a search down to it.
+type FacetValue struct {
+	Value  string            `json:"value"`
+	Label  string            `json:"label,omitempty"`
+	Count  int               `json:"count"`
+	Filter map[string]string `json:"filter,omitempty"`
 }
 
 type Folder struct {
@@ -50,6 +75,7 @@ type Folder struct {
 	FolderSize       *int64    `json:"folder_size,omitempty" db:"folder_size"`
 	SubfolderCount   *int      `json:"subfolder_count,omitempty" db:"subfolder_count"`
 	SharedPermission *string   `json:"shared_permission,omitempty" db:"shared_permission"`
+	SavedSearchID    *int      `json:"saved_search_id,omitempty" db:"saved_search_id"` // set on smart folders
 	UserEmail        *string   `json:"user_email,omitempty" db:"user_email"`
 }
 
@@ -65,6 +91,92 @@ type SystemStats struct {
 	SavingsPercentage  float64 `json:"savings_percentage"`
 }
 
+// BlobIssue describes a stored blob that failed an integrity check
+type BlobIssue struct {
+	HashID     int    `json:"hash_id"`
+	HashSHA256 string `json:"hash_sha256"`
+	Problem    string `json:"problem"`
+	References int    `json:"references"`
+}
+
+type BlobCheckReport struct {
+	Checked    int         `json:"checked"`
+	CheckedMB  float64     `json:"checked_mb"`
+	Issues     []BlobIssue `json:"issues"`
+	IssueCount int         `json:"issue_count"`
+}
+
+// OrphanBlob is a stored blob that no file refers to
+type OrphanBlob struct {
+	HashID     int       `json:"hash_id"`
+	HashSHA256 string    `json:"hash_sha256"`
+	Size       int64     `json:"size"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// OrphanFile is a legacy on-disk blob without a database row
+type OrphanFile struct {
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// GCReport lists what a garbage collection run deleted, or would delete in
+// a dry run
+type GCReport struct {
+	DryRun      bool         `json:"dry_run"`
+	GracePeriod string       `json:"grace_period"`
+	Blobs       []OrphanBlob `json:"blobs"`
+	Files       []OrphanFile `json:"files"`
+	FreedMB     float64      `json:"freed_mb"`
+}
+
+// AffectedFile is a file whose content is a corrupt blob
+type AffectedFile struct {
+	FileID   int    `json:"file_id"`
+	Name     string `json:"name"`
+	Username string `json:"username"`
+}
+
+// CorruptBlob is a blob the scrubber found not to match its hash
+type CorruptBlob struct {
+	HashID         int            `json:"hash_id"`
+	HashSHA256     string         `json:"hash_sha256"`
+	Size           int64          `json:"size"`
+	Problem        string         `json:"problem"`
+	DetectedAt     time.Time      `json:"detected_at"`
+	LastVerifiedAt time.Time      `json:"last_verified_at"`
+	Files          []AffectedFile `json:"files"`
+}
+
+// ScrubResult summarises one scrubber batch
+type ScrubResult struct {
+	Checked int   `json:"checked"`
+	Corrupt int   `json:"corrupt"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// ThumbnailResult summarises one thumbnail generator batch
+type ThumbnailResult struct {
+	Generated int `json:"generated"`
+	Failed    int `json:"failed"`
+}
+
+// IndexResult summarises one content indexer batch
+type IndexResult struct {
+	Indexed int `json:"indexed"`
+	Skipped int `json:"skipped"`
+}
+
+// ScrubReport shows how far the scrubber has got and what it has flagged
+type ScrubReport struct {
+	TotalBlobs       int           `json:"total_blobs"`
+	VerifiedBlobs    int           `json:"verified_blobs"`
+	OldestVerifiedAt *time.Time    `json:"oldest_verified_at"`
+	Corrupt          []CorruptBlob `json:"corrupt"`
+	CorruptCount     int           `json:"corrupt_count"`
+}
+
 type UserStats struct {
 	ID               int    `json:"id"`
 	Username         string `json:"username"`
@@ -119,6 +231,153 @@ type FileTag struct {
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
 
+// TagCount is a tag and how many of a user's files have it
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// UpdateFileTagsRequest adds and removes tags on a selection of files
+type UpdateFileTagsRequest struct {
+	FileIDs []int    `json:"file_ids" binding:"required,min=1,max=1000"`
+	Add     []string `json:"add"`
+	Remove  []string `json:"remove"`
+}
+
+type RenameTagRequest struct {
+	From string `json:"from" binding:"required"`
+	To   string `json:"to" binding:"required"`
+}
+
+type MergeTagsRequest struct {
+	Tags []string `json:"tags" binding:"required,min=1"`
+	Into string   `json:"into" binding:"required"`
+}
+
+// Metadata is a file's custom metadata. Values are strings, numbers or
+// booleans.
+type Metadata map[string]interface{}
+
+// Metadata field types
+const (
+	MetadataString  = "string"
+	MetadataNumber  = "number"
+	MetadataInteger = "integer"
+	MetadataBoolean = "boolean"
+	MetadataDate    = "date" // a YYYY-MM-DD string
+)
+
+// MetadataField is one field of a metadata schema. Enum, when set, lists
+// the only values the field may take.
+type MetadataField struct {
+	Name     string        `json:"name"`
+	Type     string        `json:"type"`
+	Required bool          `json:"required,omitempty"`
+	Enum     []interface{} `json:"enum,omitempty"`
+}
+
+// MetadataSchema is what the metadata of the files in a folder must look
+// like. Additional allows fields the schema doesn't list.
+type MetadataSchema struct {
+	FolderID   int             `json:"folder_id"`
+	Fields     []MetadataField `json:"fields"`
+	Additional bool            `json:"additional"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+type UpdateMetadataSchemaRequest struct {
+	Fields     []MetadataField `json:"fields" binding:"required"`
+	Additional bool            `json:"additional"`
+}
+
+// UpdateFileRequest renames a file, moves it, or both. FolderID 0 moves it
+// out of any folder.
+type UpdateFileRequest struct {
+	DisplayName *string `json:"display_name,omitempty"`
+	FolderID    *int    `json:"folder_id,omitempty"`
+}
+
+// CopyFileRequest places a copy of a file in a folder, or outside any
+// folder when FolderID is nil or 0. An empty DisplayName keeps the file's.
+type CopyFileRequest struct {
+	FolderID    *int   `json:"folder_id"`
+	DisplayName string `json:"display_name"`
+}
+
+// Bulk operation actions
+const (
+	BulkDelete  = "delete"
+	BulkMove    = "move"
+	BulkTag     = "tag"
+	BulkShare   = "share"
+	BulkPublic  = "public"
+	BulkPrivate = "private"
+)
+
+// BulkOperation applies one action to a selection of files and folders.
+// FolderID is where move puts them, 0 for outside any folder or at the top
+// level. Add and Remove are the tags of tag, which only applies to files.
+// Username and Permission are who share shares with and how, read if empty.
+type BulkOperation struct {
+	Action     string   `json:"action" binding:"required,oneof=delete move tag share public private"`
+	FileIDs    []int    `json:"file_ids,omitempty"`
+	FolderIDs  []int    `json:"folder_ids,omitempty"`
+	FolderID   *int     `json:"folder_id,omitempty"`
+	Add        []string `json:"add,omitempty"`
+	Remove     []string `json:"remove,omitempty"`
+	Username   string   `json:"username,omitempty"`
+	Permission string   `json:"permission,omitempty" binding:"omitempty,oneof=read write admin"`
+}
+
+// BulkRequest runs operations in order. Atomic ones all apply or none do;
+// otherwise each file or folder succeeds or fails on its own. Async runs
+// the batch in the background, as happens anyway for large batches.
+type BulkRequest struct {
+	Operations []BulkOperation `json:"operations" binding:"required,min=1,max=100,dive"`
+	Atomic     bool            `json:"atomic"`
+	Async      bool            `json:"async"`
+}
+
+// Bulk job and item statuses
+const (
+	BulkRunning    = "running"
+	BulkCompleted  = "completed"
+	BulkPartial    = "partial" // some items failed
+	BulkFailed     = "failed"  // rolled back, or stopped by an error
+	BulkOK         = "ok"
+	BulkRolledBack = "rolled_back"
+	BulkSkipped    = "skipped"
+)
+
+// BulkItemResult is the outcome of one operation on one file or folder
+type BulkItemResult struct {
+	Operation int    `json:"operation"` // Index in the request's operations
+	Action    string `json:"action"`
+	Type      string `json:"type"` // file or folder
+	ID        int    `json:"id"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// BulkJob is a batch of bulk operations, kept as the record of who changed
+// what
+type BulkJob struct {
+	ID         int              `json:"id"`
+	UserID     int              `json:"user_id"`
+	Username   string           `json:"username,omitempty"`
+	Operations []BulkOperation  `json:"operations"`
+	Atomic     bool             `json:"atomic"`
+	Status     string           `json:"status"`
+	Total      int              `json:"total"`
+	Done       int              `json:"done"`
+	Succeeded  int              `json:"succeeded"`
+	Failed     int              `json:"failed"`
+	Error      string           `json:"error,omitempty"`
+	Results    []BulkItemResult `json:"results,omitempty"`
+	CreatedAt  time.Time        `json:"created_at"`
+	FinishedAt *time.Time       `json:"finished_at,omitempty"`
+}
+
 type FileUploadRequest struct {
 	FolderID *int     `json:"folder_id"`
 	IsPublic bool     `json:"is_public"`
@@ -126,19 +385,33 @@ type FileUploadRequest struct {
 }
 
 type FileSearchRequest struct {
-	Query     string   `json:"query"`
-	MimeType  string   `json:"mime_type"`
-	MinSize   *int64   `json:"min_size"`
-	MaxSize   *int64   `json:"max_size"`
-	StartDate string   `json:"start_date"`
-	EndDate   string   `json:"end_date"`
-	Tags      []string `json:"tags"`
-	Uploader  string   `json:"uploader"`
-	FolderID  *int     `json:"folder_id"`
-	Page      int      `json:"page"`
-	Limit     int      `json:"limit"`
-	SortBy    string   `json:"sort_by"`
-	SortOrder string   `json:"sort_order"`
+	Query string `json:"query" form:"query"`
+	// MimeType is an exact type, or a family such as "image/*"
+	MimeType  string `json:"mime_type" form:"mime_type"`
+	MinSize   *int64 `json:"min_size" form:"min_size"`
+	MaxSize   *int64 `json:"max_size" form:"max_size"`
+	StartDate string `json:"start_date" form:"start_date"`
+	EndDate   string `json:"end_date" form:"end_date"`
+	// DateRange is an upload date range relative to when the search runs,
+	// such as "last 30 days" or "last quarter"
+	DateRange string   `json:"date_range" form:"date_range"`
+	Tags      []string `json:"tags" form:"tags"`
+	Uploader  string   `json:"uploader" form:"uploader"`   // part of a username
+	Username  string   `json:"username" form:"username"`   // a whole username
+	FolderID  *int     `json:"folder_id" form:"folder_id"` // 0 for files outside any folder
+	Page      int      `json:"page" form:"page"`
+	Limit     int      `json:"limit" form:"limit"`
+	// SortBy is a comma-separated list of sort keys, each descending when
+	// prefixed with "-" and otherwise in SortOrder
+	SortBy    string `json:"sort_by" form:"sort_by"`
+	SortOrder string `json:"sort_order" form:"sort_order"`
+	// Cursor continues a listing after the page it came from, in place of Page
+	Cursor string `json:"cursor" form:"cursor"`
+	// Facets names the facets to count matches by
+	Facets []string `json:"facets" form:"facets"`
+	// Metadata filters by custom metadata, each entry a condition such as
+	// "project=AX-12", "samples>=20", "instrument~mass" or just "sample_id"
+	Metadata []string `json:"metadata" form:"metadata"`
 }
 
 type StorageStats struct {
@@ -173,6 +446,67 @@ type ShareFolderRequest struct {
 	IsPublic   bool   `json:"is_public"`
 }
 
+type MultipartUpload struct {
+	ID          int       `json:"id" db:"id"`
+	UploadID    string    `json:"upload_id" db:"upload_id"`
+	UserID      int       `json:"user_id" db:"user_id"`
+	FolderID    int       `json:"folder_id" db:"folder_id"`
+	ObjectKey   string    `json:"object_key" db:"object_key"`
+	ContentType string    `json:"content_type" db:"content_type"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+type MultipartPart struct {
+	PartNumber int       `json:"part_number" db:"part_number"`
+	ETag       string    `json:"etag" db:"etag"`
+	Size       int64     `json:"size" db:"size"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+type FolderSearchRequest struct {
+	Search    string
+	IsPublic  *bool
+	SortBy    string
+	SortOrder string
+	Cursor    string
+	Page      int
+	Limit     int // 0 lists every folder
+}
+
+// FolderList is one page of a folder listing
+type FolderList struct {
+	Folders    []Folder `json:"folders"`
+	Total      int      `json:"total"`
+	HasMore    bool     `json:"has_more"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+// SavedSearch is a named file search. Marked as a smart folder it also has
+// a folder in the folder tree, which lists whatever the search matches.
+type SavedSearch struct {
+	ID        int               `json:"id" db:"id"`
+	UserID    int               `json:"user_id" db:"user_id"`
+	Name      string            `json:"name" db:"name"`
+	Request   FileSearchRequest `json:"request" db:"request"`
+	FolderID  *int              `json:"folder_id,omitempty" db:"folder_id"`
+	CreatedAt time.Time         `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at" db:"updated_at"`
+}
+
+type CreateSavedSearchRequest struct {
+	Name        string            `json:"name" binding:"required,min=1,max=255"`
+	Request     FileSearchRequest `json:"request"`
+	SmartFolder bool              `json:"smart_folder"`
+	ParentID    *int              `json:"parent_id"` // where the smart folder goes
+}
+
+type UpdateSavedSearchRequest struct {
+	Name        *string            `json:"name,omitempty"`
+	Request     *FileSearchRequest `json:"request,omitempty"`
+	SmartFolder *bool              `json:"smart_folder,omitempty"`
+	ParentID    *int               `json:"parent_id,omitempty"`
+}
+
 type FolderStats struct {
 	TotalFolders        int `json:"total_folders"`
 	PublicFolders       int `json:"public_folders"`
@@ -34,3 +34,32 @@ type UserResponse struct {
 	StorageQuotaMB int       `json:"storage_quota_mb"`
 	CreatedAt      time.Time `json:"created_at"`
 }
+
+type AccessKey struct {
+	ID          int        `json:"id" db:"id"`
+	UserID      int        `json:"user_id" db:"user_id"`
+	AccessKeyID string     `json:"access_key_id" db:"access_key_id"`
+	SecretKey   string     `json:"secret_access_key,omitempty" db:"secret_key"` // Only returned when the key is created
+	Description string     `json:"description" db:"description"`
+	LastUsedAt  *time.Time `json:"last_used_at" db:"last_used_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+}
+
+type CreateAccessKeyRequest struct {
+	Description string `json:"description" binding:"max=255"`
+}
+
+type SSHKey struct {
+	ID          int        `json:"id" db:"id"`
+	UserID      int        `json:"user_id" db:"user_id"`
+	Name        string     `json:"name" db:"name"`
+	PublicKey   string     `json:"public_key" db:"public_key"`
+	Fingerprint string     `json:"fingerprint" db:"fingerprint"`
+	LastUsedAt  *time.Time `json:"last_used_at" db:"last_used_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+}
+
+type CreateSSHKeyRequest struct {
+	Name      string `json:"name" binding:"max=255"`
+	PublicKey string `json:"public_key" binding:"required"`
+}
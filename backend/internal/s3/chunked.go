@@ -0,0 +1,94 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// readChunkedPayload decodes an aws-chunked request body. Each chunk is
+// "<hex-size>[;chunk-signature=<sig>]\r\n<data>\r\n" and the body ends with a
+// zero-sized chunk, optionally followed by trailing checksum headers. When the
+// payload is signed every chunk signature is verified against the previous one,
+// starting from the request signature.
+func readChunkedPayload(body io.Reader, sr *signedRequest, maxSize int64) ([]byte, *apiError) {
+	signed := sr.payloadHash == streamingSignedPayload
+	reader := bufio.NewReader(body)
+	previous := sr.signature
+
+	var payload bytes.Buffer
+	for {
+		header, err := readCRLFLine(reader)
+		if err != nil {
+			return nil, errIncompleteBody
+		}
+
+		sizeField, extension, _ := strings.Cut(header, ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+		if err != nil || size < 0 {
+			return nil, errIncompleteBody
+		}
+		if int64(payload.Len())+size > maxSize {
+			return nil, errEntityTooLarge
+		}
+
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return nil, errIncompleteBody
+		}
+
+		if signed {
+			signature := strings.TrimPrefix(extension, "chunk-signature=")
+			if signature == extension || !verifyChunk(sr, previous, chunk, signature) {
+				return nil, errSignatureDoesNotMatch
+			}
+			previous = signature
+		}
+
+		if size == 0 {
+			break
+		}
+		payload.Write(chunk)
+
+		if line, err := readCRLFLine(reader); err != nil || line != "" {
+			return nil, errIncompleteBody
+		}
+	}
+
+	// Skip trailing headers (e.g. x-amz-checksum-crc32) up to the final empty line
+	for {
+		line, err := readCRLFLine(reader)
+		if err != nil || line == "" {
+			break
+		}
+	}
+
+	return payload.Bytes(), nil
+}
+
+func verifyChunk(sr *signedRequest, previous string, chunk []byte, signature string) bool {
+	digest := sha256.Sum256(chunk)
+	toSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256-PAYLOAD",
+		sr.amzDate,
+		sr.cred.scope(),
+		previous,
+		emptySHA256,
+		hex.EncodeToString(digest[:]),
+	}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(sr.signingKey, toSign))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+func readCRLFLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
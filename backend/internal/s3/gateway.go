@@ -0,0 +1,584 @@
+// Package s3 exposes the vault through a subset of the Amazon S3 REST API so
+// that S3 tooling (rclone, backup agents, the AWS SDKs) can use it directly.
+//
+// Each of a user's top-level folders is a bucket and object keys map onto the
+// folder tree below it, so "reports/2024/q1.pdf" in bucket "work" is the file
+// q1.pdf in folder work/reports/2024. Requests are authenticated with SigV4
+// using access keys issued through the REST API, and every write goes through
+// FileService so deduplication and quotas apply exactly as for web uploads.
+// Only path-style addressing is supported.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"filevault/internal/config"
+	"filevault/internal/logging"
+	"filevault/internal/models"
+	"filevault/internal/services"
+	"filevault/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Gateway struct {
+	fileService      *services.FileService
+	folderService    *services.FolderService
+	accessKeyService *services.AccessKeyService
+	multipartService *services.MultipartService
+	region           string
+	now              func() time.Time
+	logger           *slog.Logger
+	// maxObjectSize matches the per-file limit of the REST upload endpoint
+	maxObjectSize int64
+}
+
+func NewGateway(fileService *services.FileService, folderService *services.FolderService, accessKeyService *services.AccessKeyService, multipartService *services.MultipartService, cfg *config.Config, logger *slog.Logger) *Gateway {
+	return &Gateway{
+		fileService:      fileService,
+		folderService:    folderService,
+		accessKeyService: accessKeyService,
+		multipartService: multipartService,
+		region:           cfg.S3.Region,
+		now:              time.Now,
+		logger:           logger,
+		maxObjectSize:    cfg.Storage.MaxFileSize(),
+	}
+}
+
+// SetClock replaces the clock request signatures are checked against, so
+// requests signed at a fixed time can be verified
+func (g *Gateway) SetClock(now func() time.Time) {
+	g.now = now
+}
+
+// Router returns the handler to serve on the gateway's own listener
+func (g *Gateway) Router() *gin.Engine {
+	r := gin.New()
+	r.Use(gin.Recovery(), logging.Middleware(g.logger))
+	r.Any("/*path", g.serve)
+	return r
+}
+
+func (g *Gateway) serve(c *gin.Context) {
+	// S3 clients look for the request ID under their own header name
+	c.Header("x-amz-request-id", c.GetString("request_id"))
+	c.Header("Server", "FileVault")
+
+	user, auth, apiErr := g.authenticate(c.Request)
+	if apiErr != nil {
+		writeError(c, apiErr)
+		return
+	}
+	c.Set("user_id", user.ID)
+
+	bucketName, key := splitPath(c.Request.URL.Path)
+	query := c.Request.URL.Query()
+	method := c.Request.Method
+
+	if bucketName == "" {
+		if method != http.MethodGet {
+			writeError(c, errMethodNotAllowed)
+			return
+		}
+		g.listBuckets(c, user)
+		return
+	}
+
+	if key == "" {
+		switch {
+		case method == http.MethodGet && query.Has("location"):
+			g.getBucketLocation(c, user, bucketName)
+		case method == http.MethodGet && query.Has("uploads"):
+			g.listMultipartUploads(c, user, bucketName)
+		case method == http.MethodGet:
+			g.listObjects(c, user, bucketName)
+		case method == http.MethodHead:
+			g.headBucket(c, user, bucketName)
+		case method == http.MethodPut:
+			g.createBucket(c, user, bucketName)
+		case method == http.MethodDelete:
+			g.deleteBucket(c, user, bucketName)
+		case method == http.MethodPost && query.Has("delete"):
+			g.deleteObjects(c, user, auth, bucketName)
+		default:
+			writeError(c, errNotImplemented)
+		}
+		return
+	}
+
+	switch {
+	case method == http.MethodGet && query.Has("uploadId"):
+		g.listParts(c, user, bucketName, key)
+	case method == http.MethodGet:
+		g.getObject(c, user, bucketName, key)
+	case method == http.MethodHead:
+		g.headObject(c, user, bucketName, key)
+	case method == http.MethodPut && query.Has("uploadId"):
+		g.uploadPart(c, user, auth, bucketName, key)
+	case method == http.MethodPut && c.GetHeader("X-Amz-Copy-Source") != "":
+		writeError(c, errNotImplemented)
+	case method == http.MethodPut:
+		g.putObject(c, user, auth, bucketName, key)
+	case method == http.MethodPost && query.Has("uploads"):
+		g.createMultipartUpload(c, user, bucketName, key)
+	case method == http.MethodPost && query.Has("uploadId"):
+		g.completeMultipartUpload(c, user, auth, bucketName, key)
+	case method == http.MethodDelete && query.Has("uploadId"):
+		g.abortMultipartUpload(c, user, bucketName, key)
+	case method == http.MethodDelete:
+		g.deleteObject(c, user, bucketName, key)
+	default:
+		writeError(c, errNotImplemented)
+	}
+}
+
+// authenticate verifies the SigV4 signature and returns the key's owner
+func (g *Gateway) authenticate(r *http.Request) (*models.User, *signedRequest, *apiError) {
+	sr, apiErr := parseSignedRequest(r, g.now().UTC(), g.region)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+
+	secretKey, user, err := g.accessKeyService.LookupAccessKey(r.Context(), sr.cred.accessKeyID)
+	if err == sql.ErrNoRows {
+		return nil, nil, errInvalidAccessKeyID
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("S3 access key lookup failed", "error", err)
+		return nil, nil, errInternal
+	}
+
+	if !sr.verify(r, secretKey) {
+		return nil, nil, errSignatureDoesNotMatch
+	}
+
+	return user, sr, nil
+}
+
+// readPayload reads the request body into memory, decoding aws-chunked
+// bodies and checking any payload digest the client signed or sent
+func (g *Gateway) readPayload(c *gin.Context, sr *signedRequest) ([]byte, *apiError) {
+	if c.Request.ContentLength > g.maxObjectSize {
+		return nil, errEntityTooLarge
+	}
+
+	var data []byte
+	switch sr.payloadHash {
+	case streamingSignedPayload, streamingUnsignedTrailer:
+		decoded, apiErr := readChunkedPayload(c.Request.Body, sr, g.maxObjectSize)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		data = decoded
+	default:
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, g.maxObjectSize+1))
+		if err != nil {
+			return nil, errIncompleteBody
+		}
+		if int64(len(body)) > g.maxObjectSize {
+			return nil, errEntityTooLarge
+		}
+		if sr.payloadHash != unsignedPayload {
+			digest := sha256.Sum256(body)
+			if hex.EncodeToString(digest[:]) != sr.payloadHash {
+				return nil, errContentSHA256Mismatch
+			}
+		}
+		data = body
+	}
+
+	if contentMD5 := c.GetHeader("Content-MD5"); contentMD5 != "" {
+		expected, err := base64.StdEncoding.DecodeString(contentMD5)
+		if err != nil || hex.EncodeToString(expected) != utils.CalculateMD5FromData(data) {
+			return nil, errBadDigest
+		}
+	}
+
+	return data, nil
+}
+
+// splitPath turns "/bucket/some/key" into ("bucket", "some/key")
+func splitPath(path string) (string, string) {
+	path = strings.TrimPrefix(path, "/")
+	bucketName, key, _ := strings.Cut(path, "/")
+	return bucketName, key
+}
+
+// splitKey turns an object key into its folder segments and file name
+func splitKey(key string) ([]string, string, *apiError) {
+	if len(key) > 1024 {
+		return nil, "", errKeyTooLong
+	}
+
+	segments := strings.Split(key, "/")
+	name := segments[len(segments)-1]
+	dirs := segments[:len(segments)-1]
+	for _, segment := range dirs {
+		if segment == "" || segment == "." || segment == ".." || len(segment) > 255 {
+			return nil, "", errInvalidObjectName
+		}
+	}
+	if name == "." || name == ".." || len(name) > 255 {
+		return nil, "", errInvalidObjectName
+	}
+
+	return dirs, name, nil
+}
+
+func objectETag(file *models.File) string {
+	if file.HashMD5 != "" {
+		return `"` + file.HashMD5 + `"`
+	}
+	return `"` + file.HashSHA256 + `"`
+}
+
+func contentType(file *models.File) string {
+	if file.MimeType == "" {
+		return "application/octet-stream"
+	}
+	return file.MimeType
+}
+
+func (g *Gateway) lookupBucket(c *gin.Context, user *models.User, bucketName string) *models.Folder {
+	folder, err := g.folderService.GetRootFolderByName(c.Request.Context(), user.ID, bucketName)
+	if err == sql.ErrNoRows {
+		writeError(c, errNoSuchBucket)
+		return nil
+	}
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("S3 bucket lookup failed", "error", err)
+		writeError(c, errInternal)
+		return nil
+	}
+	return folder
+}
+
+// lookupObject resolves a key to the newest file stored at that path
+func (g *Gateway) lookupObject(c *gin.Context, user *models.User, bucketName, key string) *models.File {
+	folder := g.lookupBucket(c, user, bucketName)
+	if folder == nil {
+		return nil
+	}
+
+	dirs, name, apiErr := splitKey(key)
+	if apiErr != nil {
+		writeError(c, apiErr)
+		return nil
+	}
+	if name == "" {
+		writeError(c, errNoSuchKey)
+		return nil
+	}
+
+	folderID, err := g.folderService.ResolvePath(c.Request.Context(), user.ID, folder.ID, dirs, false)
+	if err == nil {
+		var file *models.File
+		file, err = g.fileService.GetFileByName(c.Request.Context(), user.ID, &folderID, name)
+		if err == nil {
+			return file
+		}
+	}
+	if err == sql.ErrNoRows {
+		writeError(c, errNoSuchKey)
+		return nil
+	}
+
+	logging.FromContext(c.Request.Context()).Error("S3 object lookup failed", "error", err)
+	writeError(c, errInternal)
+	return nil
+}
+
+// storeObject writes data to key inside the bucket folder, creating missing
+// folders, and replaces whatever file was previously stored at that key
+func (g *Gateway) storeObject(ctx context.Context, user *models.User, bucketFolder *models.Folder, key string, data []byte) (*models.File, *apiError) {
+	dirs, name, apiErr := splitKey(key)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	folderID, err := g.folderService.ResolvePath(ctx, user.ID, bucketFolder.ID, dirs, true)
+	if err != nil {
+		logging.FromContext(ctx).Error("S3 folder creation failed", "error", err)
+		return nil, errInternal
+	}
+
+	// A trailing slash with no content is a directory marker
+	if name == "" {
+		if len(data) > 0 {
+			return nil, errInvalidObjectName
+		}
+		return nil, nil
+	}
+
+	previous, err := g.fileService.GetFileByName(ctx, user.ID, &folderID, name)
+	if err != nil && err != sql.ErrNoRows {
+		logging.FromContext(ctx).Error("S3 object lookup failed", "error", err)
+		return nil, errInternal
+	}
+
+	file, err := g.fileService.UploadContent(ctx, user.ID, name, data, models.FileUploadRequest{FolderID: &folderID})
+	if errors.Is(err, services.ErrQuotaExceeded) {
+		return nil, errQuotaExceeded
+	}
+	if errors.Is(err, services.ErrSmartFolder) || errors.Is(err, services.ErrFolderNotWritable) {
+		return nil, errAccessDenied
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("S3 upload failed", "error", err)
+		return nil, errInternal
+	}
+
+	if previous != nil {
+		if err := g.fileService.DeleteFile(ctx, previous.ID, user.ID); err != nil {
+			logging.FromContext(ctx).Error("S3 failed to remove replaced file", "file_id", previous.ID, "error", err)
+		}
+	}
+
+	return file, nil
+}
+
+func (g *Gateway) listBuckets(c *gin.Context, user *models.User) {
+	folders, err := g.folderService.GetUserFolders(c.Request.Context(), user.ID)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("S3 list buckets failed", "error", err)
+		writeError(c, errInternal)
+		return
+	}
+
+	result := listAllMyBucketsResult{
+		Xmlns:   s3Namespace,
+		Owner:   owner{ID: user.Username, DisplayName: user.Username},
+		Buckets: []bucket{},
+	}
+	for _, folder := range folders {
+		if folder.ParentID != nil {
+			continue
+		}
+		result.Buckets = append(result.Buckets, bucket{Name: folder.Name, CreationDate: formatTime(folder.CreatedAt)})
+	}
+
+	writeXML(c, http.StatusOK, result)
+}
+
+func (g *Gateway) getBucketLocation(c *gin.Context, user *models.User, bucketName string) {
+	if g.lookupBucket(c, user, bucketName) == nil {
+		return
+	}
+
+	// us-east-1 is reported as an empty constraint, as S3 itself does
+	location := g.region
+	if location == "us-east-1" {
+		location = ""
+	}
+	writeXML(c, http.StatusOK, locationConstraint{Xmlns: s3Namespace, Location: location})
+}
+
+func (g *Gateway) headBucket(c *gin.Context, user *models.User, bucketName string) {
+	if g.lookupBucket(c, user, bucketName) == nil {
+		return
+	}
+	c.Header("x-amz-bucket-region", g.region)
+	c.Status(http.StatusOK)
+}
+
+func (g *Gateway) createBucket(c *gin.Context, user *models.User, bucketName string) {
+	if len(bucketName) > 255 {
+		writeError(c, errInvalidBucketName)
+		return
+	}
+
+	_, err := g.folderService.GetRootFolderByName(c.Request.Context(), user.ID, bucketName)
+	if err == nil {
+		writeError(c, errBucketAlreadyOwnedByYou)
+		return
+	}
+	if err != sql.ErrNoRows {
+		logging.FromContext(c.Request.Context()).Error("S3 bucket lookup failed", "error", err)
+		writeError(c, errInternal)
+		return
+	}
+
+	if _, err := g.folderService.CreateFolder(c.Request.Context(), user.ID, models.CreateFolderRequest{Name: bucketName}); err != nil {
+		logging.FromContext(c.Request.Context()).Error("S3 create bucket failed", "error", err)
+		writeError(c, errInternal)
+		return
+	}
+
+	c.Header("Location", "/"+bucketName)
+	c.Status(http.StatusOK)
+}
+
+func (g *Gateway) deleteBucket(c *gin.Context, user *models.User, bucketName string) {
+	folder := g.lookupBucket(c, user, bucketName)
+	if folder == nil {
+		return
+	}
+
+	// Ownership was checked above, so any refusal means the folder isn't empty
+	if err := g.folderService.DeleteFolder(c.Request.Context(), folder.ID, user.ID); err != nil {
+		writeError(c, errBucketNotEmpty)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (g *Gateway) getObject(c *gin.Context, user *models.User, bucketName, key string) {
+	file := g.lookupObject(c, user, bucketName, key)
+	if file == nil {
+		return
+	}
+
+	data, _, err := g.fileService.DownloadFile(c.Request.Context(), file.ID)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("S3 download failed", "file_id", file.ID, "error", err)
+		writeError(c, errInternal)
+		return
+	}
+
+	query := c.Request.URL.Query()
+	c.Header("ETag", objectETag(file))
+	c.Header("Content-Type", contentType(file))
+	if value := query.Get("response-content-type"); value != "" {
+		c.Header("Content-Type", value)
+	}
+	if value := query.Get("response-content-disposition"); value != "" {
+		c.Header("Content-Disposition", value)
+	}
+	if value := query.Get("response-cache-control"); value != "" {
+		c.Header("Cache-Control", value)
+	}
+
+	// ServeContent takes care of Range and conditional request headers
+	http.ServeContent(c.Writer, c.Request, file.DisplayName, file.UpdatedAt, bytes.NewReader(data))
+}
+
+func (g *Gateway) headObject(c *gin.Context, user *models.User, bucketName, key string) {
+	file := g.lookupObject(c, user, bucketName, key)
+	if file == nil {
+		return
+	}
+
+	c.Header("ETag", objectETag(file))
+	c.Header("Content-Type", contentType(file))
+	c.Header("Last-Modified", file.UpdatedAt.UTC().Format(http.TimeFormat))
+	c.Header("Accept-Ranges", "bytes")
+	c.Header("Content-Length", formatInt(file.FileSize))
+	c.Status(http.StatusOK)
+}
+
+func (g *Gateway) putObject(c *gin.Context, user *models.User, auth *signedRequest, bucketName, key string) {
+	folder := g.lookupBucket(c, user, bucketName)
+	if folder == nil {
+		return
+	}
+
+	data, apiErr := g.readPayload(c, auth)
+	if apiErr != nil {
+		writeError(c, apiErr)
+		return
+	}
+
+	if _, apiErr := g.storeObject(c.Request.Context(), user, folder, key, data); apiErr != nil {
+		writeError(c, apiErr)
+		return
+	}
+
+	c.Header("ETag", `"`+utils.CalculateMD5FromData(data)+`"`)
+	c.Status(http.StatusOK)
+}
+
+func (g *Gateway) deleteObject(c *gin.Context, user *models.User, bucketName, key string) {
+	folder := g.lookupBucket(c, user, bucketName)
+	if folder == nil {
+		return
+	}
+
+	if apiErr := g.removeObject(c.Request.Context(), user, folder, key); apiErr != nil {
+		writeError(c, apiErr)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// removeObject deletes the file stored at key; a missing key is not an error
+func (g *Gateway) removeObject(ctx context.Context, user *models.User, bucketFolder *models.Folder, key string) *apiError {
+	dirs, name, apiErr := splitKey(key)
+	if apiErr != nil {
+		return apiErr
+	}
+	if name == "" {
+		return nil
+	}
+
+	folderID, err := g.folderService.ResolvePath(ctx, user.ID, bucketFolder.ID, dirs, false)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("S3 folder lookup failed", "error", err)
+		return errInternal
+	}
+
+	file, err := g.fileService.GetFileByName(ctx, user.ID, &folderID, name)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("S3 object lookup failed", "error", err)
+		return errInternal
+	}
+
+	if err := g.fileService.DeleteFile(ctx, file.ID, user.ID); err != nil {
+		logging.FromContext(ctx).Error("S3 delete failed", "file_id", file.ID, "error", err)
+		return errInternal
+	}
+
+	return nil
+}
+
+func (g *Gateway) deleteObjects(c *gin.Context, user *models.User, auth *signedRequest, bucketName string) {
+	folder := g.lookupBucket(c, user, bucketName)
+	if folder == nil {
+		return
+	}
+
+	body, apiErr := g.readPayload(c, auth)
+	if apiErr != nil {
+		writeError(c, apiErr)
+		return
+	}
+
+	var req deleteRequest
+	if err := xml.Unmarshal(body, &req); err != nil || len(req.Objects) > 1000 {
+		writeError(c, errMalformedXML)
+		return
+	}
+
+	result := deleteResult{Xmlns: s3Namespace}
+	for _, obj := range req.Objects {
+		if apiErr := g.removeObject(c.Request.Context(), user, folder, obj.Key); apiErr != nil {
+			result.Errors = append(result.Errors, deleteError{Key: obj.Key, Code: apiErr.Code, Message: apiErr.Message})
+			continue
+		}
+		if !req.Quiet {
+			result.Deleted = append(result.Deleted, deletedObject{Key: obj.Key})
+		}
+	}
+
+	writeXML(c, http.StatusOK, result)
+}
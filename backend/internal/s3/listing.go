@@ -0,0 +1,156 @@
+package s3
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"filevault/internal/logging"
+	"filevault/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultMaxKeys = 1000
+
+// listObjects implements both ListObjects (marker based) and ListObjectsV2
+// (continuation token based); they only differ in how paging is expressed
+func (g *Gateway) listObjects(c *gin.Context, user *models.User, bucketName string) {
+	folder := g.lookupBucket(c, user, bucketName)
+	if folder == nil {
+		return
+	}
+
+	query := c.Request.URL.Query()
+	v2 := query.Get("list-type") == "2"
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	encodeKeys := query.Get("encoding-type") == "url"
+
+	maxKeys := defaultMaxKeys
+	if value := query.Get("max-keys"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			writeError(c, errInvalidMaxKeys)
+			return
+		}
+		if parsed < maxKeys {
+			maxKeys = parsed
+		}
+	}
+
+	marker := query.Get("marker")
+	if v2 {
+		marker = query.Get("start-after")
+		if token := query.Get("continuation-token"); token != "" {
+			decoded, err := base64.RawURLEncoding.DecodeString(token)
+			if err != nil {
+				writeError(c, errInvalidContinuationToken)
+				return
+			}
+			marker = string(decoded)
+		}
+	}
+
+	// Without a delimiter every row is one key, so the database can stop early
+	limit := 0
+	if delimiter == "" {
+		limit = maxKeys + 1
+	}
+	files, err := g.fileService.ListFilesByPath(c.Request.Context(), user.ID, folder.ID, prefix, marker, limit)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("S3 list objects failed", "error", err)
+		writeError(c, errInternal)
+		return
+	}
+
+	encode := func(s string) string {
+		if encodeKeys {
+			return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+		}
+		return s
+	}
+
+	result := listBucketResult{
+		Xmlns:     s3Namespace,
+		Name:      bucketName,
+		Prefix:    encode(prefix),
+		MaxKeys:   maxKeys,
+		Delimiter: encode(delimiter),
+	}
+	if encodeKeys {
+		result.EncodingType = "url"
+	}
+
+	// A marker that is itself a common prefix means that whole group was
+	// already returned on the previous page
+	skipPrefix := ""
+	if delimiter != "" && strings.HasSuffix(marker, delimiter) {
+		skipPrefix = marker
+	}
+
+	count := 0
+	last := ""
+	lastPrefix := ""
+	for i := range files {
+		path := files[i].Path
+		if skipPrefix != "" && strings.HasPrefix(path, skipPrefix) {
+			continue
+		}
+
+		groupPrefix := ""
+		if delimiter != "" {
+			if idx := strings.Index(path[len(prefix):], delimiter); idx >= 0 {
+				groupPrefix = path[:len(prefix)+idx+len(delimiter)]
+			}
+		}
+		if groupPrefix != "" && groupPrefix == lastPrefix {
+			continue
+		}
+
+		if count == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		count++
+
+		if groupPrefix != "" {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: encode(groupPrefix)})
+			lastPrefix = groupPrefix
+			last = groupPrefix
+			continue
+		}
+
+		result.Contents = append(result.Contents, object{
+			Key:          encode(path),
+			LastModified: formatTime(files[i].UpdatedAt),
+			ETag:         objectETag(&files[i]),
+			Size:         files[i].FileSize,
+			StorageClass: "STANDARD",
+			Owner:        &owner{ID: user.Username, DisplayName: user.Username},
+		})
+		last = path
+	}
+
+	if v2 {
+		result.KeyCount = &count
+		result.StartAfter = encode(query.Get("start-after"))
+		result.ContinuationToken = query.Get("continuation-token")
+		if result.IsTruncated {
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		}
+	} else {
+		result.Marker = &marker
+		if result.IsTruncated {
+			result.NextMarker = encode(last)
+		}
+	}
+
+	writeXML(c, http.StatusOK, result)
+}
+
+func formatInt(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
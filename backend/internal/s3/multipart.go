@@ -0,0 +1,245 @@
+package s3
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"filevault/internal/logging"
+	"filevault/internal/models"
+	"filevault/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// lookupUpload finds an in-progress upload and checks it targets this key
+func (g *Gateway) lookupUpload(c *gin.Context, user *models.User, bucketFolder *models.Folder, key string) *models.MultipartUpload {
+	upload, err := g.multipartService.GetUpload(c.Request.Context(), user.ID, c.Query("uploadId"))
+	if errors.Is(err, services.ErrUploadNotFound) {
+		writeError(c, errNoSuchUpload)
+		return nil
+	}
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("S3 multipart upload lookup failed", "error", err)
+		writeError(c, errInternal)
+		return nil
+	}
+
+	if upload.FolderID != bucketFolder.ID || upload.ObjectKey != key {
+		writeError(c, errNoSuchUpload)
+		return nil
+	}
+
+	return upload
+}
+
+func (g *Gateway) createMultipartUpload(c *gin.Context, user *models.User, bucketName, key string) {
+	folder := g.lookupBucket(c, user, bucketName)
+	if folder == nil {
+		return
+	}
+
+	if _, name, apiErr := splitKey(key); apiErr != nil || name == "" {
+		if apiErr == nil {
+			apiErr = errInvalidObjectName
+		}
+		writeError(c, apiErr)
+		return
+	}
+
+	upload, err := g.multipartService.CreateUpload(c.Request.Context(), user.ID, folder.ID, key, c.GetHeader("Content-Type"))
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("S3 create multipart upload failed", "error", err)
+		writeError(c, errInternal)
+		return
+	}
+
+	writeXML(c, http.StatusOK, initiateMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Bucket:   bucketName,
+		Key:      key,
+		UploadID: upload.UploadID,
+	})
+}
+
+func (g *Gateway) uploadPart(c *gin.Context, user *models.User, auth *signedRequest, bucketName, key string) {
+	folder := g.lookupBucket(c, user, bucketName)
+	if folder == nil {
+		return
+	}
+
+	partNumber, err := strconv.Atoi(c.Query("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > 10000 {
+		writeError(c, errInvalidPartNumber)
+		return
+	}
+
+	upload := g.lookupUpload(c, user, folder, key)
+	if upload == nil {
+		return
+	}
+
+	data, apiErr := g.readPayload(c, auth)
+	if apiErr != nil {
+		writeError(c, apiErr)
+		return
+	}
+
+	etag, err := g.multipartService.PutPart(c.Request.Context(), upload, partNumber, data)
+	if errors.Is(err, services.ErrQuotaExceeded) {
+		writeError(c, errQuotaExceeded)
+		return
+	}
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("S3 upload part failed", "error", err)
+		writeError(c, errInternal)
+		return
+	}
+
+	c.Header("ETag", `"`+etag+`"`)
+	c.Status(http.StatusOK)
+}
+
+func (g *Gateway) completeMultipartUpload(c *gin.Context, user *models.User, auth *signedRequest, bucketName, key string) {
+	folder := g.lookupBucket(c, user, bucketName)
+	if folder == nil {
+		return
+	}
+
+	upload := g.lookupUpload(c, user, folder, key)
+	if upload == nil {
+		return
+	}
+
+	body, apiErr := g.readPayload(c, auth)
+	if apiErr != nil {
+		writeError(c, apiErr)
+		return
+	}
+
+	var req completeMultipartUpload
+	if err := xml.Unmarshal(body, &req); err != nil || len(req.Parts) == 0 {
+		writeError(c, errMalformedXML)
+		return
+	}
+
+	partNumbers := make([]int, len(req.Parts))
+	etags := make([]string, len(req.Parts))
+	for i, p := range req.Parts {
+		partNumbers[i] = p.PartNumber
+		etags[i] = strings.Trim(p.ETag, `"`)
+		if i > 0 && partNumbers[i] <= partNumbers[i-1] {
+			writeError(c, errInvalidPartOrder)
+			return
+		}
+	}
+
+	data, etag, err := g.multipartService.AssembleParts(c.Request.Context(), upload, partNumbers, etags, g.maxObjectSize)
+	switch {
+	case errors.Is(err, services.ErrInvalidPart):
+		writeError(c, errInvalidPart)
+		return
+	case errors.Is(err, services.ErrObjectTooLarge):
+		writeError(c, errEntityTooLarge)
+		return
+	case err != nil:
+		logging.FromContext(c.Request.Context()).Error("S3 assembling multipart upload failed", "error", err)
+		writeError(c, errInternal)
+		return
+	}
+
+	if _, apiErr := g.storeObject(c.Request.Context(), user, folder, key, data); apiErr != nil {
+		writeError(c, apiErr)
+		return
+	}
+
+	if err := g.multipartService.AbortUpload(c.Request.Context(), upload); err != nil {
+		logging.FromContext(c.Request.Context()).Error("S3 failed to discard parts of completed upload", "upload_id", upload.UploadID, "error", err)
+	}
+
+	writeXML(c, http.StatusOK, completeMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Location: "/" + bucketName + "/" + key,
+		Bucket:   bucketName,
+		Key:      key,
+		ETag:     `"` + etag + `"`,
+	})
+}
+
+func (g *Gateway) abortMultipartUpload(c *gin.Context, user *models.User, bucketName, key string) {
+	folder := g.lookupBucket(c, user, bucketName)
+	if folder == nil {
+		return
+	}
+
+	upload := g.lookupUpload(c, user, folder, key)
+	if upload == nil {
+		return
+	}
+
+	if err := g.multipartService.AbortUpload(c.Request.Context(), upload); err != nil {
+		logging.FromContext(c.Request.Context()).Error("S3 abort multipart upload failed", "error", err)
+		writeError(c, errInternal)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (g *Gateway) listParts(c *gin.Context, user *models.User, bucketName, key string) {
+	folder := g.lookupBucket(c, user, bucketName)
+	if folder == nil {
+		return
+	}
+
+	upload := g.lookupUpload(c, user, folder, key)
+	if upload == nil {
+		return
+	}
+
+	parts, err := g.multipartService.GetParts(c.Request.Context(), upload)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("S3 list parts failed", "error", err)
+		writeError(c, errInternal)
+		return
+	}
+
+	result := listPartsResult{Xmlns: s3Namespace, Bucket: bucketName, Key: key, UploadID: upload.UploadID}
+	for _, p := range parts {
+		result.Parts = append(result.Parts, part{
+			PartNumber:   p.PartNumber,
+			LastModified: formatTime(p.CreatedAt),
+			ETag:         `"` + p.ETag + `"`,
+			Size:         p.Size,
+		})
+	}
+
+	writeXML(c, http.StatusOK, result)
+}
+
+func (g *Gateway) listMultipartUploads(c *gin.Context, user *models.User, bucketName string) {
+	folder := g.lookupBucket(c, user, bucketName)
+	if folder == nil {
+		return
+	}
+
+	uploads, err := g.multipartService.GetUploads(c.Request.Context(), user.ID, folder.ID)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("S3 list multipart uploads failed", "error", err)
+		writeError(c, errInternal)
+		return
+	}
+
+	result := listMultipartUploadsResult{Xmlns: s3Namespace, Bucket: bucketName}
+	for _, u := range uploads {
+		result.Uploads = append(result.Uploads, upload{
+			Key:       u.ObjectKey,
+			UploadID:  u.UploadID,
+			Initiated: formatTime(u.CreatedAt),
+		})
+	}
+
+	writeXML(c, http.StatusOK, result)
+}
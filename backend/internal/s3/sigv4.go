@@ -0,0 +1,284 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	signV4Algorithm          = "AWS4-HMAC-SHA256"
+	unsignedPayload          = "UNSIGNED-PAYLOAD"
+	streamingSignedPayload   = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+	emptySHA256              = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	amzDateFormat            = "20060102T150405Z"
+	maxClockSkew             = 15 * time.Minute
+	maxPresignExpiry         = 7 * 24 * time.Hour
+)
+
+// credential is the parsed Credential component of a SigV4 signature,
+// e.g. AKID/20240101/us-east-1/s3/aws4_request
+type credential struct {
+	accessKeyID string
+	date        string
+	region      string
+	service     string
+}
+
+func (c credential) scope() string {
+	return c.date + "/" + c.region + "/" + c.service + "/aws4_request"
+}
+
+// signedRequest holds everything taken from a request's Authorization header
+// or presigned query string that is needed to verify it
+type signedRequest struct {
+	cred          credential
+	signedHeaders []string
+	signature     string
+	amzDate       string
+	payloadHash   string
+	presigned     bool
+
+	// Filled in once the signature has been verified, so streaming payload
+	// chunks can be checked against the same key
+	signingKey []byte
+}
+
+// parseCredential parses a credential scoped to S3 in the gateway's region
+func parseCredential(value, region string) (credential, bool) {
+	parts := strings.Split(value, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" || parts[0] == "" || parts[2] != region || parts[3] != "s3" {
+		return credential{}, false
+	}
+	return credential{accessKeyID: parts[0], date: parts[1], region: parts[2], service: parts[3]}, true
+}
+
+// parseSignedRequest extracts the signature details from either the
+// Authorization header or the X-Amz-* query parameters of a presigned URL
+func parseSignedRequest(r *http.Request, now time.Time, region string) (*signedRequest, *apiError) {
+	query := r.URL.Query()
+	if query.Get("X-Amz-Algorithm") != "" {
+		return parsePresigned(query, now, region)
+	}
+
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, errAccessDenied
+	}
+	if !strings.HasPrefix(header, signV4Algorithm+" ") {
+		return nil, errSignatureVersionNotSupported
+	}
+
+	sr := &signedRequest{}
+	for _, field := range strings.Split(strings.TrimPrefix(header, signV4Algorithm+" "), ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(field), "=")
+		if !found {
+			return nil, errAuthorizationHeaderMalformed
+		}
+		switch key {
+		case "Credential":
+			cred, ok := parseCredential(value, region)
+			if !ok {
+				return nil, errAuthorizationHeaderMalformed
+			}
+			sr.cred = cred
+		case "SignedHeaders":
+			sr.signedHeaders = strings.Split(value, ";")
+		case "Signature":
+			sr.signature = value
+		}
+	}
+	if sr.cred.accessKeyID == "" || len(sr.signedHeaders) == 0 || sr.signature == "" {
+		return nil, errAuthorizationHeaderMalformed
+	}
+
+	sr.amzDate = r.Header.Get("X-Amz-Date")
+	if sr.amzDate == "" {
+		date, err := http.ParseTime(r.Header.Get("Date"))
+		if err != nil {
+			return nil, errAccessDenied
+		}
+		sr.amzDate = date.UTC().Format(amzDateFormat)
+	}
+	signedAt, err := time.Parse(amzDateFormat, sr.amzDate)
+	if err != nil || !strings.HasPrefix(sr.amzDate, sr.cred.date) {
+		return nil, errAuthorizationHeaderMalformed
+	}
+	if now.Sub(signedAt) > maxClockSkew || signedAt.Sub(now) > maxClockSkew {
+		return nil, errRequestTimeTooSkewed
+	}
+
+	sr.payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+	if sr.payloadHash == "" {
+		return nil, errMissingContentSHA256
+	}
+
+	return sr, nil
+}
+
+func parsePresigned(query url.Values, now time.Time, region string) (*signedRequest, *apiError) {
+	if query.Get("X-Amz-Algorithm") != signV4Algorithm {
+		return nil, errSignatureVersionNotSupported
+	}
+
+	cred, ok := parseCredential(query.Get("X-Amz-Credential"), region)
+	if !ok {
+		return nil, errAuthorizationQueryParametersError
+	}
+
+	sr := &signedRequest{
+		cred:          cred,
+		signedHeaders: strings.Split(query.Get("X-Amz-SignedHeaders"), ";"),
+		signature:     query.Get("X-Amz-Signature"),
+		amzDate:       query.Get("X-Amz-Date"),
+		payloadHash:   unsignedPayload,
+		presigned:     true,
+	}
+	if sr.signature == "" || len(sr.signedHeaders) == 0 || sr.signedHeaders[0] == "" {
+		return nil, errAuthorizationQueryParametersError
+	}
+
+	signedAt, err := time.Parse(amzDateFormat, sr.amzDate)
+	if err != nil || !strings.HasPrefix(sr.amzDate, cred.date) {
+		return nil, errAuthorizationQueryParametersError
+	}
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || expires < 0 || time.Duration(expires)*time.Second > maxPresignExpiry {
+		return nil, errAuthorizationQueryParametersError
+	}
+	if signedAt.Sub(now) > maxClockSkew {
+		return nil, errRequestNotReadyYet
+	}
+	if now.After(signedAt.Add(time.Duration(expires) * time.Second)) {
+		return nil, errExpiredPresignRequest
+	}
+
+	return sr, nil
+}
+
+// verify recomputes the request signature with the given secret and records
+// the signing key for later payload chunk verification
+func (sr *signedRequest) verify(r *http.Request, secretKey string) bool {
+	key := signingKey(secretKey, sr.cred)
+	expected := hex.EncodeToString(hmacSHA256(key, stringToSign(sr.amzDate, sr.cred.scope(), canonicalRequest(r, sr))))
+	if !hmac.Equal([]byte(expected), []byte(sr.signature)) {
+		return false
+	}
+
+	sr.signingKey = key
+	return true
+}
+
+func canonicalRequest(r *http.Request, sr *signedRequest) string {
+	path := r.URL.Path
+	if path == "" {
+		path = "/"
+	}
+
+	var headers strings.Builder
+	for _, name := range sr.signedHeaders {
+		headers.WriteString(name)
+		headers.WriteByte(':')
+		headers.WriteString(canonicalHeaderValue(r, name))
+		headers.WriteByte('\n')
+	}
+
+	return strings.Join([]string{
+		r.Method,
+		uriEncode(path, false),
+		canonicalQuery(r.URL.Query(), sr.presigned),
+		headers.String(),
+		strings.Join(sr.signedHeaders, ";"),
+		sr.payloadHash,
+	}, "\n")
+}
+
+func canonicalHeaderValue(r *http.Request, name string) string {
+	switch name {
+	case "host":
+		return r.Host
+	case "content-length":
+		if r.Header.Get("Content-Length") == "" && r.ContentLength >= 0 {
+			return strconv.FormatInt(r.ContentLength, 10)
+		}
+	}
+
+	values := r.Header.Values(name)
+	trimmed := make([]string, len(values))
+	for i, value := range values {
+		trimmed[i] = strings.Join(strings.Fields(value), " ")
+	}
+	return strings.Join(trimmed, ",")
+}
+
+func canonicalQuery(query url.Values, presigned bool) string {
+	type pair struct{ key, value string }
+	var pairs []pair
+	for key, values := range query {
+		if presigned && key == "X-Amz-Signature" {
+			continue
+		}
+		for _, value := range values {
+			pairs = append(pairs, pair{uriEncode(key, true), uriEncode(value, true)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].key != pairs[j].key {
+			return pairs[i].key < pairs[j].key
+		}
+		return pairs[i].value < pairs[j].value
+	})
+
+	encoded := make([]string, len(pairs))
+	for i, p := range pairs {
+		encoded[i] = p.key + "=" + p.value
+	}
+	return strings.Join(encoded, "&")
+}
+
+func stringToSign(amzDate, scope, canonical string) string {
+	digest := sha256.Sum256([]byte(canonical))
+	return signV4Algorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+}
+
+func signingKey(secretKey string, cred credential) []byte {
+	key := hmacSHA256([]byte("AWS4"+secretKey), cred.date)
+	key = hmacSHA256(key, cred.region)
+	key = hmacSHA256(key, cred.service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// uriEncode applies the SigV4 flavour of percent-encoding, which leaves only
+// unreserved characters (and optionally '/') as they are
+func uriEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&0x0f])
+		}
+	}
+	return b.String()
+}
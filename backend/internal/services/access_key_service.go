@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"filevault/internal/models"
+)
+
+type AccessKeyService struct {
+	db *sql.DB
+}
+
+func NewAccessKeyService(db *sql.DB) *AccessKeyService {
+	return &AccessKeyService{db: db}
+}
+
+const accessKeyAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+
+// CreateAccessKey issues a new S3 access key pair for the user. The secret is
+// only ever returned from this call.
+func (s *AccessKeyService) CreateAccessKey(ctx context.Context, userID int, description string) (*models.AccessKey, error) {
+	idBytes := make([]byte, 18)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, err
+	}
+	var accessKeyID strings.Builder
+	accessKeyID.WriteString("FV")
+	for _, b := range idBytes {
+		accessKeyID.WriteByte(accessKeyAlphabet[int(b)%len(accessKeyAlphabet)])
+	}
+
+	secretBytes := make([]byte, 30)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return nil, err
+	}
+	secretKey := base64.RawURLEncoding.EncodeToString(secretBytes)
+
+	var key models.AccessKey
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO s3_access_keys (user_id, access_key_id, secret_key, description)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, user_id, access_key_id, secret_key, description, last_used_at, created_at`,
+		userID, accessKeyID.String(), secretKey, description).Scan(
+		&key.ID, &key.UserID, &key.AccessKeyID, &key.SecretKey, &key.Description, &key.LastUsedAt, &key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func (s *AccessKeyService) GetAccessKeys(ctx context.Context, userID int) ([]models.AccessKey, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, access_key_id, description, last_used_at, created_at
+		FROM s3_access_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.AccessKey
+	for rows.Next() {
+		var key models.AccessKey
+		err := rows.Scan(&key.ID, &key.UserID, &key.AccessKeyID, &key.Description, &key.LastUsedAt, &key.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (s *AccessKeyService) DeleteAccessKey(ctx context.Context, userID int, accessKeyID string) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM s3_access_keys WHERE user_id = $1 AND access_key_id = $2", userID, accessKeyID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errors.New("access key not found")
+	}
+
+	return nil
+}
+
+// LookupAccessKey returns the secret and owner of an access key so a request
+// signature can be verified, and records that the key was used
+func (s *AccessKeyService) LookupAccessKey(ctx context.Context, accessKeyID string) (string, *models.User, error) {
+	var secretKey string
+	var user models.User
+	err := s.db.QueryRowContext(ctx, `
+		SELECT k.secret_key, u.id, u.username, u.email, u.is_admin, u.storage_quota_mb, u.created_at, u.updated_at
+		FROM s3_access_keys k
+		JOIN users u ON k.user_id = u.id
+		WHERE k.access_key_id = $1`,
+		accessKeyID).Scan(&secretKey, &user.ID, &user.Username, &user.Email, &user.IsAdmin,
+		&user.StorageQuotaMB, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Only touch the row once a minute so busy clients don't turn every read into a write
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE s3_access_keys SET last_used_at = CURRENT_TIMESTAMP
+		WHERE access_key_id = $1 AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - INTERVAL '1 minute')`,
+		accessKeyID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return secretKey, &user, nil
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"filevault/internal/models"
 	"fmt"
@@ -14,131 +15,36 @@ func NewAdminService(db *sql.DB) *AdminService {
 	return &AdminService{db: db}
 }
 
-// GetAllFilesForAdmin returns all files in the system with uploader details
-func (s *AdminService) GetAllFilesForAdmin(page, limit int, search, sortBy, sortOrder string) ([]models.File, int, error) {
-	offset := (page - 1) * limit
-
-	query := `
-		SELECT f.id, f.user_id, f.hash_id, f.original_name, f.display_name, f.folder_id, 
-		       f.is_public, f.download_count, f.created_at, f.updated_at,
-		       fh.hash_sha256, fh.file_size, fh.mime_type, u.username, fo.name as folder_name,
-		       (SELECT COUNT(*) FROM files f2 WHERE f2.hash_id = f.hash_id) as reference_count
-		FROM files f
-		JOIN file_hashes fh ON f.hash_id = fh.id
-		JOIN users u ON f.user_id = u.id
-		LEFT JOIN folders fo ON f.folder_id = fo.id
-		WHERE 1=1`
-
-	args := []interface{}{}
-	argIndex := 1
-
-	// Add search filter
-	if search != "" {
-		query += fmt.Sprintf(" AND (f.original_name ILIKE $%d OR f.display_name ILIKE $%d OR u.username ILIKE $%d)", argIndex, argIndex, argIndex)
-		args = append(args, "%"+search+"%", "%"+search+"%", "%"+search+"%")
-		argIndex++
-	}
-
-	// Add sorting
-	orderBy := "f.created_at"
-	if sortBy != "" {
-		switch sortBy {
-		case "file_size":
-			orderBy = "fh.file_size"
-		case "download_count":
-			orderBy = "f.download_count"
-		case "original_name":
-			orderBy = "f.original_name"
-		case "created_at":
-			orderBy = "f.created_at"
-		case "username":
-			orderBy = "u.username"
-		default:
-			orderBy = "f.created_at"
-		}
-	}
-
-	orderDirection := "DESC"
-	if sortOrder == "asc" {
-		orderDirection = "ASC"
-	}
-
-	query += fmt.Sprintf(" ORDER BY %s %s", orderBy, orderDirection)
-
-	// Add pagination
-	query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", argIndex, argIndex+1)
-	args = append(args, limit, offset)
-
-	rows, err := s.db.Query(query, args...)
-	if err != nil {
-		return nil, 0, err
-	}
-	defer rows.Close()
-
-	var files []models.File
-	for rows.Next() {
-		var file models.File
-		var folderName sql.NullString
-		var referenceCount int
-
-		err := rows.Scan(&file.ID, &file.UserID, &file.HashID, &file.OriginalName, &file.DisplayName,
-			&file.FolderID, &file.IsPublic, &file.DownloadCount, &file.CreatedAt, &file.UpdatedAt,
-			&file.HashSHA256, &file.FileSize, &file.MimeType, &file.Username, &folderName, &referenceCount)
-		if err != nil {
-			return nil, 0, err
-		}
-
-		if folderName.Valid {
-			file.FolderName = folderName.String
-		}
-		file.ReferenceCount = referenceCount
-		file.IsDuplicate = referenceCount > 1
-
-		files = append(files, file)
-	}
-
-	// Get total count
-	countQuery := `
-		SELECT COUNT(*)
-		FROM files f
-		JOIN users u ON f.user_id = u.id
-		WHERE 1=1`
-
-	countArgs := []interface{}{}
-	countArgIndex := 1
-
-	if search != "" {
-		countQuery += fmt.Sprintf(" AND (f.original_name ILIKE $%d OR f.display_name ILIKE $%d OR u.username ILIKE $%d)", countArgIndex, countArgIndex, countArgIndex)
-		countArgs = append(countArgs, "%"+search+"%", "%"+search+"%", "%"+search+"%")
-	}
-
-	var total int
-	err = s.db.QueryRow(countQuery, countArgs...).Scan(&total)
-	if err != nil {
-		return nil, 0, err
+// GetAllFilesForAdmin returns a page of all files in the system with
+// uploader details. req.Query matches file names and uploaders.
+func (s *AdminService) GetAllFilesForAdmin(ctx context.Context, req models.FileSearchRequest) (*models.FileList, error) {
+	var args queryArgs
+	where := "1=1"
+	if req.Query != "" {
+		pattern := args.add("%" + req.Query + "%")
+		where += fmt.Sprintf(" AND (f.original_name ILIKE %[1]s OR f.display_name ILIKE %[1]s OR u.username ILIKE %[1]s)", pattern)
 	}
-
-	return files, total, nil
+	return listFiles(ctx, s.db, where, args, "", req)
 }
 
 // GetSystemStats returns comprehensive system statistics
-func (s *AdminService) GetSystemStats() (*models.SystemStats, error) {
+func (s *AdminService) GetSystemStats(ctx context.Context) (*models.SystemStats, error) {
 	stats := &models.SystemStats{}
 
 	// Total files
-	err := s.db.QueryRow("SELECT COUNT(*) FROM files").Scan(&stats.TotalFiles)
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM files").Scan(&stats.TotalFiles)
 	if err != nil {
 		return nil, err
 	}
 
 	// Total users
-	err = s.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&stats.TotalUsers)
+	err = s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&stats.TotalUsers)
 	if err != nil {
 		return nil, err
 	}
 
 	// Total storage used
-	err = s.db.QueryRow(`
+	err = s.db.QueryRowContext(ctx, `
 		SELECT COALESCE(SUM(fh.file_size), 0)
 		FROM files f
 		JOIN file_hashes fh ON f.hash_id = fh.id
@@ -148,19 +54,19 @@ func (s *AdminService) GetSystemStats() (*models.SystemStats, error) {
 	}
 
 	// Total downloads
-	err = s.db.QueryRow("SELECT COALESCE(SUM(download_count), 0) FROM files").Scan(&stats.TotalDownloads)
+	err = s.db.QueryRowContext(ctx, "SELECT COALESCE(SUM(download_count), 0) FROM files").Scan(&stats.TotalDownloads)
 	if err != nil {
 		return nil, err
 	}
 
 	// Public files
-	err = s.db.QueryRow("SELECT COUNT(*) FROM files WHERE is_public = true").Scan(&stats.PublicFiles)
+	err = s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM files WHERE is_public = true").Scan(&stats.PublicFiles)
 	if err != nil {
 		return nil, err
 	}
 
 	// Deduplication stats - calculate correctly
-	err = s.db.QueryRow(`
+	err = s.db.QueryRowContext(ctx, `
 		WITH file_refs AS (
 			SELECT fh.id, fh.file_size, COUNT(f.id) as reference_count
 			FROM file_hashes fh
@@ -188,7 +94,7 @@ func (s *AdminService) GetSystemStats() (*models.SystemStats, error) {
 }
 
 // GetTopDownloadedFiles returns most downloaded files
-func (s *AdminService) GetTopDownloadedFiles(limit int) ([]models.File, error) {
+func (s *AdminService) GetTopDownloadedFiles(ctx context.Context, limit int) ([]models.File, error) {
 	query := `
 		SELECT f.id, f.user_id, f.hash_id, f.original_name, f.display_name, f.folder_id, 
 		       f.is_public, f.download_count, f.created_at, f.updated_at,
@@ -201,7 +107,7 @@ func (s *AdminService) GetTopDownloadedFiles(limit int) ([]models.File, error) {
 		ORDER BY f.download_count DESC
 		LIMIT $1`
 
-	rows, err := s.db.Query(query, limit)
+	rows, err := s.db.QueryContext(ctx, query, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -230,7 +136,7 @@ func (s *AdminService) GetTopDownloadedFiles(limit int) ([]models.File, error) {
 }
 
 // GetRecentActivity returns recent file uploads and downloads
-func (s *AdminService) GetRecentActivity(limit int) ([]models.Activity, error) {
+func (s *AdminService) GetRecentActivity(ctx context.Context, limit int) ([]models.Activity, error) {
 	query := `
 		SELECT 
 			'upload' as activity_type,
@@ -253,7 +159,7 @@ func (s *AdminService) GetRecentActivity(limit int) ([]models.Activity, error) {
 		ORDER BY activity_date DESC
 		LIMIT $1`
 
-	rows, err := s.db.Query(query, limit)
+	rows, err := s.db.QueryContext(ctx, query, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -273,7 +179,7 @@ func (s *AdminService) GetRecentActivity(limit int) ([]models.Activity, error) {
 }
 
 // GetAllUserStats returns statistics for all users
-func (s *AdminService) GetAllUserStats() ([]models.UserStats, error) {
+func (s *AdminService) GetAllUserStats(ctx context.Context) ([]models.UserStats, error) {
 	query := `
 		SELECT 
 			u.id,
@@ -290,7 +196,7 @@ func (s *AdminService) GetAllUserStats() ([]models.UserStats, error) {
 		GROUP BY u.id, u.username, u.email, u.created_at, u.storage_quota_mb
 		ORDER BY u.created_at DESC`
 
-	rows, err := s.db.Query(query)
+	rows, err := s.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,498 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"filevault/internal/models"
+)
+
+const (
+	// MaxBulkItems caps how many files and folders one batch touches
+	MaxBulkItems = 10000
+	// BulkSyncItems is the largest batch run while the request waits; larger
+	// ones run in the background
+	BulkSyncItems = 100
+	// bulkProgressEvery is how many items a batch runs between progress
+	// reports
+	bulkProgressEvery = 25
+)
+
+var (
+	ErrInvalidBulkOperation  = errors.New("invalid bulk operation")
+	ErrBulkOperationNotFound = errors.New("bulk operation not found")
+	ErrFolderNotEmpty        = errors.New("folder contains files or subfolders")
+	ErrFolderCycle           = errors.New("folder can't move into itself")
+	ErrUserNotFound          = errors.New("user not found")
+)
+
+// bulkItemErrors fail a single item of a batch. Any other error stops the
+// batch.
+var bulkItemErrors = []error{
+	ErrFilesNotFound, ErrFolderNotFound, ErrFolderNotWritable, ErrSmartFolder, ErrParentFolderNotFound,
+	ErrFolderNameTaken, ErrFolderNotEmpty, ErrFolderCycle, ErrUserNotFound,
+}
+
+// errBulkRolledBack rolls back an atomic batch after one of its items failed
+var errBulkRolledBack = errors.New("bulk operation rolled back")
+
+func bulkItemFailed(err error) bool {
+	for _, itemErr := range bulkItemErrors {
+		if errors.Is(err, itemErr) {
+			return true
+		}
+	}
+	return false
+}
+
+// BulkService applies batches of operations to files and folders. Every
+// batch is recorded, so it can be followed while it runs in the background
+// and audited afterwards.
+type BulkService struct {
+	db        *sql.DB
+	uploadDir string
+}
+
+func NewBulkService(db *sql.DB, uploadDir string) *BulkService {
+	return &BulkService{db: db, uploadDir: uploadDir}
+}
+
+// bulkItem is one operation on one file or folder
+type bulkItem struct {
+	op     int
+	folder bool
+	id     int
+}
+
+// bulkItems checks a batch's operations and lists its items in the order
+// they run: operation by operation, each one's files before its folders
+func bulkItems(ops []models.BulkOperation) ([]bulkItem, error) {
+	var items []bulkItem
+	for i, op := range ops {
+		invalid := func(problem string) error {
+			return fmt.Errorf("%w: operation %d %s", ErrInvalidBulkOperation, i, problem)
+		}
+		if len(op.FileIDs)+len(op.FolderIDs) == 0 {
+			return nil, invalid("has no files or folders")
+		}
+		switch op.Action {
+		case models.BulkDelete, models.BulkPublic, models.BulkPrivate:
+		case models.BulkMove:
+			if op.FolderID == nil {
+				return nil, invalid("has no folder_id to move to")
+			}
+		case models.BulkTag:
+			if len(op.FolderIDs) > 0 {
+				return nil, invalid("tags folders, which can't be tagged")
+			}
+			if len(op.Add)+len(op.Remove) == 0 {
+				return nil, invalid("has no tags to add or remove")
+			}
+			if _, err := normalizeTags(append(append([]string{}, op.Add...), op.Remove...)); err != nil {
+				return nil, err
+			}
+		case models.BulkShare:
+			if strings.TrimSpace(op.Username) == "" {
+				return nil, invalid("has no username to share with")
+			}
+		default:
+			return nil, invalid(fmt.Sprintf("has unknown action %q", op.Action))
+		}
+
+		for _, id := range op.FileIDs {
+			items = append(items, bulkItem{op: i, id: id})
+		}
+		for _, id := range op.FolderIDs {
+			items = append(items, bulkItem{op: i, folder: true, id: id})
+		}
+	}
+	if len(items) > MaxBulkItems {
+		return nil, fmt.Errorf("%w: %d files and folders, at most %d", ErrInvalidBulkOperation, len(items), MaxBulkItems)
+	}
+	return items, nil
+}
+
+// Start checks a batch and records it as running, for Run to apply
+func (s *BulkService) Start(ctx context.Context, userID int, req models.BulkRequest) (*models.BulkJob, error) {
+	items, err := bulkItems(req.Operations)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(req.Operations)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &models.BulkJob{
+		UserID:     userID,
+		Operations: req.Operations,
+		Atomic:     req.Atomic,
+		Status:     models.BulkRunning,
+		Total:      len(items),
+	}
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO bulk_operations (user_id, operations, atomic, total)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`,
+		userID, raw, req.Atomic, job.Total).Scan(&job.ID, &job.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Run applies a started batch and records how it went, calling progress
+// every few items. When an item of an atomic batch fails, the items before
+// it are rolled back and those after it skipped. An error other than an
+// item failing stops the batch; it is recorded and returned.
+func (s *BulkService) Run(ctx context.Context, job *models.BulkJob, progress func(job *models.BulkJob)) error {
+	items, err := bulkItems(job.Operations)
+	if err != nil {
+		return s.finish(ctx, job, err)
+	}
+
+	run := &bulkRun{userID: job.UserID, ops: job.Operations, users: make(map[string]int)}
+	job.Results = make([]models.BulkItemResult, 0, len(items))
+	step := func() {
+		job.Done++
+		if job.Done%bulkProgressEvery != 0 || job.Done == job.Total {
+			return
+		}
+		// Progress is only informative; the outcome is recorded by finish
+		s.db.ExecContext(ctx, "UPDATE bulk_operations SET done = $1 WHERE id = $2", job.Done, job.ID)
+		if progress != nil {
+			progress(job)
+		}
+	}
+
+	if job.Atomic {
+		var orphaned []string
+		err = inTx(ctx, s.db, func(tx *sql.Tx) error {
+			for i, item := range items {
+				hash, err := run.apply(ctx, tx, item)
+				if bulkItemFailed(err) {
+					job.Results = append(job.Results, run.result(item, err))
+					for _, skipped := range items[i+1:] {
+						job.Results = append(job.Results, run.result(skipped, nil))
+						job.Results[len(job.Results)-1].Status = models.BulkSkipped
+					}
+					return errBulkRolledBack
+				} else if err != nil {
+					return err
+				}
+				job.Results = append(job.Results, run.result(item, nil))
+				if hash != "" {
+					orphaned = append(orphaned, hash)
+				}
+				step()
+			}
+			return nil
+		})
+		if err != nil {
+			for i := range job.Results {
+				if job.Results[i].Status == models.BulkOK {
+					job.Results[i].Status = models.BulkRolledBack
+				}
+			}
+			if err == errBulkRolledBack {
+				err = nil
+			}
+		} else {
+			for _, hash := range orphaned {
+				os.Remove(legacyBlobPath(s.uploadDir, hash))
+			}
+		}
+		return s.finish(ctx, job, err)
+	}
+
+	for _, item := range items {
+		var hash string
+		err = inTx(ctx, s.db, func(tx *sql.Tx) error {
+			var err error
+			hash, err = run.apply(ctx, tx, item)
+			return err
+		})
+		if err != nil && !bulkItemFailed(err) {
+			break
+		}
+		job.Results = append(job.Results, run.result(item, err))
+		err = nil
+		if hash != "" {
+			os.Remove(legacyBlobPath(s.uploadDir, hash))
+		}
+		step()
+	}
+	return s.finish(ctx, job, err)
+}
+
+// finish totals a batch's results and records its outcome. It is recorded
+// even if the request running the batch has gone.
+func (s *BulkService) finish(ctx context.Context, job *models.BulkJob, runErr error) error {
+	job.Done = len(job.Results)
+	job.Succeeded, job.Failed = 0, 0
+	for _, result := range job.Results {
+		switch result.Status {
+		case models.BulkOK:
+			job.Succeeded++
+		case models.BulkFailed:
+			job.Failed++
+		}
+	}
+	switch {
+	case runErr != nil:
+		job.Status = models.BulkFailed
+		job.Error = runErr.Error()
+	case job.Failed == 0:
+		job.Status = models.BulkCompleted
+	case job.Atomic:
+		job.Status = models.BulkFailed
+	default:
+		job.Status = models.BulkPartial
+	}
+	finishedAt := time.Now()
+	job.FinishedAt = &finishedAt
+
+	results, err := json.Marshal(job.Results)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(context.WithoutCancel(ctx), `
+		UPDATE bulk_operations
+		SET status = $1, done = $2, succeeded = $3, failed = $4, error = $5, results = $6, finished_at = $7
+		WHERE id = $8`,
+		job.Status, job.Done, job.Succeeded, job.Failed, sql.NullString{String: job.Error, Valid: job.Error != ""},
+		results, finishedAt, job.ID)
+	if runErr != nil {
+		return runErr
+	}
+	return err
+}
+
+// bulkRun applies the items of one user's batch
+type bulkRun struct {
+	userID int
+	ops    []models.BulkOperation
+	users  map[string]int // IDs of the users shared with, by username
+}
+
+// result reports an item as done, or failed with err
+func (r *bulkRun) result(item bulkItem, err error) models.BulkItemResult {
+	result := models.BulkItemResult{
+		Operation: item.op,
+		Action:    r.ops[item.op].Action,
+		Type:      "file",
+		ID:        item.id,
+		Status:    models.BulkOK,
+	}
+	if item.folder {
+		result.Type = "folder"
+	}
+	if err != nil {
+		result.Status = models.BulkFailed
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// apply applies one item within tx. Deleting a file may return the hash of
+// a blob it left unreferenced, whose legacy copy on disk goes once tx
+// commits.
+func (r *bulkRun) apply(ctx context.Context, tx *sql.Tx, item bulkItem) (string, error) {
+	op := r.ops[item.op]
+	if item.folder {
+		return "", r.applyFolder(ctx, tx, op, item.id)
+	}
+	return r.applyFile(ctx, tx, op, item.id)
+}
+
+func (r *bulkRun) applyFile(ctx context.Context, tx *sql.Tx, op models.BulkOperation, fileID int) (string, error) {
+	switch op.Action {
+	case models.BulkDelete:
+		hash, err := deleteFileTx(ctx, tx, fileID, func(ownerID int) error {
+			if ownerID != r.userID {
+				return ErrFilesNotFound
+			}
+			return nil
+		})
+		if err == sql.ErrNoRows {
+			return "", ErrFilesNotFound
+		}
+		return hash, err
+	case models.BulkMove:
+		return "", updateFile(ctx, tx, fileID, r.userID, models.UpdateFileRequest{FolderID: op.FolderID})
+	case models.BulkTag:
+		// bulkItems has checked the tags
+		add, _ := normalizeTags(op.Add)
+		remove, _ := normalizeTags(op.Remove)
+		return "", updateFileTags(ctx, tx, r.userID, []int64{int64(fileID)}, add, remove)
+	case models.BulkShare:
+		sharedWith, err := r.shareTarget(ctx, tx, op.Username)
+		if err != nil {
+			return "", err
+		}
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO file_shares (file_id, shared_with_user_id, permission)
+			SELECT id, $2, $3 FROM files WHERE id = $1 AND user_id = $4
+			ON CONFLICT (file_id, shared_with_user_id)
+			DO UPDATE SET permission = EXCLUDED.permission`,
+			fileID, sharedWith, sharePermission(op), r.userID)
+		return "", rowsChanged(res, err, ErrFilesNotFound)
+	case models.BulkPublic, models.BulkPrivate:
+		res, err := tx.ExecContext(ctx, "UPDATE files SET is_public = $1 WHERE id = $2 AND user_id = $3",
+			op.Action == models.BulkPublic, fileID, r.userID)
+		return "", rowsChanged(res, err, ErrFilesNotFound)
+	}
+	return "", fmt.Errorf("%w: unknown action %q", ErrInvalidBulkOperation, op.Action)
+}
+
+func (r *bulkRun) applyFolder(ctx context.Context, tx *sql.Tx, op models.BulkOperation, folderID int) error {
+	switch op.Action {
+	case models.BulkDelete:
+		return deleteEmptyFolder(ctx, tx, r.userID, folderID)
+	case models.BulkMove:
+		return moveFolder(ctx, tx, r.userID, folderID, *op.FolderID, "")
+	case models.BulkShare:
+		sharedWith, err := r.shareTarget(ctx, tx, op.Username)
+		if err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO folder_shares (folder_id, shared_with_user_id, permission)
+			SELECT id, $2, $3 FROM folders WHERE id = $1 AND user_id = $4
+			ON CONFLICT (folder_id, shared_with_user_id)
+			DO UPDATE SET permission = EXCLUDED.permission`,
+			folderID, sharedWith, sharePermission(op), r.userID)
+		return rowsChanged(res, err, ErrFolderNotFound)
+	case models.BulkPublic, models.BulkPrivate:
+		res, err := tx.ExecContext(ctx, "UPDATE folders SET is_public = $1 WHERE id = $2 AND user_id = $3",
+			op.Action == models.BulkPublic, folderID, r.userID)
+		return rowsChanged(res, err, ErrFolderNotFound)
+	}
+	return fmt.Errorf("%w: can't %s a folder", ErrInvalidBulkOperation, op.Action)
+}
+
+// shareTarget returns the ID of the user a share operation shares with
+func (r *bulkRun) shareTarget(ctx context.Context, tx *sql.Tx, username string) (int, error) {
+	username = strings.TrimSpace(username)
+	if id, ok := r.users[username]; ok {
+		return id, nil
+	}
+	var id int
+	err := tx.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1", username).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: %q", ErrUserNotFound, username)
+	} else if err != nil {
+		return 0, err
+	}
+	r.users[username] = id
+	return id, nil
+}
+
+func sharePermission(op models.BulkOperation) string {
+	if op.Permission == "" {
+		return "read"
+	}
+	return op.Permission
+}
+
+// rowsChanged returns notFound if a statement that had to change a row
+// changed none
+func rowsChanged(res sql.Result, err, notFound error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		return notFound
+	}
+	return err
+}
+
+// deleteEmptyFolder deletes one of the user's folders that holds no files
+// or subfolders
+func deleteEmptyFolder(ctx context.Context, tx *sql.Tx, userID, folderID int) error {
+	var inUse bool
+	err := tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM folders WHERE parent_id = $1) OR EXISTS (SELECT 1 FROM files WHERE folder_id = $1)
+		FROM folders WHERE id = $1 AND user_id = $2
+		FOR UPDATE`,
+		folderID, userID).Scan(&inUse)
+	if err == sql.ErrNoRows {
+		return ErrFolderNotFound
+	} else if err != nil {
+		return err
+	}
+	if inUse {
+		return ErrFolderNotEmpty
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM folders WHERE id = $1", folderID)
+	return err
+}
+
+const bulkJobColumns = `
+		SELECT b.id, b.user_id, u.username, b.operations, b.atomic, b.status, b.total, b.done, b.succeeded,
+		       b.failed, COALESCE(b.error, ''), b.created_at, b.finished_at, %s
+		FROM bulk_operations b
+		JOIN users u ON u.id = b.user_id`
+
+func scanBulkJob(scan func(dest ...interface{}) error) (*models.BulkJob, error) {
+	var job models.BulkJob
+	var operations, results []byte
+	err := scan(&job.ID, &job.UserID, &job.Username, &operations, &job.Atomic, &job.Status, &job.Total, &job.Done,
+		&job.Succeeded, &job.Failed, &job.Error, &job.CreatedAt, &job.FinishedAt, &results)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(operations, &job.Operations); err != nil {
+		return nil, err
+	}
+	if results != nil {
+		if err := json.Unmarshal(results, &job.Results); err != nil {
+			return nil, err
+		}
+	}
+	return &job, nil
+}
+
+// GetJob returns one of the user's batches with the result of each item
+func (s *BulkService) GetJob(ctx context.Context, jobID, userID int) (*models.BulkJob, error) {
+	job, err := scanBulkJob(s.db.QueryRowContext(ctx, fmt.Sprintf(bulkJobColumns, "b.results")+`
+		WHERE b.id = $1 AND b.user_id = $2`, jobID, userID).Scan)
+	if err == sql.ErrNoRows {
+		return nil, ErrBulkOperationNotFound
+	}
+	return job, err
+}
+
+// ListJobs lists the most recent batches, without their item results, of a
+// user or, with userID 0, of everyone
+func (s *BulkService) ListJobs(ctx context.Context, userID, limit int) ([]models.BulkJob, error) {
+	var args queryArgs
+	query := fmt.Sprintf(bulkJobColumns, "NULL::jsonb")
+	if userID != 0 {
+		query += " WHERE b.user_id = " + args.add(userID)
+	}
+	query += " ORDER BY b.created_at DESC, b.id DESC LIMIT " + args.add(limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []models.BulkJob{}
+	for rows.Next() {
+		job, err := scanBulkJob(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"slices"
+
+	"filevault/internal/tracing"
+	"filevault/internal/utils"
+
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// minChunkedSize is the smallest blob stored as chunks when chunk-level
+// dedup is on. Smaller blobs would be only a chunk or three, which saves
+// little and costs a row per chunk.
+const minChunkedSize = 4 * utils.MaxChunkSize
+
+// storeChunks splits data into content-defined chunks, stores the ones not
+// stored yet and records them in order as the manifest of blob hashID. It
+// returns the blob's stored size, the sum of its chunks' stored sizes.
+//
+// Chunks are locked in hash order, here and in releaseChunks, so uploads and
+// deletes sharing chunks can't deadlock.
+func storeChunks(ctx context.Context, tx *sql.Tx, hashID int, data []byte, mimeType, compression string) (int64, error) {
+	ctx, span := tracing.Start(ctx, "store_chunks")
+	defer span.End()
+
+	type chunkRef struct {
+		data       []byte
+		refs       int
+		id         int
+		storedSize int64
+	}
+	chunks := utils.SplitChunks(data)
+	refs := make(map[string]*chunkRef, len(chunks))
+	order := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		hash, err := utils.CalculateHashFromData(chunk)
+		if err != nil {
+			return 0, err
+		}
+		order[i] = hash
+		if ref, ok := refs[hash]; ok {
+			ref.refs++
+		} else {
+			refs[hash] = &chunkRef{data: chunk, refs: 1}
+		}
+	}
+	hashes := make([]string, 0, len(refs))
+	for hash := range refs {
+		hashes = append(hashes, hash)
+	}
+	slices.Sort(hashes)
+
+	reused := 0
+	for _, hash := range hashes {
+		ref := refs[hash]
+		err := tx.QueryRowContext(ctx,
+			"UPDATE chunks SET ref_count = ref_count + $2 WHERE hash_sha256 = $1 RETURNING id, stored_size",
+			hash, ref.refs).Scan(&ref.id, &ref.storedSize)
+		if err == nil {
+			reused++
+			continue
+		}
+		if err != sql.ErrNoRows {
+			return 0, err
+		}
+
+		// New chunk; a concurrent upload may store it first, in which case
+		// its row is reused
+		stored, algorithm := utils.CompressBlob(ref.data, mimeType, compression)
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO chunks (hash_sha256, size, stored_size, compression, data, ref_count)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (hash_sha256) DO UPDATE SET ref_count = chunks.ref_count + EXCLUDED.ref_count
+			RETURNING id, stored_size`,
+			hash, len(ref.data), len(stored), algorithm, stored, ref.refs).Scan(&ref.id, &ref.storedSize)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	seqs := make([]int64, len(order))
+	ids := make([]int64, len(order))
+	var storedSize int64
+	for i, hash := range order {
+		seqs[i], ids[i] = int64(i), int64(refs[hash].id)
+		storedSize += refs[hash].storedSize
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO blob_chunks (hash_id, seq, chunk_id)
+		SELECT $1, m.seq, m.chunk_id FROM unnest($2::int[], $3::int[]) AS m(seq, chunk_id)`,
+		hashID, pq.Array(seqs), pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+
+	span.SetAttributes(attribute.Int("chunks.count", len(chunks)), attribute.Int("chunks.unique", len(refs)),
+		attribute.Int("chunks.reused", reused))
+	return storedSize, nil
+}
+
+// releaseChunks drops blob hashID's references to its chunks, deleting its
+// manifest and every chunk no other blob uses any more
+func releaseChunks(ctx context.Context, tx *sql.Tx, hashID int) error {
+	_, err := tx.ExecContext(ctx, `
+		SELECT c.id FROM chunks c
+		WHERE c.id IN (SELECT chunk_id FROM blob_chunks WHERE hash_id = $1)
+		ORDER BY c.hash_sha256
+		FOR UPDATE`, hashID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE chunks c SET ref_count = c.ref_count - m.refs
+		FROM (SELECT chunk_id, COUNT(*) AS refs FROM blob_chunks WHERE hash_id = $1 GROUP BY chunk_id) m
+		WHERE c.id = m.chunk_id
+		RETURNING c.id, c.ref_count`, hashID)
+	if err != nil {
+		return err
+	}
+	var unused []int64
+	for rows.Next() {
+		var id int64
+		var refCount int
+		if err := rows.Scan(&id, &refCount); err != nil {
+			rows.Close()
+			return err
+		}
+		if refCount <= 0 {
+			unused = append(unused, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM blob_chunks WHERE hash_id = $1", hashID); err != nil {
+		return err
+	}
+	if len(unused) > 0 {
+		_, err = tx.ExecContext(ctx, "DELETE FROM chunks WHERE id = ANY($1) AND ref_count <= 0", pq.Array(unused))
+	}
+	return err
+}
+
+// chunkReader streams a chunked blob, loading one chunk at a time and
+// checking each against its hash, so a bad chunk fails the read instead of
+// being served
+type chunkReader struct {
+	ctx context.Context
+	db  *sql.DB
+	ids []int
+	buf []byte
+}
+
+func openChunks(ctx context.Context, db *sql.DB, hashID int) (*chunkReader, error) {
+	rows, err := db.QueryContext(ctx, "SELECT chunk_id FROM blob_chunks WHERE hash_id = $1 ORDER BY seq", hashID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	r := &chunkReader{ctx: ctx, db: db}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		r.ids = append(r.ids, id)
+	}
+	return r, rows.Err()
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if len(r.ids) == 0 {
+			return 0, io.EOF
+		}
+		id := r.ids[0]
+		var stored []byte
+		var compression, hash string
+		err := r.db.QueryRowContext(r.ctx, "SELECT data, compression, hash_sha256 FROM chunks WHERE id = $1", id).
+			Scan(&stored, &compression, &hash)
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("%w: chunk %d is missing", ErrBlobCorrupt, id)
+		} else if err != nil {
+			return 0, err
+		}
+		data, err := utils.DecompressBlob(stored, compression)
+		if err != nil {
+			return 0, fmt.Errorf("%w: chunk %d can't be decompressed", ErrBlobCorrupt, id)
+		}
+		if actual, _ := utils.CalculateHashFromData(data); actual != hash {
+			return 0, fmt.Errorf("%w: chunk %d does not match its hash", ErrBlobCorrupt, id)
+		}
+		r.ids = r.ids[1:]
+		r.buf = data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *chunkReader) Close() error {
+	r.ids, r.buf = nil, nil
+	return nil
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidDateRange is returned for a date range expression that isn't
+// understood
+var ErrInvalidDateRange = errors.New("invalid date range")
+
+// ParseDateRange resolves a relative date range against now. It returns the
+// range's start and its exclusive end, which is zero for ranges running up
+// to now. Understood are "today", "yesterday", "this" or "last" followed by
+// "week", "month", "quarter" or "year", and "last N days", "weeks",
+// "months" or "years". Weeks start on Monday.
+func ParseDateRange(expr string, now time.Time) (time.Time, time.Time, error) {
+	fields := strings.Fields(strings.ToLower(expr))
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch {
+	case len(fields) == 1 && fields[0] == "today":
+		return today, today.AddDate(0, 0, 1), nil
+	case len(fields) == 1 && fields[0] == "yesterday":
+		return today.AddDate(0, 0, -1), today, nil
+	case len(fields) == 2 && (fields[0] == "this" || fields[0] == "last"):
+		start, step, ok := calendarUnit(fields[1], today)
+		if !ok {
+			break
+		}
+		if fields[0] == "last" {
+			return step(start, -1), start, nil
+		}
+		return start, step(start, 1), nil
+	case len(fields) == 3 && fields[0] == "last":
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n <= 0 {
+			break
+		}
+		switch strings.TrimSuffix(fields[2], "s") {
+		case "day":
+			return now.AddDate(0, 0, -n), time.Time{}, nil
+		case "week":
+			return now.AddDate(0, 0, -7*n), time.Time{}, nil
+		case "month":
+			return now.AddDate(0, -n, 0), time.Time{}, nil
+		case "year":
+			return now.AddDate(-n, 0, 0), time.Time{}, nil
+		}
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("%w: %q", ErrInvalidDateRange, expr)
+}
+
+// calendarUnit returns the start of the named unit that today falls in, and
+// how to move a start by whole units
+func calendarUnit(unit string, today time.Time) (time.Time, func(time.Time, int) time.Time, bool) {
+	switch unit {
+	case "week":
+		offset := (int(today.Weekday()) + 6) % 7
+		return today.AddDate(0, 0, -offset), func(t time.Time, n int) time.Time { return t.AddDate(0, 0, 7*n) }, true
+	case "month":
+		start := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location())
+		return start, func(t time.Time, n int) time.Time { return t.AddDate(0, n, 0) }, true
+	case "quarter":
+		month := time.Month((int(today.Month())-1)/3*3 + 1)
+		start := time.Date(today.Year(), month, 1, 0, 0, 0, 0, today.Location())
+		return start, func(t time.Time, n int) time.Time { return t.AddDate(0, 3*n, 0) }, true
+	case "year":
+		start := time.Date(today.Year(), time.January, 1, 0, 0, 0, 0, today.Location())
+		return start, func(t time.Time, n int) time.Time { return t.AddDate(n, 0, 0) }, true
+	}
+	return time.Time{}, nil, false
+}
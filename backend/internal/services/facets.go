@@ -0,0 +1,195 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"filevault/internal/models"
+)
+
+// ErrInvalidFacet is returned for a facet a file listing can't count by
+var ErrInvalidFacet = errors.New("invalid facet")
+
+// facetLimit caps the values returned per facet
+const facetLimit = 20
+
+// sizeBuckets are the ranges the size facet counts files in. A max of 0 is
+// unbounded.
+var sizeBuckets = []struct {
+	value    string
+	min, max int64
+}{
+	{"0-100KB", 0, 100 << 10},
+	{"100KB-1MB", 100 << 10, 1 << 20},
+	{"1MB-10MB", 1 << 20, 10 << 20},
+	{"10MB-100MB", 10 << 20, 100 << 20},
+	{"100MB-1GB", 100 << 20, 1 << 30},
+	{"1GB+", 1 << 30, 0},
+}
+
+// facet is a way of grouping the files of a listing. Each group's filter is
+// the search parameters selecting just it, so picking a facet value is
+// another search with the filter added.
+type facet struct {
+	value  string // the expression grouped by
+	label  string // an expression naming a group, if its value doesn't
+	join   string // joined onto the listing's tables
+	order  string
+	filter func(value string) map[string]string
+}
+
+var facets = map[string]facet{
+	"type": {
+		value: "split_part(fh.mime_type, '/', 1)",
+		order: "3 DESC, 1",
+		filter: func(value string) map[string]string {
+			if value == "" {
+				return nil
+			}
+			return map[string]string{"mime_type": value + "/*"}
+		},
+	},
+	"tag": {
+		value: "ft.tag",
+		join:  " JOIN file_tags ft ON ft.file_id = f.id",
+		order: "3 DESC, 1",
+		filter: func(value string) map[string]string {
+			return map[string]string{"tags": value}
+		},
+	},
+	"uploader": {
+		value: "u.username",
+		order: "3 DESC, 1",
+		filter: func(value string) map[string]string {
+			return map[string]string{"username": value}
+		},
+	},
+	// Files outside any folder are counted under folder 0
+	"folder": {
+		value: "COALESCE(f.folder_id, 0)::text",
+		label: "COALESCE(MAX(fo.name), '')",
+		order: "3 DESC, 2",
+		filter: func(value string) map[string]string {
+			return map[string]string{"folder_id": value}
+		},
+	},
+	"size": {
+		value:  sizeBucketExpr(),
+		order:  "MIN(fh.file_size)",
+		filter: sizeBucketFilter,
+	},
+	// Newest months first
+	"month": {
+		value:  "to_char(f.created_at, 'YYYY-MM')",
+		order:  "1 DESC",
+		filter: monthFilter,
+	},
+}
+
+// sizeBucketExpr names the size bucket of a file
+func sizeBucketExpr() string {
+	var expr strings.Builder
+	expr.WriteString("CASE")
+	for _, b := range sizeBuckets {
+		if b.max == 0 {
+			fmt.Fprintf(&expr, " ELSE '%s'", b.value)
+			break
+		}
+		fmt.Fprintf(&expr, " WHEN fh.file_size < %d THEN '%s'", b.max, b.value)
+	}
+	expr.WriteString(" END")
+	return expr.String()
+}
+
+func sizeBucketFilter(value string) map[string]string {
+	for _, b := range sizeBuckets {
+		if b.value != value {
+			continue
+		}
+		filter := map[string]string{"min_size": strconv.FormatInt(b.min, 10)}
+		if b.max > 0 {
+			filter["max_size"] = strconv.FormatInt(b.max-1, 10)
+		}
+		return filter
+	}
+	return nil
+}
+
+// monthFilter selects a month of uploads. The end date is inclusive, so it
+// is the month's last instant.
+func monthFilter(value string) map[string]string {
+	month, err := time.Parse("2006-01", value)
+	if err != nil {
+		return nil
+	}
+	return map[string]string{
+		"start_date": month.Format("2006-01-02"),
+		"end_date":   month.AddDate(0, 1, 0).Add(-time.Microsecond).Format("2006-01-02T15:04:05.999999"),
+	}
+}
+
+// parseFacets reads the facets a listing asks for, given as repeated or
+// comma-separated names
+func parseFacets(names []string) ([]string, error) {
+	var parsed []string
+	seen := make(map[string]bool)
+	for _, list := range names {
+		for _, name := range strings.Split(list, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" || seen[name] {
+				continue
+			}
+			if _, ok := facets[name]; !ok {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidFacet, name)
+			}
+			seen[name] = true
+			parsed = append(parsed, name)
+		}
+	}
+	return parsed, nil
+}
+
+// countFacets counts the files matching where by each of the named facets.
+// Only the facetLimit largest groups of a facet are returned.
+func countFacets(ctx context.Context, db *sql.DB, names []string, where string, args queryArgs) (map[string][]models.FacetValue, error) {
+	counts := make(map[string][]models.FacetValue, len(names))
+	for _, name := range names {
+		f := facets[name]
+		label := f.label
+		if label == "" {
+			label = "''"
+		}
+		query := fmt.Sprintf("SELECT %s, %s, COUNT(DISTINCT f.id)%s%s WHERE %s GROUP BY 1 ORDER BY %s LIMIT %d",
+			f.value, label, fileListFrom, f.join, where, f.order, facetLimit)
+		values, err := scanFacet(ctx, db, query, args, f)
+		if err != nil {
+			return nil, fmt.Errorf("counting %s facet: %w", name, err)
+		}
+		counts[name] = values
+	}
+	return counts, nil
+}
+
+func scanFacet(ctx context.Context, db *sql.DB, query string, args queryArgs, f facet) ([]models.FacetValue, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []models.FacetValue{}
+	for rows.Next() {
+		var v models.FacetValue
+		if err := rows.Scan(&v.Value, &v.Label, &v.Count); err != nil {
+			return nil, err
+		}
+		v.Filter = f.filter(v.Value)
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -8,21 +9,51 @@ import (
 	"mime/multipart"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"filevault/internal/metrics"
 	"filevault/internal/models"
+	"filevault/internal/tracing"
 	"filevault/internal/utils"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type FileService struct {
-	db        *sql.DB
-	uploadDir string
+	db          *sql.DB
+	uploadDir   string
+	compression string
+	chunking    bool
 }
 
 func NewFileService(db *sql.DB, uploadDir string) *FileService {
-	return &FileService{db: db, uploadDir: uploadDir}
+	return &FileService{db: db, uploadDir: uploadDir, compression: utils.CompressionNone}
+}
+
+// SetCompression sets the algorithm new blobs are compressed with when they
+// compress well, utils.CompressionZstd or utils.CompressionNone. Existing
+// blobs keep whatever they were stored with.
+func (s *FileService) SetCompression(algorithm string) {
+	s.compression = algorithm
+}
+
+// SetChunking turns chunk-level dedup on or off for new blobs. Large blobs
+// are then stored as manifests of content-defined chunks, so files that
+// differ in a few bytes share all but the chunks around the change.
+func (s *FileService) SetChunking(enabled bool) {
+	s.chunking = enabled
 }
 
-func (s *FileService) UploadFile(userID int, fileHeader *multipart.FileHeader, req models.FileUploadRequest) (*models.File, error) {
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// ErrBlobCorrupt is returned instead of the content of a file whose blob the
+// scrubber found not to match its hash, so bad bytes are never served
+var ErrBlobCorrupt = errors.New("file content failed its integrity check")
+
+func (s *FileService) UploadFile(ctx context.Context, userID int, fileHeader *multipart.FileHeader, req models.FileUploadRequest) (*models.File, error) {
+	ctx, span := tracing.Start(ctx, "FileService.UploadFile")
+	defer span.End()
+
 	// Open uploaded file
 	file, err := fileHeader.Open()
 	if err != nil {
@@ -36,9 +67,23 @@ func (s *FileService) UploadFile(userID int, fileHeader *multipart.FileHeader, r
 		return nil, err
 	}
 
+	return s.UploadContent(ctx, userID, fileHeader.Filename, fileData, req)
+}
+
+// UploadContent stores already-read file data under the given name, applying
+// the same deduplication and quota rules as a multipart upload. The folder,
+// if any, must be one the user can write to and not a smart folder.
+func (s *FileService) UploadContent(ctx context.Context, userID int, filename string, fileData []byte, req models.FileUploadRequest) (*models.File, error) {
+	ctx, span := tracing.Start(ctx, "FileService.UploadContent",
+		attribute.Int("user.id", userID), attribute.Int("file.size", len(fileData)))
+	defer span.End()
+
 	// Calculate file hash from data
+	_, hashSpan := tracing.Start(ctx, "hash")
 	hash, err := utils.CalculateHashFromData(fileData)
+	hashSpan.End()
 	if err != nil {
+		tracing.RecordError(span, err)
 		return nil, err
 	}
 
@@ -48,212 +93,200 @@ func (s *FileService) UploadFile(userID int, fileHeader *multipart.FileHeader, r
 	// Detect MIME type from data
 	actualMimeType := utils.DetectMimeTypeFromData(fileData)
 
-	// Check if file already exists (deduplication)
-	var hashID int
-	var existingHash models.FileHash
+	// Everything below commits or rolls back together, so a rejected upload
+	// leaves no blob behind
+	var fileRecord models.File
 	var isNewFile bool
-	err = s.db.QueryRow(`
-		SELECT id, hash_sha256, file_size, mime_type, created_at 
-		FROM file_hashes WHERE hash_sha256 = $1`,
-		hash).Scan(&existingHash.ID, &existingHash.HashSHA256, &existingHash.FileSize, &existingHash.MimeType, &existingHash.CreatedAt)
-
-	if err == sql.ErrNoRows {
-		// File doesn't exist, create new hash record with file data
-		isNewFile = true
-		err = s.db.QueryRow(`
-			INSERT INTO file_hashes (hash_sha256, file_size, mime_type, file_data) 
-			VALUES ($1, $2, $3, $4) RETURNING id`,
-			hash, fileSize, actualMimeType, fileData).Scan(&hashID)
+	err = inTx(ctx, s.db, func(tx *sql.Tx) error {
+		// Lock the user first so concurrent uploads by the same user see each
+		// other's usage when checking the quota
+		var quotaMB int64
+		err := tx.QueryRowContext(ctx, "SELECT storage_quota_mb FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&quotaMB)
 		if err != nil {
-			return nil, err
+			return err
 		}
-	} else if err != nil {
-		return nil, err
-	} else {
-		// File exists, use existing hash
-		hashID = existingHash.ID
-		isNewFile = false
-	}
-
-	// Check storage quota only for new files (not deduplicated)
-	if isNewFile {
-		var currentUsage int64
-		err = s.db.QueryRow(`
-			SELECT COALESCE(SUM(fh.file_size), 0) 
-			FROM files f 
-			JOIN file_hashes fh ON f.hash_id = fh.id 
-			WHERE f.user_id = $1`,
-			userID).Scan(&currentUsage)
 
-		if err != nil {
-			return nil, err
+		var folderID *int
+		if req.FolderID != nil {
+			if folderID, err = targetFolder(ctx, tx, userID, *req.FolderID); err != nil {
+				return err
+			}
 		}
 
-		var quota int64
-		err = s.db.QueryRow("SELECT storage_quota_mb FROM users WHERE id = $1", userID).Scan(&quota)
+		var hashID int
+		hashID, isNewFile, err = s.storeBlob(ctx, tx, hash, fileData, actualMimeType)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		quota = quota * 1024 * 1024 // Convert MB to bytes
 
-		if currentUsage+fileSize > quota {
-			return nil, errors.New("storage quota exceeded")
+		// Usage is summed over the user's files, so a deduplicated upload
+		// counts as much as new content
+		if err := s.checkQuota(ctx, tx, userID, quotaMB, fileSize); err != nil {
+			return err
 		}
-	}
 
-	// Create file record
-	var fileRecord models.File
-	err = s.db.QueryRow(`
-		INSERT INTO files (user_id, hash_id, original_name, display_name, folder_id, is_public) 
-		VALUES ($1, $2, $3, $4, $5, $6) 
-		RETURNING id, user_id, hash_id, original_name, display_name, folder_id, is_public, download_count, created_at, updated_at`,
-		userID, hashID, fileHeader.Filename, fileHeader.Filename, req.FolderID, req.IsPublic).Scan(
-		&fileRecord.ID, &fileRecord.UserID, &fileRecord.HashID, &fileRecord.OriginalName,
-		&fileRecord.DisplayName, &fileRecord.FolderID, &fileRecord.IsPublic, &fileRecord.DownloadCount,
-		&fileRecord.CreatedAt, &fileRecord.UpdatedAt)
-
-	if err != nil {
-		return nil, err
-	}
+		// Create file record
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO files (user_id, hash_id, original_name, display_name, folder_id, is_public) 
+			VALUES ($1, $2, $3, $4, $5, $6) 
+			RETURNING id, user_id, hash_id, original_name, display_name, folder_id, is_public, download_count, created_at, updated_at`,
+			userID, hashID, filename, filename, folderID, req.IsPublic).Scan(
+			&fileRecord.ID, &fileRecord.UserID, &fileRecord.HashID, &fileRecord.OriginalName,
+			&fileRecord.DisplayName, &fileRecord.FolderID, &fileRecord.IsPublic, &fileRecord.DownloadCount,
+			&fileRecord.CreatedAt, &fileRecord.UpdatedAt)
+		if err != nil {
+			return err
+		}
 
-	// Add tags if provided
-	if len(req.Tags) > 0 {
+		// Add tags if provided
 		for _, tag := range req.Tags {
-			_, err = s.db.Exec("INSERT INTO file_tags (file_id, tag) VALUES ($1, $2) ON CONFLICT DO NOTHING", fileRecord.ID, tag)
+			_, err = tx.ExecContext(ctx, "INSERT INTO file_tags (file_id, tag) VALUES ($1, $2) ON CONFLICT DO NOTHING", fileRecord.ID, tag)
 			if err != nil {
-				return nil, err
+				return err
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	metrics.RecordDedup(!isNewFile)
+	span.SetAttributes(attribute.Bool("dedup.hit", !isNewFile))
+	metrics.UploadedBytes.Add(float64(fileSize))
 	return &fileRecord, nil
 }
 
-func (s *FileService) GetFiles(userID int, searchReq models.FileSearchRequest) ([]models.File, error) {
-	query := `
-		SELECT f.id, f.user_id, f.hash_id, f.original_name, f.display_name, f.folder_id, 
-		       f.is_public, f.download_count, f.created_at, f.updated_at,
-		       fh.hash_sha256, fh.file_size, fh.mime_type, u.username, fo.name as folder_name,
-		       (SELECT COUNT(*) FROM files f2 WHERE f2.hash_id = f.hash_id) as reference_count
-		FROM files f
-		JOIN file_hashes fh ON f.hash_id = fh.id
-		JOIN users u ON f.user_id = u.id
-		LEFT JOIN folders fo ON f.folder_id = fo.id
-		WHERE f.user_id = $1`
-
-	args := []interface{}{userID}
-	argIndex := 2
-
-	// Add search filters with full-text search
-	if searchReq.Query != "" {
-		// Use full-text search for better performance
-		query += fmt.Sprintf(" AND (f.original_name ILIKE $%d OR f.display_name ILIKE $%d OR to_tsvector('english', f.original_name || ' ' || f.display_name) @@ plainto_tsquery('english', $%d))", argIndex, argIndex, argIndex+1)
-		args = append(args, "%"+searchReq.Query+"%", searchReq.Query)
-		argIndex += 2
-	}
-
-	if searchReq.MimeType != "" {
-		query += fmt.Sprintf(" AND fh.mime_type = $%d", argIndex)
-		args = append(args, searchReq.MimeType)
-		argIndex++
-	}
-
-	if searchReq.MinSize != nil {
-		query += fmt.Sprintf(" AND fh.file_size >= $%d", argIndex)
-		args = append(args, *searchReq.MinSize)
-		argIndex++
-	}
-
-	if searchReq.MaxSize != nil {
-		query += fmt.Sprintf(" AND fh.file_size <= $%d", argIndex)
-		args = append(args, *searchReq.MaxSize)
-		argIndex++
-	}
+// storeBlobAttempts bounds how often storeBlob retries when the blob it
+// found is deleted before it could lock it
+const storeBlobAttempts = 3
+
+// storeBlob returns the id of the blob with the given hash, inserting it if
+// it is new. The row is share-locked until tx ends, so a concurrent delete of
+// its last reference waits and then sees the file this upload adds. When two
+// uploads insert the same new hash, the second waits on the unique index and
+// then reuses the first one's row. New content is compressed if it is worth
+// it, or chunked when chunking is on and it is large enough; the hash and
+// file_size always describe the original.
+func (s *FileService) storeBlob(ctx context.Context, tx *sql.Tx, hash string, fileData []byte, mimeType string) (hashID int, isNew bool, err error) {
+	chunked := s.chunking && len(fileData) >= minChunkedSize
+	var stored []byte
+	compression := utils.CompressionNone
+	for attempt := 0; attempt < storeBlobAttempts; attempt++ {
+		lookupCtx, lookupSpan := tracing.Start(ctx, "dedup_lookup")
+		err = tx.QueryRowContext(lookupCtx, "SELECT id FROM file_hashes WHERE hash_sha256 = $1 FOR SHARE", hash).Scan(&hashID)
+		lookupSpan.SetAttributes(attribute.Bool("dedup.hit", err == nil))
+		lookupSpan.End()
+		if err == nil {
+			return hashID, false, nil
+		}
+		if err != sql.ErrNoRows {
+			return 0, false, err
+		}
 
-	if searchReq.StartDate != "" {
-		query += fmt.Sprintf(" AND f.created_at >= $%d", argIndex)
-		args = append(args, searchReq.StartDate)
-		argIndex++
-	}
+		if stored == nil && !chunked {
+			_, compressSpan := tracing.Start(ctx, "compress")
+			stored, compression = utils.CompressBlob(fileData, mimeType, s.compression)
+			compressSpan.SetAttributes(attribute.String("blob.compression", compression), attribute.Int("blob.stored_size", len(stored)))
+			compressSpan.End()
+		}
 
-	if searchReq.EndDate != "" {
-		query += fmt.Sprintf(" AND f.created_at <= $%d", argIndex)
-		args = append(args, searchReq.EndDate)
-		argIndex++
+		// New content; on a conflict another upload stored it first, so look
+		// it up again. Chunked content has no file_data of its own.
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO file_hashes (hash_sha256, hash_md5, file_size, mime_type, file_data, compression, stored_size, chunked) 
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT (hash_sha256) DO NOTHING
+			RETURNING id`,
+			hash, utils.CalculateMD5FromData(fileData), int64(len(fileData)), mimeType, stored, compression, int64(len(stored)), chunked).Scan(&hashID)
+		if err == nil && chunked {
+			storedSize, err := storeChunks(ctx, tx, hashID, fileData, mimeType, s.compression)
+			if err != nil {
+				return 0, false, err
+			}
+			_, err = tx.ExecContext(ctx, "UPDATE file_hashes SET stored_size = $2 WHERE id = $1", hashID, storedSize)
+			if err != nil {
+				return 0, false, err
+			}
+		}
+		if err == nil {
+			return hashID, true, nil
+		}
+		if err != sql.ErrNoRows {
+			return 0, false, err
+		}
 	}
+	return 0, false, fmt.Errorf("blob %s changed concurrently %d times", hash, storeBlobAttempts)
+}
 
-	if searchReq.Uploader != "" {
-		query += fmt.Sprintf(" AND u.username ILIKE $%d", argIndex)
-		args = append(args, "%"+searchReq.Uploader+"%")
-		argIndex++
-	}
+// checkQuota returns ErrQuotaExceeded if storing fileSize more bytes would
+// take the user past their storage quota. The caller holds the user's row
+// lock, so usage can't change underneath it.
+func (s *FileService) checkQuota(ctx context.Context, tx *sql.Tx, userID int, quotaMB, fileSize int64) error {
+	ctx, span := tracing.Start(ctx, "quota_check")
+	defer span.End()
 
-	if len(searchReq.Tags) > 0 {
-		query += fmt.Sprintf(" AND f.id IN (SELECT file_id FROM file_tags WHERE tag = ANY($%d))", argIndex)
-		args = append(args, searchReq.Tags)
-		argIndex++
+	var currentUsage int64
+	err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(fh.file_size), 0) 
+		FROM files f 
+		JOIN file_hashes fh ON f.hash_id = fh.id 
+		WHERE f.user_id = $1`,
+		userID).Scan(&currentUsage)
+	if err != nil {
+		return err
 	}
 
-	if searchReq.FolderID != nil {
-		query += fmt.Sprintf(" AND f.folder_id = $%d", argIndex)
-		args = append(args, *searchReq.FolderID)
-		argIndex++
+	quota := quotaMB * 1024 * 1024 // Convert MB to bytes
+	if currentUsage+fileSize > quota {
+		metrics.QuotaRejections.Inc()
+		tracing.RecordError(span, ErrQuotaExceeded)
+		return ErrQuotaExceeded
 	}
+	return nil
+}
 
-	query += " ORDER BY f.created_at DESC"
-
-	// Add pagination
-	if searchReq.Limit > 0 {
-		query += fmt.Sprintf(" LIMIT $%d", argIndex)
-		args = append(args, searchReq.Limit)
-		argIndex++
-	}
+// GetFiles returns the page of a user's files that searchReq asks for. A
+// Limit of 0 lists every match.
+func (s *FileService) GetFiles(ctx context.Context, userID int, searchReq models.FileSearchRequest) (*models.FileList, error) {
+	ctx, span := tracing.Start(ctx, "FileService.GetFiles")
+	defer span.End()
 
-	if searchReq.Page > 0 {
-		offset := (searchReq.Page - 1) * searchReq.Limit
-		query += fmt.Sprintf(" OFFSET $%d", argIndex)
-		args = append(args, offset)
+	var args queryArgs
+	where := "f.user_id = " + args.add(userID)
+	filters, rank, err := fileFilters(searchReq, &args)
+	if err != nil {
+		return nil, err
 	}
-
-	rows, err := s.db.Query(query, args...)
+	list, err := listFiles(ctx, s.db, where+filters, args, rank, searchReq)
 	if err != nil {
+		tracing.RecordError(span, err)
 		return nil, err
 	}
-	defer rows.Close()
-
-	var files []models.File
-	for rows.Next() {
-		var file models.File
-		var folderName sql.NullString
-		var referenceCount int
-		err := rows.Scan(&file.ID, &file.UserID, &file.HashID, &file.OriginalName, &file.DisplayName,
-			&file.FolderID, &file.IsPublic, &file.DownloadCount, &file.CreatedAt, &file.UpdatedAt,
-			&file.HashSHA256, &file.FileSize, &file.MimeType, &file.Username, &folderName, &referenceCount)
-		if err != nil {
-			return nil, err
-		}
-		if folderName.Valid {
-			file.FolderName = folderName.String
-		}
-		file.ReferenceCount = referenceCount
-		file.IsDuplicate = referenceCount > 1
-		files = append(files, file)
+	if err := s.attachDetails(ctx, list.Files, searchReq.Query); err != nil {
+		return nil, err
 	}
+	return list, nil
+}
 
-	// Load tags for each file
+// attachDetails loads the tags of listed files, and their snippets when
+// they were found by query
+func (s *FileService) attachDetails(ctx context.Context, files []models.File, query string) error {
 	for i := range files {
-		tags, err := s.getFileTags(files[i].ID)
+		tags, err := s.getFileTags(ctx, files[i].ID)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		files[i].Tags = tags
 	}
-
-	return files, nil
+	if query != "" {
+		return s.attachSnippets(ctx, files, query)
+	}
+	return nil
 }
 
-func (s *FileService) getFileTags(fileID int) ([]string, error) {
-	rows, err := s.db.Query("SELECT tag FROM file_tags WHERE file_id = $1", fileID)
+func (s *FileService) getFileTags(ctx context.Context, fileID int) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT tag FROM file_tags WHERE file_id = $1", fileID)
 	if err != nil {
 		return nil, err
 	}
@@ -272,13 +305,17 @@ func (s *FileService) getFileTags(fileID int) ([]string, error) {
 	return tags, nil
 }
 
-func (s *FileService) GetFileByID(fileID int) (*models.File, error) {
+func (s *FileService) GetFileByID(ctx context.Context, fileID int) (*models.File, error) {
+	ctx, span := tracing.Start(ctx, "FileService.GetFileByID")
+	defer span.End()
+
 	var file models.File
 	var folderName sql.NullString
-	err := s.db.QueryRow(`
+	var metadata []byte
+	err := s.db.QueryRowContext(ctx, `
 		SELECT f.id, f.user_id, f.hash_id, f.original_name, f.display_name, f.folder_id, 
 		       f.is_public, f.download_count, f.created_at, f.updated_at,
-		       fh.hash_sha256, fh.file_size, fh.mime_type, u.username, fo.name as folder_name
+		       fh.hash_sha256, fh.file_size, fh.mime_type, u.username, fo.name as folder_name, f.metadata
 		FROM files f
 		JOIN file_hashes fh ON f.hash_id = fh.id
 		JOIN users u ON f.user_id = u.id
@@ -286,18 +323,21 @@ func (s *FileService) GetFileByID(fileID int) (*models.File, error) {
 		WHERE f.id = $1`,
 		fileID).Scan(&file.ID, &file.UserID, &file.HashID, &file.OriginalName, &file.DisplayName,
 		&file.FolderID, &file.IsPublic, &file.DownloadCount, &file.CreatedAt, &file.UpdatedAt,
-		&file.HashSHA256, &file.FileSize, &file.MimeType, &file.Username, &folderName)
+		&file.HashSHA256, &file.FileSize, &file.MimeType, &file.Username, &folderName, &metadata)
 
 	if err != nil {
 		return nil, err
 	}
+	if file.Metadata, err = decodeMetadata(metadata); err != nil {
+		return nil, err
+	}
 
 	if folderName.Valid {
 		file.FolderName = folderName.String
 	}
 
 	// Load tags
-	tags, err := s.getFileTags(file.ID)
+	tags, err := s.getFileTags(ctx, file.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -306,88 +346,339 @@ func (s *FileService) GetFileByID(fileID int) (*models.File, error) {
 	return &file, nil
 }
 
-func (s *FileService) DeleteFile(fileID, userID int) error {
-	// Check if user owns the file
-	var ownerID int
-	err := s.db.QueryRow("SELECT user_id FROM files WHERE id = $1", fileID).Scan(&ownerID)
+func (s *FileService) DeleteFile(ctx context.Context, fileID, userID int) error {
+	ctx, span := tracing.Start(ctx, "FileService.DeleteFile")
+	defer span.End()
+
+	return s.deleteFile(ctx, fileID, func(ownerID int) error {
+		if ownerID != userID {
+			return errors.New("not authorized to delete this file")
+		}
+		return nil
+	})
+}
+
+// deleteFile removes a file record and, if it held the last reference, its
+// blob, in one transaction. authorize sees the owner with the file row locked
+// and may veto the delete.
+func (s *FileService) deleteFile(ctx context.Context, fileID int, authorize func(ownerID int) error) error {
+	var orphanedHash string
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		var err error
+		orphanedHash, err = deleteFileTx(ctx, tx, fileID, authorize)
+		return err
+	})
 	if err != nil {
 		return err
 	}
 
-	if ownerID != userID {
-		return errors.New("not authorized to delete this file")
+	// Legacy blobs live on disk; remove the copy only once the delete has
+	// committed
+	if orphanedHash != "" {
+		os.Remove(legacyBlobPath(s.uploadDir, orphanedHash))
 	}
+	return nil
+}
 
-	// Get hash_id to check reference count
-	var hashID int
-	err = s.db.QueryRow("SELECT hash_id FROM files WHERE id = $1", fileID).Scan(&hashID)
+// deleteFileTx is deleteFile within tx. It returns the hash of a blob left
+// unreferenced, whose legacy copy on disk goes once tx commits.
+func deleteFileTx(ctx context.Context, tx *sql.Tx, fileID int, authorize func(ownerID int) error) (string, error) {
+	var ownerID, hashID int
+	err := tx.QueryRowContext(ctx, "SELECT user_id, hash_id FROM files WHERE id = $1 FOR UPDATE", fileID).Scan(&ownerID, &hashID)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if authorize != nil {
+		if err := authorize(ownerID); err != nil {
+			return "", err
+		}
 	}
 
-	// Delete file record
-	_, err = s.db.Exec("DELETE FROM files WHERE id = $1", fileID)
-	if err != nil {
-		return err
+	if _, err := tx.ExecContext(ctx, "DELETE FROM files WHERE id = $1", fileID); err != nil {
+		return "", err
+	}
+	return deleteBlobIfUnreferenced(ctx, tx, hashID)
+}
+
+// deleteBlobIfUnreferenced deletes a blob no file refers to and returns its
+// hash, or "" if it is still in use or already gone. The blob is locked
+// before its references are counted: uploads share-lock it while adding one,
+// so the count can't miss a file being added.
+func deleteBlobIfUnreferenced(ctx context.Context, tx *sql.Tx, hashID int) (string, error) {
+	var hash string
+	var chunked bool
+	err := tx.QueryRowContext(ctx, "SELECT hash_sha256, chunked FROM file_hashes WHERE id = $1 FOR UPDATE", hashID).Scan(&hash, &chunked)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", err
 	}
 
-	// Check if this was the last reference to the hash
 	var refCount int
-	err = s.db.QueryRow("SELECT COUNT(*) FROM files WHERE hash_id = $1", hashID).Scan(&refCount)
-	if err != nil {
-		return err
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM files WHERE hash_id = $1", hashID).Scan(&refCount)
+	if err != nil || refCount > 0 {
+		return "", err
 	}
 
-	if refCount == 0 {
-		// Get hash to delete physical file
-		var hash string
-		err = s.db.QueryRow("SELECT hash_sha256 FROM file_hashes WHERE id = $1", hashID).Scan(&hash)
-		if err != nil {
-			return err
+	if chunked {
+		if err := releaseChunks(ctx, tx, hashID); err != nil {
+			return "", err
 		}
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM file_hashes WHERE id = $1", hashID); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
 
-		// Delete physical file
-		filePath := filepath.Join(s.uploadDir, hash[:2], hash)
-		os.Remove(filePath)
-
-		// Delete hash record
-		_, err = s.db.Exec("DELETE FROM file_hashes WHERE id = $1", hashID)
+// loadBlob returns a blob's content, or nil if it is missing
+func loadBlob(ctx context.Context, db *sql.DB, uploadDir string, hashID int, hash string) ([]byte, error) {
+	var data []byte
+	var compression string
+	var chunked bool
+	err := db.QueryRowContext(ctx, "SELECT file_data, compression, chunked FROM file_hashes WHERE id = $1", hashID).
+		Scan(&data, &compression, &chunked)
+	if err != nil {
+		return nil, err
+	}
+	if chunked {
+		chunks, err := openChunks(ctx, db, hashID)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		data, err = io.ReadAll(chunks)
+		if errors.Is(err, ErrBlobCorrupt) {
+			return nil, fmt.Errorf("%w: %v", errBlobUnreadable, err)
+		}
+		return data, err
+	}
+	if data == nil && len(hash) > 2 {
+		// Blobs from before content moved into the database live on disk
+		data, _ = os.ReadFile(legacyBlobPath(uploadDir, hash))
+		return data, nil
+	}
+	data, err = utils.DecompressBlob(data, compression)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errBlobUnreadable, err)
 	}
+	return data, nil
+}
 
-	return nil
+// errBlobUnreadable is returned by loadBlob for stored content that can't be
+// decompressed or reassembled, which is as corrupt as content with a wrong
+// hash
+var errBlobUnreadable = errors.New("stored content can't be read back")
+
+// blobProblem describes why data is not the blob recorded with size and
+// hash, or returns "" if it is
+func blobProblem(data []byte, size int64, hash string) string {
+	actualHash, _ := utils.CalculateHashFromData(data)
+	switch {
+	case data == nil:
+		return "missing data"
+	case int64(len(data)) != size:
+		return fmt.Sprintf("size mismatch: expected %d bytes, found %d", size, len(data))
+	case actualHash != hash:
+		return "hash mismatch"
+	}
+	return ""
 }
 
-func (s *FileService) DownloadFile(fileID int) ([]byte, string, error) {
-	// Get file data and info
-	var fileData []byte
-	var originalName string
-	err := s.db.QueryRow(`
-		SELECT fh.file_data, f.original_name 
-		FROM files f 
-		JOIN file_hashes fh ON f.hash_id = fh.id 
-		WHERE f.id = $1`,
-		fileID).Scan(&fileData, &originalName)
+// legacyBlobPath is where blobs from before content moved into the database
+// are kept on disk
+func legacyBlobPath(uploadDir, hash string) string {
+	return filepath.Join(uploadDir, hash[:2], hash)
+}
 
+// Download is a file's content as stored, still compressed if its blob is
+type Download struct {
+	Name string
+	// Size is the length of the original content
+	Size int64
+	// Compression is the algorithm Stored is compressed with
+	Compression string
+	// Stored is the blob as kept in the database, nil for a chunked blob
+	Stored []byte
+
+	// chunks streams a chunked blob
+	chunks *chunkReader
+}
+
+// Open returns a reader of the original content, decompressing or
+// reassembling it as it is read so the whole file is never held
+// uncompressed. A chunked download can only be opened once.
+func (d *Download) Open() (io.ReadCloser, error) {
+	if d.chunks != nil {
+		return d.chunks, nil
+	}
+	return utils.NewBlobReader(d.Stored, d.Compression)
+}
+
+// content reads all of the original content
+func (d *Download) content() ([]byte, error) {
+	if d.chunks != nil {
+		defer d.chunks.Close()
+		return io.ReadAll(d.chunks)
+	}
+	return utils.DecompressBlob(d.Stored, d.Compression)
+}
+
+// OpenDownload counts a download of a file and returns its stored content,
+// leaving decompression to the caller. It returns ErrBlobCorrupt for a blob
+// the scrubber has flagged.
+func (s *FileService) OpenDownload(ctx context.Context, fileID int) (*Download, error) {
+	ctx, span := tracing.Start(ctx, "FileService.OpenDownload")
+	defer span.End()
+
+	download, err := s.storedContent(ctx, fileID)
 	if err != nil {
-		return nil, "", err
+		tracing.RecordError(span, err)
+		return nil, err
 	}
 
 	// Increment download count
-	_, err = s.db.Exec("UPDATE files SET download_count = download_count + 1 WHERE id = $1", fileID)
+	_, err = s.db.ExecContext(ctx, "UPDATE files SET download_count = download_count + 1 WHERE id = $1", fileID)
 	if err != nil {
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.String("blob.compression", download.Compression))
+	metrics.DownloadedBytes.Add(float64(download.Size))
+	return download, nil
+}
+
+func (s *FileService) DownloadFile(ctx context.Context, fileID int) ([]byte, string, error) {
+	ctx, span := tracing.Start(ctx, "FileService.DownloadFile")
+	defer span.End()
+
+	download, err := s.OpenDownload(ctx, fileID)
+	if err != nil {
+		return nil, "", err
+	}
+	fileData, err := download.content()
+	if err != nil {
+		tracing.RecordError(span, err)
 		return nil, "", err
 	}
+	return fileData, download.Name, nil
+}
+
+// GetFileContent returns a file's content without counting it as a download
+func (s *FileService) GetFileContent(ctx context.Context, fileID int) ([]byte, error) {
+	ctx, span := tracing.Start(ctx, "FileService.GetFileContent")
+	defer span.End()
+
+	download, err := s.storedContent(ctx, fileID)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, err
+	}
+	fileData, err := download.content()
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, err
+	}
+	return fileData, nil
+}
+
+func (s *FileService) storedContent(ctx context.Context, fileID int) (*Download, error) {
+	var download Download
+	var hashID int
+	var chunked, corrupt bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT fh.file_data, fh.compression, fh.file_size, f.original_name, fh.corrupt_at IS NOT NULL, fh.id, fh.chunked
+		FROM files f 
+		JOIN file_hashes fh ON f.hash_id = fh.id 
+		WHERE f.id = $1`,
+		fileID).Scan(&download.Stored, &download.Compression, &download.Size, &download.Name, &corrupt, &hashID, &chunked)
+	if err != nil {
+		return nil, err
+	}
+	if corrupt {
+		return nil, ErrBlobCorrupt
+	}
+	if chunked {
+		if download.chunks, err = openChunks(ctx, s.db, hashID); err != nil {
+			return nil, err
+		}
+	}
+	return &download, nil
+}
+
+// CheckBlobs re-hashes every stored blob and reports blobs whose content is
+// missing, corrupt or no longer referenced by any file. Blobs are loaded one
+// at a time so memory use stays bounded.
+func (s *FileService) CheckBlobs(ctx context.Context) (*models.BlobCheckReport, error) {
+	ctx, span := tracing.Start(ctx, "FileService.CheckBlobs")
+	defer span.End()
+
+	type blobRow struct {
+		id         int
+		hash       string
+		size       int64
+		references int
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT fh.id, fh.hash_sha256, fh.file_size,
+		       (SELECT COUNT(*) FROM files f WHERE f.hash_id = fh.id) as reference_count
+		FROM file_hashes fh
+		ORDER BY fh.id`)
+	if err != nil {
+		return nil, err
+	}
+	var blobs []blobRow
+	for rows.Next() {
+		var b blobRow
+		if err := rows.Scan(&b.id, &b.hash, &b.size, &b.references); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		blobs = append(blobs, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &models.BlobCheckReport{Issues: []models.BlobIssue{}}
+	var checkedBytes int64
+	for _, b := range blobs {
+		issue := models.BlobIssue{HashID: b.id, HashSHA256: b.hash, References: b.references}
+
+		data, err := loadBlob(ctx, s.db, s.uploadDir, b.id, b.hash)
+		if errors.Is(err, errBlobUnreadable) {
+			issue.Problem = err.Error()
+		} else if err != nil {
+			return nil, err
+		} else {
+			issue.Problem = blobProblem(data, b.size, b.hash)
+		}
+
+		report.Checked++
+		checkedBytes += int64(len(data))
+
+		if issue.Problem == "" && b.references == 0 {
+			issue.Problem = "unreferenced"
+		}
+		if issue.Problem == "" {
+			continue
+		}
+		report.Issues = append(report.Issues, issue)
+	}
 
-	return fileData, originalName, nil
+	report.IssueCount = len(report.Issues)
+	report.CheckedMB = float64(checkedBytes) / (1024 * 1024)
+	return report, nil
 }
 
-func (s *FileService) ShareFile(fileID, userID int, isPublic bool, sharedUsers []string) error {
+func (s *FileService) ShareFile(ctx context.Context, fileID, userID int, isPublic bool, sharedUsers []string) error {
+	ctx, span := tracing.Start(ctx, "FileService.ShareFile")
+	defer span.End()
+
 	// Check if user owns the file
 	var ownerID int
-	err := s.db.QueryRow("SELECT user_id FROM files WHERE id = $1", fileID).Scan(&ownerID)
+	err := s.db.QueryRowContext(ctx, "SELECT user_id FROM files WHERE id = $1", fileID).Scan(&ownerID)
 	if err != nil {
 		return err
 	}
@@ -397,13 +688,13 @@ func (s *FileService) ShareFile(fileID, userID int, isPublic bool, sharedUsers [
 	}
 
 	// Update file public status
-	_, err = s.db.Exec("UPDATE files SET is_public = $1 WHERE id = $2", isPublic, fileID)
+	_, err = s.db.ExecContext(ctx, "UPDATE files SET is_public = $1 WHERE id = $2", isPublic, fileID)
 	if err != nil {
 		return err
 	}
 
 	// Remove existing shares
-	_, err = s.db.Exec("DELETE FROM file_shares WHERE file_id = $1", fileID)
+	_, err = s.db.ExecContext(ctx, "DELETE FROM file_shares WHERE file_id = $1", fileID)
 	if err != nil {
 		return err
 	}
@@ -412,13 +703,13 @@ func (s *FileService) ShareFile(fileID, userID int, isPublic bool, sharedUsers [
 	for _, username := range sharedUsers {
 		// Get user ID by username
 		var targetUserID int
-		err = s.db.QueryRow("SELECT id FROM users WHERE username = $1", username).Scan(&targetUserID)
+		err = s.db.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1", username).Scan(&targetUserID)
 		if err != nil {
 			continue // Skip invalid usernames
 		}
 
 		// Insert share record
-		_, err = s.db.Exec(`
+		_, err = s.db.ExecContext(ctx, `
 			INSERT INTO file_shares (file_id, shared_with_user_id, permission) 
 			VALUES ($1, $2, 'read')
 			ON CONFLICT (file_id, shared_with_user_id) 
@@ -432,7 +723,189 @@ func (s *FileService) ShareFile(fileID, userID int, isPublic bool, sharedUsers [
 	return nil
 }
 
-func (s *FileService) GetPublicFiles() ([]models.File, error) {
+var (
+	ErrInvalidFileName   = errors.New("invalid file name")
+	ErrFolderNotWritable = errors.New("no write access to folder")
+	ErrSmartFolder       = errors.New("smart folders can't hold files")
+)
+
+// checkFileName rejects a name a file can't have: blank, longer than 255
+// characters, or holding a slash, which folder paths are split on
+func checkFileName(name string) error {
+	if strings.TrimSpace(name) == "" || len(name) > 255 || strings.Contains(name, "/") {
+		return fmt.Errorf("%w: %q", ErrInvalidFileName, name)
+	}
+	return nil
+}
+
+// targetFolder checks that the user may put files in a folder, that is they
+// own it or it is shared with them with write or admin permission, and
+// returns what folder_id becomes. Folder 0 is outside any folder. A folder
+// the user can't see at all is not found.
+func targetFolder(ctx context.Context, tx *sql.Tx, userID, folderID int) (*int, error) {
+	if folderID == 0 {
+		return nil, nil
+	}
+
+	var visible, writable, smart bool
+	err := tx.QueryRowContext(ctx, `
+		SELECT fo.user_id = $2 OR fo.is_public OR fs.permission IS NOT NULL,
+		       fo.user_id = $2 OR COALESCE(fs.permission, '') IN ('write', 'admin'),
+		       fo.saved_search_id IS NOT NULL
+		FROM folders fo
+		LEFT JOIN folder_shares fs ON fs.folder_id = fo.id AND fs.shared_with_user_id = $2
+		WHERE fo.id = $1`,
+		folderID, userID).Scan(&visible, &writable, &smart)
+	if err == sql.ErrNoRows || (err == nil && !visible) {
+		return nil, ErrFolderNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if !writable {
+		return nil, ErrFolderNotWritable
+	}
+	if smart {
+		return nil, ErrSmartFolder
+	}
+	return &folderID, nil
+}
+
+// UpdateFile renames one of the user's files, moves it to another folder,
+// or both, and returns it as it now is
+func (s *FileService) UpdateFile(ctx context.Context, fileID, userID int, req models.UpdateFileRequest) (*models.File, error) {
+	ctx, span := tracing.Start(ctx, "FileService.UpdateFile")
+	defer span.End()
+
+	if req.DisplayName != nil {
+		if err := checkFileName(*req.DisplayName); err != nil {
+			return nil, err
+		}
+	}
+
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		return updateFile(ctx, tx, fileID, userID, req)
+	})
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, err
+	}
+
+	return s.GetFileByID(ctx, fileID)
+}
+
+// updateFile applies an UpdateFileRequest, whose name has been checked,
+// within tx
+func updateFile(ctx context.Context, tx *sql.Tx, fileID, userID int, req models.UpdateFileRequest) error {
+	var id int
+	err := tx.QueryRowContext(ctx, "SELECT id FROM files WHERE id = $1 AND user_id = $2 FOR UPDATE",
+		fileID, userID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return ErrFilesNotFound
+	} else if err != nil {
+		return err
+	}
+
+	var args queryArgs
+	var set []string
+	if req.DisplayName != nil {
+		set = append(set, "display_name = "+args.add(*req.DisplayName))
+	}
+	if req.FolderID != nil {
+		folderID, err := targetFolder(ctx, tx, userID, *req.FolderID)
+		if err != nil {
+			return err
+		}
+		set = append(set, "folder_id = "+args.add(folderID))
+	}
+	if len(set) == 0 {
+		return nil
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE files SET "+strings.Join(set, ", ")+" WHERE id = "+args.add(fileID), args...)
+	return err
+}
+
+// CopyFile copies a file the user can see, their own, a public one or one
+// shared with them, into a folder they can write to. The copy is theirs,
+// with the original's tags and metadata. It shares the original's blob, but
+// counts towards the user's usage like every file they own, so it must fit
+// their quota. The original is share-locked until the copy commits, so
+// deleting it can't take the blob away meanwhile.
+func (s *FileService) CopyFile(ctx context.Context, fileID, userID int, req models.CopyFileRequest) (*models.File, error) {
+	ctx, span := tracing.Start(ctx, "FileService.CopyFile")
+	defer span.End()
+
+	if req.DisplayName != "" {
+		if err := checkFileName(req.DisplayName); err != nil {
+			return nil, err
+		}
+	}
+
+	var copyID int
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		// Lock the user as uploads do, so concurrent copies and uploads see
+		// each other's usage when checking the quota
+		var quotaMB int64
+		err := tx.QueryRowContext(ctx, "SELECT storage_quota_mb FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&quotaMB)
+		if err != nil {
+			return err
+		}
+
+		var hashID int
+		var fileSize int64
+		var originalName, displayName string
+		var metadata []byte
+		err = tx.QueryRowContext(ctx, `
+			SELECT f.hash_id, fh.file_size, f.original_name, f.display_name, f.metadata
+			FROM files f
+			JOIN file_hashes fh ON fh.id = f.hash_id
+			WHERE f.id = $1 AND (f.user_id = $2 OR f.is_public
+				OR EXISTS (SELECT 1 FROM file_shares fs WHERE fs.file_id = f.id AND fs.shared_with_user_id = $2)
+				OR EXISTS (SELECT 1 FROM folder_shares fs WHERE fs.folder_id = f.folder_id AND fs.shared_with_user_id = $2))
+			FOR SHARE OF f`,
+			fileID, userID).Scan(&hashID, &fileSize, &originalName, &displayName, &metadata)
+		if err == sql.ErrNoRows {
+			return ErrFilesNotFound
+		} else if err != nil {
+			return err
+		}
+		if req.DisplayName != "" {
+			displayName = req.DisplayName
+		}
+
+		var folderID *int
+		if req.FolderID != nil {
+			if folderID, err = targetFolder(ctx, tx, userID, *req.FolderID); err != nil {
+				return err
+			}
+		}
+		if err := s.checkQuota(ctx, tx, userID, quotaMB, fileSize); err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO files (user_id, hash_id, original_name, display_name, folder_id, metadata)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id`,
+			userID, hashID, originalName, displayName, folderID, metadata).Scan(&copyID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO file_tags (file_id, tag) SELECT $1, tag FROM file_tags WHERE file_id = $2",
+			copyID, fileID)
+		return err
+	})
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, err
+	}
+
+	return s.GetFileByID(ctx, copyID)
+}
+
+func (s *FileService) GetPublicFiles(ctx context.Context) ([]models.File, error) {
+	ctx, span := tracing.Start(ctx, "FileService.GetPublicFiles")
+	defer span.End()
+
 	query := `
 		SELECT f.id, f.user_id, f.hash_id, f.original_name, f.display_name, f.folder_id, 
 		       f.is_public, f.download_count, f.created_at, f.updated_at,
@@ -444,7 +917,7 @@ func (s *FileService) GetPublicFiles() ([]models.File, error) {
 		WHERE f.is_public = true
 		ORDER BY f.created_at DESC`
 
-	rows, err := s.db.Query(query)
+	rows, err := s.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -469,10 +942,13 @@ func (s *FileService) GetPublicFiles() ([]models.File, error) {
 	return files, nil
 }
 
-func (s *FileService) GetStorageStats(userID int) (map[string]interface{}, error) {
+func (s *FileService) GetStorageStats(ctx context.Context, userID int) (map[string]interface{}, error) {
+	ctx, span := tracing.Start(ctx, "FileService.GetStorageStats")
+	defer span.End()
+
 	// Get total storage used (deduplicated)
 	var totalStorage int64
-	err := s.db.QueryRow(`
+	err := s.db.QueryRowContext(ctx, `
 		SELECT COALESCE(SUM(fh.file_size), 0) 
 		FROM files f 
 		JOIN file_hashes fh ON f.hash_id = fh.id 
@@ -485,7 +961,7 @@ func (s *FileService) GetStorageStats(userID int) (map[string]interface{}, error
 
 	// Get original storage (without deduplication)
 	var originalStorage int64
-	err = s.db.QueryRow(`
+	err = s.db.QueryRowContext(ctx, `
 		SELECT COALESCE(SUM(fh.file_size * (
 			SELECT COUNT(*) FROM files f2 WHERE f2.hash_id = f.hash_id
 		)), 0)
@@ -505,16 +981,33 @@ func (s *FileService) GetStorageStats(userID int) (map[string]interface{}, error
 		savingsPercentage = float64(savings) / float64(originalStorage) * 100
 	}
 
+	// Get what the user's distinct blobs take up once compressed
+	var uniqueStorage, storedStorage int64
+	err = s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(fh.file_size), 0), COALESCE(SUM(fh.stored_size), 0)
+		FROM file_hashes fh
+		WHERE fh.id IN (SELECT hash_id FROM files WHERE user_id = $1)`,
+		userID).Scan(&uniqueStorage, &storedStorage)
+	if err != nil {
+		return nil, err
+	}
+
+	compressionSavings := uniqueStorage - storedStorage
+	compressionSavingsPercentage := float64(0)
+	if uniqueStorage > 0 {
+		compressionSavingsPercentage = float64(compressionSavings) / float64(uniqueStorage) * 100
+	}
+
 	// Get file count
 	var fileCount int
-	err = s.db.QueryRow("SELECT COUNT(*) FROM files WHERE user_id = $1", userID).Scan(&fileCount)
+	err = s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM files WHERE user_id = $1", userID).Scan(&fileCount)
 	if err != nil {
 		return nil, err
 	}
 
 	// Get unique file count (deduplicated)
 	var uniqueFileCount int
-	err = s.db.QueryRow(`
+	err = s.db.QueryRowContext(ctx, `
 		SELECT COUNT(DISTINCT f.hash_id) 
 		FROM files f 
 		WHERE f.user_id = $1`,
@@ -524,19 +1017,25 @@ func (s *FileService) GetStorageStats(userID int) (map[string]interface{}, error
 	}
 
 	return map[string]interface{}{
-		"total_storage_bytes":    totalStorage,
-		"original_storage_bytes": originalStorage,
-		"savings_bytes":          savings,
-		"savings_percentage":     savingsPercentage,
-		"file_count":             fileCount,
-		"unique_file_count":      uniqueFileCount,
-		"deduplication_ratio":    float64(uniqueFileCount) / float64(fileCount),
+		"total_storage_bytes":            totalStorage,
+		"original_storage_bytes":         originalStorage,
+		"savings_bytes":                  savings,
+		"savings_percentage":             savingsPercentage,
+		"file_count":                     fileCount,
+		"unique_file_count":              uniqueFileCount,
+		"deduplication_ratio":            float64(uniqueFileCount) / float64(fileCount),
+		"stored_bytes":                   storedStorage,
+		"compression_savings_bytes":      compressionSavings,
+		"compression_savings_percentage": compressionSavingsPercentage,
 	}, nil
 }
 
-func (s *FileService) GetDeduplicationStats(userID int) (map[string]interface{}, error) {
+func (s *FileService) GetDeduplicationStats(ctx context.Context, userID int) (map[string]interface{}, error) {
+	ctx, span := tracing.Start(ctx, "FileService.GetDeduplicationStats")
+	defer span.End()
+
 	// Get files with reference counts
-	rows, err := s.db.Query(`
+	rows, err := s.db.QueryContext(ctx, `
 		SELECT f.original_name, fh.file_size, fh.mime_type, f.created_at,
 		       (SELECT COUNT(*) FROM files f2 WHERE f2.hash_id = f.hash_id) as reference_count
 		FROM files f 
@@ -576,7 +1075,7 @@ func (s *FileService) GetDeduplicationStats(userID int) (map[string]interface{},
 	var uniqueFiles, totalFiles int
 	var uniqueSize, totalSize int64
 
-	err = s.db.QueryRow(`
+	err = s.db.QueryRowContext(ctx, `
 		WITH user_file_refs AS (
 			SELECT fh.id, fh.file_size, COUNT(f.id) as reference_count
 			FROM file_hashes fh
@@ -603,143 +1102,82 @@ func (s *FileService) GetDeduplicationStats(userID int) (map[string]interface{},
 		savingsPercentage = float64(savings) / float64(totalSize) * 100
 	}
 
-	return map[string]interface{}{
-		"files":              files,
-		"unique_files":       uniqueFiles,
-		"total_files":        totalFiles,
-		"unique_size":        uniqueSize,
-		"total_size":         totalSize,
-		"savings_bytes":      savings,
-		"savings_percentage": savingsPercentage,
-		"deduplication_rate": savingsPercentage, // Same as savings percentage
-	}, nil
-}
-
-func (s *FileService) GlobalSearch(searchReq models.FileSearchRequest) ([]models.File, error) {
-	query := `
-		SELECT f.id, f.user_id, f.hash_id, f.original_name, f.display_name, f.folder_id, 
-		       f.is_public, f.download_count, f.created_at, f.updated_at,
-		       fh.hash_sha256, fh.file_size, fh.mime_type, u.username, fo.name as folder_name
-		FROM files f
-		JOIN file_hashes fh ON f.hash_id = fh.id
-		JOIN users u ON f.user_id = u.id
-		LEFT JOIN folders fo ON f.folder_id = fo.id
-		WHERE 1=1`
-
-	args := []interface{}{}
-	argIndex := 1
-
-	// Add search filters with full-text search
-	if searchReq.Query != "" {
-		query += fmt.Sprintf(" AND (f.original_name ILIKE $%d OR f.display_name ILIKE $%d OR to_tsvector('english', f.original_name || ' ' || f.display_name) @@ plainto_tsquery('english', $%d))", argIndex, argIndex, argIndex+1)
-		args = append(args, "%"+searchReq.Query+"%", searchReq.Query)
-		argIndex += 2
-	}
-
-	if searchReq.MimeType != "" {
-		query += fmt.Sprintf(" AND fh.mime_type = $%d", argIndex)
-		args = append(args, searchReq.MimeType)
-		argIndex++
-	}
-
-	if searchReq.MinSize != nil {
-		query += fmt.Sprintf(" AND fh.file_size >= $%d", argIndex)
-		args = append(args, *searchReq.MinSize)
-		argIndex++
-	}
-
-	if searchReq.MaxSize != nil {
-		query += fmt.Sprintf(" AND fh.file_size <= $%d", argIndex)
-		args = append(args, *searchReq.MaxSize)
-		argIndex++
-	}
-
-	if searchReq.StartDate != "" {
-		query += fmt.Sprintf(" AND f.created_at >= $%d", argIndex)
-		args = append(args, searchReq.StartDate)
-		argIndex++
-	}
-
-	if searchReq.EndDate != "" {
-		query += fmt.Sprintf(" AND f.created_at <= $%d", argIndex)
-		args = append(args, searchReq.EndDate)
-		argIndex++
-	}
-
-	if searchReq.Uploader != "" {
-		query += fmt.Sprintf(" AND u.username ILIKE $%d", argIndex)
-		args = append(args, "%"+searchReq.Uploader+"%")
-		argIndex++
-	}
-
-	if len(searchReq.Tags) > 0 {
-		query += fmt.Sprintf(" AND f.id IN (SELECT file_id FROM file_tags WHERE tag = ANY($%d))", argIndex)
-		args = append(args, searchReq.Tags)
-		argIndex++
+	// Chunk-level savings come on top: chunks shared between the user's
+	// distinct chunked blobs, or repeated within one, are stored once
+	var chunkRefs, uniqueChunks int
+	var chunkReferencedSize, chunkUniqueSize int64
+	err = s.db.QueryRowContext(ctx, `
+		WITH user_chunks AS (
+			SELECT c.id, c.size
+			FROM blob_chunks bc
+			JOIN chunks c ON c.id = bc.chunk_id
+			WHERE bc.hash_id IN (SELECT hash_id FROM files WHERE user_id = $1)
+		)
+		SELECT
+			COUNT(*),
+			COUNT(DISTINCT id),
+			COALESCE(SUM(size), 0),
+			COALESCE((SELECT SUM(size) FROM (SELECT DISTINCT id, size FROM user_chunks) u), 0)
+		FROM user_chunks`,
+		userID).Scan(&chunkRefs, &uniqueChunks, &chunkReferencedSize, &chunkUniqueSize)
+	if err != nil {
+		return nil, err
 	}
 
-	if searchReq.FolderID != nil {
-		query += fmt.Sprintf(" AND f.folder_id = $%d", argIndex)
-		args = append(args, *searchReq.FolderID)
-		argIndex++
+	chunkSavings := chunkReferencedSize - chunkUniqueSize
+	var chunkSavingsPercentage float64
+	if chunkReferencedSize > 0 {
+		chunkSavingsPercentage = float64(chunkSavings) / float64(chunkReferencedSize) * 100
 	}
 
-	query += " ORDER BY f.created_at DESC"
-
-	// Add pagination
-	if searchReq.Limit > 0 {
-		query += fmt.Sprintf(" LIMIT $%d", argIndex)
-		args = append(args, searchReq.Limit)
-		argIndex++
-	}
+	return map[string]interface{}{
+		"files":                    files,
+		"unique_files":             uniqueFiles,
+		"total_files":              totalFiles,
+		"unique_size":              uniqueSize,
+		"total_size":               totalSize,
+		"savings_bytes":            savings,
+		"savings_percentage":       savingsPercentage,
+		"deduplication_rate":       savingsPercentage, // Same as savings percentage
+		"chunk_references":         chunkRefs,
+		"unique_chunks":            uniqueChunks,
+		"chunk_referenced_size":    chunkReferencedSize,
+		"chunk_unique_size":        chunkUniqueSize,
+		"chunk_savings_bytes":      chunkSavings,
+		"chunk_savings_percentage": chunkSavingsPercentage,
+	}, nil
+}
 
-	if searchReq.Page > 0 {
-		offset := (searchReq.Page - 1) * searchReq.Limit
-		query += fmt.Sprintf(" OFFSET $%d", argIndex)
-		args = append(args, offset)
-	}
+func (s *FileService) GlobalSearch(ctx context.Context, searchReq models.FileSearchRequest) (*models.FileList, error) {
+	ctx, span := tracing.Start(ctx, "FileService.GlobalSearch")
+	defer span.End()
 
-	rows, err := s.db.Query(query, args...)
+	var args queryArgs
+	filters, rank, err := fileFilters(searchReq, &args)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var files []models.File
-	for rows.Next() {
-		var file models.File
-		var folderName sql.NullString
-		err := rows.Scan(&file.ID, &file.UserID, &file.HashID, &file.OriginalName, &file.DisplayName,
-			&file.FolderID, &file.IsPublic, &file.DownloadCount, &file.CreatedAt, &file.UpdatedAt,
-			&file.HashSHA256, &file.FileSize, &file.MimeType, &file.Username, &folderName)
-		if err != nil {
-			return nil, err
-		}
-		if folderName.Valid {
-			file.FolderName = folderName.String
-		}
-		files = append(files, file)
+	list, err := listFiles(ctx, s.db, "1=1"+filters, args, rank, searchReq)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, err
 	}
-
-	// Load tags for each file
-	for i := range files {
-		tags, err := s.getFileTags(files[i].ID)
-		if err != nil {
-			return nil, err
-		}
-		files[i].Tags = tags
+	if err := s.attachDetails(ctx, list.Files, searchReq.Query); err != nil {
+		return nil, err
 	}
-
-	return files, nil
+	return list, nil
 }
 
 // GetFileDetailsForAdmin returns detailed information about a specific file
-func (s *FileService) GetFileDetailsForAdmin(fileID int) (*models.File, error) {
+func (s *FileService) GetFileDetailsForAdmin(ctx context.Context, fileID int) (*models.File, error) {
+	ctx, span := tracing.Start(ctx, "FileService.GetFileDetailsForAdmin")
+	defer span.End()
+
 	query := `
 		SELECT f.id, f.user_id, f.hash_id, f.original_name, f.display_name, f.folder_id, 
 		       f.is_public, f.download_count, f.created_at, f.updated_at,
 		       fh.hash_sha256, fh.file_size, fh.mime_type, u.username, u.email, fo.name as folder_name,
-		       (SELECT COUNT(*) FROM files f2 WHERE f2.hash_id = f.hash_id) as reference_count
+		       (SELECT COUNT(*) FROM files f2 WHERE f2.hash_id = f.hash_id) as reference_count, f.metadata
 		FROM files f
 		JOIN file_hashes fh ON f.hash_id = fh.id
 		JOIN users u ON f.user_id = u.id
@@ -749,13 +1187,17 @@ func (s *FileService) GetFileDetailsForAdmin(fileID int) (*models.File, error) {
 	var file models.File
 	var folderName sql.NullString
 	var referenceCount int
+	var metadata []byte
 
-	err := s.db.QueryRow(query, fileID).Scan(&file.ID, &file.UserID, &file.HashID, &file.OriginalName, &file.DisplayName,
+	err := s.db.QueryRowContext(ctx, query, fileID).Scan(&file.ID, &file.UserID, &file.HashID, &file.OriginalName, &file.DisplayName,
 		&file.FolderID, &file.IsPublic, &file.DownloadCount, &file.CreatedAt, &file.UpdatedAt,
-		&file.HashSHA256, &file.FileSize, &file.MimeType, &file.Username, &file.UserEmail, &folderName, &referenceCount)
+		&file.HashSHA256, &file.FileSize, &file.MimeType, &file.Username, &file.UserEmail, &folderName, &referenceCount, &metadata)
 	if err != nil {
 		return nil, err
 	}
+	if file.Metadata, err = decodeMetadata(metadata); err != nil {
+		return nil, err
+	}
 
 	if folderName.Valid {
 		file.FolderName = folderName.String
@@ -764,7 +1206,7 @@ func (s *FileService) GetFileDetailsForAdmin(fileID int) (*models.File, error) {
 	file.IsDuplicate = referenceCount > 1
 
 	// Load tags
-	tags, err := s.getFileTags(file.ID)
+	tags, err := s.getFileTags(ctx, file.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -774,50 +1216,27 @@ func (s *FileService) GetFileDetailsForAdmin(fileID int) (*models.File, error) {
 }
 
 // DeleteFileAsAdmin allows admins to delete any file
-func (s *FileService) DeleteFileAsAdmin(fileID int) error {
-	// Get file details first
-	var hashID int
-	var userID int
-	err := s.db.QueryRow("SELECT hash_id, user_id FROM files WHERE id = $1", fileID).Scan(&hashID, &userID)
-	if err != nil {
-		return err
-	}
-
-	// Delete the file
-	_, err = s.db.Exec("DELETE FROM files WHERE id = $1", fileID)
-	if err != nil {
-		return err
-	}
-
-	// Check if this was the last file using this hash
-	var count int
-	err = s.db.QueryRow("SELECT COUNT(*) FROM files WHERE hash_id = $1", hashID).Scan(&count)
-	if err != nil {
-		return err
-	}
-
-	// If no more files use this hash, delete the hash and file data
-	if count == 0 {
-		_, err = s.db.Exec("DELETE FROM file_hashes WHERE id = $1", hashID)
-		if err != nil {
-			return err
-		}
-	}
+func (s *FileService) DeleteFileAsAdmin(ctx context.Context, fileID int) error {
+	ctx, span := tracing.Start(ctx, "FileService.DeleteFileAsAdmin")
+	defer span.End()
 
-	return nil
+	return s.deleteFile(ctx, fileID, nil)
 }
 
 // ShareFileWithUser allows admins to share files with specific users
-func (s *FileService) ShareFileWithUser(fileID int, username, permission string) error {
+func (s *FileService) ShareFileWithUser(ctx context.Context, fileID int, username, permission string) error {
+	ctx, span := tracing.Start(ctx, "FileService.ShareFileWithUser")
+	defer span.End()
+
 	// Get user ID
 	var userID int
-	err := s.db.QueryRow("SELECT id FROM users WHERE username = $1", username).Scan(&userID)
+	err := s.db.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1", username).Scan(&userID)
 	if err != nil {
 		return err
 	}
 
 	// Insert or update share
-	_, err = s.db.Exec(`
+	_, err = s.db.ExecContext(ctx, `
 		INSERT INTO file_shares (file_id, user_id, permission, created_at, updated_at)
 		VALUES ($1, $2, $3, NOW(), NOW())
 		ON CONFLICT (file_id, user_id)
@@ -828,7 +1247,10 @@ func (s *FileService) ShareFileWithUser(fileID int, username, permission string)
 }
 
 // GetFileShares returns all shares for a specific file
-func (s *FileService) GetFileShares(fileID int) ([]models.FileShare, error) {
+func (s *FileService) GetFileShares(ctx context.Context, fileID int) ([]models.FileShare, error) {
+	ctx, span := tracing.Start(ctx, "FileService.GetFileShares")
+	defer span.End()
+
 	query := `
 		SELECT fs.id, fs.file_id, fs.user_id, fs.permission, fs.created_at, fs.updated_at,
 		       u.username, f.original_name
@@ -838,7 +1260,7 @@ func (s *FileService) GetFileShares(fileID int) ([]models.FileShare, error) {
 		WHERE fs.file_id = $1
 		ORDER BY fs.created_at DESC`
 
-	rows, err := s.db.Query(query, fileID)
+	rows, err := s.db.QueryContext(ctx, query, fileID)
 	if err != nil {
 		return nil, err
 	}
@@ -857,3 +1279,144 @@ func (s *FileService) GetFileShares(fileID int) ([]models.FileShare, error) {
 
 	return shares, nil
 }
+
+// GetFileByName returns the most recent file with the given display name in a
+// folder; a nil folderID looks at the user's files that are not in any folder
+func (s *FileService) GetFileByName(ctx context.Context, userID int, folderID *int, name string) (*models.File, error) {
+	ctx, span := tracing.Start(ctx, "FileService.GetFileByName")
+	defer span.End()
+
+	query := `
+		SELECT f.id, f.user_id, f.hash_id, f.original_name, f.display_name, f.folder_id, 
+		       f.is_public, f.download_count, f.created_at, f.updated_at,
+		       fh.hash_sha256, fh.hash_md5, fh.file_size, fh.mime_type
+		FROM files f
+		JOIN file_hashes fh ON f.hash_id = fh.id
+		WHERE f.user_id = $1 AND f.display_name = $2`
+	args := []interface{}{userID, name}
+	if folderID != nil {
+		query += " AND f.folder_id = $3"
+		args = append(args, *folderID)
+	} else {
+		query += " AND f.folder_id IS NULL"
+	}
+	query += " ORDER BY f.created_at DESC LIMIT 1"
+
+	var file models.File
+	var hashMD5 sql.NullString
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(&file.ID, &file.UserID, &file.HashID, &file.OriginalName, &file.DisplayName,
+		&file.FolderID, &file.IsPublic, &file.DownloadCount, &file.CreatedAt, &file.UpdatedAt,
+		&file.HashSHA256, &hashMD5, &file.FileSize, &file.MimeType)
+	if err != nil {
+		return nil, err
+	}
+	file.HashMD5 = hashMD5.String
+
+	return &file, nil
+}
+
+// GetFolderFiles lists the files directly inside a folder, or outside any
+// folder when folderID is nil. When several files share a display name only
+// the newest one is returned, so the result reads like a directory listing.
+func (s *FileService) GetFolderFiles(ctx context.Context, userID int, folderID *int) ([]models.File, error) {
+	ctx, span := tracing.Start(ctx, "FileService.GetFolderFiles")
+	defer span.End()
+
+	query := `
+		SELECT DISTINCT ON (f.display_name) f.id, f.user_id, f.hash_id, f.original_name, f.display_name, f.folder_id, 
+		       f.is_public, f.download_count, f.created_at, f.updated_at,
+		       fh.hash_sha256, fh.hash_md5, fh.file_size, fh.mime_type
+		FROM files f
+		JOIN file_hashes fh ON f.hash_id = fh.id
+		WHERE f.user_id = $1`
+	args := []interface{}{userID}
+	if folderID != nil {
+		query += " AND f.folder_id = $2"
+		args = append(args, *folderID)
+	} else {
+		query += " AND f.folder_id IS NULL"
+	}
+	query += " ORDER BY f.display_name, f.created_at DESC"
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []models.File
+	for rows.Next() {
+		var file models.File
+		var hashMD5 sql.NullString
+		err := rows.Scan(&file.ID, &file.UserID, &file.HashID, &file.OriginalName, &file.DisplayName,
+			&file.FolderID, &file.IsPublic, &file.DownloadCount, &file.CreatedAt, &file.UpdatedAt,
+			&file.HashSHA256, &hashMD5, &file.FileSize, &file.MimeType)
+		if err != nil {
+			return nil, err
+		}
+		file.HashMD5 = hashMD5.String
+		files = append(files, file)
+	}
+
+	return files, rows.Err()
+}
+
+// ListFilesByPath returns the files below a root folder ordered by their path
+// relative to it, as used by the S3 gateway to list a bucket. When several
+// files share a path only the newest one is returned. Only paths
+// starting with prefix and sorting after startAfter are returned; a limit of
+// zero or less returns every match.
+func (s *FileService) ListFilesByPath(ctx context.Context, userID, rootFolderID int, prefix, startAfter string, limit int) ([]models.File, error) {
+	ctx, span := tracing.Start(ctx, "FileService.ListFilesByPath")
+	defer span.End()
+
+	query := `
+		WITH RECURSIVE tree AS (
+			SELECT id, ''::text AS path FROM folders WHERE id = $1 AND user_id = $2
+			UNION ALL
+			SELECT fo.id, tree.path || fo.name || '/'
+			FROM folders fo
+			JOIN tree ON fo.parent_id = tree.id
+		)
+		SELECT * FROM (
+			SELECT DISTINCT ON (object_path) f.id, f.user_id, f.hash_id, f.original_name, f.display_name, f.folder_id, 
+			       f.is_public, f.download_count, f.created_at, f.updated_at,
+			       fh.hash_sha256, fh.hash_md5, fh.file_size, fh.mime_type,
+			       (tree.path || f.display_name) COLLATE "C" AS object_path
+			FROM files f
+			JOIN tree ON f.folder_id = tree.id
+			JOIN file_hashes fh ON f.hash_id = fh.id
+			WHERE f.user_id = $2
+			ORDER BY object_path, f.created_at DESC
+		) listing
+		WHERE starts_with(object_path, $3) AND object_path > $4
+		ORDER BY object_path`
+
+	args := []interface{}{rootFolderID, userID, prefix, startAfter}
+	if limit > 0 {
+		query += " LIMIT $5"
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []models.File
+	for rows.Next() {
+		var file models.File
+		var hashMD5 sql.NullString
+		err := rows.Scan(&file.ID, &file.UserID, &file.HashID, &file.OriginalName, &file.DisplayName,
+			&file.FolderID, &file.IsPublic, &file.DownloadCount, &file.CreatedAt, &file.UpdatedAt,
+			&file.HashSHA256, &hashMD5, &file.FileSize, &file.MimeType, &file.Path)
+		if err != nil {
+			return nil, err
+		}
+		file.HashMD5 = hashMD5.String
+		files = append(files, file)
+	}
+
+	return files, rows.Err()
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -16,11 +17,11 @@ func NewFolderService(db *sql.DB) *FolderService {
 	return &FolderService{db: db}
 }
 
-func (s *FolderService) CreateFolder(userID int, req models.CreateFolderRequest) (*models.Folder, error) {
+func (s *FolderService) CreateFolder(ctx context.Context, userID int, req models.CreateFolderRequest) (*models.Folder, error) {
 	// Check if parent folder exists and belongs to user
 	if req.ParentID != nil {
 		var parentOwnerID int
-		err := s.db.QueryRow("SELECT user_id FROM folders WHERE id = $1", *req.ParentID).Scan(&parentOwnerID)
+		err := s.db.QueryRowContext(ctx, "SELECT user_id FROM folders WHERE id = $1", *req.ParentID).Scan(&parentOwnerID)
 		if err != nil {
 			return nil, errors.New("parent folder not found")
 		}
@@ -40,14 +41,14 @@ func (s *FolderService) CreateFolder(userID int, req models.CreateFolderRequest)
 		query += " AND parent_id IS NULL"
 	}
 
-	err := s.db.QueryRow(query, args...).Scan(&existingID)
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(&existingID)
 	if err == nil {
 		return nil, errors.New("folder with this name already exists in the same location")
 	}
 
 	// Create folder
 	var folder models.Folder
-	err = s.db.QueryRow(`
+	err = s.db.QueryRowContext(ctx, `
 		INSERT INTO folders (user_id, name, parent_id, is_public) 
 		VALUES ($1, $2, $3, $4) 
 		RETURNING id, user_id, name, parent_id, is_public, created_at, updated_at`,
@@ -62,7 +63,7 @@ func (s *FolderService) CreateFolder(userID int, req models.CreateFolderRequest)
 	return &folder, nil
 }
 
-func (s *FolderService) GetUserFolders(userID int) ([]models.Folder, error) {
+func (s *FolderService) GetUserFolders(ctx context.Context, userID int) ([]models.Folder, error) {
 	query := `
 		SELECT f.id, f.user_id, f.name, f.parent_id, f.is_public, f.created_at, f.updated_at,
 		       u.username, pf.name as parent_name
@@ -72,7 +73,7 @@ func (s *FolderService) GetUserFolders(userID int) ([]models.Folder, error) {
 		WHERE f.user_id = $1
 		ORDER BY f.name`
 
-	rows, err := s.db.Query(query, userID)
+	rows, err := s.db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -96,70 +97,82 @@ func (s *FolderService) GetUserFolders(userID int) ([]models.Folder, error) {
 	return folders, nil
 }
 
-func (s *FolderService) GetUserFoldersWithSearch(userID int, search, sortBy, sortOrder string, isPublic *bool) ([]models.Folder, error) {
-	query := `
-		SELECT f.id, f.user_id, f.name, f.parent_id, f.is_public, f.created_at, f.updated_at,
-		       u.username, pf.name as parent_name,
-		       (SELECT COUNT(*) FROM files WHERE folder_id = f.id) as file_count,
-		       COALESCE((SELECT SUM(fh.file_size) FROM files fi JOIN file_hashes fh ON fi.hash_id = fh.id WHERE fi.folder_id = f.id), 0) as folder_size
-		FROM folders f
-		JOIN users u ON f.user_id = u.id
-		LEFT JOIN folders pf ON f.parent_id = pf.id
-		WHERE f.user_id = $1`
+// folderSortKeys are what folder listings sort by
+var folderSortKeys = map[string]sortKey{
+	"name":       {expr: "f.name", kind: textKey},
+	"created":    {expr: "f.created_at", kind: timeKey},
+	"updated":    {expr: "f.updated_at", kind: timeKey},
+	"file_count": {expr: "(SELECT COUNT(*) FROM files WHERE folder_id = f.id)", kind: intKey},
+	"size":       {expr: "COALESCE((SELECT SUM(fh.file_size) FROM files fi JOIN file_hashes fh ON fi.hash_id = fh.id WHERE fi.folder_id = f.id), 0)", kind: intKey},
+}
 
-	args := []interface{}{userID}
-	argIndex := 2
+func folderSortValue(folder *models.Folder, key string) interface{} {
+	switch key {
+	case "name":
+		return folder.Name
+	case "created":
+		return folder.CreatedAt
+	case "updated":
+		return folder.UpdatedAt
+	case "file_count":
+		return *folder.FileCount
+	case "size":
+		return *folder.FolderSize
+	default:
+		return folder.ID
+	}
+}
+
+// GetUserFoldersWithSearch returns the page of a user's folders that req
+// asks for, sorted by name unless it says otherwise
+func (s *FolderService) GetUserFoldersWithSearch(ctx context.Context, userID int, req models.FolderSearchRequest) (*models.FolderList, error) {
+	order, err := parseOrder(req.SortBy, req.SortOrder, folderSortKeys, "f.id", "name", "asc")
+	if err != nil {
+		return nil, err
+	}
+
+	var args queryArgs
+	where := "f.user_id = " + args.add(userID)
 
 	// Add search filter
-	if search != "" {
-		query += fmt.Sprintf(" AND f.name ILIKE $%d", argIndex)
-		args = append(args, "%"+search+"%")
-		argIndex++
+	if req.Search != "" {
+		where += " AND f.name ILIKE " + args.add("%"+req.Search+"%")
 	}
 
 	// Add public/private filter
-	if isPublic != nil {
-		query += fmt.Sprintf(" AND f.is_public = $%d", argIndex)
-		args = append(args, *isPublic)
-		argIndex++
-	}
-
-	// Add sorting
-	orderBy := "f.name"
-	if sortBy != "" {
-		switch sortBy {
-		case "name":
-			orderBy = "f.name"
-		case "created_at":
-			orderBy = "f.created_at"
-		case "file_count":
-			orderBy = "file_count"
-		default:
-			orderBy = "f.name"
-		}
+	if req.IsPublic != nil {
+		where += " AND f.is_public = " + args.add(*req.IsPublic)
 	}
+	countArgs := append(queryArgs(nil), args...)
 
-	orderDirection := "ASC"
-	if sortOrder == "desc" {
-		orderDirection = "DESC"
+	query, err := order.page(`
+		SELECT f.id, f.user_id, f.name, f.parent_id, f.is_public, f.created_at, f.updated_at,
+		       u.username, pf.name as parent_name, f.saved_search_id,
+		       `+folderSortKeys["file_count"].expr+` as file_count,
+		       `+folderSortKeys["size"].expr+` as folder_size
+		FROM folders f
+		JOIN users u ON f.user_id = u.id
+		LEFT JOIN folders pf ON f.parent_id = pf.id
+		WHERE `+where, &args, req.Cursor, req.Page, req.Limit)
+	if err != nil {
+		return nil, err
 	}
 
-	query += fmt.Sprintf(" ORDER BY %s %s", orderBy, orderDirection)
-
-	rows, err := s.db.Query(query, args...)
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var folders []models.Folder
+	list := &models.FolderList{}
 	for rows.Next() {
 		var folder models.Folder
 		var parentName sql.NullString
 		var fileCount int
 		var folderSize int64
 		err := rows.Scan(&folder.ID, &folder.UserID, &folder.Name, &folder.ParentID,
-			&folder.IsPublic, &folder.CreatedAt, &folder.UpdatedAt, &folder.Username, &parentName, &fileCount, &folderSize)
+			&folder.IsPublic, &folder.CreatedAt, &folder.UpdatedAt, &folder.Username, &parentName, &folder.SavedSearchID,
+			&fileCount, &folderSize)
 		if err != nil {
 			return nil, err
 		}
@@ -168,24 +181,42 @@ func (s *FolderService) GetUserFoldersWithSearch(userID int, search, sortBy, sor
 		}
 		folder.FileCount = &fileCount
 		folder.FolderSize = &folderSize
-		folders = append(folders, folder)
+		list.Folders = append(list.Folders, folder)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return folders, nil
+	if req.Limit > 0 && len(list.Folders) > req.Limit {
+		list.Folders = list.Folders[:req.Limit]
+		list.HasMore = true
+		last := &list.Folders[len(list.Folders)-1]
+		list.NextCursor = order.next(func(key string) interface{} { return folderSortValue(last, key) })
+	}
+
+	if list.HasMore || req.Cursor != "" || req.Page > 1 {
+		err = s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM folders f WHERE "+where, countArgs...).Scan(&list.Total)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		list.Total = len(list.Folders)
+	}
+	return list, nil
 }
 
-func (s *FolderService) GetFolder(folderID, userID int) (*models.Folder, error) {
+func (s *FolderService) GetFolder(ctx context.Context, folderID, userID int) (*models.Folder, error) {
 	var folder models.Folder
 	var parentName sql.NullString
-	err := s.db.QueryRow(`
+	err := s.db.QueryRowContext(ctx, `
 		SELECT f.id, f.user_id, f.name, f.parent_id, f.is_public, f.created_at, f.updated_at,
-		       u.username, pf.name as parent_name
+		       u.username, pf.name as parent_name, f.saved_search_id
 		FROM folders f
 		JOIN users u ON f.user_id = u.id
 		LEFT JOIN folders pf ON f.parent_id = pf.id
 		WHERE f.id = $1 AND f.user_id = $2`,
 		folderID, userID).Scan(&folder.ID, &folder.UserID, &folder.Name, &folder.ParentID,
-		&folder.IsPublic, &folder.CreatedAt, &folder.UpdatedAt, &folder.Username, &parentName)
+		&folder.IsPublic, &folder.CreatedAt, &folder.UpdatedAt, &folder.Username, &parentName, &folder.SavedSearchID)
 
 	if err != nil {
 		return nil, err
@@ -198,10 +229,10 @@ func (s *FolderService) GetFolder(folderID, userID int) (*models.Folder, error)
 	return &folder, nil
 }
 
-func (s *FolderService) UpdateFolder(folderID, userID int, req models.UpdateFolderRequest) (*models.Folder, error) {
+func (s *FolderService) UpdateFolder(ctx context.Context, folderID, userID int, req models.UpdateFolderRequest) (*models.Folder, error) {
 	// Check if folder exists and belongs to user
 	var currentFolder models.Folder
-	err := s.db.QueryRow("SELECT id, user_id, name, parent_id FROM folders WHERE id = $1", folderID).Scan(
+	err := s.db.QueryRowContext(ctx, "SELECT id, user_id, name, parent_id FROM folders WHERE id = $1", folderID).Scan(
 		&currentFolder.ID, &currentFolder.UserID, &currentFolder.Name, &currentFolder.ParentID)
 	if err != nil {
 		return nil, errors.New("folder not found")
@@ -213,7 +244,7 @@ func (s *FolderService) UpdateFolder(folderID, userID int, req models.UpdateFold
 	// Check if new parent folder exists and belongs to user
 	if req.ParentID != nil {
 		var parentOwnerID int
-		err = s.db.QueryRow("SELECT user_id FROM folders WHERE id = $1", *req.ParentID).Scan(&parentOwnerID)
+		err = s.db.QueryRowContext(ctx, "SELECT user_id FROM folders WHERE id = $1", *req.ParentID).Scan(&parentOwnerID)
 		if err != nil {
 			return nil, errors.New("parent folder not found")
 		}
@@ -238,7 +269,7 @@ func (s *FolderService) UpdateFolder(folderID, userID int, req models.UpdateFold
 			query += " AND parent_id IS NULL"
 		}
 
-		err = s.db.QueryRow(query, args...).Scan(&existingID)
+		err = s.db.QueryRowContext(ctx, query, args...).Scan(&existingID)
 		if err == nil {
 			return nil, errors.New("folder with this name already exists in the same location")
 		}
@@ -252,19 +283,19 @@ func (s *FolderService) UpdateFolder(folderID, userID int, req models.UpdateFold
 		args = []interface{}{req.ParentID, *req.IsPublic, folderID}
 	}
 
-	_, err = s.db.Exec(updateQuery, args...)
+	_, err = s.db.ExecContext(ctx, updateQuery, args...)
 	if err != nil {
 		return nil, err
 	}
 
 	// Return updated folder
-	return s.GetFolder(folderID, userID)
+	return s.GetFolder(ctx, folderID, userID)
 }
 
-func (s *FolderService) DeleteFolder(folderID, userID int) error {
+func (s *FolderService) DeleteFolder(ctx context.Context, folderID, userID int) error {
 	// Check if folder exists and belongs to user
 	var folderOwnerID int
-	err := s.db.QueryRow("SELECT user_id FROM folders WHERE id = $1", folderID).Scan(&folderOwnerID)
+	err := s.db.QueryRowContext(ctx, "SELECT user_id FROM folders WHERE id = $1", folderID).Scan(&folderOwnerID)
 	if err != nil {
 		return errors.New("folder not found")
 	}
@@ -274,7 +305,7 @@ func (s *FolderService) DeleteFolder(folderID, userID int) error {
 
 	// Check if folder has subfolders
 	var subfolderCount int
-	err = s.db.QueryRow("SELECT COUNT(*) FROM folders WHERE parent_id = $1", folderID).Scan(&subfolderCount)
+	err = s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM folders WHERE parent_id = $1", folderID).Scan(&subfolderCount)
 	if err != nil {
 		return err
 	}
@@ -284,7 +315,7 @@ func (s *FolderService) DeleteFolder(folderID, userID int) error {
 
 	// Check if folder has files
 	var fileCount int
-	err = s.db.QueryRow("SELECT COUNT(*) FROM files WHERE folder_id = $1", folderID).Scan(&fileCount)
+	err = s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM files WHERE folder_id = $1", folderID).Scan(&fileCount)
 	if err != nil {
 		return err
 	}
@@ -293,14 +324,79 @@ func (s *FolderService) DeleteFolder(folderID, userID int) error {
 	}
 
 	// Delete folder
-	_, err = s.db.Exec("DELETE FROM folders WHERE id = $1", folderID)
+	_, err = s.db.ExecContext(ctx, "DELETE FROM folders WHERE id = $1", folderID)
+	return err
+}
+
+// MoveFolder moves one of the user's folders into another of theirs, or to
+// the top level when parentID is 0, and renames it unless name is empty
+func (s *FolderService) MoveFolder(ctx context.Context, folderID, userID, parentID int, name string) error {
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		return moveFolder(ctx, tx, userID, folderID, parentID, name)
+	})
+}
+
+// moveFolder moves one of the user's folders into another of theirs, or to
+// the top level when parentID is 0, renaming it unless name is empty. A
+// folder can't move into itself or its own subfolders, nor next to a folder
+// of the same name.
+func moveFolder(ctx context.Context, tx *sql.Tx, userID, folderID, parentID int, name string) error {
+	var current string
+	err := tx.QueryRowContext(ctx, "SELECT name FROM folders WHERE id = $1 AND user_id = $2 FOR UPDATE",
+		folderID, userID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return ErrFolderNotFound
+	} else if err != nil {
+		return err
+	}
+	if name == "" {
+		name = current
+	}
+
+	var parent *int
+	if parentID != 0 {
+		var found, cycle bool
+		err = tx.QueryRowContext(ctx, `
+			WITH RECURSIVE ancestors AS (
+				SELECT id, parent_id FROM folders WHERE id = $1 AND user_id = $3
+				UNION ALL
+				SELECT fo.id, fo.parent_id FROM folders fo JOIN ancestors a ON fo.id = a.parent_id
+			)
+			SELECT COUNT(*) > 0, COUNT(*) FILTER (WHERE id = $2) > 0 FROM ancestors`,
+			parentID, folderID, userID).Scan(&found, &cycle)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return ErrParentFolderNotFound
+		}
+		if cycle {
+			return ErrFolderCycle
+		}
+		parent = &parentID
+	}
+
+	var taken bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM folders
+			WHERE user_id = $1 AND name = $2 AND parent_id IS NOT DISTINCT FROM $3 AND id <> $4)`,
+		userID, name, parent, folderID).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return fmt.Errorf("%w: %q", ErrFolderNameTaken, name)
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE folders SET name = $1, parent_id = $2 WHERE id = $3", name, parent, folderID)
 	return err
 }
 
-func (s *FolderService) ShareFolder(folderID, userID int, req models.ShareFolderRequest) error {
+func (s *FolderService) ShareFolder(ctx context.Context, folderID, userID int, req models.ShareFolderRequest) error {
 	// Check if folder exists and belongs to user
 	var folderOwnerID int
-	err := s.db.QueryRow("SELECT user_id FROM folders WHERE id = $1", folderID).Scan(&folderOwnerID)
+	err := s.db.QueryRowContext(ctx, "SELECT user_id FROM folders WHERE id = $1", folderID).Scan(&folderOwnerID)
 	if err != nil {
 		return errors.New("folder not found")
 	}
@@ -310,13 +406,13 @@ func (s *FolderService) ShareFolder(folderID, userID int, req models.ShareFolder
 
 	// Check if target user exists
 	var targetUserID int
-	err = s.db.QueryRow("SELECT id FROM users WHERE username = $1", req.Username).Scan(&targetUserID)
+	err = s.db.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1", req.Username).Scan(&targetUserID)
 	if err != nil {
 		return errors.New("user not found")
 	}
 
 	// Insert or update share
-	_, err = s.db.Exec(`
+	_, err = s.db.ExecContext(ctx, `
 		INSERT INTO folder_shares (folder_id, shared_with_user_id, permission) 
 		VALUES ($1, $2, $3) 
 		ON CONFLICT (folder_id, shared_with_user_id) 
@@ -326,17 +422,17 @@ func (s *FolderService) ShareFolder(folderID, userID int, req models.ShareFolder
 	return err
 }
 
-func (s *FolderService) GetSharedFolders(userID int) ([]models.Folder, error) {
+func (s *FolderService) GetSharedFolders(ctx context.Context, userID int) ([]models.Folder, error) {
 	query := `
 		SELECT f.id, f.user_id, f.name, f.parent_id, f.is_public, f.created_at, f.updated_at,
-		       u.username, fs.permission
+		       u.username, fs.permission, f.saved_search_id
 		FROM folders f
 		JOIN users u ON f.user_id = u.id
 		JOIN folder_shares fs ON f.id = fs.folder_id
 		WHERE fs.shared_with_user_id = $1
 		ORDER BY f.name`
 
-	rows, err := s.db.Query(query, userID)
+	rows, err := s.db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -347,7 +443,7 @@ func (s *FolderService) GetSharedFolders(userID int) ([]models.Folder, error) {
 		var folder models.Folder
 		var permission string
 		err := rows.Scan(&folder.ID, &folder.UserID, &folder.Name, &folder.ParentID,
-			&folder.IsPublic, &folder.CreatedAt, &folder.UpdatedAt, &folder.Username, &permission)
+			&folder.IsPublic, &folder.CreatedAt, &folder.UpdatedAt, &folder.Username, &permission, &folder.SavedSearchID)
 		if err != nil {
 			return nil, err
 		}
@@ -358,17 +454,17 @@ func (s *FolderService) GetSharedFolders(userID int) ([]models.Folder, error) {
 	return folders, nil
 }
 
-func (s *FolderService) GetFolderStats(userID int) (*models.FolderStats, error) {
+func (s *FolderService) GetFolderStats(ctx context.Context, userID int) (*models.FolderStats, error) {
 	var stats models.FolderStats
 
 	// Get total folders
-	err := s.db.QueryRow("SELECT COUNT(*) FROM folders WHERE user_id = $1", userID).Scan(&stats.TotalFolders)
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM folders WHERE user_id = $1", userID).Scan(&stats.TotalFolders)
 	if err != nil {
 		return nil, err
 	}
 
 	// Get public folders
-	err = s.db.QueryRow("SELECT COUNT(*) FROM folders WHERE user_id = $1 AND is_public = true", userID).Scan(&stats.PublicFolders)
+	err = s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM folders WHERE user_id = $1 AND is_public = true", userID).Scan(&stats.PublicFolders)
 	if err != nil {
 		return nil, err
 	}
@@ -377,7 +473,7 @@ func (s *FolderService) GetFolderStats(userID int) (*models.FolderStats, error)
 	stats.PrivateFolders = stats.TotalFolders - stats.PublicFolders
 
 	// Get total files in folders
-	err = s.db.QueryRow(`
+	err = s.db.QueryRowContext(ctx, `
 		SELECT COUNT(*) FROM files f 
 		JOIN folders fo ON f.folder_id = fo.id 
 		WHERE fo.user_id = $1`, userID).Scan(&stats.TotalFilesInFolders)
@@ -387,3 +483,104 @@ func (s *FolderService) GetFolderStats(userID int) (*models.FolderStats, error)
 
 	return &stats, nil
 }
+
+// GetRootFolderByName returns one of the user's top-level folders by name
+func (s *FolderService) GetRootFolderByName(ctx context.Context, userID int, name string) (*models.Folder, error) {
+	return s.GetFolderByName(ctx, userID, nil, name)
+}
+
+// GetFolderByName returns the user's folder with the given name inside
+// parentID, or at the top level when parentID is nil
+func (s *FolderService) GetFolderByName(ctx context.Context, userID int, parentID *int, name string) (*models.Folder, error) {
+	query := `
+		SELECT id, user_id, name, parent_id, is_public, created_at, updated_at
+		FROM folders
+		WHERE user_id = $1 AND name = $2`
+	args := []interface{}{userID, name}
+	if parentID != nil {
+		query += " AND parent_id = $3"
+		args = append(args, *parentID)
+	} else {
+		query += " AND parent_id IS NULL"
+	}
+	query += " ORDER BY id LIMIT 1"
+
+	var folder models.Folder
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(&folder.ID, &folder.UserID, &folder.Name, &folder.ParentID,
+		&folder.IsPublic, &folder.CreatedAt, &folder.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &folder, nil
+}
+
+// GetChildFolders lists the folders directly inside parentID, or the user's
+// top-level folders when parentID is nil
+func (s *FolderService) GetChildFolders(ctx context.Context, userID int, parentID *int) ([]models.Folder, error) {
+	query := `
+		SELECT id, user_id, name, parent_id, is_public, created_at, updated_at
+		FROM folders
+		WHERE user_id = $1`
+	args := []interface{}{userID}
+	if parentID != nil {
+		query += " AND parent_id = $2"
+		args = append(args, *parentID)
+	} else {
+		query += " AND parent_id IS NULL"
+	}
+	query += " ORDER BY name"
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var folders []models.Folder
+	for rows.Next() {
+		var folder models.Folder
+		err := rows.Scan(&folder.ID, &folder.UserID, &folder.Name, &folder.ParentID,
+			&folder.IsPublic, &folder.CreatedAt, &folder.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+
+	return folders, rows.Err()
+}
+
+// ResolvePath walks the given folder names below rootID and returns the ID of
+// the last one. Missing folders are created when create is true; otherwise
+// sql.ErrNoRows is returned for the first missing segment.
+func (s *FolderService) ResolvePath(ctx context.Context, userID, rootID int, segments []string, create bool) (int, error) {
+	current := rootID
+	for _, name := range segments {
+		if name == "" {
+			continue
+		}
+
+		var childID int
+		err := s.db.QueryRowContext(ctx, `
+			SELECT id FROM folders
+			WHERE user_id = $1 AND parent_id = $2 AND name = $3
+			ORDER BY id
+			LIMIT 1`,
+			userID, current, name).Scan(&childID)
+		if err == sql.ErrNoRows && create {
+			parentID := current
+			folder, createErr := s.CreateFolder(ctx, userID, models.CreateFolderRequest{Name: name, ParentID: &parentID})
+			if createErr != nil {
+				return 0, createErr
+			}
+			childID = folder.ID
+		} else if err != nil {
+			return 0, err
+		}
+
+		current = childID
+	}
+
+	return current, nil
+}
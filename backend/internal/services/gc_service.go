@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"time"
+
+	"filevault/internal/metrics"
+	"filevault/internal/models"
+	"filevault/internal/tracing"
+)
+
+// GCService reclaims storage that no file refers to any more: blob rows left
+// behind by older delete and upload code, and legacy on-disk blobs whose row
+// is gone
+type GCService struct {
+	db        *sql.DB
+	uploadDir string
+}
+
+func NewGCService(db *sql.DB, uploadDir string) *GCService {
+	return &GCService{db: db, uploadDir: uploadDir}
+}
+
+// Run deletes orphans older than gracePeriod, or only lists them when dryRun
+// is set. The grace period leaves blobs alone while an upload that created
+// them may still be adding its file.
+func (s *GCService) Run(ctx context.Context, gracePeriod time.Duration, dryRun bool) (*models.GCReport, error) {
+	ctx, span := tracing.Start(ctx, "GCService.Run")
+	defer span.End()
+
+	report := &models.GCReport{
+		DryRun:      dryRun,
+		GracePeriod: gracePeriod.String(),
+		Blobs:       []models.OrphanBlob{},
+		Files:       []models.OrphanFile{},
+	}
+
+	blobs, err := s.orphanBlobs(ctx, gracePeriod)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, err
+	}
+	var freed int64
+	for _, blob := range blobs {
+		if !dryRun {
+			// Each blob gets its own transaction so a long run holds no
+			// lock for longer than one delete
+			var deleted string
+			err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+				var err error
+				deleted, err = deleteBlobIfUnreferenced(ctx, tx, blob.HashID)
+				return err
+			})
+			if err != nil {
+				tracing.RecordError(span, err)
+				return nil, err
+			}
+			if deleted == "" {
+				// Picked up by an upload since it was listed
+				continue
+			}
+			os.Remove(legacyBlobPath(s.uploadDir, deleted))
+			metrics.GCReclaimedBytes.WithLabelValues("database").Add(float64(blob.Size))
+		}
+		report.Blobs = append(report.Blobs, blob)
+		freed += blob.Size
+	}
+
+	files, err := s.orphanFiles(ctx, time.Now().Add(-gracePeriod))
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, err
+	}
+	for _, file := range files {
+		if !dryRun {
+			if err := os.Remove(file.Path); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			metrics.GCReclaimedBytes.WithLabelValues("disk").Add(float64(file.Size))
+		}
+		report.Files = append(report.Files, file)
+		freed += file.Size
+	}
+
+	report.FreedMB = float64(freed) / (1024 * 1024)
+	return report, nil
+}
+
+func (s *GCService) orphanBlobs(ctx context.Context, gracePeriod time.Duration) ([]models.OrphanBlob, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT fh.id, fh.hash_sha256, fh.file_size, fh.created_at
+		FROM file_hashes fh
+		WHERE fh.created_at < CURRENT_TIMESTAMP - make_interval(secs => $1)
+		  AND NOT EXISTS (SELECT 1 FROM files f WHERE f.hash_id = fh.id)
+		ORDER BY fh.id`,
+		gracePeriod.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blobs []models.OrphanBlob
+	for rows.Next() {
+		var b models.OrphanBlob
+		if err := rows.Scan(&b.HashID, &b.HashSHA256, &b.Size, &b.CreatedAt); err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, b)
+	}
+	return blobs, rows.Err()
+}
+
+// orphanFiles walks the legacy blob layout, <upload dir>/<ab>/<abcdef...>,
+// and returns blobs modified before cutoff that have no database row. Other
+// files in the upload directory, such as the SFTP host key, are ignored.
+func (s *GCService) orphanFiles(ctx context.Context, cutoff time.Time) ([]models.OrphanFile, error) {
+	dirs, err := os.ReadDir(s.uploadDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var orphans []models.OrphanFile
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 || !isHex(dir.Name()) {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(s.uploadDir, dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			hash := entry.Name()
+			if !entry.Type().IsRegular() || len(hash) != 64 || !isHex(hash) || hash[:2] != dir.Name() {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			if !info.ModTime().Before(cutoff) {
+				continue
+			}
+
+			var exists bool
+			err = s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM file_hashes WHERE hash_sha256 = $1)", hash).Scan(&exists)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				orphans = append(orphans, models.OrphanFile{
+					Path:       legacyBlobPath(s.uploadDir, hash),
+					Size:       info.Size(),
+					ModifiedAt: info.ModTime(),
+				})
+			}
+		}
+	}
+	return orphans, nil
+}
+
+// isHex reports whether s is lowercase hex, as stored hashes are
+func isHex(s string) bool {
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
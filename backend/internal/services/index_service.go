@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"filevault/internal/metrics"
+	"filevault/internal/models"
+	"filevault/internal/tracing"
+	"filevault/internal/utils"
+
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// maxIndexSourceSize is the largest content text is extracted from; the whole
+// blob is loaded to parse it
+const maxIndexSourceSize = 64 << 20
+
+// IndexService extracts the text of stored content in the background and
+// indexes it for full-text search. The index belongs to content, not files,
+// so deduplicated files share it.
+type IndexService struct {
+	db        *sql.DB
+	uploadDir string
+}
+
+func NewIndexService(db *sql.DB, uploadDir string) *IndexService {
+	return &IndexService{db: db, uploadDir: uploadDir}
+}
+
+// IndexBatch indexes up to batchSize blobs that have never been tried,
+// oldest first. Content without extractable text is recorded with the reason
+// and not tried again. Blobs stored without a type are included, since older
+// uploads of text have none.
+func (s *IndexService) IndexBatch(ctx context.Context, batchSize int) (*models.IndexResult, error) {
+	ctx, span := tracing.Start(ctx, "IndexService.IndexBatch")
+	defer span.End()
+
+	patterns := []string{""}
+	for _, prefix := range utils.TextMimePrefixes {
+		patterns = append(patterns, prefix+"%")
+	}
+	type blobRow struct {
+		id       int
+		hash     string
+		size     int64
+		mimeType string
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, hash_sha256, file_size, mime_type
+		FROM file_hashes
+		WHERE indexed_at IS NULL AND corrupt_at IS NULL AND mime_type LIKE ANY($1)
+		ORDER BY id
+		LIMIT $2`,
+		pq.Array(patterns), batchSize)
+	if err != nil {
+		return nil, err
+	}
+	var blobs []blobRow
+	for rows.Next() {
+		var b blobRow
+		if err := rows.Scan(&b.id, &b.hash, &b.size, &b.mimeType); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		blobs = append(blobs, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &models.IndexResult{}
+	for _, b := range blobs {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		text, problem, err := s.extract(ctx, b.id, b.hash, b.size, b.mimeType)
+		if err == sql.ErrNoRows {
+			// Deleted since the batch was listed
+			continue
+		} else if err != nil {
+			tracing.RecordError(span, err)
+			return result, err
+		}
+
+		if err := s.save(ctx, b.id, text, problem); err != nil {
+			tracing.RecordError(span, err)
+			return result, err
+		}
+		if problem != "" {
+			result.Skipped++
+			metrics.IndexedBlobs.WithLabelValues("skipped").Inc()
+		} else {
+			result.Indexed++
+			metrics.IndexedBlobs.WithLabelValues("indexed").Inc()
+		}
+	}
+	span.SetAttributes(attribute.Int("index.indexed", result.Indexed), attribute.Int("index.skipped", result.Skipped))
+	return result, nil
+}
+
+// extract returns a blob's text. Content without text gives a problem rather
+// than an error.
+func (s *IndexService) extract(ctx context.Context, hashID int, hash string, size int64, mimeType string) (string, string, error) {
+	if size > maxIndexSourceSize {
+		return "", fmt.Sprintf("content larger than %d MB", maxIndexSourceSize>>20), nil
+	}
+	data, err := loadBlob(ctx, s.db, s.uploadDir, hashID, hash)
+	if errors.Is(err, errBlobUnreadable) {
+		return "", err.Error(), nil
+	} else if err != nil {
+		return "", "", err
+	}
+	if data == nil {
+		return "", "content is missing", nil
+	}
+
+	_, extractSpan := tracing.Start(ctx, "extract_text")
+	defer extractSpan.End()
+	text, err := utils.ExtractText(data, mimeType)
+	if err != nil {
+		return "", err.Error(), nil
+	}
+	if text == "" {
+		return "", "no text", nil
+	}
+	extractSpan.SetAttributes(attribute.Int("index.text_bytes", len(text)))
+	return text, "", nil
+}
+
+// save stores a blob's text and search vector and marks it as tried
+func (s *IndexService) save(ctx context.Context, hashID int, text, problem string) error {
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		if problem == "" {
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO content_index (hash_id, content, search_vector)
+				VALUES ($1, $2, to_tsvector('english', $2))
+				ON CONFLICT (hash_id) DO UPDATE
+				SET content = EXCLUDED.content, search_vector = EXCLUDED.search_vector, indexed_at = CURRENT_TIMESTAMP`,
+				hashID, text)
+			if err != nil {
+				return err
+			}
+		}
+
+		var indexError sql.NullString
+		if problem != "" {
+			indexError = sql.NullString{String: problem, Valid: true}
+		}
+		_, err := tx.ExecContext(ctx,
+			"UPDATE file_hashes SET indexed_at = CURRENT_TIMESTAMP, index_error = $2 WHERE id = $1",
+			hashID, indexError)
+		return err
+	})
+}
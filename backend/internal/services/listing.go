@@ -0,0 +1,235 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaxListLimit caps the page size of listings
+const MaxListLimit = 100
+
+// ErrInvalidSort is returned for a sort key or order a listing doesn't offer
+var ErrInvalidSort = errors.New("invalid sort")
+
+// ErrInvalidCursor is returned for a cursor that is malformed or was made by
+// a listing sorted another way
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// queryArgs collects the parameters of a query built up piece by piece
+type queryArgs []interface{}
+
+// add appends v and returns its placeholder
+func (a *queryArgs) add(v interface{}) string {
+	*a = append(*a, v)
+	return fmt.Sprintf("$%d", len(*a))
+}
+
+type keyKind int
+
+const (
+	textKey keyKind = iota
+	intKey
+	timeKey
+	floatKey
+)
+
+// sortKey is an expression a listing can be ordered and paged by
+type sortKey struct {
+	expr string
+	kind keyKind
+}
+
+// decode reads a cursor value of the key's kind
+func (k sortKey) decode(raw json.RawMessage) (interface{}, error) {
+	switch k.kind {
+	case intKey:
+		var v int64
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	case timeKey:
+		var v time.Time
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	case floatKey:
+		var v float64
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	default:
+		var v string
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	}
+}
+
+// sortAliases maps the column names clients sorted by before sort keys were
+// introduced to the keys
+var sortAliases = map[string]string{
+	"original_name":  "name",
+	"file_size":      "size",
+	"mime_type":      "type",
+	"download_count": "downloads",
+	"created_at":     "created",
+	"updated_at":     "updated",
+	"username":       "uploader",
+}
+
+type sortTerm struct {
+	name string
+	key  sortKey
+	desc bool
+}
+
+// listOrder is how a listing is ordered. It always ends with the row id, so
+// rows are totally ordered and a cursor picks up exactly where a page ended.
+type listOrder struct {
+	terms []sortTerm
+}
+
+// parseOrder reads a comma-separated list of sort keys. A key prefixed with
+// "-" is descending, the others go in sortOrder. Without sortBy the listing
+// is sorted by defaultBy in sortOrder, or in defaultOrder if that is empty.
+func parseOrder(sortBy, sortOrder string, keys map[string]sortKey, idExpr, defaultBy, defaultOrder string) (*listOrder, error) {
+	if sortBy == "" {
+		sortBy = defaultBy
+		if sortOrder == "" {
+			sortOrder = defaultOrder
+		}
+	}
+	desc := false
+	switch strings.ToLower(sortOrder) {
+	case "", "asc":
+	case "desc":
+		desc = true
+	default:
+		return nil, fmt.Errorf("%w: order %q", ErrInvalidSort, sortOrder)
+	}
+
+	order := &listOrder{}
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(sortBy, ",") {
+		field = strings.TrimSpace(field)
+		termDesc := desc
+		if name, ok := strings.CutPrefix(field, "-"); ok {
+			field, termDesc = name, true
+		}
+		if alias, ok := sortAliases[field]; ok {
+			field = alias
+		}
+		key, ok := keys[field]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidSort, field)
+		}
+		if seen[field] {
+			continue
+		}
+		seen[field] = true
+		order.terms = append(order.terms, sortTerm{name: field, key: key, desc: termDesc})
+	}
+	order.terms = append(order.terms, sortTerm{name: "id", key: sortKey{expr: idExpr, kind: intKey}, desc: order.terms[0].desc})
+	return order, nil
+}
+
+// sql is the ORDER BY list
+func (o *listOrder) sql() string {
+	parts := make([]string, len(o.terms))
+	for i, t := range o.terms {
+		parts[i] = t.key.expr + " ASC"
+		if t.desc {
+			parts[i] = t.key.expr + " DESC"
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// spec names the order, so a cursor isn't used with a different one
+func (o *listOrder) spec() string {
+	names := make([]string, len(o.terms))
+	for i, t := range o.terms {
+		names[i] = t.name
+		if t.desc {
+			names[i] = "-" + t.name
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+type listCursor struct {
+	Sort   string            `json:"s"`
+	Values []json.RawMessage `json:"v"`
+}
+
+// page appends to query, which must end in its WHERE clause, the condition
+// continuing after cursor, the order and the page's limit. One row more
+// than limit is fetched so the caller can tell whether there are more. A
+// limit of 0 lists every row.
+func (o *listOrder) page(query string, args *queryArgs, cursor string, page, limit int) (string, error) {
+	if cursor != "" {
+		condition, err := o.after(cursor, args)
+		if err != nil {
+			return "", err
+		}
+		query += " AND " + condition
+	}
+	query += " ORDER BY " + o.sql()
+	if limit > 0 {
+		query += " LIMIT " + args.add(limit+1)
+		if cursor == "" && page > 1 {
+			query += " OFFSET " + args.add((page-1)*limit)
+		}
+	}
+	return query, nil
+}
+
+// after returns the condition selecting the rows that sort after the one a
+// cursor was made from
+func (o *listOrder) after(cursor string, args *queryArgs) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+	var c listCursor
+	if err := json.Unmarshal(raw, &c); err != nil || c.Sort != o.spec() || len(c.Values) != len(o.terms) {
+		return "", ErrInvalidCursor
+	}
+	placeholders := make([]string, len(o.terms))
+	for i, t := range o.terms {
+		value, err := t.key.decode(c.Values[i])
+		if err != nil {
+			return "", ErrInvalidCursor
+		}
+		placeholders[i] = args.add(value)
+	}
+
+	// a > $1 OR (a = $1 AND (b > $2 OR (b = $2 AND ...))), with < for
+	// descending keys
+	condition := ""
+	for i := len(o.terms) - 1; i >= 0; i-- {
+		t := o.terms[i]
+		op := ">"
+		if t.desc {
+			op = "<"
+		}
+		term := fmt.Sprintf("%s %s %s", t.key.expr, op, placeholders[i])
+		if condition != "" {
+			term = fmt.Sprintf("%s OR (%s = %s AND (%s))", term, t.key.expr, placeholders[i], condition)
+		}
+		condition = term
+	}
+	return "(" + condition + ")", nil
+}
+
+// next returns the cursor continuing after a row, given the row's value for
+// each sort key
+func (o *listOrder) next(value func(key string) interface{}) string {
+	c := listCursor{Sort: o.spec()}
+	for _, t := range o.terms {
+		raw, _ := json.Marshal(value(t.name))
+		c.Values = append(c.Values, raw)
+	}
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
@@ -0,0 +1,388 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"filevault/internal/models"
+)
+
+// Limits on what a file's metadata may hold
+const (
+	MaxMetadataFields      = 100
+	MaxMetadataValueLength = 1024
+)
+
+var (
+	ErrInvalidMetadata        = errors.New("invalid metadata")
+	ErrInvalidMetadataSchema  = errors.New("invalid metadata schema")
+	ErrInvalidMetadataFilter  = errors.New("invalid metadata filter")
+	ErrMetadataSchemaNotFound = errors.New("metadata schema not found")
+	ErrFolderNotFound         = errors.New("folder not found")
+)
+
+var metadataTypes = map[string]bool{
+	models.MetadataString:  true,
+	models.MetadataNumber:  true,
+	models.MetadataInteger: true,
+	models.MetadataBoolean: true,
+	models.MetadataDate:    true,
+}
+
+// metadataKey is what a metadata field name looks like. Names are kept
+// simple so filters can tell where they end.
+var metadataKey = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// MetadataService manages custom metadata on files, and the schemas admins
+// set on folders that the metadata of the files in them must follow
+type MetadataService struct {
+	db *sql.DB
+}
+
+func NewMetadataService(db *sql.DB) *MetadataService {
+	return &MetadataService{db: db}
+}
+
+// decodeMetadata reads a metadata column, where NULL is no metadata
+func decodeMetadata(raw []byte) (models.Metadata, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var metadata models.Metadata
+	if err := json.Unmarshal(raw, &metadata); err != nil {
+		return nil, err
+	}
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	return metadata, nil
+}
+
+// GetFileMetadata returns the metadata of one of the user's files
+func (s *MetadataService) GetFileMetadata(ctx context.Context, userID, fileID int) (models.Metadata, error) {
+	var raw []byte
+	err := s.db.QueryRowContext(ctx, "SELECT metadata FROM files WHERE id = $1 AND user_id = $2",
+		fileID, userID).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, ErrFilesNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	metadata, err := decodeMetadata(raw)
+	if metadata == nil && err == nil {
+		metadata = models.Metadata{}
+	}
+	return metadata, err
+}
+
+// UpdateFileMetadata writes the metadata of one of the user's files and
+// returns what it now holds. With replace the values become the metadata;
+// otherwise they are merged into it, and a null value removes its field.
+// The result must fit the schema of the file's folder, if it has one.
+func (s *MetadataService) UpdateFileMetadata(ctx context.Context, userID, fileID int, values models.Metadata, replace bool) (models.Metadata, error) {
+	metadata := models.Metadata{}
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		var raw []byte
+		var folderID sql.NullInt64
+		err := tx.QueryRowContext(ctx, "SELECT metadata, folder_id FROM files WHERE id = $1 AND user_id = $2 FOR UPDATE",
+			fileID, userID).Scan(&raw, &folderID)
+		if err == sql.ErrNoRows {
+			return ErrFilesNotFound
+		} else if err != nil {
+			return err
+		}
+
+		if !replace {
+			current, err := decodeMetadata(raw)
+			if err != nil {
+				return err
+			}
+			for key, value := range current {
+				metadata[key] = value
+			}
+		}
+		for key, value := range values {
+			if value == nil {
+				delete(metadata, key)
+			} else {
+				metadata[key] = value
+			}
+		}
+
+		var schema *models.MetadataSchema
+		if folderID.Valid {
+			schema, err = folderSchema(ctx, tx, int(folderID.Int64))
+			if err != nil && !errors.Is(err, ErrMetadataSchemaNotFound) {
+				return err
+			}
+		}
+		if err := validateMetadata(metadata, schema); err != nil {
+			return err
+		}
+
+		encoded, err := json.Marshal(metadata)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE files SET metadata = $1 WHERE id = $2", encoded, fileID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// validateMetadata checks metadata against the limits on it and, when
+// there is one, a schema
+func validateMetadata(metadata models.Metadata, schema *models.MetadataSchema) error {
+	if len(metadata) > MaxMetadataFields {
+		return fmt.Errorf("%w: more than %d fields", ErrInvalidMetadata, MaxMetadataFields)
+	}
+	for key, value := range metadata {
+		if !metadataKey.MatchString(key) {
+			return fmt.Errorf("%w: bad field name %q", ErrInvalidMetadata, key)
+		}
+		switch value := value.(type) {
+		case string:
+			if len(value) > MaxMetadataValueLength {
+				return fmt.Errorf("%w: %s is longer than %d characters", ErrInvalidMetadata, key, MaxMetadataValueLength)
+			}
+		case float64, bool:
+		default:
+			return fmt.Errorf("%w: %s must be a string, number or boolean", ErrInvalidMetadata, key)
+		}
+	}
+	if schema == nil {
+		return nil
+	}
+
+	for _, field := range schema.Fields {
+		value, ok := metadata[field.Name]
+		if !ok {
+			if field.Required {
+				return fmt.Errorf("%w: %s is required", ErrInvalidMetadata, field.Name)
+			}
+			continue
+		}
+		if !metadataTypeOK(field.Type, value) {
+			return fmt.Errorf("%w: %s must be a %s", ErrInvalidMetadata, field.Name, field.Type)
+		}
+		if len(field.Enum) > 0 && !slices.Contains(field.Enum, value) {
+			return fmt.Errorf("%w: %s must be one of %v", ErrInvalidMetadata, field.Name, field.Enum)
+		}
+	}
+	if !schema.Additional {
+		for key := range metadata {
+			if !slices.ContainsFunc(schema.Fields, func(f models.MetadataField) bool { return f.Name == key }) {
+				return fmt.Errorf("%w: %s is not in the folder's schema", ErrInvalidMetadata, key)
+			}
+		}
+	}
+	return nil
+}
+
+// metadataTypeOK reports whether value, as decoded from JSON, is of a
+// metadata field type
+func metadataTypeOK(fieldType string, value interface{}) bool {
+	switch fieldType {
+	case models.MetadataString:
+		_, ok := value.(string)
+		return ok
+	case models.MetadataNumber:
+		_, ok := value.(float64)
+		return ok
+	case models.MetadataInteger:
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case models.MetadataBoolean:
+		_, ok := value.(bool)
+		return ok
+	case models.MetadataDate:
+		s, ok := value.(string)
+		if !ok {
+			return false
+		}
+		_, err := time.Parse("2006-01-02", s)
+		return err == nil
+	default:
+		return false
+	}
+}
+
+// validateSchema checks that a schema's fields are well formed: named,
+// named once, of a known type, and with enumerations of that type
+func validateSchema(fields []models.MetadataField) error {
+	if len(fields) > MaxMetadataFields {
+		return fmt.Errorf("%w: more than %d fields", ErrInvalidMetadataSchema, MaxMetadataFields)
+	}
+	seen := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		if !metadataKey.MatchString(field.Name) {
+			return fmt.Errorf("%w: bad field name %q", ErrInvalidMetadataSchema, field.Name)
+		}
+		if seen[field.Name] {
+			return fmt.Errorf("%w: %s is listed twice", ErrInvalidMetadataSchema, field.Name)
+		}
+		seen[field.Name] = true
+		if !metadataTypes[field.Type] {
+			return fmt.Errorf("%w: %s has unknown type %q", ErrInvalidMetadataSchema, field.Name, field.Type)
+		}
+		for _, value := range field.Enum {
+			if !metadataTypeOK(field.Type, value) {
+				return fmt.Errorf("%w: %s allows %v, which isn't a %s", ErrInvalidMetadataSchema, field.Name, value, field.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// querier is what folderSchema needs of a database or transaction
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func folderSchema(ctx context.Context, db querier, folderID int) (*models.MetadataSchema, error) {
+	schema := models.MetadataSchema{FolderID: folderID}
+	var fields []byte
+	err := db.QueryRowContext(ctx, "SELECT fields, additional, updated_at FROM metadata_schemas WHERE folder_id = $1",
+		folderID).Scan(&fields, &schema.Additional, &schema.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrMetadataSchemaNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(fields, &schema.Fields); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// GetSchema returns the metadata schema of a folder the user owns, has had
+// shared with them, or that is public
+func (s *MetadataService) GetSchema(ctx context.Context, folderID, userID int) (*models.MetadataSchema, error) {
+	var visible bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT fo.user_id = $2 OR fo.is_public OR EXISTS (
+			SELECT 1 FROM folder_shares fs WHERE fs.folder_id = fo.id AND fs.shared_with_user_id = $2)
+		FROM folders fo
+		WHERE fo.id = $1`, folderID, userID).Scan(&visible)
+	if err == sql.ErrNoRows || (err == nil && !visible) {
+		return nil, ErrFolderNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return folderSchema(ctx, s.db, folderID)
+}
+
+// GetFolderSchema returns a folder's metadata schema regardless of who owns
+// the folder, for admins
+func (s *MetadataService) GetFolderSchema(ctx context.Context, folderID int) (*models.MetadataSchema, error) {
+	return folderSchema(ctx, s.db, folderID)
+}
+
+// SetSchema sets the metadata schema of a folder, replacing any it had.
+// Files already in the folder are checked against it when their metadata
+// is next written.
+func (s *MetadataService) SetSchema(ctx context.Context, folderID int, req models.UpdateMetadataSchemaRequest) (*models.MetadataSchema, error) {
+	if err := validateSchema(req.Fields); err != nil {
+		return nil, err
+	}
+	fields, err := json.Marshal(req.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := models.MetadataSchema{FolderID: folderID, Fields: req.Fields, Additional: req.Additional}
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO metadata_schemas (folder_id, fields, additional)
+		SELECT id, $2, $3 FROM folders WHERE id = $1
+		ON CONFLICT (folder_id) DO UPDATE SET fields = EXCLUDED.fields, additional = EXCLUDED.additional
+		RETURNING updated_at`,
+		folderID, fields, req.Additional).Scan(&schema.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrFolderNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// DeleteSchema removes a folder's metadata schema, leaving its files'
+// metadata as it is
+func (s *MetadataService) DeleteSchema(ctx context.Context, folderID int) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM metadata_schemas WHERE folder_id = $1", folderID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrMetadataSchemaNotFound
+	}
+	return nil
+}
+
+// metadataOperators are the comparisons a metadata filter can make, longest
+// first so "<=" isn't read as "<"
+var metadataOperators = []string{"!=", ">=", "<=", "=", ">", "<", "~"}
+
+// metadataFilter turns a metadata filter into a search condition. A filter
+// is a field name alone, matching files that have the field, or a name, an
+// operator and a value. Ordering operators compare numerically when the
+// value is a number and as text otherwise; "~" matches values containing
+// it.
+func metadataFilter(filter string, args *queryArgs) (string, error) {
+	end := strings.IndexAny(filter, "!=<>~")
+	if end < 0 {
+		end = len(filter)
+	}
+	key := strings.TrimSpace(filter[:end])
+	if !metadataKey.MatchString(key) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidMetadataFilter, filter)
+	}
+	if end == len(filter) {
+		return " AND f.metadata ? " + args.add(key), nil
+	}
+
+	rest := filter[end:]
+	var op string
+	for _, candidate := range metadataOperators {
+		if strings.HasPrefix(rest, candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		return "", fmt.Errorf("%w: %q", ErrInvalidMetadataFilter, filter)
+	}
+	value := strings.TrimSpace(rest[len(op):])
+	field := args.add(key)
+
+	switch op {
+	case "=":
+		return fmt.Sprintf(" AND (f.metadata ->> %s = %s)", field, args.add(value)), nil
+	case "!=":
+		return fmt.Sprintf(" AND (f.metadata ->> %s <> %s)", field, args.add(value)), nil
+	case "~":
+		return fmt.Sprintf(" AND (f.metadata ->> %s ILIKE %s)", field, args.add("%"+escapeLike(value)+"%")), nil
+	}
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		return fmt.Sprintf(" AND (CASE WHEN jsonb_typeof(f.metadata -> %[1]s) = 'number' THEN (f.metadata ->> %[1]s)::numeric END %[2]s %[3]s)",
+			field, op, args.add(n)), nil
+	}
+	return fmt.Sprintf(" AND (f.metadata ->> %s %s %s)", field, op, args.add(value)), nil
+}
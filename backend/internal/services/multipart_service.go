@@ -0,0 +1,226 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"filevault/internal/metrics"
+	"filevault/internal/models"
+	"filevault/internal/utils"
+)
+
+var (
+	ErrUploadNotFound = errors.New("multipart upload not found")
+	ErrInvalidPart    = errors.New("one or more of the specified parts could not be found")
+	ErrObjectTooLarge = errors.New("object exceeds the maximum allowed size")
+)
+
+// MultipartService keeps the parts of in-progress S3 multipart uploads until
+// they are completed into a regular file or aborted
+type MultipartService struct {
+	db *sql.DB
+}
+
+func NewMultipartService(db *sql.DB) *MultipartService {
+	return &MultipartService{db: db}
+}
+
+func (s *MultipartService) CreateUpload(ctx context.Context, userID, folderID int, objectKey, contentType string) (*models.MultipartUpload, error) {
+	uploadID, err := utils.GenerateRandomString(24)
+	if err != nil {
+		return nil, err
+	}
+
+	var upload models.MultipartUpload
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO s3_multipart_uploads (upload_id, user_id, folder_id, object_key, content_type)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, upload_id, user_id, folder_id, object_key, content_type, created_at`,
+		uploadID, userID, folderID, objectKey, contentType).Scan(
+		&upload.ID, &upload.UploadID, &upload.UserID, &upload.FolderID, &upload.ObjectKey,
+		&upload.ContentType, &upload.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &upload, nil
+}
+
+// GetUpload returns an upload owned by the user, or ErrUploadNotFound
+func (s *MultipartService) GetUpload(ctx context.Context, userID int, uploadID string) (*models.MultipartUpload, error) {
+	var upload models.MultipartUpload
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, upload_id, user_id, folder_id, object_key, content_type, created_at
+		FROM s3_multipart_uploads
+		WHERE upload_id = $1 AND user_id = $2`,
+		uploadID, userID).Scan(&upload.ID, &upload.UploadID, &upload.UserID, &upload.FolderID,
+		&upload.ObjectKey, &upload.ContentType, &upload.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &upload, nil
+}
+
+func (s *MultipartService) GetUploads(ctx context.Context, userID, folderID int) ([]models.MultipartUpload, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, upload_id, user_id, folder_id, object_key, content_type, created_at
+		FROM s3_multipart_uploads
+		WHERE user_id = $1 AND folder_id = $2
+		ORDER BY object_key, created_at`,
+		userID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uploads []models.MultipartUpload
+	for rows.Next() {
+		var upload models.MultipartUpload
+		err := rows.Scan(&upload.ID, &upload.UploadID, &upload.UserID, &upload.FolderID,
+			&upload.ObjectKey, &upload.ContentType, &upload.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+
+	return uploads, nil
+}
+
+// PutPart stores (or replaces) one part of an upload and returns its ETag.
+// Parts count towards the user's quota while they wait, so it returns
+// ErrQuotaExceeded if the user's files and pending parts would outgrow it.
+func (s *MultipartService) PutPart(ctx context.Context, upload *models.MultipartUpload, partNumber int, data []byte) (string, error) {
+	etag := utils.CalculateMD5FromData(data)
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		// Lock the user like uploads do, so concurrent parts and uploads see
+		// each other's usage
+		var quotaMB int64
+		err := tx.QueryRowContext(ctx, "SELECT storage_quota_mb FROM users WHERE id = $1 FOR UPDATE", upload.UserID).Scan(&quotaMB)
+		if err != nil {
+			return err
+		}
+
+		// The part being replaced no longer counts
+		var usage int64
+		err = tx.QueryRowContext(ctx, `
+			SELECT (SELECT COALESCE(SUM(fh.file_size), 0)
+			        FROM files f
+			        JOIN file_hashes fh ON f.hash_id = fh.id
+			        WHERE f.user_id = $1)
+			     + (SELECT COALESCE(SUM(p.size), 0)
+			        FROM s3_multipart_parts p
+			        JOIN s3_multipart_uploads u ON u.id = p.upload_id
+			        WHERE u.user_id = $1 AND NOT (p.upload_id = $2 AND p.part_number = $3))`,
+			upload.UserID, upload.ID, partNumber).Scan(&usage)
+		if err != nil {
+			return err
+		}
+		if usage+int64(len(data)) > quotaMB*1024*1024 {
+			metrics.QuotaRejections.Inc()
+			return ErrQuotaExceeded
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO s3_multipart_parts (upload_id, part_number, etag, size, data)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (upload_id, part_number)
+			DO UPDATE SET etag = $3, size = $4, data = $5, created_at = CURRENT_TIMESTAMP`,
+			upload.ID, partNumber, etag, len(data), data)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return etag, nil
+}
+
+func (s *MultipartService) GetParts(ctx context.Context, upload *models.MultipartUpload) ([]models.MultipartPart, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT part_number, etag, size, created_at
+		FROM s3_multipart_parts
+		WHERE upload_id = $1
+		ORDER BY part_number`, upload.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var parts []models.MultipartPart
+	for rows.Next() {
+		var part models.MultipartPart
+		err := rows.Scan(&part.PartNumber, &part.ETag, &part.Size, &part.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+
+	return parts, nil
+}
+
+// AssembleParts concatenates the requested parts in order. Every part must
+// exist and match the ETag the client recorded for it. The combined ETag uses
+// the usual S3 "md5-of-md5s-N" form.
+func (s *MultipartService) AssembleParts(ctx context.Context, upload *models.MultipartUpload, partNumbers []int, etags []string, maxSize int64) ([]byte, string, error) {
+	var buf bytes.Buffer
+	var digests bytes.Buffer
+	for i, partNumber := range partNumbers {
+		if i > 0 && partNumber <= partNumbers[i-1] {
+			return nil, "", errors.New("parts must be listed in ascending order")
+		}
+
+		var etag string
+		var data []byte
+		err := s.db.QueryRowContext(ctx, "SELECT etag, data FROM s3_multipart_parts WHERE upload_id = $1 AND part_number = $2",
+			upload.ID, partNumber).Scan(&etag, &data)
+		if err == sql.ErrNoRows {
+			return nil, "", ErrInvalidPart
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if etags[i] != "" && etags[i] != etag {
+			return nil, "", ErrInvalidPart
+		}
+		if int64(buf.Len()+len(data)) > maxSize {
+			return nil, "", ErrObjectTooLarge
+		}
+
+		digest, err := hex.DecodeString(etag)
+		if err != nil {
+			return nil, "", err
+		}
+		buf.Write(data)
+		digests.Write(digest)
+	}
+
+	combined := fmt.Sprintf("%s-%d", utils.CalculateMD5FromData(digests.Bytes()), len(partNumbers))
+	return buf.Bytes(), combined, nil
+}
+
+// AbortUpload discards an upload and all of its parts
+func (s *MultipartService) AbortUpload(ctx context.Context, upload *models.MultipartUpload) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM s3_multipart_uploads WHERE id = $1", upload.ID)
+	return err
+}
+
+// AbortStaleUploads removes uploads that were started more than maxAge ago and
+// returns how many were discarded
+func (s *MultipartService) AbortStaleUploads(ctx context.Context, maxAge time.Duration) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM s3_multipart_uploads WHERE created_at < $1", time.Now().Add(-maxAge))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,293 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"filevault/internal/models"
+)
+
+var (
+	ErrSavedSearchNotFound  = errors.New("saved search not found")
+	ErrSavedSearchExists    = errors.New("a saved search with this name already exists")
+	ErrParentFolderNotFound = errors.New("parent folder not found")
+	ErrFolderNameTaken      = errors.New("folder with this name already exists in the same location")
+)
+
+// SavedSearchService keeps named file searches. They run through
+// FileService.GetFiles, so a saved search lists what the same search made
+// by hand would.
+type SavedSearchService struct {
+	db    *sql.DB
+	files *FileService
+}
+
+func NewSavedSearchService(db *sql.DB, files *FileService) *SavedSearchService {
+	return &SavedSearchService{db: db, files: files}
+}
+
+// savedRequest is the part of a search that is saved: its filters and sort.
+// It is rejected if searching with it would be.
+func savedRequest(req models.FileSearchRequest) (models.FileSearchRequest, error) {
+	req.Page, req.Limit, req.Cursor, req.Facets = 0, 0, "", nil
+	_, rank, err := fileFilters(req, &queryArgs{})
+	if err != nil {
+		return req, err
+	}
+	if _, err := parseOrder(req.SortBy, req.SortOrder, fileSortKeys(rank), "f.id", "created", "desc"); err != nil {
+		return req, err
+	}
+	return req, nil
+}
+
+// CreateSavedSearch saves a search, and when asked adds its smart folder
+// inside req.ParentID, or at the top level
+func (s *SavedSearchService) CreateSavedSearch(ctx context.Context, userID int, req models.CreateSavedSearchRequest) (*models.SavedSearch, error) {
+	request, err := savedRequest(req.Request)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var searchID int
+	err = inTx(ctx, s.db, func(tx *sql.Tx) error {
+		var existingID int
+		err := tx.QueryRowContext(ctx, "SELECT id FROM saved_searches WHERE user_id = $1 AND name = $2", userID, req.Name).Scan(&existingID)
+		if err == nil {
+			return ErrSavedSearchExists
+		} else if err != sql.ErrNoRows {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO saved_searches (user_id, name, request)
+			VALUES ($1, $2, $3)
+			RETURNING id`,
+			userID, req.Name, raw).Scan(&searchID)
+		if err != nil {
+			return err
+		}
+		if req.SmartFolder {
+			return addSmartFolder(ctx, tx, userID, searchID, req.Name, req.ParentID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetSavedSearch(ctx, searchID, userID)
+}
+
+// addSmartFolder puts a saved search in the folder tree
+func addSmartFolder(ctx context.Context, tx *sql.Tx, userID, searchID int, name string, parentID *int) error {
+	if err := checkSmartFolderPlace(ctx, tx, userID, searchID, name, parentID); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO folders (user_id, name, parent_id, is_public, saved_search_id)
+		VALUES ($1, $2, $3, false, $4)`,
+		userID, name, parentID, searchID)
+	return err
+}
+
+// checkSmartFolderPlace checks that a saved search's smart folder can be
+// named name inside parentID
+func checkSmartFolderPlace(ctx context.Context, tx *sql.Tx, userID, searchID int, name string, parentID *int) error {
+	query := "SELECT id FROM folders WHERE user_id = $1 AND name = $2 AND saved_search_id IS DISTINCT FROM $3"
+	args := []interface{}{userID, name, searchID}
+	if parentID != nil {
+		var ownerID int
+		err := tx.QueryRowContext(ctx, "SELECT user_id FROM folders WHERE id = $1 AND saved_search_id IS NULL", *parentID).Scan(&ownerID)
+		if err == sql.ErrNoRows || (err == nil && ownerID != userID) {
+			return ErrParentFolderNotFound
+		} else if err != nil {
+			return err
+		}
+		query += " AND parent_id = $4"
+		args = append(args, *parentID)
+	} else {
+		query += " AND parent_id IS NULL"
+	}
+
+	var existingID int
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&existingID)
+	if err == nil {
+		return ErrFolderNameTaken
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+	return nil
+}
+
+const savedSearchColumns = `
+		SELECT ss.id, ss.user_id, ss.name, ss.request, fo.id, ss.created_at, ss.updated_at
+		FROM saved_searches ss
+		LEFT JOIN folders fo ON fo.saved_search_id = ss.id`
+
+func scanSavedSearch(scan func(dest ...interface{}) error) (*models.SavedSearch, error) {
+	var search models.SavedSearch
+	var raw []byte
+	err := scan(&search.ID, &search.UserID, &search.Name, &raw, &search.FolderID, &search.CreatedAt, &search.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &search.Request); err != nil {
+		return nil, err
+	}
+	return &search, nil
+}
+
+// GetSavedSearches lists a user's saved searches by name
+func (s *SavedSearchService) GetSavedSearches(ctx context.Context, userID int) ([]models.SavedSearch, error) {
+	rows, err := s.db.QueryContext(ctx, savedSearchColumns+`
+		WHERE ss.user_id = $1
+		ORDER BY ss.name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	searches := []models.SavedSearch{}
+	for rows.Next() {
+		search, err := scanSavedSearch(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		searches = append(searches, *search)
+	}
+	return searches, rows.Err()
+}
+
+func (s *SavedSearchService) GetSavedSearch(ctx context.Context, searchID, userID int) (*models.SavedSearch, error) {
+	search, err := scanSavedSearch(s.db.QueryRowContext(ctx, savedSearchColumns+`
+		WHERE ss.id = $1 AND ss.user_id = $2`, searchID, userID).Scan)
+	if err == sql.ErrNoRows {
+		return nil, ErrSavedSearchNotFound
+	}
+	return search, err
+}
+
+// UpdateSavedSearch renames, changes or moves a saved search, and adds or
+// removes its smart folder. The smart folder keeps the search's name.
+func (s *SavedSearchService) UpdateSavedSearch(ctx context.Context, searchID, userID int, req models.UpdateSavedSearchRequest) (*models.SavedSearch, error) {
+	current, err := s.GetSavedSearch(ctx, searchID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = inTx(ctx, s.db, func(tx *sql.Tx) error {
+		name := current.Name
+		if req.Name != nil && *req.Name != "" && *req.Name != current.Name {
+			name = *req.Name
+			var existingID int
+			err := tx.QueryRowContext(ctx, "SELECT id FROM saved_searches WHERE user_id = $1 AND name = $2", userID, name).Scan(&existingID)
+			if err == nil {
+				return ErrSavedSearchExists
+			} else if err != sql.ErrNoRows {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, "UPDATE saved_searches SET name = $1 WHERE id = $2", name, searchID); err != nil {
+				return err
+			}
+		}
+
+		if req.Request != nil {
+			request, err := savedRequest(*req.Request)
+			if err != nil {
+				return err
+			}
+			raw, err := json.Marshal(request)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, "UPDATE saved_searches SET request = $1 WHERE id = $2", raw, searchID); err != nil {
+				return err
+			}
+		}
+
+		smart := current.FolderID != nil
+		if req.SmartFolder != nil {
+			smart = *req.SmartFolder
+		}
+		switch {
+		case !smart && current.FolderID != nil:
+			_, err := tx.ExecContext(ctx, "DELETE FROM folders WHERE saved_search_id = $1", searchID)
+			return err
+		case smart && current.FolderID == nil:
+			return addSmartFolder(ctx, tx, userID, searchID, name, req.ParentID)
+		case smart && (req.ParentID != nil || name != current.Name):
+			var parentID *int
+			err := tx.QueryRowContext(ctx, "SELECT parent_id FROM folders WHERE saved_search_id = $1", searchID).Scan(&parentID)
+			if err != nil {
+				return err
+			}
+			if req.ParentID != nil {
+				parentID = req.ParentID
+			}
+			if err := checkSmartFolderPlace(ctx, tx, userID, searchID, name, parentID); err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, "UPDATE folders SET name = $1, parent_id = $2 WHERE saved_search_id = $3", name, parentID, searchID)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetSavedSearch(ctx, searchID, userID)
+}
+
+// DeleteSavedSearch deletes a saved search along with its smart folder
+func (s *SavedSearchService) DeleteSavedSearch(ctx context.Context, searchID, userID int) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM saved_searches WHERE id = $1 AND user_id = $2", searchID, userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrSavedSearchNotFound
+	}
+
+	return nil
+}
+
+// RunSavedSearch lists the files a saved search matches now, among its
+// owner's files. Its owner can run it, and so can the users its smart folder
+// is shared with. page gives the page, the facets and, when set, a sort in
+// place of the saved one.
+func (s *SavedSearchService) RunSavedSearch(ctx context.Context, searchID, userID int, page models.FileSearchRequest) (*models.FileList, error) {
+	var ownerID int
+	var raw []byte
+	err := s.db.QueryRowContext(ctx, `
+		SELECT ss.user_id, ss.request
+		FROM saved_searches ss
+		LEFT JOIN folders fo ON fo.saved_search_id = ss.id
+		WHERE ss.id = $1 AND (ss.user_id = $2 OR EXISTS (
+			SELECT 1 FROM folder_shares fs WHERE fs.folder_id = fo.id AND fs.shared_with_user_id = $2))`,
+		searchID, userID).Scan(&ownerID, &raw)
+	if err == sql.ErrNoRows {
+		return nil, ErrSavedSearchNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var req models.FileSearchRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return nil, err
+	}
+	req.Page, req.Limit, req.Cursor, req.Facets = page.Page, page.Limit, page.Cursor, page.Facets
+	if page.SortBy != "" {
+		req.SortBy, req.SortOrder = page.SortBy, page.SortOrder
+	}
+	return s.files.GetFiles(ctx, ownerID, req)
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"filevault/internal/metrics"
+	"filevault/internal/models"
+	"filevault/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ScrubService re-reads stored blobs, recomputes their SHA-256 and flags the
+// ones that no longer match, so silent corruption is found before a user
+// downloads it
+type ScrubService struct {
+	db        *sql.DB
+	uploadDir string
+}
+
+func NewScrubService(db *sql.DB, uploadDir string) *ScrubService {
+	return &ScrubService{db: db, uploadDir: uploadDir}
+}
+
+// ScrubOptions controls how much one batch verifies and how fast
+type ScrubOptions struct {
+	// BatchSize is the most blobs verified per batch
+	BatchSize int
+	// ReverifyAfter is how long a verified blob is left alone
+	ReverifyAfter time.Duration
+	// BytesPerSecond caps the read rate; 0 means unthrottled
+	BytesPerSecond int64
+}
+
+// ScrubBatch verifies the blobs checked longest ago, or never, recording the
+// result on each. It pauses between blobs to stay under the read rate and
+// stops early when ctx is done.
+func (s *ScrubService) ScrubBatch(ctx context.Context, opts ScrubOptions) (*models.ScrubResult, error) {
+	ctx, span := tracing.Start(ctx, "ScrubService.ScrubBatch")
+	defer span.End()
+
+	type blobRow struct {
+		id   int
+		hash string
+		size int64
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, hash_sha256, file_size
+		FROM file_hashes
+		WHERE last_verified_at IS NULL
+		   OR last_verified_at < CURRENT_TIMESTAMP - make_interval(secs => $1)
+		ORDER BY last_verified_at NULLS FIRST, id
+		LIMIT $2`,
+		opts.ReverifyAfter.Seconds(), opts.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	var blobs []blobRow
+	for rows.Next() {
+		var b blobRow
+		if err := rows.Scan(&b.id, &b.hash, &b.size); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		blobs = append(blobs, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &models.ScrubResult{}
+	for _, b := range blobs {
+		var problem string
+		data, err := loadBlob(ctx, s.db, s.uploadDir, b.id, b.hash)
+		if err == sql.ErrNoRows {
+			// Deleted since the batch was listed
+			continue
+		} else if errors.Is(err, errBlobUnreadable) {
+			problem = err.Error()
+		} else if err != nil {
+			tracing.RecordError(span, err)
+			return result, err
+		} else {
+			problem = blobProblem(data, b.size, b.hash)
+		}
+
+		if err := s.record(ctx, b.id, problem); err != nil {
+			tracing.RecordError(span, err)
+			return result, err
+		}
+
+		result.Checked++
+		result.Bytes += int64(len(data))
+		metrics.ScrubbedBytes.Add(float64(len(data)))
+		if problem != "" {
+			result.Corrupt++
+			metrics.ScrubbedBlobs.WithLabelValues("corrupt").Inc()
+		} else {
+			metrics.ScrubbedBlobs.WithLabelValues("ok").Inc()
+		}
+
+		if err := throttle(ctx, int64(len(data)), opts.BytesPerSecond); err != nil {
+			return result, err
+		}
+	}
+	span.SetAttributes(attribute.Int("scrub.checked", result.Checked), attribute.Int("scrub.corrupt", result.Corrupt))
+
+	if err := s.updateCorruptGauge(ctx); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// record stores the outcome of verifying a blob. A blob that verifies again,
+// for example after its legacy file was restored from backup, is cleared.
+func (s *ScrubService) record(ctx context.Context, hashID int, problem string) error {
+	if problem == "" {
+		_, err := s.db.ExecContext(ctx, `
+			UPDATE file_hashes
+			SET last_verified_at = CURRENT_TIMESTAMP, corrupt_at = NULL, corruption = NULL
+			WHERE id = $1`, hashID)
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE file_hashes
+		SET last_verified_at = CURRENT_TIMESTAMP, corrupt_at = COALESCE(corrupt_at, CURRENT_TIMESTAMP), corruption = $2
+		WHERE id = $1`, hashID, problem)
+	return err
+}
+
+// throttle waits as long as reading n bytes takes at bytesPerSecond
+func throttle(ctx context.Context, n, bytesPerSecond int64) error {
+	if bytesPerSecond <= 0 || n == 0 {
+		return nil
+	}
+	timer := time.NewTimer(time.Duration(n) * time.Second / time.Duration(bytesPerSecond))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (s *ScrubService) updateCorruptGauge(ctx context.Context) error {
+	var corrupt int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM file_hashes WHERE corrupt_at IS NOT NULL").Scan(&corrupt)
+	if err != nil {
+		return err
+	}
+	metrics.CorruptBlobs.Set(float64(corrupt))
+	return nil
+}
+
+// Report returns the scrubber's progress and every blob flagged as corrupt,
+// with the files that use it
+func (s *ScrubService) Report(ctx context.Context) (*models.ScrubReport, error) {
+	ctx, span := tracing.Start(ctx, "ScrubService.Report")
+	defer span.End()
+
+	report := &models.ScrubReport{Corrupt: []models.CorruptBlob{}}
+	var oldest sql.NullTime
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COUNT(last_verified_at), MIN(last_verified_at)
+		FROM file_hashes`).Scan(&report.TotalBlobs, &report.VerifiedBlobs, &oldest)
+	if err != nil {
+		return nil, err
+	}
+	if oldest.Valid {
+		report.OldestVerifiedAt = &oldest.Time
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, hash_sha256, file_size, corruption, corrupt_at, last_verified_at
+		FROM file_hashes
+		WHERE corrupt_at IS NOT NULL
+		ORDER BY corrupt_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var b models.CorruptBlob
+		if err := rows.Scan(&b.HashID, &b.HashSHA256, &b.Size, &b.Problem, &b.DetectedAt, &b.LastVerifiedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		report.Corrupt = append(report.Corrupt, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range report.Corrupt {
+		files, err := s.affectedFiles(ctx, report.Corrupt[i].HashID)
+		if err != nil {
+			return nil, err
+		}
+		report.Corrupt[i].Files = files
+	}
+	report.CorruptCount = len(report.Corrupt)
+	metrics.CorruptBlobs.Set(float64(report.CorruptCount))
+	return report, nil
+}
+
+func (s *ScrubService) affectedFiles(ctx context.Context, hashID int) ([]models.AffectedFile, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT f.id, f.display_name, u.username
+		FROM files f
+		JOIN users u ON f.user_id = u.id
+		WHERE f.hash_id = $1
+		ORDER BY f.id`, hashID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []models.AffectedFile{}
+	for rows.Next() {
+		var f models.AffectedFile
+		if err := rows.Scan(&f.FileID, &f.Name, &f.Username); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
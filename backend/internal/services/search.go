@@ -0,0 +1,289 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"filevault/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// Snippets mark matches with control characters, which extracted text never
+// contains, so the text can be HTML-escaped before they become <mark> tags
+const (
+	snippetStart   = "\x02"
+	snippetStop    = "\x03"
+	snippetOptions = "StartSel=" + snippetStart + ", StopSel=" + snippetStop + ", MaxFragments=2, MaxWords=20, MinWords=8"
+)
+
+// fileTagText is a file's tags as one string
+const fileTagText = "COALESCE((SELECT string_agg(tag, ' ') FROM file_tags WHERE file_id = f.id), '')"
+
+// nameOrContentMatch is the search condition for a query: the name or a tag
+// contains it, or the name or indexed content contains its words. pattern is
+// the placeholder of the ILIKE pattern and query that of the query.
+func nameOrContentMatch(pattern, query string) string {
+	return fmt.Sprintf(" AND (f.original_name ILIKE %[1]s OR f.display_name ILIKE %[1]s"+
+		" OR f.id IN (SELECT file_id FROM file_tags WHERE tag ILIKE %[1]s)"+
+		" OR to_tsvector('english', f.original_name || ' ' || f.display_name) @@ plainto_tsquery('english', %[2]s)"+
+		" OR ci.search_vector @@ plainto_tsquery('english', %[2]s))", pattern, query)
+}
+
+// searchRank scores a file against the query in placeholder query, weighting
+// words in its name above words in its tags, and those above words in its
+// content
+func searchRank(query string) string {
+	return fmt.Sprintf("ts_rank(setweight(to_tsvector('english', f.original_name || ' ' || f.display_name), 'A')"+
+		" || setweight(to_tsvector('english', "+fileTagText+"), 'B')"+
+		" || setweight(COALESCE(ci.search_vector, ''::tsvector), 'C'), plainto_tsquery('english', %s))", query)
+}
+
+// fileFilters returns the conditions of a file search, and when it has a
+// query the expression ranking files against it. A relative date range is
+// resolved against the current time.
+func fileFilters(req models.FileSearchRequest, args *queryArgs) (string, string, error) {
+	var where strings.Builder
+	rank := ""
+	if req.Query != "" {
+		pattern := args.add("%" + req.Query + "%")
+		query := args.add(req.Query)
+		where.WriteString(nameOrContentMatch(pattern, query))
+		rank = searchRank(query)
+	}
+	if family, ok := strings.CutSuffix(req.MimeType, "/*"); ok {
+		where.WriteString(" AND fh.mime_type LIKE " + args.add(family+"/%"))
+	} else if req.MimeType != "" {
+		where.WriteString(" AND fh.mime_type = " + args.add(req.MimeType))
+	}
+	if req.MinSize != nil {
+		where.WriteString(" AND fh.file_size >= " + args.add(*req.MinSize))
+	}
+	if req.MaxSize != nil {
+		where.WriteString(" AND fh.file_size <= " + args.add(*req.MaxSize))
+	}
+	if req.StartDate != "" {
+		where.WriteString(" AND f.created_at >= " + args.add(req.StartDate))
+	}
+	if req.EndDate != "" {
+		where.WriteString(" AND f.created_at <= " + args.add(req.EndDate))
+	}
+	if req.DateRange != "" {
+		start, end, err := ParseDateRange(req.DateRange, time.Now())
+		if err != nil {
+			return "", "", err
+		}
+		where.WriteString(" AND f.created_at >= " + args.add(start))
+		if !end.IsZero() {
+			where.WriteString(" AND f.created_at < " + args.add(end))
+		}
+	}
+	if req.Uploader != "" {
+		where.WriteString(" AND u.username ILIKE " + args.add("%"+req.Uploader+"%"))
+	}
+	if req.Username != "" {
+		where.WriteString(" AND u.username = " + args.add(req.Username))
+	}
+	if len(req.Tags) > 0 {
+		where.WriteString(" AND f.id IN (SELECT file_id FROM file_tags WHERE tag = ANY(" + args.add(pq.Array(req.Tags)) + "))")
+	}
+	for _, filter := range req.Metadata {
+		condition, err := metadataFilter(filter, args)
+		if err != nil {
+			return "", "", err
+		}
+		where.WriteString(condition)
+	}
+	if req.FolderID != nil && *req.FolderID == 0 {
+		where.WriteString(" AND f.folder_id IS NULL")
+	} else if req.FolderID != nil {
+		where.WriteString(" AND f.folder_id = " + args.add(*req.FolderID))
+	}
+	return where.String(), rank, nil
+}
+
+// fileSortKeys are what file listings sort by. Relevance is only offered
+// with a rank expression, that is when searching.
+func fileSortKeys(rank string) map[string]sortKey {
+	keys := map[string]sortKey{
+		"name":      {expr: "f.display_name", kind: textKey},
+		"size":      {expr: "fh.file_size", kind: intKey},
+		"type":      {expr: "fh.mime_type", kind: textKey},
+		"downloads": {expr: "f.download_count", kind: intKey},
+		"created":   {expr: "f.created_at", kind: timeKey},
+		"updated":   {expr: "f.updated_at", kind: timeKey},
+		"uploader":  {expr: "u.username", kind: textKey},
+	}
+	if rank != "" {
+		keys["relevance"] = sortKey{expr: rank, kind: floatKey}
+	}
+	return keys
+}
+
+func fileSortValue(file *models.File, key string) interface{} {
+	switch key {
+	case "name":
+		return file.DisplayName
+	case "size":
+		return file.FileSize
+	case "type":
+		return file.MimeType
+	case "downloads":
+		return file.DownloadCount
+	case "created":
+		return file.CreatedAt
+	case "updated":
+		return file.UpdatedAt
+	case "uploader":
+		return file.Username
+	case "relevance":
+		return file.Score
+	default:
+		return file.ID
+	}
+}
+
+const fileListFrom = `
+		FROM files f
+		JOIN file_hashes fh ON f.hash_id = fh.id
+		JOIN users u ON f.user_id = u.id
+		LEFT JOIN folders fo ON f.folder_id = fo.id
+		LEFT JOIN content_index ci ON ci.hash_id = f.hash_id`
+
+// listFiles returns the page of the files matching where that req asks for.
+// Searches are sorted by relevance unless req says otherwise, everything
+// else newest first.
+func listFiles(ctx context.Context, db *sql.DB, where string, args queryArgs, rank string, req models.FileSearchRequest) (*models.FileList, error) {
+	defaultBy := "created"
+	if rank != "" {
+		defaultBy = "relevance,created"
+	}
+	order, err := parseOrder(req.SortBy, req.SortOrder, fileSortKeys(rank), "f.id", defaultBy, "desc")
+	if err != nil {
+		return nil, err
+	}
+	facets, err := parseFacets(req.Facets)
+	if err != nil {
+		return nil, err
+	}
+	countArgs := append(queryArgs(nil), args...)
+
+	score := "0"
+	if rank != "" {
+		score = rank
+	}
+	query, err := order.page(`
+		SELECT f.id, f.user_id, f.hash_id, f.original_name, f.display_name, f.folder_id, 
+		       f.is_public, f.download_count, f.created_at, f.updated_at,
+		       fh.hash_sha256, fh.file_size, fh.mime_type, u.username, fo.name as folder_name,
+		       (SELECT COUNT(*) FROM files f2 WHERE f2.hash_id = f.hash_id) as reference_count,
+		       `+score+` as score, f.metadata`+fileListFrom+`
+		WHERE `+where, &args, req.Cursor, req.Page, req.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := &models.FileList{}
+	for rows.Next() {
+		var file models.File
+		var folderName sql.NullString
+		var metadata []byte
+		err := rows.Scan(&file.ID, &file.UserID, &file.HashID, &file.OriginalName, &file.DisplayName,
+			&file.FolderID, &file.IsPublic, &file.DownloadCount, &file.CreatedAt, &file.UpdatedAt,
+			&file.HashSHA256, &file.FileSize, &file.MimeType, &file.Username, &folderName, &file.ReferenceCount, &file.Score, &metadata)
+		if err != nil {
+			return nil, err
+		}
+		if file.Metadata, err = decodeMetadata(metadata); err != nil {
+			return nil, err
+		}
+		if folderName.Valid {
+			file.FolderName = folderName.String
+		}
+		file.IsDuplicate = file.ReferenceCount > 1
+		list.Files = append(list.Files, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if req.Limit > 0 && len(list.Files) > req.Limit {
+		list.Files = list.Files[:req.Limit]
+		list.HasMore = true
+		last := &list.Files[len(list.Files)-1]
+		list.NextCursor = order.next(func(key string) interface{} { return fileSortValue(last, key) })
+	}
+
+	// A first page with everything on it needs no count
+	if list.HasMore || req.Cursor != "" || req.Page > 1 {
+		err = db.QueryRowContext(ctx, "SELECT COUNT(*)"+fileListFrom+" WHERE "+where, countArgs...).Scan(&list.Total)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		list.Total = len(list.Files)
+	}
+	if len(facets) > 0 {
+		if list.Facets, err = countFacets(ctx, db, facets, where, countArgs); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// attachSnippets sets the snippet of each file whose content matches query.
+// Only files already in the results are looked up, so access control is
+// whatever chose them.
+func (s *FileService) attachSnippets(ctx context.Context, files []models.File, query string) error {
+	if len(files) == 0 {
+		return nil
+	}
+	hashIDs := make([]int64, len(files))
+	for i, file := range files {
+		hashIDs[i] = int64(file.HashID)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT hash_id, ts_headline('english', content, plainto_tsquery('english', $2), $3)
+		FROM content_index
+		WHERE hash_id = ANY($1) AND search_vector @@ plainto_tsquery('english', $2)`,
+		pq.Array(hashIDs), query, snippetOptions)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	snippets := make(map[int]string)
+	for rows.Next() {
+		var hashID int
+		var headline string
+		if err := rows.Scan(&hashID, &headline); err != nil {
+			return err
+		}
+		snippets[hashID] = highlightSnippet(headline)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range files {
+		files[i].Snippet = snippets[files[i].HashID]
+	}
+	return nil
+}
+
+// highlightSnippet HTML-escapes a headline and turns its match markers into
+// <mark> tags
+func highlightSnippet(headline string) string {
+	escaped := html.EscapeString(strings.Join(strings.Fields(headline), " "))
+	return strings.NewReplacer(snippetStart, "<mark>", snippetStop, "</mark>").Replace(escaped)
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"filevault/internal/models"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type SSHKeyService struct {
+	db *sql.DB
+}
+
+func NewSSHKeyService(db *sql.DB) *SSHKeyService {
+	return &SSHKeyService{db: db}
+}
+
+// AddSSHKey registers a public key in authorized_keys format for SFTP logins.
+// The key comment is used as the name when none is given.
+func (s *SSHKeyService) AddSSHKey(ctx context.Context, userID int, req models.CreateSSHKeyRequest) (*models.SSHKey, error) {
+	publicKey, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(req.PublicKey))
+	if err != nil {
+		return nil, errors.New("invalid SSH public key")
+	}
+
+	name := req.Name
+	if name == "" {
+		name = comment
+	}
+	fingerprint := ssh.FingerprintSHA256(publicKey)
+	authorizedKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey)))
+
+	var existingID int
+	err = s.db.QueryRowContext(ctx, "SELECT id FROM ssh_keys WHERE fingerprint = $1", fingerprint).Scan(&existingID)
+	if err == nil {
+		return nil, errors.New("SSH key is already registered")
+	}
+
+	var key models.SSHKey
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO ssh_keys (user_id, name, public_key, fingerprint)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, user_id, name, public_key, fingerprint, last_used_at, created_at`,
+		userID, name, authorizedKey, fingerprint).Scan(
+		&key.ID, &key.UserID, &key.Name, &key.PublicKey, &key.Fingerprint, &key.LastUsedAt, &key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func (s *SSHKeyService) GetSSHKeys(ctx context.Context, userID int) ([]models.SSHKey, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, name, public_key, fingerprint, last_used_at, created_at
+		FROM ssh_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.SSHKey
+	for rows.Next() {
+		var key models.SSHKey
+		err := rows.Scan(&key.ID, &key.UserID, &key.Name, &key.PublicKey, &key.Fingerprint, &key.LastUsedAt, &key.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (s *SSHKeyService) DeleteSSHKey(ctx context.Context, userID, keyID int) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM ssh_keys WHERE user_id = $1 AND id = $2", userID, keyID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errors.New("SSH key not found")
+	}
+
+	return nil
+}
+
+// LookupSSHKey returns the owner of a registered public key and records that
+// the key was used
+func (s *SSHKeyService) LookupSSHKey(ctx context.Context, publicKey ssh.PublicKey) (*models.User, error) {
+	fingerprint := ssh.FingerprintSHA256(publicKey)
+
+	var user models.User
+	err := s.db.QueryRowContext(ctx, `
+		SELECT u.id, u.username, u.email, u.is_admin, u.storage_quota_mb, u.created_at, u.updated_at
+		FROM ssh_keys k
+		JOIN users u ON k.user_id = u.id
+		WHERE k.fingerprint = $1`,
+		fingerprint).Scan(&user.ID, &user.Username, &user.Email, &user.IsAdmin,
+		&user.StorageQuotaMB, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.db.ExecContext(ctx, "UPDATE ssh_keys SET last_used_at = CURRENT_TIMESTAMP WHERE fingerprint = $1", fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,255 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"filevault/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// MaxTagLength is the longest tag file_tags holds
+const MaxTagLength = 50
+
+var (
+	ErrInvalidTag    = errors.New("invalid tag")
+	ErrTagNotFound   = errors.New("tag not found")
+	ErrFilesNotFound = errors.New("file not found")
+)
+
+// TagService manages the tags on a user's files. Every operation only
+// touches files the user owns.
+type TagService struct {
+	db *sql.DB
+}
+
+func NewTagService(db *sql.DB) *TagService {
+	return &TagService{db: db}
+}
+
+// normalizeTags trims tags and drops repeats. An empty or overlong tag is
+// rejected.
+func normalizeTags(tags []string) ([]string, error) {
+	var normalized []string
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || len(tag) > MaxTagLength {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTag, tag)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
+// GetTags lists the tags on a user's files with how many files have each,
+// the most used first. A prefix narrows them down for autocompletion, and
+// a limit of 0 lists them all.
+func (s *TagService) GetTags(ctx context.Context, userID int, prefix string, limit int) ([]models.TagCount, error) {
+	var args queryArgs
+	query := `
+		SELECT ft.tag, COUNT(*)
+		FROM file_tags ft
+		JOIN files f ON f.id = ft.file_id
+		WHERE f.user_id = ` + args.add(userID)
+	if prefix = strings.TrimSpace(prefix); prefix != "" {
+		query += " AND ft.tag ILIKE " + args.add(escapeLike(prefix)+"%")
+	}
+	query += " GROUP BY ft.tag ORDER BY COUNT(*) DESC, ft.tag"
+	if limit > 0 {
+		query += " LIMIT " + args.add(limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []models.TagCount{}
+	for rows.Next() {
+		var tag models.TagCount
+		if err := rows.Scan(&tag.Tag, &tag.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// UpdateFileTags adds and removes tags on a selection of the user's files.
+// If any of the files isn't the user's, nothing changes.
+func (s *TagService) UpdateFileTags(ctx context.Context, userID int, req models.UpdateFileTagsRequest) error {
+	add, err := normalizeTags(req.Add)
+	if err != nil {
+		return err
+	}
+	remove, err := normalizeTags(req.Remove)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	seen := make(map[int]bool, len(req.FileIDs))
+	for _, id := range req.FileIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, int64(id))
+		}
+	}
+
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		return updateFileTags(ctx, tx, userID, ids, add, remove)
+	})
+}
+
+// updateFileTags adds and removes normalized tags on files within tx,
+// failing if any of them isn't the user's
+func updateFileTags(ctx context.Context, tx *sql.Tx, userID int, ids []int64, add, remove []string) error {
+	var owned int
+	err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM files WHERE id = ANY($1) AND user_id = $2",
+		pq.Array(ids), userID).Scan(&owned)
+	if err != nil {
+		return err
+	}
+	if owned != len(ids) {
+		return ErrFilesNotFound
+	}
+
+	if len(remove) > 0 {
+		_, err = tx.ExecContext(ctx, "DELETE FROM file_tags WHERE file_id = ANY($1) AND tag = ANY($2)",
+			pq.Array(ids), pq.Array(remove))
+		if err != nil {
+			return err
+		}
+	}
+	if len(add) > 0 {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO file_tags (file_id, tag)
+			SELECT f.id, t.tag FROM unnest($1::int[]) AS f(id) CROSS JOIN unnest($2::text[]) AS t(tag)
+			ON CONFLICT (file_id, tag) DO NOTHING`,
+			pq.Array(ids), pq.Array(add))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MergeTags replaces the given tags with into on all the user's files, and
+// returns how many files were retagged. Renaming a tag is merging it alone.
+func (s *TagService) MergeTags(ctx context.Context, userID int, tags []string, into string) (int, error) {
+	sources, err := normalizeTags(tags)
+	if err != nil {
+		return 0, err
+	}
+	target, err := normalizeTags([]string{into})
+	if err != nil {
+		return 0, err
+	}
+
+	var retagged int
+	err = inTx(ctx, s.db, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `
+			SELECT COUNT(DISTINCT ft.file_id)
+			FROM file_tags ft
+			JOIN files f ON f.id = ft.file_id
+			WHERE f.user_id = $1 AND ft.tag = ANY($2) AND ft.tag <> $3`,
+			userID, pq.Array(sources), target[0]).Scan(&retagged)
+		if err != nil {
+			return err
+		}
+		if retagged == 0 {
+			return ErrTagNotFound
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO file_tags (file_id, tag)
+			SELECT DISTINCT ft.file_id, $3
+			FROM file_tags ft
+			JOIN files f ON f.id = ft.file_id
+			WHERE f.user_id = $1 AND ft.tag = ANY($2)
+			ON CONFLICT (file_id, tag) DO NOTHING`,
+			userID, pq.Array(sources), target[0])
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM file_tags ft
+			USING files f
+			WHERE f.id = ft.file_id AND f.user_id = $1 AND ft.tag = ANY($2) AND ft.tag <> $3`,
+			userID, pq.Array(sources), target[0])
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return retagged, nil
+}
+
+// DeleteTag removes a tag from all the user's files and returns how many
+// files had it
+func (s *TagService) DeleteTag(ctx context.Context, userID int, tag string) (int, error) {
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM file_tags ft
+		USING files f
+		WHERE f.id = ft.file_id AND f.user_id = $1 AND ft.tag = $2`,
+		userID, strings.TrimSpace(tag))
+	if err != nil {
+		return 0, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if affected == 0 {
+		return 0, ErrTagNotFound
+	}
+	return int(affected), nil
+}
+
+// GetFileTags lists the tags on one of the user's files. A file the user
+// doesn't own is ErrFilesNotFound rather than an empty list.
+func (s *TagService) GetFileTags(ctx context.Context, userID, fileID int) ([]string, error) {
+	var exists int
+	err := s.db.QueryRowContext(ctx, "SELECT 1 FROM files WHERE id = $1 AND user_id = $2",
+		fileID, userID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return nil, ErrFilesNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT ft.tag
+		FROM file_tags ft
+		JOIN files f ON f.id = ft.file_id
+		WHERE f.id = $1 AND f.user_id = $2
+		ORDER BY ft.tag`, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
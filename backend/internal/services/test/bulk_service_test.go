@@ -0,0 +1,137 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/internal/models"
+	"filevault/internal/services"
+)
+
+func TestBulkService_Run(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	bulkService := services.NewBulkService(db, t.TempDir())
+
+	req := models.BulkRequest{Operations: []models.BulkOperation{
+		{Action: models.BulkDelete, FileIDs: []int{5, 6}},
+		{Action: models.BulkPublic, FolderIDs: []int{3}},
+	}}
+	raw, err := json.Marshal(req.Operations)
+	require.NoError(t, err)
+	mock.ExpectQuery("INSERT INTO bulk_operations \\(user_id, operations, atomic, total\\)").
+		WithArgs(1, raw, false, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(7, time.Now()))
+
+	job, err := bulkService.Start(context.Background(), 1, req)
+	require.NoError(t, err)
+	assert.Equal(t, models.BulkRunning, job.Status)
+	assert.Equal(t, 3, job.Total)
+
+	// Each item stands alone: the file that isn't the user's fails and the
+	// others go ahead
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT user_id, hash_id FROM files WHERE id = \\$1 FOR UPDATE").WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "hash_id"}).AddRow(1, 40))
+	mock.ExpectExec("DELETE FROM files WHERE id = \\$1").WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT hash_sha256, chunked FROM file_hashes WHERE id = \\$1 FOR UPDATE").WithArgs(40).
+		WillReturnRows(sqlmock.NewRows([]string{"hash_sha256", "chunked"}).AddRow("h40", false))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM files WHERE hash_id = \\$1").WithArgs(40).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT user_id, hash_id FROM files").WithArgs(6).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "hash_id"}).AddRow(2, 41))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE folders SET is_public = \\$1 WHERE id = \\$2 AND user_id = \\$3").
+		WithArgs(true, 3, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("UPDATE bulk_operations\\s+SET status = \\$1, done = \\$2, succeeded = \\$3, failed = \\$4").
+		WithArgs(models.BulkPartial, 3, 2, 1, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, bulkService.Run(context.Background(), job, nil))
+	assert.Equal(t, models.BulkPartial, job.Status)
+	assert.Equal(t, []models.BulkItemResult{
+		{Operation: 0, Action: "delete", Type: "file", ID: 5, Status: models.BulkOK},
+		{Operation: 0, Action: "delete", Type: "file", ID: 6, Status: models.BulkFailed, Error: "file not found"},
+		{Operation: 1, Action: "public", Type: "folder", ID: 3, Status: models.BulkOK},
+	}, job.Results)
+	assert.NotNil(t, job.FinishedAt)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBulkService_RunAtomic(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	bulkService := services.NewBulkService(db, t.TempDir())
+
+	outside, reports := 0, 9
+	job := &models.BulkJob{ID: 8, UserID: 1, Atomic: true, Total: 3, Operations: []models.BulkOperation{
+		{Action: models.BulkMove, FileIDs: []int{5}, FolderID: &outside},
+		{Action: models.BulkMove, FolderIDs: []int{3, 4}, FolderID: &reports},
+	}}
+
+	// Moving folder 3 into its own subfolder fails, which rolls back the
+	// file already moved and skips folder 4
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM files WHERE id = \\$1 AND user_id = \\$2 FOR UPDATE").WithArgs(5, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectExec("UPDATE files SET folder_id = \\$1 WHERE id = \\$2").WithArgs(nil, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT name FROM folders WHERE id = \\$1 AND user_id = \\$2 FOR UPDATE").WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("2023"))
+	mock.ExpectQuery("WITH RECURSIVE ancestors").WithArgs(9, 3, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"found", "cycle"}).AddRow(true, true))
+	mock.ExpectRollback()
+	mock.ExpectExec("UPDATE bulk_operations").
+		WithArgs(models.BulkFailed, 3, 0, 1, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), 8).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, bulkService.Run(context.Background(), job, nil))
+	assert.Equal(t, models.BulkFailed, job.Status)
+	require.Len(t, job.Results, 3)
+	assert.Equal(t, models.BulkRolledBack, job.Results[0].Status)
+	assert.Equal(t, models.BulkFailed, job.Results[1].Status)
+	assert.Equal(t, services.ErrFolderCycle.Error(), job.Results[1].Error)
+	assert.Equal(t, models.BulkSkipped, job.Results[2].Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBulkService_StartRejectsInvalidOperations(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	bulkService := services.NewBulkService(db, t.TempDir())
+
+	for name, op := range map[string]models.BulkOperation{
+		"nothing selected":   {Action: models.BulkDelete},
+		"move without place": {Action: models.BulkMove, FileIDs: []int{1}},
+		"tagging a folder":   {Action: models.BulkTag, FolderIDs: []int{1}, Add: []string{"x"}},
+		"tag without tags":   {Action: models.BulkTag, FileIDs: []int{1}},
+		"share without user": {Action: models.BulkShare, FileIDs: []int{1}},
+	} {
+		_, err := bulkService.Start(context.Background(), 1, models.BulkRequest{Operations: []models.BulkOperation{op}})
+		assert.ErrorIs(t, err, services.ErrInvalidBulkOperation, name)
+	}
+
+	_, err = bulkService.Start(context.Background(), 1, models.BulkRequest{Operations: []models.BulkOperation{
+		{Action: models.BulkTag, FileIDs: []int{1}, Add: []string{" "}},
+	}})
+	assert.ErrorIs(t, err, services.ErrInvalidTag)
+
+	_, err = bulkService.Start(context.Background(), 1, models.BulkRequest{Operations: []models.BulkOperation{
+		{Action: models.BulkDelete, FileIDs: make([]int, services.MaxBulkItems+1)},
+	}})
+	assert.ErrorIs(t, err, services.ErrInvalidBulkOperation)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,242 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/internal/models"
+	"filevault/internal/services"
+	"filevault/internal/utils"
+)
+
+func randomBytes(t *testing.T, size int) []byte {
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	return data
+}
+
+func chunkHashes(chunks [][]byte) []string {
+	hashes := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		hashes[i] = sha256Hex(chunk)
+	}
+	return hashes
+}
+
+func TestSplitChunks_BoundariesFollowContent(t *testing.T) {
+	data := randomBytes(t, 4<<20)
+	chunks := utils.SplitChunks(data)
+
+	assert.Equal(t, data, bytes.Join(chunks, nil))
+	for i, chunk := range chunks {
+		assert.LessOrEqual(t, len(chunk), utils.MaxChunkSize)
+		if i < len(chunks)-1 {
+			assert.GreaterOrEqual(t, len(chunk), utils.MinChunkSize)
+		}
+	}
+
+	// A few bytes inserted in the middle only disturb the chunks around them
+	edited := bytes.Join([][]byte{data[:2<<20], []byte("edit"), data[2<<20:]}, nil)
+	before := chunkHashes(chunks)
+	shared := 0
+	for _, hash := range chunkHashes(utils.SplitChunks(edited)) {
+		if slices.Contains(before, hash) {
+			shared++
+		}
+	}
+	assert.GreaterOrEqual(t, shared, len(chunks)-2)
+}
+
+func TestFileService_ChunkedUploadStoresManifest(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	data := randomBytes(t, 2<<20)
+	hashes := chunkHashes(utils.SplitChunks(data))
+	sorted := slices.Clone(hashes)
+	slices.Sort(sorted)
+
+	stored := &captureArg{}
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT storage_quota_mb FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"storage_quota_mb"}).AddRow(100))
+	mock.ExpectQuery("SELECT id FROM file_hashes WHERE hash_sha256 = \\$1 FOR SHARE").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("INSERT INTO file_hashes").
+		WithArgs(sha256Hex(data), sqlmock.AnyArg(), int64(len(data)), sqlmock.AnyArg(), stored, utils.CompressionNone, int64(0), true).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	var storedSize int64
+	for i, hash := range sorted {
+		// The first chunk is already stored by another blob
+		if i == 0 {
+			mock.ExpectQuery("UPDATE chunks SET ref_count = ref_count \\+ \\$2 WHERE hash_sha256 = \\$1").WithArgs(hash, 1).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "stored_size"}).AddRow(100, 1000))
+			storedSize += 1000
+			continue
+		}
+		mock.ExpectQuery("UPDATE chunks SET ref_count").WithArgs(hash, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "stored_size"}))
+		mock.ExpectQuery("INSERT INTO chunks").WithArgs(hash, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "stored_size"}).AddRow(100+i, 2000))
+		storedSize += 2000
+	}
+	mock.ExpectExec("INSERT INTO blob_chunks \\(hash_id, seq, chunk_id\\)").WithArgs(4, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, int64(len(hashes))))
+	mock.ExpectExec("UPDATE file_hashes SET stored_size = \\$2 WHERE id = \\$1").WithArgs(4, storedSize).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(fh.file_size\\), 0\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(0))
+	mock.ExpectQuery("INSERT INTO files").
+		WillReturnRows(sqlmock.NewRows(fileColumns).AddRow(9, 1, 4, "disk.img", "disk.img", nil, false, 0, time.Now(), time.Now()))
+	mock.ExpectCommit()
+
+	service := services.NewFileService(db, t.TempDir())
+	service.SetChunking(true)
+	_, err = service.UploadContent(context.Background(), 1, "disk.img", data, models.FileUploadRequest{})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	// The content lives in the chunks only
+	assert.Nil(t, stored.value)
+}
+
+func expectChunkedDownload(mock sqlmock.Sqlmock, size int) {
+	mock.ExpectQuery("SELECT fh.file_data, fh.compression, fh.file_size, f.original_name").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"file_data", "compression", "file_size", "original_name", "corrupt", "id", "chunked"}).
+			AddRow(nil, utils.CompressionNone, size, "disk.img", false, 4, true))
+	mock.ExpectQuery("SELECT chunk_id FROM blob_chunks WHERE hash_id = \\$1 ORDER BY seq").WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"chunk_id"}).AddRow(11).AddRow(12).AddRow(11))
+	mock.ExpectExec("UPDATE files SET download_count").WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestFileService_ChunkedDownloadStreams(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	// The second chunk is stored compressed
+	first, second := randomBytes(t, 1000), bytes.Repeat([]byte("row,"), 250)
+	compressed, compression := utils.CompressBlob(second, "text/csv", utils.CompressionZstd)
+	require.Equal(t, utils.CompressionZstd, compression)
+
+	expectChunkedDownload(mock, 2*len(first)+len(second))
+	mock.ExpectQuery("SELECT data, compression, hash_sha256 FROM chunks WHERE id = \\$1").WithArgs(11).
+		WillReturnRows(sqlmock.NewRows([]string{"data", "compression", "hash_sha256"}).AddRow(first, utils.CompressionNone, sha256Hex(first)))
+	mock.ExpectQuery("SELECT data, compression, hash_sha256 FROM chunks").WithArgs(12).
+		WillReturnRows(sqlmock.NewRows([]string{"data", "compression", "hash_sha256"}).AddRow(compressed, compression, sha256Hex(second)))
+	mock.ExpectQuery("SELECT data, compression, hash_sha256 FROM chunks").WithArgs(11).
+		WillReturnRows(sqlmock.NewRows([]string{"data", "compression", "hash_sha256"}).AddRow(first, utils.CompressionNone, sha256Hex(first)))
+
+	download, err := services.NewFileService(db, t.TempDir()).OpenDownload(context.Background(), 7)
+	require.NoError(t, err)
+	reader, err := download.Open()
+	require.NoError(t, err)
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+
+	assert.Equal(t, bytes.Join([][]byte{first, second, first}, nil), content)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFileService_ChunkedDownloadFailsOnCorruptChunk(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	chunk := randomBytes(t, 1000)
+	expectChunkedDownload(mock, 3*len(chunk))
+	mock.ExpectQuery("SELECT data, compression, hash_sha256 FROM chunks").WithArgs(11).
+		WillReturnRows(sqlmock.NewRows([]string{"data", "compression", "hash_sha256"}).AddRow(chunk, utils.CompressionNone, sha256Hex(chunk)))
+	flipped := slices.Clone(chunk)
+	flipped[0] ^= 0xff
+	mock.ExpectQuery("SELECT data, compression, hash_sha256 FROM chunks").WithArgs(12).
+		WillReturnRows(sqlmock.NewRows([]string{"data", "compression", "hash_sha256"}).AddRow(flipped, utils.CompressionNone, sha256Hex(chunk)))
+
+	_, _, err = services.NewFileService(db, t.TempDir()).DownloadFile(context.Background(), 7)
+	assert.ErrorIs(t, err, services.ErrBlobCorrupt)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFileService_DeletingChunkedBlobReleasesChunks(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT user_id, hash_id FROM files WHERE id = \\$1 FOR UPDATE").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "hash_id"}).AddRow(1, 4))
+	mock.ExpectExec("DELETE FROM files WHERE id = \\$1").WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT hash_sha256, chunked FROM file_hashes WHERE id = \\$1 FOR UPDATE").WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"hash_sha256", "chunked"}).AddRow(sha256Hex([]byte("disk")), true))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM files WHERE hash_id = \\$1").WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec("SELECT c.id FROM chunks c\\s+WHERE c.id IN \\(SELECT chunk_id FROM blob_chunks WHERE hash_id = \\$1\\)\\s+ORDER BY c.hash_sha256\\s+FOR UPDATE").
+		WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 2))
+	// Chunk 11 is shared with another blob and stays
+	mock.ExpectQuery("UPDATE chunks c SET ref_count = c.ref_count - m.refs").WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ref_count"}).AddRow(11, 1).AddRow(12, 0))
+	mock.ExpectExec("DELETE FROM blob_chunks WHERE hash_id = \\$1").WithArgs(4).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("DELETE FROM chunks WHERE id = ANY\\(\\$1\\) AND ref_count <= 0").WithArgs("{12}").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM file_hashes WHERE id = \\$1").WithArgs(4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err = services.NewFileService(db, t.TempDir()).DeleteFile(context.Background(), 7, 1)
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFileService_ChunkedUploadsShareChunks(t *testing.T) {
+	db := openTestDB(t)
+	service := services.NewFileService(db, t.TempDir())
+	service.SetChunking(true)
+	service.SetCompression(utils.CompressionZstd)
+	userID := createTestUser(t, db, 100)
+
+	original, hash := randomContent(t, 8<<20)
+	edited := bytes.Join([][]byte{original[:4<<20], []byte("a few new bytes"), original[4<<20:]}, nil)
+	editedHash := sha256Hex(edited)
+	t.Cleanup(func() {
+		db.Exec("DELETE FROM files WHERE user_id = $1", userID)
+		db.Exec("DELETE FROM file_hashes WHERE hash_sha256 IN ($1, $2)", hash, editedHash)
+	})
+
+	ctx := context.Background()
+	first, err := service.UploadContent(ctx, userID, "disk-v1.img", original, models.FileUploadRequest{})
+	require.NoError(t, err)
+	chunksBefore := countRows(t, db, "SELECT COUNT(*) FROM chunks")
+	second, err := service.UploadContent(ctx, userID, "disk-v2.img", edited, models.FileUploadRequest{})
+	require.NoError(t, err)
+
+	added := countRows(t, db, "SELECT COUNT(*) FROM chunks") - chunksBefore
+	assert.LessOrEqual(t, added, 3, "only the chunks around the edit are new")
+
+	content, _, err := service.DownloadFile(ctx, second.ID)
+	require.NoError(t, err)
+	assert.Equal(t, edited, content)
+
+	stats, err := service.GetDeduplicationStats(ctx, userID)
+	require.NoError(t, err)
+	assert.Greater(t, stats["chunk_savings_bytes"].(int64), int64(7<<20))
+
+	require.NoError(t, service.DeleteFile(ctx, first.ID, userID))
+	require.NoError(t, service.DeleteFile(ctx, second.ID, userID))
+	assert.Equal(t, 0, countRows(t, db, `
+		SELECT COUNT(*) FROM chunks c
+		WHERE NOT EXISTS (SELECT 1 FROM blob_chunks bc WHERE bc.chunk_id = c.id)`), "no chunk outlives its last blob")
+}
@@ -0,0 +1,139 @@
+package test
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/internal/models"
+	"filevault/internal/services"
+	"filevault/internal/utils"
+)
+
+// captureArg matches any argument and keeps it for later assertions
+type captureArg struct{ value driver.Value }
+
+func (a *captureArg) Match(v driver.Value) bool {
+	a.value = v
+	return true
+}
+
+func csvContent(rows int) []byte {
+	var b strings.Builder
+	b.WriteString("id,name,amount\n")
+	for i := 0; i < rows; i++ {
+		fmt.Fprintf(&b, "%d,customer-%d,%d.00\n", i, i%50, i*7)
+	}
+	return []byte(b.String())
+}
+
+// expectNewBlob expects an upload of new content and captures what is stored
+func expectNewBlob(mock sqlmock.Sqlmock, stored, compression, storedSize *captureArg) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT storage_quota_mb FROM users WHERE id = \\$1 FOR UPDATE").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"storage_quota_mb"}).AddRow(100))
+	mock.ExpectQuery("SELECT id FROM file_hashes WHERE hash_sha256 = \\$1 FOR SHARE").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("INSERT INTO file_hashes \\(hash_sha256, hash_md5, file_size, mime_type, file_data, compression, stored_size, chunked\\)").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), stored, compression, storedSize, false).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(fh.file_size\\), 0\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(0))
+	now := time.Now()
+	mock.ExpectQuery("INSERT INTO files").
+		WillReturnRows(sqlmock.NewRows(fileColumns).AddRow(9, 1, 4, "data.csv", "data.csv", nil, false, 0, now, now))
+	mock.ExpectCommit()
+}
+
+func TestFileService_CompressesCompressibleUploads(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	data := csvContent(2000)
+	stored, compression, storedSize := &captureArg{}, &captureArg{}, &captureArg{}
+	expectNewBlob(mock, stored, compression, storedSize)
+
+	service := services.NewFileService(db, t.TempDir())
+	service.SetCompression(utils.CompressionZstd)
+	_, err = service.UploadContent(context.Background(), 1, "data.csv", data, models.FileUploadRequest{})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Equal(t, utils.CompressionZstd, compression.value)
+	blob := stored.value.([]byte)
+	assert.Less(t, len(blob), len(data)/2)
+	assert.Equal(t, int64(len(blob)), storedSize.value)
+
+	original, err := utils.DecompressBlob(blob, utils.CompressionZstd)
+	require.NoError(t, err)
+	assert.Equal(t, data, original)
+}
+
+func TestFileService_StoresIncompressibleUploadsAsIs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	data := make([]byte, 256<<10)
+	_, err = rand.Read(data)
+	require.NoError(t, err)
+	stored, compression, storedSize := &captureArg{}, &captureArg{}, &captureArg{}
+	expectNewBlob(mock, stored, compression, storedSize)
+
+	service := services.NewFileService(db, t.TempDir())
+	service.SetCompression(utils.CompressionZstd)
+	_, err = service.UploadContent(context.Background(), 1, "random.bin", data, models.FileUploadRequest{})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Equal(t, utils.CompressionNone, compression.value)
+	assert.Equal(t, data, stored.value)
+	assert.Equal(t, int64(len(data)), storedSize.value)
+}
+
+func TestFileService_DownloadDecompresses(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	data := csvContent(500)
+	blob, compression := utils.CompressBlob(data, "text/csv", utils.CompressionZstd)
+	require.Equal(t, utils.CompressionZstd, compression)
+
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery("SELECT fh.file_data, fh.compression, fh.file_size, f.original_name").WithArgs(7).
+			WillReturnRows(sqlmock.NewRows([]string{"file_data", "compression", "file_size", "original_name", "corrupt", "id", "chunked"}).
+				AddRow(blob, compression, len(data), "data.csv", false, 4, false))
+		mock.ExpectExec("UPDATE files SET download_count = download_count \\+ 1").WithArgs(7).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	service := services.NewFileService(db, t.TempDir())
+	content, name, err := service.DownloadFile(context.Background(), 7)
+	require.NoError(t, err)
+	assert.Equal(t, "data.csv", name)
+	assert.Equal(t, data, content)
+
+	// OpenDownload leaves the blob compressed and streams the original
+	download, err := service.OpenDownload(context.Background(), 7)
+	require.NoError(t, err)
+	assert.Equal(t, blob, download.Stored)
+	assert.Equal(t, int64(len(data)), download.Size)
+	reader, err := download.Open()
+	require.NoError(t, err)
+	defer reader.Close()
+	streamed, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, data, streamed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,200 @@
+package test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/internal/models"
+	"filevault/internal/services"
+	"filevault/migrations"
+)
+
+// The tests in this file need real row locks and unique indexes, so they run
+// against the Postgres at DATABASE_URL and are skipped without one
+
+const hammerGoroutines = 32
+
+func openTestDB(t *testing.T) *sql.DB {
+	url := os.Getenv("DATABASE_URL")
+	if url == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	db, err := sql.Open("postgres", url)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		t.Skipf("Database not available: %v", err)
+	}
+
+	all, err := migrations.Load()
+	require.NoError(t, err)
+	_, err = migrations.NewRunner(db, all).Up(context.Background())
+	require.NoError(t, err)
+	return db
+}
+
+// createTestUser inserts a throwaway user that is removed, with its files,
+// when the test ends
+func createTestUser(t *testing.T, db *sql.DB, quotaMB int) int {
+	name := "tx_" + randomHex(t, 6)
+	var id int
+	err := db.QueryRow(`
+		INSERT INTO users (username, email, password_hash, storage_quota_mb)
+		VALUES ($1, $2, 'x', $3) RETURNING id`,
+		name, name+"@example.com", quotaMB).Scan(&id)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Exec("DELETE FROM users WHERE id = $1", id) })
+	return id
+}
+
+func randomContent(t *testing.T, size int) ([]byte, string) {
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	sum := sha256.Sum256(data)
+	return data, hex.EncodeToString(sum[:])
+}
+
+func randomHex(t *testing.T, n int) string {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	require.NoError(t, err)
+	return hex.EncodeToString(b)
+}
+
+func countRows(t *testing.T, db *sql.DB, query string, args ...interface{}) int {
+	var n int
+	require.NoError(t, db.QueryRow(query, args...).Scan(&n))
+	return n
+}
+
+func TestFileService_ConcurrentUploadsOfSameContent(t *testing.T) {
+	db := openTestDB(t)
+	service := services.NewFileService(db, t.TempDir())
+	users := []int{createTestUser(t, db, 100), createTestUser(t, db, 100)}
+	data, hash := randomContent(t, 4096)
+	t.Cleanup(func() { db.Exec("DELETE FROM file_hashes WHERE hash_sha256 = $1", hash) })
+
+	var wg sync.WaitGroup
+	errs := make(chan error, hammerGoroutines)
+	for i := 0; i < hammerGoroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := service.UploadContent(context.Background(), users[i%len(users)], fmt.Sprintf("copy-%d.bin", i), data, models.FileUploadRequest{})
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, 1, countRows(t, db, "SELECT COUNT(*) FROM file_hashes WHERE hash_sha256 = $1", hash))
+	assert.Equal(t, hammerGoroutines, countRows(t, db, `
+		SELECT COUNT(*) FROM files f JOIN file_hashes fh ON f.hash_id = fh.id
+		WHERE fh.hash_sha256 = $1`, hash))
+}
+
+func TestFileService_ConcurrentUploadsAndDeletesOfSameContent(t *testing.T) {
+	db := openTestDB(t)
+	service := services.NewFileService(db, t.TempDir())
+	userID := createTestUser(t, db, 100)
+	data, hash := randomContent(t, 4096)
+	t.Cleanup(func() { db.Exec("DELETE FROM file_hashes WHERE hash_sha256 = $1", hash) })
+
+	// Each goroutine repeatedly adds and removes a reference, so the last
+	// reference keeps disappearing while other uploads are adding one
+	var wg sync.WaitGroup
+	errs := make(chan error, hammerGoroutines*10)
+	for i := 0; i < hammerGoroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for round := 0; round < 5; round++ {
+				file, err := service.UploadContent(context.Background(), userID, fmt.Sprintf("churn-%d-%d.bin", i, round), data, models.FileUploadRequest{})
+				if err != nil {
+					errs <- err
+					return
+				}
+				if err := service.DeleteFile(context.Background(), file.ID, userID); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, 0, countRows(t, db, "SELECT COUNT(*) FROM files WHERE user_id = $1", userID))
+	assert.Equal(t, 0, countRows(t, db, "SELECT COUNT(*) FROM file_hashes WHERE hash_sha256 = $1", hash),
+		"the blob goes with its last reference")
+}
+
+func TestFileService_ConcurrentUploadsRespectQuota(t *testing.T) {
+	db := openTestDB(t)
+	service := services.NewFileService(db, t.TempDir())
+	// Room for exactly two of the 400 KiB files below
+	userID := createTestUser(t, db, 1)
+
+	hashes := make([]string, hammerGoroutines)
+	contents := make([][]byte, hammerGoroutines)
+	for i := range contents {
+		contents[i], hashes[i] = randomContent(t, 400<<10)
+	}
+	t.Cleanup(func() {
+		for _, hash := range hashes {
+			db.Exec("DELETE FROM file_hashes WHERE hash_sha256 = $1", hash)
+		}
+	})
+
+	var wg sync.WaitGroup
+	results := make(chan error, hammerGoroutines)
+	for i := 0; i < hammerGoroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := service.UploadContent(context.Background(), userID, fmt.Sprintf("big-%d.bin", i), contents[i], models.FileUploadRequest{})
+			results <- err
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+
+	accepted := 0
+	for err := range results {
+		if err == nil {
+			accepted++
+			continue
+		}
+		assert.ErrorIs(t, err, services.ErrQuotaExceeded)
+	}
+	assert.Equal(t, 2, accepted)
+
+	// Rejected uploads must not leave their blobs behind
+	stored := 0
+	for _, hash := range hashes {
+		stored += countRows(t, db, "SELECT COUNT(*) FROM file_hashes WHERE hash_sha256 = $1", hash)
+	}
+	assert.Equal(t, 2, stored)
+}
@@ -0,0 +1,145 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/internal/models"
+	"filevault/internal/services"
+)
+
+var fileDetailColumns = append(append([]string{}, fileColumns...),
+	"hash_sha256", "file_size", "mime_type", "username", "folder_name", "metadata")
+
+func TestFileService_UpdateFile(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	fileService := services.NewFileService(db, t.TempDir())
+
+	now := time.Now()
+	name, folderID := "final.txt", 7
+	expectTarget := func(visible, writable, smart bool) {
+		mock.ExpectQuery("SELECT fo.user_id = \\$2 OR fo.is_public OR fs.permission IS NOT NULL").
+			WithArgs(7, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"visible", "writable", "smart"}).AddRow(visible, writable, smart))
+	}
+
+	// A folder shared with write permission takes the file
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM files WHERE id = \\$1 AND user_id = \\$2 FOR UPDATE").
+		WithArgs(5, 1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	expectTarget(true, true, false)
+	mock.ExpectExec("UPDATE files SET display_name = \\$1, folder_id = \\$2 WHERE id = \\$3").
+		WithArgs("final.txt", &folderID, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT f.id, f.user_id, f.hash_id").WithArgs(5).
+		WillReturnRows(sqlmock.NewRows(fileDetailColumns).
+			AddRow(5, 1, 4, "draft.txt", "final.txt", 7, false, 0, now, now, "h4", 10, "text/plain", "alice", "Shared", nil))
+	mock.ExpectQuery("SELECT tag FROM file_tags WHERE file_id = \\$1").WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"tag"}))
+
+	file, err := fileService.UpdateFile(context.Background(), 5, 1, models.UpdateFileRequest{DisplayName: &name, FolderID: &folderID})
+	require.NoError(t, err)
+	assert.Equal(t, "final.txt", file.DisplayName)
+	assert.Equal(t, "draft.txt", file.OriginalName)
+
+	for _, tt := range []struct {
+		visible, writable, smart bool
+		err                      error
+	}{
+		{visible: true, writable: false, err: services.ErrFolderNotWritable},
+		{visible: false, err: services.ErrFolderNotFound},
+		{visible: true, writable: true, smart: true, err: services.ErrSmartFolder},
+	} {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM files").WithArgs(5, 1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+		expectTarget(tt.visible, tt.writable, tt.smart)
+		mock.ExpectRollback()
+
+		_, err := fileService.UpdateFile(context.Background(), 5, 1, models.UpdateFileRequest{FolderID: &folderID})
+		assert.ErrorIs(t, err, tt.err)
+	}
+
+	// Someone else's file
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM files").WithArgs(5, 2).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+	_, err = fileService.UpdateFile(context.Background(), 5, 2, models.UpdateFileRequest{DisplayName: &name})
+	assert.ErrorIs(t, err, services.ErrFilesNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	for _, bad := range []string{" ", "a/b.txt"} {
+		_, err = fileService.UpdateFile(context.Background(), 5, 1, models.UpdateFileRequest{DisplayName: &bad})
+		assert.ErrorIs(t, err, services.ErrInvalidFileName)
+	}
+}
+
+func TestFileService_CopyFile(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	fileService := services.NewFileService(db, t.TempDir())
+
+	now := time.Now()
+	metadata := []byte(`{"project":"AX-12"}`)
+
+	expectSource := func(userID int) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT storage_quota_mb FROM users WHERE id = \\$1 FOR UPDATE").WithArgs(userID).
+			WillReturnRows(sqlmock.NewRows([]string{"storage_quota_mb"}).AddRow(10))
+		mock.ExpectQuery("SELECT f.hash_id, fh.file_size, f.original_name, f.display_name, f.metadata\\s+FROM files f.*file_shares.*folder_shares.*FOR SHARE OF f").
+			WithArgs(5, userID).
+			WillReturnRows(sqlmock.NewRows([]string{"hash_id", "file_size", "original_name", "display_name", "metadata"}).
+				AddRow(4, 2*1024*1024, "data.csv", "data.csv", metadata))
+	}
+	expectUsage := func(userID int, usedMB int64) {
+		mock.ExpectQuery("SELECT COALESCE\\(SUM\\(fh.file_size\\), 0\\)").WithArgs(userID).
+			WillReturnRows(sqlmock.NewRows([]string{"usage"}).AddRow(usedMB * 1024 * 1024))
+	}
+
+	// A file shared with user 2 is copied outside any folder, reusing its
+	// blob and keeping its tags and metadata. It counts towards their usage.
+	expectSource(2)
+	expectUsage(2, 8)
+	mock.ExpectQuery("INSERT INTO files \\(user_id, hash_id, original_name, display_name, folder_id, metadata\\)").
+		WithArgs(2, 4, "data.csv", "data copy.csv", nil, metadata).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	mock.ExpectExec("INSERT INTO file_tags \\(file_id, tag\\) SELECT \\$1, tag FROM file_tags WHERE file_id = \\$2").
+		WithArgs(9, 5).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT f.id, f.user_id, f.hash_id").WithArgs(9).
+		WillReturnRows(sqlmock.NewRows(fileDetailColumns).
+			AddRow(9, 2, 4, "data.csv", "data copy.csv", nil, false, 0, now, now, "h4", 10, "text/csv", "bob", nil, metadata))
+	mock.ExpectQuery("SELECT tag FROM file_tags WHERE file_id = \\$1").WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"tag"}).AddRow("raw").AddRow("2024"))
+
+	file, err := fileService.CopyFile(context.Background(), 5, 2, models.CopyFileRequest{DisplayName: "data copy.csv"})
+	require.NoError(t, err)
+	assert.Equal(t, 9, file.ID)
+	assert.Equal(t, 4, file.HashID)
+	assert.Equal(t, []string{"raw", "2024"}, file.Tags)
+	assert.Equal(t, "AX-12", file.Metadata["project"])
+
+	// Copying again would take them past their 10 MB quota
+	expectSource(2)
+	expectUsage(2, 9)
+	mock.ExpectRollback()
+	_, err = fileService.CopyFile(context.Background(), 5, 2, models.CopyFileRequest{})
+	assert.ErrorIs(t, err, services.ErrQuotaExceeded)
+
+	// A file the user can't see
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT storage_quota_mb FROM users").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"storage_quota_mb"}).AddRow(10))
+	mock.ExpectQuery("SELECT f.hash_id").WithArgs(5, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"hash_id", "file_size", "original_name", "display_name", "metadata"}))
+	mock.ExpectRollback()
+	_, err = fileService.CopyFile(context.Background(), 5, 3, models.CopyFileRequest{})
+	assert.ErrorIs(t, err, services.ErrFilesNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
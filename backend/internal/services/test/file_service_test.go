@@ -1,7 +1,9 @@
 package test
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
@@ -12,13 +14,22 @@ import (
 )
 
 func TestFileService_GetFiles(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	noTags := func(mock sqlmock.Sqlmock, fileIDs ...int) {
+		for _, id := range fileIDs {
+			mock.ExpectQuery("SELECT tag FROM file_tags WHERE file_id = \\$1").WithArgs(id).
+				WillReturnRows(sqlmock.NewRows([]string{"tag"}))
+		}
+	}
+
 	tests := []struct {
 		name          string
 		userID        int
 		searchRequest models.FileSearchRequest
 		mockSetup     func(sqlmock.Sqlmock)
-		expectedError bool
+		expectedError error
 		expectedCount int
+		expectedTotal int
 	}{
 		{
 			name:   "successful file retrieval",
@@ -28,18 +39,16 @@ func TestFileService_GetFiles(t *testing.T) {
 				Limit: 10,
 			},
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				// Mock count query
-				mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM files").
-					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
-
-				// Mock files query
-				mock.ExpectQuery("SELECT f\\.id, f\\.original_name, f\\.file_size, f\\.mime_type, f\\.is_public, f\\.created_at, f\\.download_count, u\\.username").
-					WillReturnRows(sqlmock.NewRows([]string{"id", "original_name", "file_size", "mime_type", "is_public", "created_at", "download_count", "username"}).
-						AddRow(1, "test1.txt", 1024, "text/plain", false, "2023-01-01T00:00:00Z", 0, "user1").
-						AddRow(2, "test2.txt", 2048, "text/plain", true, "2023-01-02T00:00:00Z", 5, "user1"))
+				// A first page with no more rows is counted from the rows
+				mock.ExpectQuery("WHERE f.user_id = \\$1 ORDER BY f.created_at DESC, f.id DESC LIMIT \\$2$").
+					WithArgs(1, 11).
+					WillReturnRows(sqlmock.NewRows(fileListColumns).
+						AddRow(2, 1, 20, "test2.txt", "test2.txt", nil, true, 5, now, now, "h2", 2048, "text/plain", "user1", nil, 1, 0, nil).
+						AddRow(1, 1, 10, "test1.txt", "test1.txt", nil, false, 0, now, now, "h1", 1024, "text/plain", "user1", nil, 1, 0, nil))
+				noTags(mock, 2, 1)
 			},
-			expectedError: false,
 			expectedCount: 2,
+			expectedTotal: 2,
 		},
 		{
 			name:   "empty file list",
@@ -49,16 +58,12 @@ func TestFileService_GetFiles(t *testing.T) {
 				Limit: 10,
 			},
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				// Mock count query
-				mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM files").
-					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
-
-				// Mock files query
-				mock.ExpectQuery("SELECT f\\.id, f\\.original_name, f\\.file_size, f\\.mime_type, f\\.is_public, f\\.created_at, f\\.download_count, u\\.username").
-					WillReturnRows(sqlmock.NewRows([]string{"id", "original_name", "file_size", "mime_type", "is_public", "created_at", "download_count", "username"}))
+				mock.ExpectQuery("WHERE f.user_id = \\$1 ORDER BY").
+					WithArgs(1, 11).
+					WillReturnRows(sqlmock.NewRows(fileListColumns))
 			},
-			expectedError: false,
 			expectedCount: 0,
+			expectedTotal: 0,
 		},
 		{
 			name:   "search with filters",
@@ -70,17 +75,43 @@ func TestFileService_GetFiles(t *testing.T) {
 				MimeType: "text/plain",
 			},
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				// Mock count query with filters
-				mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM files").
+				mock.ExpectQuery("WHERE f.user_id = \\$1 AND .*fh.mime_type").
+					WillReturnRows(sqlmock.NewRows(fileListColumns).
+						AddRow(1, 1, 10, "test.txt", "test.txt", nil, true, 0, now, now, "h1", 1024, "text/plain", "user1", nil, 1, 1, nil))
+				noTags(mock, 1)
+				mock.ExpectQuery("SELECT hash_id, ts_headline").
+					WillReturnRows(sqlmock.NewRows([]string{"hash_id", "headline"}))
+			},
+			expectedCount: 1,
+			expectedTotal: 1,
+		},
+		{
+			name:   "sorted by whitelisted keys",
+			userID: 1,
+			searchRequest: models.FileSearchRequest{
+				SortBy: "-size,name",
+				Limit:  1,
+			},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				// A further page is there, so the matches are counted
+				mock.ExpectQuery("ORDER BY fh.file_size DESC, f.display_name ASC, f.id DESC LIMIT \\$2$").
+					WithArgs(1, 2).
+					WillReturnRows(sqlmock.NewRows(fileListColumns).
+						AddRow(2, 1, 20, "big.bin", "big.bin", nil, false, 0, now, now, "h2", 4096, "application/octet-stream", "user1", nil, 1, 0, nil).
+						AddRow(1, 1, 10, "small.txt", "small.txt", nil, false, 0, now, now, "h1", 10, "text/plain", "user1", nil, 1, 0, nil))
+				mock.ExpectQuery("SELECT COUNT\\(\\*\\)").WithArgs(1).
 					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
-
-				// Mock files query with filters
-				mock.ExpectQuery("SELECT f\\.id, f\\.original_name, f\\.file_size, f\\.mime_type, f\\.is_public, f\\.created_at, f\\.download_count, u\\.username").
-					WillReturnRows(sqlmock.NewRows([]string{"id", "original_name", "file_size", "mime_type", "is_public", "created_at", "download_count", "username"}).
-						AddRow(1, "test.txt", 1024, "text/plain", true, "2023-01-01T00:00:00Z", 0, "user1"))
+				noTags(mock, 2)
 			},
-			expectedError: false,
 			expectedCount: 1,
+			expectedTotal: 2,
+		},
+		{
+			name:          "key that isn't whitelisted",
+			userID:        1,
+			searchRequest: models.FileSearchRequest{SortBy: "u.password_hash"},
+			mockSetup:     func(mock sqlmock.Sqlmock) {},
+			expectedError: services.ErrInvalidSort,
 		},
 	}
 
@@ -93,19 +124,59 @@ func TestFileService_GetFiles(t *testing.T) {
 			tt.mockSetup(mock)
 
 			fileService := services.NewFileService(db, "/tmp")
-			result, err := fileService.GetFiles(tt.userID, tt.searchRequest)
+			result, err := fileService.GetFiles(context.Background(), tt.userID, tt.searchRequest)
 
-			if tt.expectedError {
-				assert.Error(t, err)
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
 			} else {
-				assert.NoError(t, err)
-				assert.NotNil(t, result)
-				assert.Equal(t, tt.expectedCount, len(result))
+				require.NoError(t, err)
+				require.NotNil(t, result)
+				assert.Equal(t, tt.expectedCount, len(result.Files))
+				assert.Equal(t, tt.expectedTotal, result.Total)
 			}
 
 			assert.NoError(t, mock.ExpectationsWereMet())
 		})
 	}
+
+	t.Run("cursor round trip", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+		fileService := services.NewFileService(db, "/tmp")
+
+		req := models.FileSearchRequest{SortBy: "name", Limit: 1}
+		mock.ExpectQuery("ORDER BY f.display_name ASC, f.id ASC LIMIT \\$2$").WithArgs(1, 2).
+			WillReturnRows(sqlmock.NewRows(fileListColumns).
+				AddRow(4, 1, 40, "a.txt", "a.txt", nil, false, 0, now, now, "h4", 10, "text/plain", "user1", nil, 1, 0, nil).
+				AddRow(3, 1, 30, "b.txt", "b.txt", nil, false, 0, now, now, "h3", 10, "text/plain", "user1", nil, 1, 0, nil))
+		mock.ExpectQuery("SELECT COUNT\\(\\*\\)").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		noTags(mock, 4)
+
+		first, err := fileService.GetFiles(context.Background(), 1, req)
+		require.NoError(t, err)
+		require.NotNil(t, first)
+		require.Len(t, first.Files, 1)
+		require.NotEmpty(t, first.NextCursor)
+
+		// The cursor carries the last row's name and ID
+		req.Cursor = first.NextCursor
+		mock.ExpectQuery("WHERE f.user_id = \\$1 AND \\(f.display_name > \\$2 OR \\(f.display_name = \\$2 AND \\(f.id > \\$3\\)\\)\\) ORDER BY").
+			WithArgs(1, "a.txt", int64(4), 2).
+			WillReturnRows(sqlmock.NewRows(fileListColumns).
+				AddRow(3, 1, 30, "b.txt", "b.txt", nil, false, 0, now, now, "h3", 10, "text/plain", "user1", nil, 1, 0, nil))
+		mock.ExpectQuery("SELECT COUNT\\(\\*\\)").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		noTags(mock, 3)
+
+		second, err := fileService.GetFiles(context.Background(), 1, req)
+		require.NoError(t, err)
+		require.NotNil(t, second)
+		require.Len(t, second.Files, 1)
+		assert.Equal(t, "b.txt", second.Files[0].DisplayName)
+		assert.Equal(t, 2, second.Total)
+		assert.False(t, second.HasMore)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
 }
 
 func TestFileService_GetDeduplicationStats(t *testing.T) {
@@ -148,7 +219,7 @@ func TestFileService_GetDeduplicationStats(t *testing.T) {
 			tt.mockSetup(mock)
 
 			fileService := services.NewFileService(db, "/tmp")
-			result, err := fileService.GetDeduplicationStats(tt.userID)
+			result, err := fileService.GetDeduplicationStats(context.Background(), tt.userID)
 
 			if tt.expectedError {
 				assert.Error(t, err)
@@ -205,7 +276,7 @@ func TestFileService_GetPublicFiles(t *testing.T) {
 			tt.mockSetup(mock)
 
 			fileService := services.NewFileService(db, "/tmp")
-			result, err := fileService.GetPublicFiles()
+			result, err := fileService.GetPublicFiles(context.Background())
 
 			if tt.expectedError {
 				assert.Error(t, err)
@@ -219,3 +290,38 @@ func TestFileService_GetPublicFiles(t *testing.T) {
 		})
 	}
 }
+
+func TestFileService_CheckBlobs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	good := []byte("hello")
+	goodHash := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	mock.ExpectQuery("SELECT fh\\.id, fh\\.hash_sha256, fh\\.file_size").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "hash_sha256", "file_size", "reference_count"}).
+			AddRow(1, goodHash, 5, 2).
+			AddRow(2, goodHash, 5, 1).
+			AddRow(3, goodHash, 5, 0).
+			AddRow(4, "ab"+goodHash[2:], 5, 1))
+	mock.ExpectQuery("SELECT file_data, compression, chunked FROM file_hashes").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"file_data", "compression", "chunked"}).AddRow(good, "none", false))
+	mock.ExpectQuery("SELECT file_data, compression, chunked FROM file_hashes").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"file_data", "compression", "chunked"}).AddRow([]byte("hello!"), "none", false))
+	mock.ExpectQuery("SELECT file_data, compression, chunked FROM file_hashes").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"file_data", "compression", "chunked"}).AddRow(good, "none", false))
+	mock.ExpectQuery("SELECT file_data, compression, chunked FROM file_hashes").WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"file_data", "compression", "chunked"}).AddRow(good, "none", false))
+
+	service := services.NewFileService(db, t.TempDir())
+	report, err := service.CheckBlobs(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, 4, report.Checked)
+	require.Len(t, report.Issues, 3)
+	assert.Contains(t, report.Issues[0].Problem, "size mismatch")
+	assert.Equal(t, "unreferenced", report.Issues[1].Problem)
+	assert.Equal(t, "hash mismatch", report.Issues[2].Problem)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
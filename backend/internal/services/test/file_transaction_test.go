@@ -0,0 +1,227 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/internal/models"
+	"filevault/internal/services"
+)
+
+var fileColumns = []string{"id", "user_id", "hash_id", "original_name", "display_name", "folder_id", "is_public", "download_count", "created_at", "updated_at"}
+
+func TestFileService_UploadContentTransaction(t *testing.T) {
+	now := time.Now()
+	smartFolder := 3
+
+	tests := []struct {
+		name          string
+		folderID      *int
+		mockSetup     func(sqlmock.Sqlmock)
+		expectedError error
+	}{
+		{
+			name: "new blob is committed with its file and tags",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT storage_quota_mb FROM users WHERE id = \\$1 FOR UPDATE").
+					WillReturnRows(sqlmock.NewRows([]string{"storage_quota_mb"}).AddRow(10))
+				mock.ExpectQuery("SELECT id FROM file_hashes WHERE hash_sha256 = \\$1 FOR SHARE").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectQuery("INSERT INTO file_hashes .* ON CONFLICT \\(hash_sha256\\) DO NOTHING").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+				mock.ExpectQuery("SELECT COALESCE\\(SUM\\(fh.file_size\\), 0\\)").
+					WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(0))
+				mock.ExpectQuery("INSERT INTO files").
+					WillReturnRows(sqlmock.NewRows(fileColumns).AddRow(10, 1, 4, "a.txt", "a.txt", nil, false, 0, now, now))
+				mock.ExpectExec("INSERT INTO file_tags").WithArgs(10, "work").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "quota rejection rolls back the new blob",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT storage_quota_mb FROM users").
+					WillReturnRows(sqlmock.NewRows([]string{"storage_quota_mb"}).AddRow(1))
+				mock.ExpectQuery("SELECT id FROM file_hashes").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectQuery("INSERT INTO file_hashes").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+				mock.ExpectQuery("SELECT COALESCE\\(SUM\\(fh.file_size\\), 0\\)").
+					WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(1024 * 1024))
+				mock.ExpectRollback()
+			},
+			expectedError: services.ErrQuotaExceeded,
+		},
+		{
+			// Usage is summed per file, so content already stored still
+			// counts
+			name: "deduplicated upload over quota is rejected",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT storage_quota_mb FROM users").
+					WillReturnRows(sqlmock.NewRows([]string{"storage_quota_mb"}).AddRow(1))
+				mock.ExpectQuery("SELECT id FROM file_hashes").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+				mock.ExpectQuery("SELECT COALESCE\\(SUM\\(fh.file_size\\), 0\\)").
+					WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(1024 * 1024))
+				mock.ExpectRollback()
+			},
+			expectedError: services.ErrQuotaExceeded,
+		},
+		{
+			name: "losing an insert race reuses the winner's blob",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT storage_quota_mb FROM users").
+					WillReturnRows(sqlmock.NewRows([]string{"storage_quota_mb"}).AddRow(10))
+				mock.ExpectQuery("SELECT id FROM file_hashes").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				// ON CONFLICT DO NOTHING returns no row when another upload
+				// committed the same hash first
+				mock.ExpectQuery("INSERT INTO file_hashes").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectQuery("SELECT id FROM file_hashes").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+				mock.ExpectQuery("SELECT COALESCE\\(SUM\\(fh.file_size\\), 0\\)").
+					WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(0))
+				mock.ExpectQuery("INSERT INTO files").WithArgs(1, 7, "a.txt", "a.txt", nil, false).
+					WillReturnRows(sqlmock.NewRows(fileColumns).AddRow(10, 1, 7, "a.txt", "a.txt", nil, false, 0, now, now))
+				mock.ExpectExec("INSERT INTO file_tags").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "failed file insert rolls back",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT storage_quota_mb FROM users").
+					WillReturnRows(sqlmock.NewRows([]string{"storage_quota_mb"}).AddRow(10))
+				mock.ExpectQuery("SELECT id FROM file_hashes").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+				mock.ExpectQuery("SELECT COALESCE\\(SUM\\(fh.file_size\\), 0\\)").
+					WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(0))
+				mock.ExpectQuery("INSERT INTO files").
+					WillReturnError(errors.New("folder does not exist"))
+				mock.ExpectRollback()
+			},
+			expectedError: errors.New("folder does not exist"),
+		},
+		{
+			name:     "smart folder refuses the upload before storing anything",
+			folderID: &smartFolder,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT storage_quota_mb FROM users").
+					WillReturnRows(sqlmock.NewRows([]string{"storage_quota_mb"}).AddRow(10))
+				mock.ExpectQuery("SELECT fo.user_id = \\$2 OR fo.is_public OR fs.permission IS NOT NULL").WithArgs(3, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"visible", "writable", "smart"}).AddRow(true, true, true))
+				mock.ExpectRollback()
+			},
+			expectedError: services.ErrSmartFolder,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+			tt.mockSetup(mock)
+
+			service := services.NewFileService(db, t.TempDir())
+			file, err := service.UploadContent(context.Background(), 1, "a.txt", []byte("hello"), models.FileUploadRequest{Tags: []string{"work"}, FolderID: tt.folderID})
+
+			if tt.expectedError != nil {
+				assert.EqualError(t, err, tt.expectedError.Error())
+				assert.Nil(t, file)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, 10, file.ID)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestFileService_DeleteFileTransaction(t *testing.T) {
+	tests := []struct {
+		name          string
+		userID        int
+		mockSetup     func(sqlmock.Sqlmock)
+		expectedError string
+	}{
+		{
+			name:   "last reference deletes the blob",
+			userID: 1,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT user_id, hash_id FROM files WHERE id = \\$1 FOR UPDATE").
+					WillReturnRows(sqlmock.NewRows([]string{"user_id", "hash_id"}).AddRow(1, 4))
+				mock.ExpectExec("DELETE FROM files WHERE id = \\$1").WithArgs(10).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery("SELECT hash_sha256, chunked FROM file_hashes WHERE id = \\$1 FOR UPDATE").
+					WillReturnRows(sqlmock.NewRows([]string{"hash_sha256", "chunked"}).AddRow("2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", false))
+				mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM files WHERE hash_id = \\$1").
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+				mock.ExpectExec("DELETE FROM file_hashes WHERE id = \\$1").WithArgs(4).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:   "shared blob is kept",
+			userID: 1,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT user_id, hash_id FROM files").
+					WillReturnRows(sqlmock.NewRows([]string{"user_id", "hash_id"}).AddRow(1, 4))
+				mock.ExpectExec("DELETE FROM files").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery("SELECT hash_sha256, chunked FROM file_hashes").
+					WillReturnRows(sqlmock.NewRows([]string{"hash_sha256", "chunked"}).AddRow("2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", false))
+				mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM files").
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:   "other user's file is left alone",
+			userID: 2,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT user_id, hash_id FROM files").
+					WillReturnRows(sqlmock.NewRows([]string{"user_id", "hash_id"}).AddRow(1, 4))
+				mock.ExpectRollback()
+			},
+			expectedError: "not authorized to delete this file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+			tt.mockSetup(mock)
+
+			err = services.NewFileService(db, t.TempDir()).DeleteFile(context.Background(), 10, tt.userID)
+
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/internal/services"
+)
+
+var (
+	orphanedHash   = "aa" + strings.Repeat("1", 62)
+	referencedHash = "aa" + strings.Repeat("2", 62)
+	freshHash      = "bb" + strings.Repeat("3", 62)
+)
+
+// writeLegacyBlob creates an on-disk blob in the legacy layout, modified age ago
+func writeLegacyBlob(t *testing.T, uploadDir, hash string, age time.Duration) string {
+	path := filepath.Join(uploadDir, hash[:2], hash)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte("legacy"), 0644))
+	modified := time.Now().Add(-age)
+	require.NoError(t, os.Chtimes(path, modified, modified))
+	return path
+}
+
+func expectOrphanBlobs(mock sqlmock.Sqlmock) {
+	created := time.Now().Add(-48 * time.Hour)
+	mock.ExpectQuery("SELECT fh.id, fh.hash_sha256, fh.file_size, fh.created_at\\s+FROM file_hashes fh").
+		WithArgs(float64(3600)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "hash_sha256", "file_size", "created_at"}).
+			AddRow(4, strings.Repeat("c", 64), 1024, created).
+			AddRow(5, strings.Repeat("d", 64), 2048, created))
+}
+
+func TestGCService_DryRunDeletesNothing(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	uploadDir := t.TempDir()
+	orphan := writeLegacyBlob(t, uploadDir, orphanedHash, 2*time.Hour)
+	writeLegacyBlob(t, uploadDir, referencedHash, 2*time.Hour)
+	writeLegacyBlob(t, uploadDir, freshHash, time.Minute)
+	require.NoError(t, os.WriteFile(filepath.Join(uploadDir, "ssh_host_ed25519_key"), []byte("key"), 0600))
+
+	expectOrphanBlobs(mock)
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(orphanedHash).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(referencedHash).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	report, err := services.NewGCService(db, uploadDir).Run(context.Background(), time.Hour, true)
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.True(t, report.DryRun)
+	require.Len(t, report.Blobs, 2)
+	require.Len(t, report.Files, 1)
+	assert.Equal(t, orphan, report.Files[0].Path)
+	assert.FileExists(t, orphan)
+}
+
+func TestGCService_DeletesOrphansStillUnreferenced(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	uploadDir := t.TempDir()
+	orphan := writeLegacyBlob(t, uploadDir, orphanedHash, 2*time.Hour)
+
+	expectOrphanBlobs(mock)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT hash_sha256, chunked FROM file_hashes WHERE id = \\$1 FOR UPDATE").WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"hash_sha256", "chunked"}).AddRow(strings.Repeat("c", 64), false))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM files WHERE hash_id = \\$1").WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec("DELETE FROM file_hashes WHERE id = \\$1").WithArgs(4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	// An upload reused blob 5 after it was listed, so it survives
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT hash_sha256, chunked FROM file_hashes WHERE id = \\$1 FOR UPDATE").WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"hash_sha256", "chunked"}).AddRow(strings.Repeat("d", 64), false))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM files WHERE hash_id = \\$1").WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(orphanedHash).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	report, err := services.NewGCService(db, uploadDir).Run(context.Background(), time.Hour, false)
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	require.Len(t, report.Blobs, 1)
+	assert.Equal(t, 4, report.Blobs[0].HashID)
+	require.Len(t, report.Files, 1)
+	assert.NoFileExists(t, orphan)
+	assert.InDelta(t, float64(1024+len("legacy"))/(1024*1024), report.FreedMB, 1e-9)
+}
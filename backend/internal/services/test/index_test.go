@@ -0,0 +1,181 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/internal/models"
+	"filevault/internal/services"
+	"filevault/internal/utils"
+)
+
+// buildPDF returns a one-page PDF whose page content is a Flate stream
+func buildPDF(t *testing.T, content string) []byte {
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	_, err := w.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	pdf.WriteString("1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	pdf.WriteString("2 0 obj\n<< /Type /Pages /Kids [3 0 R] /Count 1 >>\nendobj\n")
+	pdf.WriteString("3 0 obj\n<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>\nendobj\n")
+	fmt.Fprintf(&pdf, "4 0 obj\n<< /Length %d /Filter /FlateDecode >>\nstream\n", compressed.Len())
+	pdf.Write(compressed.Bytes())
+	pdf.WriteString("\nendstream\nendobj\ntrailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return pdf.Bytes()
+}
+
+func buildDocx(t *testing.T, body string) []byte {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	f, err := archive.Create("word/document.xml")
+	require.NoError(t, err)
+	_, err = f.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` + body + `</w:body></w:document>`))
+	require.NoError(t, err)
+	require.NoError(t, archive.Close())
+	return buf.Bytes()
+}
+
+func TestExtractText(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		mimeType string
+		expected string
+	}{
+		{
+			name:     "plain text loses control characters",
+			data:     []byte("quarterly\x00report\r\n\tbudget "),
+			mimeType: "text/plain; charset=utf-8",
+			expected: "quarterly report \n\tbudget",
+		},
+		{
+			name:     "untyped text is sniffed",
+			data:     []byte("meeting notes for tuesday"),
+			expected: "meeting notes for tuesday",
+		},
+		{
+			name:     "html drops markup and scripts",
+			data:     []byte(`<html><head><style>p{}</style><script>var secret = 1;</script></head><body><p>Invoice &amp; receipt</p></body></html>`),
+			mimeType: "text/html",
+			expected: "Invoice & receipt",
+		},
+		{
+			name:     "docx paragraphs",
+			data:     buildDocx(t, `<w:p><w:r><w:t>Project</w:t></w:r><w:r><w:t xml:space="preserve"> plan</w:t></w:r></w:p><w:p><w:r><w:t>Milestones</w:t></w:r></w:p>`),
+			mimeType: utils.DocxMimeType,
+			expected: "Project plan\nMilestones",
+		},
+		{
+			name:     "pdf text operators",
+			data:     buildPDF(t, "BT /F1 12 Tf 72 712 Td (Hello \\(PDF\\) world) Tj 0 -14 Td [(Kern)-120(ed)] TJ ET"),
+			mimeType: "application/pdf",
+			expected: "Hello (PDF) world\nKerned",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, err := utils.ExtractText(tt.data, tt.mimeType)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, text)
+		})
+	}
+}
+
+func TestExtractText_Rejects(t *testing.T) {
+	_, err := utils.ExtractText(encodePNG(t, gradient(4, 4, 0xff)), "image/png")
+	assert.ErrorIs(t, err, utils.ErrNoText)
+
+	_, err = utils.ExtractText([]byte("%PDF-1.4\n%%EOF\n"), "application/pdf")
+	assert.ErrorIs(t, err, utils.ErrNoText)
+
+	long, err := utils.ExtractText(bytes.Repeat([]byte("é"), utils.MaxExtractedText), "text/plain")
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(long), utils.MaxExtractedText)
+	assert.True(t, strings.HasSuffix(long, "é"), "cut on a rune boundary")
+}
+
+func TestIndexService_IndexBatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	notes := []byte("minutes of the budget meeting")
+	photo := encodePNG(t, gradient(4, 4, 0xff))
+	mock.ExpectQuery("SELECT id, hash_sha256, file_size, mime_type\\s+FROM file_hashes\\s+WHERE indexed_at IS NULL").
+		WithArgs(sqlmock.AnyArg(), 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "hash_sha256", "file_size", "mime_type"}).
+			AddRow(1, sha256Hex(notes), len(notes), "").
+			AddRow(2, sha256Hex(photo), len(photo), "application/octet-stream"))
+
+	mock.ExpectQuery("SELECT file_data, compression, chunked FROM file_hashes WHERE id = \\$1").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"file_data", "compression", "chunked"}).AddRow(notes, "none", false))
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO content_index \\(hash_id, content, search_vector\\)").
+		WithArgs(1, string(notes)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE file_hashes SET indexed_at = CURRENT_TIMESTAMP, index_error = \\$2").WithArgs(1, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Untyped content that turns out not to be text is recorded and not retried
+	mock.ExpectQuery("SELECT file_data, compression, chunked FROM file_hashes WHERE id = \\$1").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"file_data", "compression", "chunked"}).AddRow(photo, "none", false))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE file_hashes SET indexed_at = CURRENT_TIMESTAMP, index_error = \\$2").
+		WithArgs(2, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	result, err := services.NewIndexService(db, t.TempDir()).IndexBatch(context.Background(), 10)
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, 1, result.Indexed)
+	assert.Equal(t, 1, result.Skipped)
+}
+
+func TestFileService_GetFilesSearchesContent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	columns := append(append([]string{}, fileColumns...), "hash_sha256", "file_size", "mime_type", "username", "folder_name", "reference_count", "score", "metadata")
+	mock.ExpectQuery("LEFT JOIN content_index ci ON ci.hash_id = f.hash_id\\s+WHERE f.user_id = \\$1 AND \\(f.original_name ILIKE \\$2 .*ci.search_vector @@ plainto_tsquery\\('english', \\$3\\)\\) ORDER BY ts_rank\\(.*\\$3\\)\\) DESC").
+		WithArgs(1, "%budget%", "budget").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(7, 1, 4, "notes.txt", "notes.txt", nil, false, 0, now, now, "abc", 30, "text/plain", "alice", nil, 1, 0.6, nil).
+			AddRow(8, 1, 5, "budget.xlsx", "budget.xlsx", nil, false, 0, now, now, "def", 30, "application/zip", "alice", nil, 1, 0.2, nil))
+	mock.ExpectQuery("SELECT tag FROM file_tags WHERE file_id = \\$1").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"tag"}))
+	mock.ExpectQuery("SELECT tag FROM file_tags WHERE file_id = \\$1").WithArgs(8).
+		WillReturnRows(sqlmock.NewRows([]string{"tag"}))
+	mock.ExpectQuery("SELECT hash_id, ts_headline\\('english', content").
+		WithArgs(sqlmock.AnyArg(), "budget", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"hash_id", "ts_headline"}).
+			AddRow(4, "the <b>\x02budget\x03</b>\n  for  next year"))
+
+	list, err := services.NewFileService(db, t.TempDir()).GetFiles(context.Background(), 1, models.FileSearchRequest{Query: "budget"})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	files := list.Files
+	require.Len(t, files, 2)
+	assert.Equal(t, "the &lt;b&gt;<mark>budget</mark>&lt;/b&gt; for next year", files[0].Snippet,
+		"content is escaped and only the markers become tags")
+	assert.Empty(t, files[1].Snippet, "a file matched by name has no snippet")
+}
@@ -0,0 +1,207 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/internal/models"
+	"filevault/internal/services"
+)
+
+var fileListColumns = append(append([]string{}, fileColumns...),
+	"hash_sha256", "file_size", "mime_type", "username", "folder_name", "reference_count", "score", "metadata")
+
+func TestFileService_GetFilesSortsAndPagesByCursor(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	fileService := services.NewFileService(db, t.TempDir())
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	req := models.FileSearchRequest{SortBy: "type,-file_size", Limit: 2}
+
+	// One row more than the page tells there is another page
+	mock.ExpectQuery("WHERE f.user_id = \\$1 ORDER BY fh.mime_type ASC, fh.file_size DESC, f.id ASC LIMIT \\$2$").
+		WithArgs(1, 3).
+		WillReturnRows(sqlmock.NewRows(fileListColumns).
+			AddRow(3, 1, 30, "a.csv", "a.csv", nil, false, 0, now, now, "h3", 900, "text/csv", "alice", nil, 1, 0, nil).
+			AddRow(1, 1, 10, "b.csv", "b.csv", nil, false, 0, now, now, "h1", 500, "text/csv", "alice", nil, 1, 0, nil).
+			AddRow(2, 1, 20, "c.txt", "c.txt", nil, false, 0, now, now, "h2", 700, "text/plain", "alice", nil, 1, 0, nil))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\)\\s+FROM files f.*WHERE f.user_id = \\$1$").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery("SELECT tag FROM file_tags WHERE file_id = \\$1").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"tag"}))
+	mock.ExpectQuery("SELECT tag FROM file_tags WHERE file_id = \\$1").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"tag"}))
+
+	list, err := fileService.GetFiles(context.Background(), 1, req)
+	require.NoError(t, err)
+	require.Len(t, list.Files, 2)
+	assert.Equal(t, 3, list.Total)
+	assert.True(t, list.HasMore)
+	require.NotEmpty(t, list.NextCursor)
+
+	// The next page starts after the last row's keys, whatever was inserted
+	// before it in the meantime
+	req.Cursor = list.NextCursor
+	mock.ExpectQuery("WHERE f.user_id = \\$1 AND \\(fh.mime_type > \\$2 OR \\(fh.mime_type = \\$2 AND \\(fh.file_size < \\$3 OR \\(fh.file_size = \\$3 AND \\(f.id > \\$4\\)\\)\\)\\)\\) ORDER BY .* LIMIT \\$5$").
+		WithArgs(1, "text/csv", int64(500), int64(1), 3).
+		WillReturnRows(sqlmock.NewRows(fileListColumns).
+			AddRow(2, 1, 20, "c.txt", "c.txt", nil, false, 0, now, now, "h2", 700, "text/plain", "alice", nil, 1, 0, nil))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\)").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery("SELECT tag FROM file_tags WHERE file_id = \\$1").WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"tag"}))
+
+	list, err = fileService.GetFiles(context.Background(), 1, req)
+	require.NoError(t, err)
+	require.Len(t, list.Files, 1)
+	assert.Equal(t, 3, list.Total)
+	assert.False(t, list.HasMore)
+	assert.Empty(t, list.NextCursor)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFileService_GetFilesRejectsBadListings(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	fileService := services.NewFileService(db, t.TempDir())
+
+	_, err = fileService.GetFiles(context.Background(), 1, models.FileSearchRequest{SortBy: "password_hash"})
+	assert.ErrorIs(t, err, services.ErrInvalidSort)
+
+	_, err = fileService.GetFiles(context.Background(), 1, models.FileSearchRequest{SortBy: "name", SortOrder: "sideways"})
+	assert.ErrorIs(t, err, services.ErrInvalidSort)
+
+	_, err = fileService.GetFiles(context.Background(), 1, models.FileSearchRequest{Cursor: "not a cursor", Limit: 10})
+	assert.ErrorIs(t, err, services.ErrInvalidCursor)
+
+	// Relevance only exists when searching
+	_, err = fileService.GetFiles(context.Background(), 1, models.FileSearchRequest{SortBy: "relevance"})
+	assert.ErrorIs(t, err, services.ErrInvalidSort)
+
+	// A cursor only continues the order it was made for
+	now := time.Now()
+	mock.ExpectQuery("ORDER BY f.created_at DESC, f.id DESC").
+		WillReturnRows(sqlmock.NewRows(fileListColumns).
+			AddRow(2, 1, 20, "c.txt", "c.txt", nil, false, 0, now, now, "h2", 700, "text/plain", "alice", nil, 1, 0, nil).
+			AddRow(1, 1, 10, "b.csv", "b.csv", nil, false, 0, now, now, "h1", 500, "text/csv", "alice", nil, 1, 0, nil))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\)").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery("SELECT tag FROM file_tags").WillReturnRows(sqlmock.NewRows([]string{"tag"}))
+	list, err := fileService.GetFiles(context.Background(), 1, models.FileSearchRequest{Limit: 1})
+	require.NoError(t, err)
+	require.NotEmpty(t, list.NextCursor)
+
+	_, err = fileService.GetFiles(context.Background(), 1, models.FileSearchRequest{SortBy: "name", Cursor: list.NextCursor, Limit: 1})
+	assert.ErrorIs(t, err, services.ErrInvalidCursor)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFolderService_GetUserFoldersWithSearch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	columns := []string{"id", "user_id", "name", "parent_id", "is_public", "created_at", "updated_at", "username", "parent_name", "saved_search_id", "file_count", "folder_size"}
+
+	// Without a limit every folder is listed and counted from the rows
+	mock.ExpectQuery("WHERE f.user_id = \\$1 AND f.is_public = \\$2 ORDER BY \\(SELECT COUNT\\(\\*\\) FROM files WHERE folder_id = f.id\\) DESC, f.name ASC, f.id DESC$").
+		WithArgs(1, true).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(4, 1, "Photos", nil, true, now, now, "alice", nil, nil, 12, 4096).
+			AddRow(2, 1, "Docs", nil, true, now, now, "alice", nil, nil, 3, 1024))
+
+	public := true
+	list, err := services.NewFolderService(db).GetUserFoldersWithSearch(context.Background(), 1, models.FolderSearchRequest{
+		SortBy:   "-file_count,name",
+		IsPublic: &public,
+	})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	require.Len(t, list.Folders, 2)
+	assert.Equal(t, 2, list.Total)
+	assert.False(t, list.HasMore)
+	assert.Equal(t, "Photos", list.Folders[0].Name)
+}
+
+func TestFileService_GetFilesCountsFacets(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	fileService := services.NewFileService(db, t.TempDir())
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	root := 0
+	req := models.FileSearchRequest{MimeType: "image/*", FolderID: &root, Facets: []string{"size,folder", "month", "size"}, Limit: 20}
+
+	// Facets are counted over the same filters as the page
+	where := "WHERE f.user_id = \\$1 AND fh.mime_type LIKE \\$2 AND f.folder_id IS NULL"
+	mock.ExpectQuery(where+" ORDER BY").
+		WithArgs(1, "image/%", 21).
+		WillReturnRows(sqlmock.NewRows(fileListColumns).
+			AddRow(1, 1, 10, "a.png", "a.png", nil, false, 0, now, now, "h1", 2048, "image/png", "alice", nil, 1, 0, nil))
+	mock.ExpectQuery("SELECT CASE WHEN fh.file_size < 102400 THEN '0-100KB' .* ELSE '1GB\\+' END, '', COUNT\\(DISTINCT f.id\\).* "+
+		where+" GROUP BY 1 ORDER BY MIN\\(fh.file_size\\) LIMIT 20").
+		WithArgs(1, "image/%").
+		WillReturnRows(sqlmock.NewRows([]string{"value", "label", "count"}).
+			AddRow("0-100KB", "", 1).
+			AddRow("1GB+", "", 2))
+	mock.ExpectQuery("SELECT COALESCE\\(f.folder_id, 0\\)::text, COALESCE\\(MAX\\(fo.name\\), ''\\)").
+		WithArgs(1, "image/%").
+		WillReturnRows(sqlmock.NewRows([]string{"value", "label", "count"}).AddRow("0", "", 3))
+	mock.ExpectQuery("SELECT to_char\\(f.created_at, 'YYYY-MM'\\)").
+		WithArgs(1, "image/%").
+		WillReturnRows(sqlmock.NewRows([]string{"value", "label", "count"}).AddRow("2024-02", "", 3))
+	mock.ExpectQuery("SELECT tag FROM file_tags WHERE file_id = \\$1").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"tag"}))
+
+	list, err := fileService.GetFiles(context.Background(), 1, req)
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	require.Len(t, list.Facets, 3)
+	assert.Equal(t, []models.FacetValue{
+		{Value: "0-100KB", Count: 1, Filter: map[string]string{"min_size": "0", "max_size": "102399"}},
+		{Value: "1GB+", Count: 2, Filter: map[string]string{"min_size": "1073741824"}},
+	}, list.Facets["size"])
+	assert.Equal(t, map[string]string{"folder_id": "0"}, list.Facets["folder"][0].Filter)
+	assert.Equal(t, map[string]string{"start_date": "2024-02-01", "end_date": "2024-02-29T23:59:59.999999"}, list.Facets["month"][0].Filter)
+
+	_, err = fileService.GetFiles(context.Background(), 1, models.FileSearchRequest{Facets: []string{"password_hash"}})
+	assert.ErrorIs(t, err, services.ErrInvalidFacet)
+}
+
+func TestFileService_GetFilesUploaderFacetMatchesExactly(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	fileService := services.NewFileService(db, t.TempDir())
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("WHERE f.user_id = \\$1 ORDER BY").WithArgs(1, 21).
+		WillReturnRows(sqlmock.NewRows(fileListColumns).
+			AddRow(1, 1, 10, "a.png", "a.png", nil, false, 0, now, now, "h1", 2048, "image/png", "al", nil, 1, 0, nil))
+	mock.ExpectQuery("SELECT u.username, '', COUNT\\(DISTINCT f.id\\)").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"value", "label", "count"}).AddRow("al", "", 1).AddRow("alice", "", 4))
+	mock.ExpectQuery("SELECT tag FROM file_tags WHERE file_id = \\$1").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"tag"}))
+
+	list, err := fileService.GetFiles(context.Background(), 1, models.FileSearchRequest{Facets: []string{"uploader"}, Limit: 20})
+	require.NoError(t, err)
+	require.Len(t, list.Facets["uploader"], 2)
+	filter := list.Facets["uploader"][0].Filter
+	assert.Equal(t, map[string]string{"username": "al"}, filter)
+
+	// Drilling into "al" doesn't match alice's files as well
+	mock.ExpectQuery("WHERE f.user_id = \\$1 AND u.username = \\$2 ORDER BY").WithArgs(1, "al", 21).
+		WillReturnRows(sqlmock.NewRows(fileListColumns).
+			AddRow(1, 1, 10, "a.png", "a.png", nil, false, 0, now, now, "h1", 2048, "image/png", "al", nil, 1, 0, nil))
+	mock.ExpectQuery("SELECT tag FROM file_tags WHERE file_id = \\$1").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"tag"}))
+
+	list, err = fileService.GetFiles(context.Background(), 1, models.FileSearchRequest{Username: filter["username"], Limit: 20})
+	require.NoError(t, err)
+	assert.Len(t, list.Files, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
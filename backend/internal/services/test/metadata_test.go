@@ -0,0 +1,127 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/internal/models"
+	"filevault/internal/services"
+)
+
+func TestMetadataService_UpdateFileMetadata(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	metadataService := services.NewMetadataService(db)
+
+	now := time.Now()
+	schema := []byte(`[{"name":"project","type":"string","required":true,"enum":["AX-12","BQ-7"]},
+		{"name":"samples","type":"integer"},{"name":"collected","type":"date"}]`)
+	expectFile := func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT metadata, folder_id FROM files WHERE id = \\$1 AND user_id = \\$2 FOR UPDATE").
+			WithArgs(5, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"metadata", "folder_id"}).
+				AddRow([]byte(`{"project":"AX-12","samples":12,"collected":"2024-03-01"}`), 3))
+		mock.ExpectQuery("SELECT fields, additional, updated_at FROM metadata_schemas WHERE folder_id = \\$1").
+			WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"fields", "additional", "updated_at"}).AddRow(schema, false, now))
+	}
+
+	// Merging keeps the other fields, and null removes one
+	expectFile()
+	mock.ExpectExec("UPDATE files SET metadata = \\$1 WHERE id = \\$2").
+		WithArgs([]byte(`{"project":"AX-12","samples":24}`), 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	metadata, err := metadataService.UpdateFileMetadata(context.Background(), 1, 5,
+		models.Metadata{"samples": float64(24), "collected": nil}, false)
+	require.NoError(t, err)
+	assert.Equal(t, models.Metadata{"project": "AX-12", "samples": float64(24)}, metadata)
+
+	for name, tt := range map[string]struct {
+		values  models.Metadata
+		replace bool
+	}{
+		"value outside the enumeration": {values: models.Metadata{"project": "ZZ-1"}},
+		"fraction in an integer":        {values: models.Metadata{"samples": 2.5}},
+		"malformed date":                {values: models.Metadata{"collected": "03/01/2024"}},
+		"field not in the schema":       {values: models.Metadata{"operator": "kim"}},
+		"required field missing":        {values: models.Metadata{"samples": float64(3)}, replace: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			expectFile()
+			mock.ExpectRollback()
+
+			_, err := metadataService.UpdateFileMetadata(context.Background(), 1, 5, tt.values, tt.replace)
+			assert.ErrorIs(t, err, services.ErrInvalidMetadata)
+		})
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMetadataService_SetSchema(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	metadataService := services.NewMetadataService(db)
+
+	mock.ExpectQuery("INSERT INTO metadata_schemas \\(folder_id, fields, additional\\)\\s+SELECT id, \\$2, \\$3 FROM folders WHERE id = \\$1\\s+ON CONFLICT").
+		WithArgs(3, []byte(`[{"name":"instrument","type":"string","enum":["MS-3","MS-4"]}]`), true).
+		WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(time.Now()))
+
+	schema, err := metadataService.SetSchema(context.Background(), 3, models.UpdateMetadataSchemaRequest{
+		Fields:     []models.MetadataField{{Name: "instrument", Type: "string", Enum: []interface{}{"MS-3", "MS-4"}}},
+		Additional: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, schema.FolderID)
+
+	for _, fields := range [][]models.MetadataField{
+		{{Name: "ph", Type: "float"}},
+		{{Name: "sample id", Type: "string"}},
+		{{Name: "run", Type: "integer"}, {Name: "run", Type: "string"}},
+		{{Name: "run", Type: "integer", Enum: []interface{}{1.0, "two"}}},
+	} {
+		_, err := metadataService.SetSchema(context.Background(), 3, models.UpdateMetadataSchemaRequest{Fields: fields})
+		assert.ErrorIs(t, err, services.ErrInvalidMetadataSchema)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFileService_GetFilesFiltersByMetadata(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	fileService := services.NewFileService(db, t.TempDir())
+
+	now := time.Now()
+	mock.ExpectQuery("WHERE f.user_id = \\$1 AND \\(f.metadata ->> \\$2 = \\$3\\)"+
+		" AND \\(CASE WHEN jsonb_typeof\\(f.metadata -> \\$4\\) = 'number' THEN \\(f.metadata ->> \\$4\\)::numeric END >= \\$5\\)"+
+		" AND \\(f.metadata ->> \\$6 ILIKE \\$7\\) AND f.metadata \\? \\$8 ORDER BY").
+		WithArgs(1, "project", "AX-12", "samples", float64(20), "instrument", `%ma\_s%`, "sample_id", 11).
+		WillReturnRows(sqlmock.NewRows(fileListColumns).
+			AddRow(5, 1, 50, "run.csv", "run.csv", nil, false, 0, now, now, "h5", 900, "text/csv", "alice", nil, 1, 0,
+				[]byte(`{"project":"AX-12","samples":24,"sample_id":"S-9","instrument":"ma_spec"}`)))
+	mock.ExpectQuery("SELECT tag FROM file_tags WHERE file_id = \\$1").WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"tag"}))
+
+	list, err := fileService.GetFiles(context.Background(), 1, models.FileSearchRequest{
+		Metadata: []string{"project=AX-12", "samples >= 20", "instrument~ma_s", "sample_id"},
+		Limit:    10,
+	})
+	require.NoError(t, err)
+	require.Len(t, list.Files, 1)
+	assert.Equal(t, "S-9", list.Files[0].Metadata["sample_id"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	for _, filter := range []string{"", "=AX-12", "project name=x", "project^x"} {
+		_, err := fileService.GetFiles(context.Background(), 1, models.FileSearchRequest{Metadata: []string{filter}})
+		assert.ErrorIs(t, err, services.ErrInvalidMetadataFilter, filter)
+	}
+}
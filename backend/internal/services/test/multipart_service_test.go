@@ -0,0 +1,153 @@
+package test
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/internal/models"
+	"filevault/internal/services"
+)
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestMultipartService_AssembleParts(t *testing.T) {
+	part1 := []byte("hello ")
+	part2 := []byte("world")
+	etag1 := md5Hex(part1)
+	etag2 := md5Hex(part2)
+
+	d1, _ := hex.DecodeString(etag1)
+	d2, _ := hex.DecodeString(etag2)
+	expectedETag := md5Hex(append(d1, d2...)) + "-2"
+
+	tests := []struct {
+		name          string
+		partNumbers   []int
+		etags         []string
+		maxSize       int64
+		mockSetup     func(sqlmock.Sqlmock)
+		expectedError error
+		expectedData  string
+		expectedETag  string
+	}{
+		{
+			name:        "successful assembly",
+			partNumbers: []int{1, 2},
+			etags:       []string{etag1, etag2},
+			maxSize:     1024,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT etag, data FROM s3_multipart_parts").
+					WithArgs(7, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"etag", "data"}).AddRow(etag1, part1))
+				mock.ExpectQuery("SELECT etag, data FROM s3_multipart_parts").
+					WithArgs(7, 2).
+					WillReturnRows(sqlmock.NewRows([]string{"etag", "data"}).AddRow(etag2, part2))
+			},
+			expectedData: "hello world",
+			expectedETag: expectedETag,
+		},
+		{
+			name:        "etag mismatch",
+			partNumbers: []int{1},
+			etags:       []string{etag2},
+			maxSize:     1024,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT etag, data FROM s3_multipart_parts").
+					WithArgs(7, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"etag", "data"}).AddRow(etag1, part1))
+			},
+			expectedError: services.ErrInvalidPart,
+		},
+		{
+			name:        "missing part",
+			partNumbers: []int{3},
+			etags:       []string{""},
+			maxSize:     1024,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT etag, data FROM s3_multipart_parts").
+					WithArgs(7, 3).
+					WillReturnRows(sqlmock.NewRows([]string{"etag", "data"}))
+			},
+			expectedError: services.ErrInvalidPart,
+		},
+		{
+			name:        "object too large",
+			partNumbers: []int{1, 2},
+			etags:       []string{etag1, etag2},
+			maxSize:     8,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT etag, data FROM s3_multipart_parts").
+					WithArgs(7, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"etag", "data"}).AddRow(etag1, part1))
+				mock.ExpectQuery("SELECT etag, data FROM s3_multipart_parts").
+					WithArgs(7, 2).
+					WillReturnRows(sqlmock.NewRows([]string{"etag", "data"}).AddRow(etag2, part2))
+			},
+			expectedError: services.ErrObjectTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			tt.mockSetup(mock)
+
+			service := services.NewMultipartService(db)
+			data, etag, err := service.AssembleParts(context.Background(), &models.MultipartUpload{ID: 7}, tt.partNumbers, tt.etags, tt.maxSize)
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedData, string(data))
+				assert.Equal(t, tt.expectedETag, etag)
+			}
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestMultipartService_PutPart(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	service := services.NewMultipartService(db)
+	upload := &models.MultipartUpload{ID: 7, UserID: 1}
+	part := []byte("hello")
+
+	expectUsage := func(usage int64) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT storage_quota_mb FROM users WHERE id = \\$1 FOR UPDATE").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"storage_quota_mb"}).AddRow(1))
+		mock.ExpectQuery("FROM s3_multipart_parts p").WithArgs(1, 7, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"usage"}).AddRow(usage))
+	}
+
+	expectUsage(1024*1024 - int64(len(part)))
+	mock.ExpectExec("INSERT INTO s3_multipart_parts").WithArgs(7, 2, md5Hex(part), len(part), part).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	etag, err := service.PutPart(context.Background(), upload, 2, part)
+	require.NoError(t, err)
+	assert.Equal(t, md5Hex(part), etag)
+
+	// Files and other pending parts already fill the quota
+	expectUsage(1024*1024 - int64(len(part)) + 1)
+	mock.ExpectRollback()
+	_, err = service.PutPart(context.Background(), upload, 2, part)
+	assert.ErrorIs(t, err, services.ErrQuotaExceeded)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
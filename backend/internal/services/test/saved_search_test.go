@@ -0,0 +1,136 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/internal/models"
+	"filevault/internal/services"
+)
+
+func TestParseDateRange(t *testing.T) {
+	// A Wednesday in the middle of the second quarter
+	now := time.Date(2024, 5, 15, 14, 30, 0, 0, time.UTC)
+	day := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		expr       string
+		start, end time.Time
+	}{
+		{"today", day(2024, 5, 15), day(2024, 5, 16)},
+		{"Yesterday", day(2024, 5, 14), day(2024, 5, 15)},
+		{"this week", day(2024, 5, 13), day(2024, 5, 20)},
+		{"last week", day(2024, 5, 6), day(2024, 5, 13)},
+		{"this month", day(2024, 5, 1), day(2024, 6, 1)},
+		{"last  month", day(2024, 4, 1), day(2024, 5, 1)},
+		{"last quarter", day(2024, 1, 1), day(2024, 4, 1)},
+		{"this year", day(2024, 1, 1), day(2025, 1, 1)},
+		{"last 30 days", now.AddDate(0, 0, -30), time.Time{}},
+		{"last 1 week", now.AddDate(0, 0, -7), time.Time{}},
+		{"last 6 months", now.AddDate(0, -6, 0), time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			start, end, err := services.ParseDateRange(tt.expr, now)
+			require.NoError(t, err)
+			assert.Equal(t, tt.start, start)
+			assert.Equal(t, tt.end, end)
+		})
+	}
+
+	for _, expr := range []string{"", "next week", "last 0 days", "last -3 days", "last fortnight", "last 3 decades"} {
+		_, _, err := services.ParseDateRange(expr, now)
+		assert.ErrorIs(t, err, services.ErrInvalidDateRange, expr)
+	}
+}
+
+func TestSavedSearchService_CreateSavedSearch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	savedSearches := services.NewSavedSearchService(db, services.NewFileService(db, t.TempDir()))
+
+	parentID := 7
+	now := time.Now()
+
+	// Paging is not part of what is saved
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM saved_searches WHERE user_id = \\$1 AND name = \\$2").
+		WithArgs(1, "Invoices").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("INSERT INTO saved_searches").
+		WithArgs(1, "Invoices", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectQuery("SELECT user_id FROM folders WHERE id = \\$1 AND saved_search_id IS NULL").
+		WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	mock.ExpectQuery("SELECT id FROM folders WHERE user_id = \\$1 AND name = \\$2 AND saved_search_id IS DISTINCT FROM \\$3 AND parent_id = \\$4").
+		WithArgs(1, "Invoices", 3, 7).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectExec("INSERT INTO folders \\(user_id, name, parent_id, is_public, saved_search_id\\)").
+		WithArgs(1, "Invoices", &parentID, 3).WillReturnResult(sqlmock.NewResult(12, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("FROM saved_searches ss\\s+LEFT JOIN folders fo ON fo.saved_search_id = ss.id\\s+WHERE ss.id = \\$1 AND ss.user_id = \\$2").
+		WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "name", "request", "folder_id", "created_at", "updated_at"}).
+			AddRow(3, 1, "Invoices", []byte(`{"mime_type":"application/pdf","tags":["invoice"],"date_range":"last quarter"}`), 12, now, now))
+
+	search, err := savedSearches.CreateSavedSearch(context.Background(), 1, models.CreateSavedSearchRequest{
+		Name:        "Invoices",
+		Request:     models.FileSearchRequest{MimeType: "application/pdf", Tags: []string{"invoice"}, DateRange: "last quarter", Page: 4},
+		SmartFolder: true,
+		ParentID:    &parentID,
+	})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	require.NotNil(t, search.FolderID)
+	assert.Equal(t, 12, *search.FolderID)
+	assert.Equal(t, "last quarter", search.Request.DateRange)
+
+	// A search that couldn't run isn't saved
+	_, err = savedSearches.CreateSavedSearch(context.Background(), 1, models.CreateSavedSearchRequest{
+		Name:    "Soon",
+		Request: models.FileSearchRequest{DateRange: "next week"},
+	})
+	assert.ErrorIs(t, err, services.ErrInvalidDateRange)
+
+	_, err = savedSearches.CreateSavedSearch(context.Background(), 1, models.CreateSavedSearchRequest{
+		Name:    "Best",
+		Request: models.FileSearchRequest{SortBy: "relevance"},
+	})
+	assert.ErrorIs(t, err, services.ErrInvalidSort)
+}
+
+func TestSavedSearchService_RunSavedSearch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	savedSearches := services.NewSavedSearchService(db, services.NewFileService(db, t.TempDir()))
+
+	now := time.Now()
+
+	// User 2 has the smart folder shared with them and sees the owner's files
+	mock.ExpectQuery("SELECT ss.user_id, ss.request\\s+FROM saved_searches ss.*folder_shares").
+		WithArgs(3, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "request"}).
+			AddRow(1, []byte(`{"mime_type":"application/pdf","date_range":"last 30 days","sort_by":"name"}`)))
+	mock.ExpectQuery("WHERE f.user_id = \\$1 AND fh.mime_type = \\$2 AND f.created_at >= \\$3 ORDER BY f.display_name ASC, f.id ASC LIMIT \\$4$").
+		WithArgs(1, "application/pdf", sqlmock.AnyArg(), 11).
+		WillReturnRows(sqlmock.NewRows(fileListColumns).
+			AddRow(5, 1, 50, "march.pdf", "march.pdf", nil, false, 0, now, now, "h5", 900, "application/pdf", "alice", nil, 1, 0, nil))
+	mock.ExpectQuery("SELECT tag FROM file_tags WHERE file_id = \\$1").WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"tag"}))
+
+	list, err := savedSearches.RunSavedSearch(context.Background(), 3, 2, models.FileSearchRequest{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, list.Files, 1)
+	assert.Equal(t, "march.pdf", list.Files[0].OriginalName)
+
+	// Anyone else can't tell it exists
+	mock.ExpectQuery("SELECT ss.user_id, ss.request").WithArgs(3, 9).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "request"}))
+	_, err = savedSearches.RunSavedSearch(context.Background(), 3, 9, models.FileSearchRequest{Limit: 10})
+	assert.ErrorIs(t, err, services.ErrSavedSearchNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
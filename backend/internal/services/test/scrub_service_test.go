@@ -0,0 +1,137 @@
+package test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/internal/services"
+)
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func expectScrubCandidates(mock sqlmock.Sqlmock, rows *sqlmock.Rows) {
+	mock.ExpectQuery("SELECT id, hash_sha256, file_size\\s+FROM file_hashes\\s+WHERE last_verified_at IS NULL").
+		WithArgs(float64(7*24*3600), 10).
+		WillReturnRows(rows)
+}
+
+func TestScrubService_FlagsCorruptBlobs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	good := []byte("hello")
+	expectScrubCandidates(mock, sqlmock.NewRows([]string{"id", "hash_sha256", "file_size"}).
+		AddRow(1, sha256Hex(good), len(good)).
+		AddRow(2, sha256Hex(good), len(good)))
+
+	mock.ExpectQuery("SELECT file_data, compression, chunked FROM file_hashes WHERE id = \\$1").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"file_data", "compression", "chunked"}).AddRow(good, "none", false))
+	mock.ExpectExec("UPDATE file_hashes\\s+SET last_verified_at = CURRENT_TIMESTAMP, corrupt_at = NULL").WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// Same length, different bytes
+	mock.ExpectQuery("SELECT file_data, compression, chunked FROM file_hashes WHERE id = \\$1").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"file_data", "compression", "chunked"}).AddRow([]byte("jello"), "none", false))
+	mock.ExpectExec("UPDATE file_hashes\\s+SET last_verified_at = CURRENT_TIMESTAMP, corrupt_at = COALESCE").
+		WithArgs(2, "hash mismatch").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM file_hashes WHERE corrupt_at IS NOT NULL").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	result, err := services.NewScrubService(db, t.TempDir()).ScrubBatch(context.Background(), services.ScrubOptions{
+		BatchSize:     10,
+		ReverifyAfter: 7 * 24 * time.Hour,
+	})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Equal(t, 2, result.Checked)
+	assert.Equal(t, 1, result.Corrupt)
+	assert.Equal(t, int64(2*len(good)), result.Bytes)
+}
+
+func TestScrubService_ThrottleStopsOnCancel(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	data := []byte(strings.Repeat("x", 1024))
+	expectScrubCandidates(mock, sqlmock.NewRows([]string{"id", "hash_sha256", "file_size"}).
+		AddRow(1, sha256Hex(data), len(data)).
+		AddRow(2, sha256Hex(data), len(data)))
+	mock.ExpectQuery("SELECT file_data, compression, chunked FROM file_hashes WHERE id = \\$1").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"file_data", "compression", "chunked"}).AddRow(data, "none", false))
+	mock.ExpectExec("UPDATE file_hashes").WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// At one byte per second the pause after the first blob outlasts ctx
+	start := time.Now()
+	result, err := services.NewScrubService(db, t.TempDir()).ScrubBatch(ctx, services.ScrubOptions{
+		BatchSize:      10,
+		ReverifyAfter:  7 * 24 * time.Hour,
+		BytesPerSecond: 1,
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, 1, result.Checked)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestScrubService_ReportListsAffectedFiles(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	verified := time.Now().Add(-time.Hour)
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\), COUNT\\(last_verified_at\\), MIN\\(last_verified_at\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"count", "count", "min"}).AddRow(3, 2, verified))
+	mock.ExpectQuery("SELECT id, hash_sha256, file_size, corruption, corrupt_at, last_verified_at").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "hash_sha256", "file_size", "corruption", "corrupt_at", "last_verified_at"}).
+			AddRow(2, strings.Repeat("e", 64), 5, "hash mismatch", verified, verified))
+	mock.ExpectQuery("SELECT f.id, f.display_name, u.username").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "display_name", "username"}).
+			AddRow(7, "report.pdf", "alice").
+			AddRow(9, "copy of report.pdf", "bob"))
+
+	report, err := services.NewScrubService(db, t.TempDir()).Report(context.Background())
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Equal(t, 3, report.TotalBlobs)
+	assert.Equal(t, 2, report.VerifiedBlobs)
+	require.NotNil(t, report.OldestVerifiedAt)
+	require.Equal(t, 1, report.CorruptCount)
+	assert.Equal(t, "hash mismatch", report.Corrupt[0].Problem)
+	require.Len(t, report.Corrupt[0].Files, 2)
+	assert.Equal(t, "bob", report.Corrupt[0].Files[1].Username)
+}
+
+func TestFileService_DownloadRefusesCorruptBlob(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT fh.file_data, fh.compression, fh.file_size, f.original_name, fh.corrupt_at IS NOT NULL").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"file_data", "compression", "file_size", "original_name", "corrupt", "id", "chunked"}).
+			AddRow([]byte("jello"), "none", 5, "report.pdf", true, 4, false))
+
+	data, _, err := services.NewFileService(db, t.TempDir()).DownloadFile(context.Background(), 7)
+	assert.ErrorIs(t, err, services.ErrBlobCorrupt)
+	assert.Nil(t, data)
+	// The download is not counted
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
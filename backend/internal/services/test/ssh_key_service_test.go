@@ -0,0 +1,78 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/internal/models"
+	"filevault/internal/services"
+)
+
+const testPublicKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGiYLNHYJ4VhOl2O8Q3Ww4j6N0zq6n0e0Q8FzMqfS5rp partner@example.com"
+
+func TestSSHKeyService_AddSSHKey(t *testing.T) {
+	tests := []struct {
+		name          string
+		req           models.CreateSSHKeyRequest
+		mockSetup     func(sqlmock.Sqlmock)
+		expectedError bool
+		expectedName  string
+	}{
+		{
+			name: "uses key comment as name",
+			req:  models.CreateSSHKeyRequest{PublicKey: testPublicKey},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT id FROM ssh_keys WHERE fingerprint").
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery("INSERT INTO ssh_keys").
+					WithArgs(1, "partner@example.com", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "name", "public_key", "fingerprint", "last_used_at", "created_at"}).
+						AddRow(1, 1, "partner@example.com", testPublicKey, "SHA256:abc", nil, time.Now()))
+			},
+			expectedName: "partner@example.com",
+		},
+		{
+			name:          "invalid key",
+			req:           models.CreateSSHKeyRequest{PublicKey: "not a key"},
+			mockSetup:     func(mock sqlmock.Sqlmock) {},
+			expectedError: true,
+		},
+		{
+			name: "duplicate key",
+			req:  models.CreateSSHKeyRequest{Name: "laptop", PublicKey: testPublicKey},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT id FROM ssh_keys WHERE fingerprint").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			tt.mockSetup(mock)
+
+			service := services.NewSSHKeyService(db)
+			key, err := service.AddSSHKey(context.Background(), 1, tt.req)
+
+			if tt.expectedError {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedName, key.Name)
+			}
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/internal/models"
+	"filevault/internal/services"
+)
+
+func TestTagService_GetTags(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Wildcards in the prefix are matched literally
+	mock.ExpectQuery("WHERE f.user_id = \\$1 AND ft.tag ILIKE \\$2 GROUP BY ft.tag ORDER BY COUNT\\(\\*\\) DESC, ft.tag LIMIT \\$3").
+		WithArgs(1, `in\_v%`, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"tag", "count"}).AddRow("in_voice", 4))
+
+	tags, err := services.NewTagService(db).GetTags(context.Background(), 1, " in_v", 5)
+	require.NoError(t, err)
+	assert.Equal(t, []models.TagCount{{Tag: "in_voice", Count: 4}}, tags)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTagService_GetFileTags(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	tagService := services.NewTagService(db)
+
+	mock.ExpectQuery("SELECT 1 FROM files WHERE id = \\$1 AND user_id = \\$2").WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(1))
+	mock.ExpectQuery("SELECT ft.tag\\s+FROM file_tags ft").WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"tag"}))
+
+	tags, err := tagService.GetFileTags(context.Background(), 1, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, tags)
+
+	// Another user's file isn't an empty tag list
+	mock.ExpectQuery("SELECT 1 FROM files WHERE id = \\$1 AND user_id = \\$2").WithArgs(9, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}))
+
+	_, err = tagService.GetFileTags(context.Background(), 1, 9)
+	assert.ErrorIs(t, err, services.ErrFilesNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTagService_UpdateFileTags(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	tagService := services.NewTagService(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM files WHERE id = ANY\\(\\$1\\) AND user_id = \\$2").
+		WithArgs(pq.Array([]int64{3, 4}), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectExec("DELETE FROM file_tags WHERE file_id = ANY\\(\\$1\\) AND tag = ANY\\(\\$2\\)").
+		WithArgs(pq.Array([]int64{3, 4}), pq.Array([]string{"draft"})).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO file_tags \\(file_id, tag\\)\\s+SELECT f.id, t.tag FROM unnest").
+		WithArgs(pq.Array([]int64{3, 4}), pq.Array([]string{"final", "2024"})).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectCommit()
+
+	err = tagService.UpdateFileTags(context.Background(), 1, models.UpdateFileTagsRequest{
+		FileIDs: []int{3, 4, 3},
+		Add:     []string{" final", "2024", "final"},
+		Remove:  []string{"draft"},
+	})
+	require.NoError(t, err)
+
+	// One file that isn't the user's leaves every file as it was
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM files").
+		WithArgs(pq.Array([]int64{3, 9}), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectRollback()
+
+	err = tagService.UpdateFileTags(context.Background(), 1, models.UpdateFileTagsRequest{FileIDs: []int{3, 9}, Add: []string{"final"}})
+	assert.ErrorIs(t, err, services.ErrFilesNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	err = tagService.UpdateFileTags(context.Background(), 1, models.UpdateFileTagsRequest{FileIDs: []int{3}, Add: []string{strings.Repeat("x", 51)}})
+	assert.ErrorIs(t, err, services.ErrInvalidTag)
+}
+
+func TestTagService_MergeTags(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	tagService := services.NewTagService(db)
+
+	sources := pq.Array([]string{"Invoice", "invoices"})
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT COUNT\\(DISTINCT ft.file_id\\)").
+		WithArgs(1, sources, "invoice").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(6))
+	mock.ExpectExec("INSERT INTO file_tags \\(file_id, tag\\)\\s+SELECT DISTINCT ft.file_id, \\$3").
+		WithArgs(1, sources, "invoice").
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.ExpectExec("DELETE FROM file_tags ft\\s+USING files f").
+		WithArgs(1, sources, "invoice").
+		WillReturnResult(sqlmock.NewResult(0, 7))
+	mock.ExpectCommit()
+
+	retagged, err := tagService.MergeTags(context.Background(), 1, []string{"Invoice", "invoices"}, "invoice")
+	require.NoError(t, err)
+	assert.Equal(t, 6, retagged)
+
+	// Renaming a tag nobody uses
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT COUNT\\(DISTINCT ft.file_id\\)").
+		WithArgs(1, pq.Array([]string{"misc"}), "other").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectRollback()
+
+	_, err = tagService.MergeTags(context.Background(), 1, []string{"misc"}, "other")
+	assert.ErrorIs(t, err, services.ErrTagNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,131 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/internal/services"
+	"filevault/internal/utils"
+)
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func gradient(w, h int, alpha uint8) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 0x80, A: alpha})
+		}
+	}
+	return img
+}
+
+func TestGenerateThumbnails_Images(t *testing.T) {
+	var opaque bytes.Buffer
+	require.NoError(t, jpeg.Encode(&opaque, gradient(1600, 900, 0xff), nil))
+
+	thumbnails, err := utils.GenerateThumbnails(opaque.Bytes(), "image/jpeg")
+	require.NoError(t, err)
+	require.Len(t, thumbnails, len(utils.ThumbnailSizes))
+	for name, box := range utils.ThumbnailSizes {
+		thumbnail := thumbnails[name]
+		assert.Equal(t, "image/jpeg", thumbnail.MimeType)
+		assert.Equal(t, box, thumbnail.Width, name)
+		assert.Equal(t, box*9/16, thumbnail.Height, "%s keeps the aspect ratio", name)
+		decoded, err := jpeg.Decode(bytes.NewReader(thumbnail.Data))
+		require.NoError(t, err)
+		assert.Equal(t, thumbnail.Width, decoded.Bounds().Dx())
+	}
+
+	// Transparency needs PNG, and small images are not scaled up
+	thumbnails, err = utils.GenerateThumbnails(encodePNG(t, gradient(200, 100, 0x80)), "image/png")
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", thumbnails["large"].MimeType)
+	assert.Equal(t, 200, thumbnails["large"].Width)
+	assert.Equal(t, 128, thumbnails["small"].Width)
+	assert.Equal(t, 64, thumbnails["small"].Height)
+}
+
+func TestGenerateThumbnails_TextPreview(t *testing.T) {
+	text := strings.Repeat("SELECT id, name\tFROM users WHERE active;\n", 100)
+	thumbnails, err := utils.GenerateThumbnails([]byte(text), "application/sql")
+	require.NoError(t, err)
+
+	large := thumbnails["large"]
+	assert.Equal(t, "image/png", large.MimeType)
+	assert.Less(t, large.Width, large.Height, "previews are page shaped")
+	page, err := png.Decode(bytes.NewReader(large.Data))
+	require.NoError(t, err)
+	assert.Equal(t, color.RGBAModel.Convert(color.White), color.RGBAModel.Convert(page.At(0, 0)))
+}
+
+func TestGenerateThumbnails_Rejects(t *testing.T) {
+	_, err := utils.GenerateThumbnails([]byte("PK\x03\x04"), "application/zip")
+	assert.Error(t, err)
+
+	_, err = utils.GenerateThumbnails([]byte("not a png"), "image/png")
+	assert.Error(t, err)
+
+	// A tiny GIF claiming to be 65535x65535 is refused before decoding
+	var buf bytes.Buffer
+	require.NoError(t, gif.Encode(&buf, image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.Black}), nil))
+	bomb := buf.Bytes()
+	copy(bomb[6:10], []byte{0xff, 0xff, 0xff, 0xff})
+	_, err = utils.GenerateThumbnails(bomb, "image/gif")
+	assert.ErrorIs(t, err, utils.ErrImageTooLarge)
+}
+
+func TestThumbnailService_GenerateBatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	photo := encodePNG(t, gradient(300, 300, 0xff))
+	mock.ExpectQuery("SELECT id, hash_sha256, file_size, mime_type\\s+FROM file_hashes\\s+WHERE thumbnailed_at IS NULL").
+		WithArgs(sqlmock.AnyArg(), 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "hash_sha256", "file_size", "mime_type"}).
+			AddRow(1, sha256Hex(photo), len(photo), "image/png").
+			AddRow(2, sha256Hex([]byte("broken")), 6, "image/jpeg"))
+
+	mock.ExpectQuery("SELECT file_data, compression, chunked FROM file_hashes WHERE id = \\$1").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"file_data", "compression", "chunked"}).AddRow(photo, "none", false))
+	mock.ExpectBegin()
+	for _, size := range []string{"large", "medium", "small"} {
+		mock.ExpectExec("INSERT INTO thumbnails \\(hash_sha256, size, mime_type, width, height, data\\)").
+			WithArgs(sha256Hex(photo), size, "image/jpeg", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec("UPDATE file_hashes SET thumbnailed_at = CURRENT_TIMESTAMP, thumbnail_error = \\$2").WithArgs(1, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Content that doesn't decode is recorded and not retried
+	mock.ExpectQuery("SELECT file_data, compression, chunked FROM file_hashes WHERE id = \\$1").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"file_data", "compression", "chunked"}).AddRow([]byte("broken"), "none", false))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE file_hashes SET thumbnailed_at = CURRENT_TIMESTAMP, thumbnail_error = \\$2").
+		WithArgs(2, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	result, err := services.NewThumbnailService(db, t.TempDir()).GenerateBatch(context.Background(), 10)
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, 1, result.Generated)
+	assert.Equal(t, 1, result.Failed)
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+
+	"filevault/internal/metrics"
+	"filevault/internal/models"
+	"filevault/internal/tracing"
+	"filevault/internal/utils"
+
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// maxThumbnailSourceSize is the largest content thumbnails are generated
+// from; the whole blob is loaded to render it
+const maxThumbnailSourceSize = 64 << 20
+
+// ErrNoThumbnail means a file has no thumbnail of the requested size, either
+// because its type has none or because it hasn't been generated yet
+var ErrNoThumbnail = errors.New("no thumbnail available")
+
+// ThumbnailService renders previews of stored content in the background and
+// serves them. Thumbnails belong to content, not files, so deduplicated files
+// share them.
+type ThumbnailService struct {
+	db        *sql.DB
+	uploadDir string
+}
+
+func NewThumbnailService(db *sql.DB, uploadDir string) *ThumbnailService {
+	return &ThumbnailService{db: db, uploadDir: uploadDir}
+}
+
+// Thumbnail is a stored preview of one size
+type Thumbnail struct {
+	HashSHA256 string
+	Size       string
+	MimeType   string
+	Data       []byte
+}
+
+// GenerateBatch renders thumbnails for up to batchSize blobs that have never
+// been tried, oldest first. A blob that can't be rendered is recorded with the
+// reason and not tried again.
+func (s *ThumbnailService) GenerateBatch(ctx context.Context, batchSize int) (*models.ThumbnailResult, error) {
+	ctx, span := tracing.Start(ctx, "ThumbnailService.GenerateBatch")
+	defer span.End()
+
+	patterns := make([]string, len(utils.ThumbnailMimePrefixes))
+	for i, prefix := range utils.ThumbnailMimePrefixes {
+		patterns[i] = prefix + "%"
+	}
+	type blobRow struct {
+		id       int
+		hash     string
+		size     int64
+		mimeType string
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, hash_sha256, file_size, mime_type
+		FROM file_hashes
+		WHERE thumbnailed_at IS NULL AND corrupt_at IS NULL AND mime_type LIKE ANY($1)
+		ORDER BY id
+		LIMIT $2`,
+		pq.Array(patterns), batchSize)
+	if err != nil {
+		return nil, err
+	}
+	var blobs []blobRow
+	for rows.Next() {
+		var b blobRow
+		if err := rows.Scan(&b.id, &b.hash, &b.size, &b.mimeType); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		blobs = append(blobs, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &models.ThumbnailResult{}
+	for _, b := range blobs {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		thumbnails, problem, err := s.render(ctx, b.id, b.hash, b.size, b.mimeType)
+		if err == sql.ErrNoRows {
+			// Deleted since the batch was listed
+			continue
+		} else if err != nil {
+			tracing.RecordError(span, err)
+			return result, err
+		}
+
+		if err := s.save(ctx, b.id, b.hash, thumbnails, problem); err != nil {
+			tracing.RecordError(span, err)
+			return result, err
+		}
+		if problem != "" {
+			result.Failed++
+			metrics.ThumbnailBlobs.WithLabelValues("failed").Inc()
+		} else {
+			result.Generated++
+			metrics.ThumbnailBlobs.WithLabelValues("generated").Inc()
+		}
+	}
+	span.SetAttributes(attribute.Int("thumbnails.generated", result.Generated), attribute.Int("thumbnails.failed", result.Failed))
+	return result, nil
+}
+
+// render generates a blob's thumbnails. Content that can't be previewed gives
+// a problem rather than an error.
+func (s *ThumbnailService) render(ctx context.Context, hashID int, hash string, size int64, mimeType string) (map[string]utils.Thumbnail, string, error) {
+	if size > maxThumbnailSourceSize {
+		return nil, fmt.Sprintf("content larger than %d MB", maxThumbnailSourceSize>>20), nil
+	}
+	data, err := loadBlob(ctx, s.db, s.uploadDir, hashID, hash)
+	if errors.Is(err, errBlobUnreadable) {
+		return nil, err.Error(), nil
+	} else if err != nil {
+		return nil, "", err
+	}
+	if data == nil {
+		return nil, "content is missing", nil
+	}
+
+	_, renderSpan := tracing.Start(ctx, "render_thumbnails")
+	defer renderSpan.End()
+	thumbnails, err := utils.GenerateThumbnails(data, mimeType)
+	if err != nil {
+		return nil, err.Error(), nil
+	}
+	return thumbnails, "", nil
+}
+
+// save stores a blob's thumbnails and marks it as tried
+func (s *ThumbnailService) save(ctx context.Context, hashID int, hash string, thumbnails map[string]utils.Thumbnail, problem string) error {
+	sizes := make([]string, 0, len(thumbnails))
+	for size := range thumbnails {
+		sizes = append(sizes, size)
+	}
+	slices.Sort(sizes)
+
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		for _, size := range sizes {
+			t := thumbnails[size]
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO thumbnails (hash_sha256, size, mime_type, width, height, data)
+				VALUES ($1, $2, $3, $4, $5, $6)
+				ON CONFLICT (hash_sha256, size) DO UPDATE
+				SET mime_type = EXCLUDED.mime_type, width = EXCLUDED.width, height = EXCLUDED.height,
+				    data = EXCLUDED.data, created_at = CURRENT_TIMESTAMP`,
+				hash, size, t.MimeType, t.Width, t.Height, t.Data)
+			if err != nil {
+				return err
+			}
+		}
+
+		var thumbnailError sql.NullString
+		if problem != "" {
+			thumbnailError = sql.NullString{String: problem, Valid: true}
+		}
+		_, err := tx.ExecContext(ctx,
+			"UPDATE file_hashes SET thumbnailed_at = CURRENT_TIMESTAMP, thumbnail_error = $2 WHERE id = $1",
+			hashID, thumbnailError)
+		return err
+	})
+}
+
+// GetThumbnail returns file fileID's thumbnail of the given size, one of
+// utils.ThumbnailSizes. The file must be the user's, public or shared with
+// them. It returns sql.ErrNoRows if the user can't see the file and
+// ErrNoThumbnail if it has no such thumbnail.
+func (s *ThumbnailService) GetThumbnail(ctx context.Context, fileID, userID int, size string) (*Thumbnail, error) {
+	ctx, span := tracing.Start(ctx, "ThumbnailService.GetThumbnail")
+	defer span.End()
+
+	t := &Thumbnail{Size: size}
+	var mimeType sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		SELECT fh.hash_sha256, t.mime_type, t.data
+		FROM files f
+		JOIN file_hashes fh ON f.hash_id = fh.id
+		LEFT JOIN thumbnails t ON t.hash_sha256 = fh.hash_sha256 AND t.size = $2
+		WHERE f.id = $1 AND (f.user_id = $3 OR f.is_public
+			OR EXISTS (SELECT 1 FROM file_shares fs WHERE fs.file_id = f.id AND fs.shared_with_user_id = $3)
+			OR EXISTS (SELECT 1 FROM folder_shares fs WHERE fs.folder_id = f.folder_id AND fs.shared_with_user_id = $3))`,
+		fileID, size, userID).Scan(&t.HashSHA256, &mimeType, &t.Data)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			tracing.RecordError(span, err)
+		}
+		return nil, err
+	}
+	if !mimeType.Valid {
+		return nil, ErrNoThumbnail
+	}
+	t.MimeType = mimeType.String
+	return t, nil
+}
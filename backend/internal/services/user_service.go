@@ -1,28 +1,29 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 
+	"filevault/internal/config"
 	"filevault/internal/models"
 	"filevault/internal/utils"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 type UserService struct {
-	db *sql.DB
+	db  *sql.DB
+	cfg *config.Config
 }
 
-func NewUserService(db *sql.DB) *UserService {
-	return &UserService{db: db}
+func NewUserService(db *sql.DB, cfg *config.Config) *UserService {
+	return &UserService{db: db, cfg: cfg}
 }
 
-func (s *UserService) CreateUser(req models.UserCreateRequest) (*models.User, error) {
+func (s *UserService) CreateUser(ctx context.Context, req models.UserCreateRequest) (*models.User, error) {
 	// Check if username or email already exists
 	var count int
-	err := s.db.QueryRow("SELECT COUNT(*) FROM users WHERE username = $1 OR email = $2", req.Username, req.Email).Scan(&count)
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE username = $1 OR email = $2", req.Username, req.Email).Scan(&count)
 	if err != nil {
 		return nil, err
 	}
@@ -31,18 +32,18 @@ func (s *UserService) CreateUser(req models.UserCreateRequest) (*models.User, er
 	}
 
 	// Hash password
-	hashedPassword, err := utils.HashPassword(req.Password)
+	hashedPassword, err := utils.HashPassword(req.Password, s.cfg.Auth.BcryptCost)
 	if err != nil {
 		return nil, err
 	}
 
 	// Insert user
 	var user models.User
-	err = s.db.QueryRow(`
+	err = s.db.QueryRowContext(ctx, `
 		INSERT INTO users (username, email, password_hash, storage_quota_mb) 
 		VALUES ($1, $2, $3, $4) 
 		RETURNING id, username, email, password_hash, is_admin, storage_quota_mb, created_at, updated_at`,
-		req.Username, req.Email, hashedPassword, 10).Scan(
+		req.Username, req.Email, hashedPassword, s.cfg.Storage.DefaultQuotaMB).Scan(
 		&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.IsAdmin, &user.StorageQuotaMB, &user.CreatedAt, &user.UpdatedAt)
 
 	if err != nil {
@@ -52,9 +53,9 @@ func (s *UserService) CreateUser(req models.UserCreateRequest) (*models.User, er
 	return &user, nil
 }
 
-func (s *UserService) AuthenticateUser(username, password string) (*models.User, error) {
+func (s *UserService) AuthenticateUser(ctx context.Context, username, password string) (*models.User, error) {
 	var user models.User
-	err := s.db.QueryRow(`
+	err := s.db.QueryRowContext(ctx, `
 		SELECT id, username, email, password_hash, is_admin, storage_quota_mb, created_at, updated_at 
 		FROM users WHERE username = $1`,
 		username).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.IsAdmin, &user.StorageQuotaMB, &user.CreatedAt, &user.UpdatedAt)
@@ -73,9 +74,9 @@ func (s *UserService) AuthenticateUser(username, password string) (*models.User,
 	return &user, nil
 }
 
-func (s *UserService) GetUserByID(userID int) (*models.User, error) {
+func (s *UserService) GetUserByID(ctx context.Context, userID int) (*models.User, error) {
 	var user models.User
-	err := s.db.QueryRow(`
+	err := s.db.QueryRowContext(ctx, `
 		SELECT id, username, email, password_hash, is_admin, storage_quota_mb, created_at, updated_at 
 		FROM users WHERE id = $1`,
 		userID).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.IsAdmin, &user.StorageQuotaMB, &user.CreatedAt, &user.UpdatedAt)
@@ -87,19 +88,43 @@ func (s *UserService) GetUserByID(userID int) (*models.User, error) {
 	return &user, nil
 }
 
-func (s *UserService) GetUserStats(userID int) (*models.StorageStats, error) {
+func (s *UserService) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	var user models.User
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, username, email, password_hash, is_admin, storage_quota_mb, created_at, updated_at 
+		FROM users WHERE username = $1`,
+		username).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.IsAdmin, &user.StorageQuotaMB, &user.CreatedAt, &user.UpdatedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (s *UserService) UpdatePassword(ctx context.Context, userID int, password string) error {
+	hashedPassword, err := utils.HashPassword(password, s.cfg.Auth.BcryptCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, "UPDATE users SET password_hash = $1, updated_at = NOW() WHERE id = $2", hashedPassword, userID)
+	return err
+}
+
+func (s *UserService) GetUserStats(ctx context.Context, userID int) (*models.StorageStats, error) {
 	var stats models.StorageStats
 
 	// Get user quota in MB
 	var quotaMB int
-	err := s.db.QueryRow("SELECT storage_quota_mb FROM users WHERE id = $1", userID).Scan(&quotaMB)
+	err := s.db.QueryRowContext(ctx, "SELECT storage_quota_mb FROM users WHERE id = $1", userID).Scan(&quotaMB)
 	if err != nil {
 		return nil, err
 	}
 	stats.QuotaBytes = int64(quotaMB) * 1024 * 1024 // Convert MB to bytes
 
 	// Get total used storage (deduplicated)
-	err = s.db.QueryRow(`
+	err = s.db.QueryRowContext(ctx, `
 		SELECT COALESCE(SUM(fh.file_size), 0) 
 		FROM files f 
 		JOIN file_hashes fh ON f.hash_id = fh.id 
@@ -111,7 +136,7 @@ func (s *UserService) GetUserStats(userID int) (*models.StorageStats, error) {
 	}
 
 	// Get original storage (without deduplication)
-	err = s.db.QueryRow(`
+	err = s.db.QueryRowContext(ctx, `
 		SELECT COALESCE(SUM(fh.file_size), 0) 
 		FROM files f 
 		JOIN file_hashes fh ON f.hash_id = fh.id 
@@ -138,7 +163,7 @@ func (s *UserService) GetUserStats(userID int) (*models.StorageStats, error) {
 	stats.UsedStorageMB = stats.TotalUsedBytes / (1024 * 1024) // Convert bytes to MB
 
 	// Get total file count
-	err = s.db.QueryRow("SELECT COUNT(*) FROM files WHERE user_id = $1", userID).Scan(&stats.TotalFiles)
+	err = s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM files WHERE user_id = $1", userID).Scan(&stats.TotalFiles)
 	if err != nil {
 		return nil, err
 	}
@@ -146,13 +171,13 @@ func (s *UserService) GetUserStats(userID int) (*models.StorageStats, error) {
 	return &stats, nil
 }
 
-func (s *UserService) UpdateUserQuota(userID int, quotaMB int) error {
-	_, err := s.db.Exec("UPDATE users SET storage_quota_mb = $1 WHERE id = $2", quotaMB, userID)
+func (s *UserService) UpdateUserQuota(ctx context.Context, userID int, quotaMB int) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE users SET storage_quota_mb = $1 WHERE id = $2", quotaMB, userID)
 	return err
 }
 
-func (s *UserService) GetAllUsers() ([]models.User, error) {
-	rows, err := s.db.Query(`
+func (s *UserService) GetAllUsers(ctx context.Context) ([]models.User, error) {
+	rows, err := s.db.QueryContext(ctx, `
 		SELECT id, username, email, password_hash, is_admin, storage_quota_mb, created_at, updated_at 
 		FROM users ORDER BY created_at DESC`)
 	if err != nil {
@@ -174,27 +199,27 @@ func (s *UserService) GetAllUsers() ([]models.User, error) {
 }
 
 // CreateUserWithAdmin creates a user with admin privileges
-func (s *UserService) CreateUserWithAdmin(req models.UserCreateRequest, storageQuotaMB int, isAdmin bool) (*models.User, error) {
+func (s *UserService) CreateUserWithAdmin(ctx context.Context, req models.UserCreateRequest, storageQuotaMB int, isAdmin bool) (*models.User, error) {
 	// Check if user already exists
 	var existingID int
-	err := s.db.QueryRow("SELECT id FROM users WHERE username = $1 OR email = $2", req.Username, req.Email).Scan(&existingID)
+	err := s.db.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1 OR email = $2", req.Username, req.Email).Scan(&existingID)
 	if err == nil {
 		return nil, fmt.Errorf("user already exists")
 	}
 
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := utils.HashPassword(req.Password, s.cfg.Auth.BcryptCost)
 	if err != nil {
 		return nil, fmt.Errorf("failed to hash password: %v", err)
 	}
 
 	// Create user
 	var user models.User
-	err = s.db.QueryRow(`
+	err = s.db.QueryRowContext(ctx, `
 		INSERT INTO users (username, email, password_hash, storage_quota_mb, is_admin, created_at, updated_at)
 		VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
 		RETURNING id, username, email, storage_quota_mb, is_admin, created_at, updated_at
-	`, req.Username, req.Email, string(hashedPassword), storageQuotaMB, isAdmin).Scan(
+	`, req.Username, req.Email, hashedPassword, storageQuotaMB, isAdmin).Scan(
 		&user.ID, &user.Username, &user.Email, &user.StorageQuotaMB, &user.IsAdmin, &user.CreatedAt, &user.UpdatedAt)
 
 	if err != nil {
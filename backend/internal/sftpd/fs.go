@@ -0,0 +1,435 @@
+package sftpd
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"filevault/internal/models"
+	"filevault/internal/services"
+
+	"github.com/pkg/sftp"
+)
+
+var errFileTooLarge = errors.New("file exceeds the maximum upload size")
+
+// vaultFS maps SFTP requests for one user onto the file and folder services.
+// Paths are always relative to the user's own tree, so there is nothing
+// outside it to escape to.
+type vaultFS struct {
+	userID        int
+	fileService   *services.FileService
+	folderService *services.FolderService
+	// maxFileSize matches the limit on regular HTTP uploads
+	maxFileSize int64
+	logger      *slog.Logger
+}
+
+// Handlers serves one user's tree over an SFTP request server
+func Handlers(userID int, fileService *services.FileService, folderService *services.FolderService,
+	maxFileSize int64, logger *slog.Logger) sftp.Handlers {
+	fs := &vaultFS{
+		userID:        userID,
+		fileService:   fileService,
+		folderService: folderService,
+		maxFileSize:   maxFileSize,
+		logger:        logger,
+	}
+	return sftp.Handlers{FileGet: fs, FilePut: fs, FileCmd: fs, FileList: fs}
+}
+
+// splitPath cleans an SFTP path and returns its folder segments and final name
+func splitPath(p string) ([]string, string) {
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if p == "" {
+		return nil, ""
+	}
+	segments := strings.Split(p, "/")
+	return segments[:len(segments)-1], segments[len(segments)-1]
+}
+
+// lookupDir returns the ID of the folder named by segments, or nil for the root
+func (fs *vaultFS) lookupDir(ctx context.Context, segments []string) (*int, error) {
+	var folderID *int
+	for _, name := range segments {
+		folder, err := fs.folderService.GetFolderByName(ctx, fs.userID, folderID, name)
+		if err != nil {
+			return nil, fs.fsError(err)
+		}
+		id := folder.ID
+		folderID = &id
+	}
+	return folderID, nil
+}
+
+func (fs *vaultFS) lookupFile(ctx context.Context, p string) (*models.File, error) {
+	dirs, name := splitPath(p)
+	if name == "" {
+		return nil, os.ErrNotExist
+	}
+	folderID, err := fs.lookupDir(ctx, dirs)
+	if err != nil {
+		return nil, err
+	}
+	file, err := fs.fileService.GetFileByName(ctx, fs.userID, folderID, name)
+	if err != nil {
+		return nil, fs.fsError(err)
+	}
+	return file, nil
+}
+
+func (fs *vaultFS) stat(ctx context.Context, p string) (os.FileInfo, error) {
+	dirs, name := splitPath(p)
+	if name == "" {
+		return &fileInfo{name: "/", dir: true, modTime: time.Now()}, nil
+	}
+
+	parentID, err := fs.lookupDir(ctx, dirs)
+	if err != nil {
+		return nil, err
+	}
+
+	folder, err := fs.folderService.GetFolderByName(ctx, fs.userID, parentID, name)
+	if err == nil {
+		return folderInfo(folder), nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, fs.fsError(err)
+	}
+
+	file, err := fs.fileService.GetFileByName(ctx, fs.userID, parentID, name)
+	if err != nil {
+		return nil, fs.fsError(err)
+	}
+	return fileInfoFor(file), nil
+}
+
+func (fs *vaultFS) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	file, err := fs.lookupFile(r.Context(), r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	data, _, err := fs.fileService.DownloadFile(r.Context(), file.ID)
+	if err != nil {
+		return nil, fs.fsError(err)
+	}
+
+	return bytes.NewReader(data), nil
+}
+
+func (fs *vaultFS) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	dirs, name := splitPath(r.Filepath)
+	if name == "" {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+
+	folderID, err := fs.lookupDir(r.Context(), dirs)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := fs.folderService.GetFolderByName(r.Context(), fs.userID, folderID, name); err == nil {
+		return nil, sftp.ErrSSHFxFailure
+	}
+
+	w := &uploadWriter{fs: fs, folderID: folderID, name: name}
+
+	previous, err := fs.fileService.GetFileByName(r.Context(), fs.userID, folderID, name)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return nil, fs.fsError(err)
+	default:
+		flags := r.Pflags()
+		if flags.Excl {
+			return nil, os.ErrExist
+		}
+		w.previous = previous
+		// Writes that don't truncate (resumed uploads, appends) start from
+		// the current content
+		if !flags.Trunc {
+			data, _, err := fs.fileService.DownloadFile(r.Context(), previous.ID)
+			if err != nil {
+				return nil, fs.fsError(err)
+			}
+			w.data = data
+		}
+	}
+
+	return w, nil
+}
+
+func (fs *vaultFS) Filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Mkdir":
+		dirs, name := splitPath(r.Filepath)
+		if name == "" {
+			return os.ErrExist
+		}
+		parentID, err := fs.lookupDir(r.Context(), dirs)
+		if err != nil {
+			return err
+		}
+		if _, err := fs.folderService.GetFolderByName(r.Context(), fs.userID, parentID, name); err == nil {
+			return os.ErrExist
+		}
+		_, err = fs.folderService.CreateFolder(r.Context(), fs.userID, models.CreateFolderRequest{Name: name, ParentID: parentID})
+		return err
+
+	case "Rmdir":
+		dirs, name := splitPath(r.Filepath)
+		if name == "" {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		folderID, err := fs.lookupDir(r.Context(), append(dirs, name))
+		if err != nil {
+			return err
+		}
+		return fs.folderService.DeleteFolder(r.Context(), *folderID, fs.userID)
+
+	case "Remove":
+		file, err := fs.lookupFile(r.Context(), r.Filepath)
+		if err != nil {
+			return err
+		}
+		return fs.fileService.DeleteFile(r.Context(), file.ID, fs.userID)
+
+	case "Rename":
+		return fs.rename(r.Context(), r.Filepath, r.Target, false)
+
+	case "Setstat":
+		// Permissions and timestamps are not stored, but clients set them
+		// after every upload so failing here would break transfers
+		return nil
+	}
+
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// rename moves and renames a file or folder. Clients upload to a temporary
+// name and rename it into place, so posix-rename replaces a file already at
+// the target; a plain rename refuses to, as SFTP specifies. Folders are never
+// replaced.
+func (fs *vaultFS) rename(ctx context.Context, from, to string, replace bool) error {
+	srcDirs, srcName := splitPath(from)
+	dstDirs, dstName := splitPath(to)
+	if srcName == "" || dstName == "" {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	srcParent, err := fs.lookupDir(ctx, srcDirs)
+	if err != nil {
+		return err
+	}
+	dstParent, err := fs.lookupDir(ctx, dstDirs)
+	if err != nil {
+		return err
+	}
+	parentID := 0
+	if dstParent != nil {
+		parentID = *dstParent
+	}
+
+	if _, err := fs.folderService.GetFolderByName(ctx, fs.userID, dstParent, dstName); err == nil {
+		return os.ErrExist
+	} else if err != sql.ErrNoRows {
+		return fs.fsError(err)
+	}
+	previous, err := fs.fileService.GetFileByName(ctx, fs.userID, dstParent, dstName)
+	switch {
+	case err == sql.ErrNoRows:
+		previous = nil
+	case err != nil:
+		return fs.fsError(err)
+	case !replace:
+		return os.ErrExist
+	}
+
+	folder, err := fs.folderService.GetFolderByName(ctx, fs.userID, srcParent, srcName)
+	if err == nil {
+		if previous != nil {
+			return os.ErrExist
+		}
+		return fs.fsError(fs.folderService.MoveFolder(ctx, folder.ID, fs.userID, parentID, dstName))
+	}
+	if err != sql.ErrNoRows {
+		return fs.fsError(err)
+	}
+
+	file, err := fs.fileService.GetFileByName(ctx, fs.userID, srcParent, srcName)
+	if err != nil {
+		return fs.fsError(err)
+	}
+	if previous != nil && previous.ID == file.ID {
+		return nil
+	}
+	_, err = fs.fileService.UpdateFile(ctx, file.ID, fs.userID, models.UpdateFileRequest{DisplayName: &dstName, FolderID: &parentID})
+	if err != nil {
+		return fs.fsError(err)
+	}
+
+	if previous != nil {
+		if err := fs.fileService.DeleteFile(ctx, previous.ID, fs.userID); err != nil {
+			fs.logger.Error("SFTP failed to remove replaced file", "file_id", previous.ID, "error", err)
+		}
+	}
+	return nil
+}
+
+// PosixRename handles posix-rename@openssh.com, which pkg/sftp would
+// otherwise treat as a plain rename
+func (fs *vaultFS) PosixRename(r *sftp.Request) error {
+	return fs.rename(r.Context(), r.Filepath, r.Target, true)
+}
+
+func (fs *vaultFS) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		dirs, name := splitPath(r.Filepath)
+		if name != "" {
+			dirs = append(dirs, name)
+		}
+		folderID, err := fs.lookupDir(r.Context(), dirs)
+		if err != nil {
+			return nil, err
+		}
+
+		folders, err := fs.folderService.GetChildFolders(r.Context(), fs.userID, folderID)
+		if err != nil {
+			return nil, fs.fsError(err)
+		}
+		files, err := fs.fileService.GetFolderFiles(r.Context(), fs.userID, folderID)
+		if err != nil {
+			return nil, fs.fsError(err)
+		}
+
+		entries := make(listerAt, 0, len(folders)+len(files))
+		for i := range folders {
+			entries = append(entries, folderInfo(&folders[i]))
+		}
+		for i := range files {
+			entries = append(entries, fileInfoFor(&files[i]))
+		}
+		return entries, nil
+
+	case "Stat", "Lstat":
+		info, err := fs.stat(r.Context(), r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt{info}, nil
+	}
+
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// fsError turns service errors into ones SFTP clients understand without
+// leaking database details
+func (fs *vaultFS) fsError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case err == sql.ErrNoRows, errors.Is(err, services.ErrFilesNotFound), errors.Is(err, services.ErrFolderNotFound),
+		errors.Is(err, services.ErrParentFolderNotFound):
+		return os.ErrNotExist
+	case errors.Is(err, services.ErrFolderNameTaken):
+		return os.ErrExist
+	case errors.Is(err, services.ErrFolderCycle), errors.Is(err, services.ErrInvalidFileName),
+		errors.Is(err, services.ErrFolderNotWritable), errors.Is(err, services.ErrSmartFolder):
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	fs.logger.Error("SFTP operation failed", "error", err)
+	return sftp.ErrSSHFxFailure
+}
+
+// uploadWriter buffers an upload in memory and stores it when the client
+// closes the handle, replacing any previous file with the same name
+type uploadWriter struct {
+	fs       *vaultFS
+	folderID *int
+	name     string
+	previous *models.File
+	data     []byte
+}
+
+func (w *uploadWriter) WriteAt(p []byte, off int64) (int, error) {
+	end := off + int64(len(p))
+	if end > w.fs.maxFileSize {
+		return 0, errFileTooLarge
+	}
+	if end > int64(len(w.data)) {
+		grown := make([]byte, end)
+		copy(grown, w.data)
+		w.data = grown
+	}
+	copy(w.data[off:], p)
+	return len(p), nil
+}
+
+// Close stores the upload. It runs after the client has closed the handle,
+// when the request context is already done, so it uses its own.
+func (w *uploadWriter) Close() error {
+	file, err := w.fs.fileService.UploadContent(context.Background(), w.fs.userID, w.name, w.data, models.FileUploadRequest{FolderID: w.folderID})
+	if errors.Is(err, services.ErrQuotaExceeded) {
+		return err
+	}
+	if err != nil {
+		return w.fs.fsError(err)
+	}
+
+	if w.previous != nil && w.previous.ID != file.ID {
+		if err := w.fs.fileService.DeleteFile(context.Background(), w.previous.ID, w.fs.userID); err != nil {
+			w.fs.logger.Error("SFTP failed to remove replaced file", "file_id", w.previous.ID, "error", err)
+		}
+	}
+
+	return nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func folderInfo(folder *models.Folder) *fileInfo {
+	return &fileInfo{name: folder.Name, modTime: folder.UpdatedAt, dir: true}
+}
+
+func fileInfoFor(file *models.File) *fileInfo {
+	return &fileInfo{name: file.DisplayName, size: file.FileSize, modTime: file.UpdatedAt}
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(entries []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(entries, l[offset:])
+	if n < len(entries) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,275 @@
+// Package sftpd serves vault storage over SFTP. Users log in with their vault
+// password or a registered SSH public key and see their own folder tree as
+// the filesystem root; every operation goes through FileService and
+// FolderService so quotas and ownership checks still apply.
+package sftpd
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"filevault/internal/config"
+	"filevault/internal/services"
+	"filevault/internal/utils"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+type Server struct {
+	userService   *services.UserService
+	sshKeyService *services.SSHKeyService
+	fileService   *services.FileService
+	folderService *services.FolderService
+	maxFileSize   int64
+	hostKey       ssh.Signer
+	logger        *slog.Logger
+
+	// mu guards listener, conns and closing
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closing  bool
+	active   sync.WaitGroup
+}
+
+// ErrServerClosed is returned by ListenAndServe after Shutdown
+var ErrServerClosed = errors.New("sftpd: server closed")
+
+// NewServer loads the host key from cfg.SFTP.HostKeyPath, generating a new
+// ed25519 key there on first start
+func NewServer(userService *services.UserService, sshKeyService *services.SSHKeyService,
+	fileService *services.FileService, folderService *services.FolderService, cfg *config.Config, logger *slog.Logger) (*Server, error) {
+	hostKey, err := loadOrCreateHostKey(cfg.SFTP.HostKeyPath, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		userService:   userService,
+		sshKeyService: sshKeyService,
+		fileService:   fileService,
+		folderService: folderService,
+		maxFileSize:   cfg.Storage.MaxFileSize(),
+		hostKey:       hostKey,
+		logger:        logger,
+		conns:         make(map[net.Conn]struct{}),
+	}
+
+	return s, nil
+}
+
+// serverConfig authenticates one connection. Its lookups use ctx, which ends
+// with the connection.
+func (s *Server) serverConfig(ctx context.Context) *ssh.ServerConfig {
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			user, err := s.userService.AuthenticateUser(ctx, conn.User(), string(password))
+			if err != nil {
+				return nil, errors.New("invalid credentials")
+			}
+			return userPermissions(user.ID), nil
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			user, err := s.sshKeyService.LookupSSHKey(ctx, key)
+			if err != nil || user.Username != conn.User() {
+				return nil, errors.New("unknown public key")
+			}
+			return userPermissions(user.ID), nil
+		},
+	}
+	config.AddHostKey(s.hostKey)
+	return config
+}
+
+func userPermissions(userID int) *ssh.Permissions {
+	return &ssh.Permissions{Extensions: map[string]string{"user_id": strconv.Itoa(userID)}}
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closing := s.closing
+			s.mu.Unlock()
+			if closing {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !s.track(conn) {
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer s.untrack(conn)
+			s.handleConn(conn)
+		}()
+	}
+}
+
+// Shutdown stops accepting connections and waits for open sessions to end.
+// Sessions still open when ctx is done are disconnected, which interrupts
+// their transfers.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.active.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.active.Add(1)
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.active.Done()
+}
+
+func (s *Server) handleConn(netConn net.Conn) {
+	defer netConn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conn, chans, reqs, err := ssh.NewServerConn(netConn, s.serverConfig(ctx))
+	if err != nil {
+		// Failed logins and port scanners end up here, so don't log loudly
+		return
+	}
+	defer conn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	userID, err := strconv.Atoi(conn.Permissions.Extensions["user_id"])
+	if err != nil {
+		return
+	}
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			s.logger.Warn("SFTP failed to accept channel", "username", conn.User(), "error", err)
+			continue
+		}
+
+		go s.handleSession(userID, channel, requests)
+	}
+}
+
+// handleSession only accepts the sftp subsystem; shells and exec are refused
+func (s *Server) handleSession(userID int, channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	for req := range requests {
+		if req.Type != "subsystem" || len(req.Payload) < 4 || string(req.Payload[4:]) != "sftp" {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+
+		logger := s.logger.With("user_id", userID)
+		server := sftp.NewRequestServer(channel, Handlers(userID, s.fileService, s.folderService, s.maxFileSize, logger))
+		if err := server.Serve(); err != nil && err != io.EOF {
+			logger.Warn("SFTP session ended with error", "error", err)
+		}
+		server.Close()
+		return
+	}
+}
+
+func loadOrCreateHostKey(path string, logger *slog.Logger) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return ssh.ParsePrivateKey(data)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	data = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	if err := utils.EnsureDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to save SFTP host key: %w", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Generated SFTP host key", "path", path, "fingerprint", ssh.FingerprintSHA256(signer.PublicKey()))
+
+	return signer, nil
+}
@@ -0,0 +1,157 @@
+package test
+
+import (
+	"database/sql/driver"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/internal/services"
+	"filevault/internal/sftpd"
+)
+
+// newClient serves user 1's tree over a pipe and connects a client to it
+func newClient(t *testing.T) (*sftp.Client, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handlers := sftpd.Handlers(1, services.NewFileService(db, t.TempDir()), services.NewFolderService(db), 1<<20, logger)
+
+	serverConn, clientConn := net.Pipe()
+	server := sftp.NewRequestServer(serverConn, handlers)
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return client, mock
+}
+
+// expectFolder expects a lookup of the folder name inside parentID, or at
+// the top level when parentID is 0, finding folder id or nothing when id is 0
+func expectFolder(mock sqlmock.Sqlmock, name string, id int, parentID int) {
+	args := []driver.Value{1, name}
+	if parentID != 0 {
+		args = append(args, parentID)
+	}
+	q := mock.ExpectQuery("SELECT id, user_id, name, parent_id, is_public, created_at, updated_at\\s+FROM folders").
+		WithArgs(args...)
+	if id == 0 {
+		q.WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		return
+	}
+	q.WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "name", "parent_id", "is_public", "created_at", "updated_at"}).
+		AddRow(id, 1, name, nil, false, time.Now(), time.Now()))
+}
+
+// expectFile expects a lookup of the file name inside folderID, finding
+// file id or nothing when id is 0
+func expectFile(mock sqlmock.Sqlmock, name string, id int, folderID int) {
+	q := mock.ExpectQuery("WHERE f.user_id = \\$1 AND f.display_name = \\$2").WithArgs(1, name, folderID)
+	if id == 0 {
+		q.WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		return
+	}
+	q.WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "hash_id", "original_name", "display_name", "folder_id",
+		"is_public", "download_count", "created_at", "updated_at", "hash_sha256", "hash_md5", "file_size", "mime_type"}).
+		AddRow(id, 1, 40+id, name, name, folderID, false, 0, time.Now(), time.Now(), "h", "m", 10, "text/csv"))
+}
+
+// expectMoveFile expects file 7 to be renamed to report.csv in folder 3
+func expectMoveFile(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM files WHERE id = \\$1 AND user_id = \\$2 FOR UPDATE").WithArgs(7, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectQuery("FROM folders fo").WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"visible", "writable", "smart"}).AddRow(true, true, false))
+	mock.ExpectExec("UPDATE files SET display_name = \\$1, folder_id = \\$2 WHERE id = \\$3").
+		WithArgs("report.csv", 3, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("WHERE f.id = \\$1").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "hash_id", "original_name", "display_name", "folder_id",
+			"is_public", "download_count", "created_at", "updated_at", "hash_sha256", "file_size", "mime_type",
+			"username", "folder_name", "metadata"}).
+			AddRow(7, 1, 47, "report.csv.filepart", "report.csv", 3, false, 0, time.Now(), time.Now(), "h", 10, "text/csv",
+				"alice", "inbox", nil))
+	mock.ExpectQuery("SELECT tag FROM file_tags WHERE file_id = \\$1").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"tag"}))
+}
+
+func TestVaultFS_Rename(t *testing.T) {
+	t.Run("temporary upload renamed into place", func(t *testing.T) {
+		client, mock := newClient(t)
+		expectFolder(mock, "inbox", 3, 0)
+		expectFolder(mock, "inbox", 3, 0)
+		expectFolder(mock, "report.csv", 0, 3)
+		expectFile(mock, "report.csv", 0, 3)
+		expectFolder(mock, "report.csv.filepart", 0, 3)
+		expectFile(mock, "report.csv.filepart", 7, 3)
+		expectMoveFile(mock)
+
+		require.NoError(t, client.Rename("/inbox/report.csv.filepart", "/inbox/report.csv"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("rename refuses to replace a file", func(t *testing.T) {
+		client, mock := newClient(t)
+		expectFolder(mock, "inbox", 3, 0)
+		expectFolder(mock, "inbox", 3, 0)
+		expectFolder(mock, "report.csv", 0, 3)
+		expectFile(mock, "report.csv", 8, 3)
+
+		assert.Error(t, client.Rename("/inbox/report.csv.filepart", "/inbox/report.csv"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("posix rename replaces a file", func(t *testing.T) {
+		client, mock := newClient(t)
+		expectFolder(mock, "inbox", 3, 0)
+		expectFolder(mock, "inbox", 3, 0)
+		expectFolder(mock, "report.csv", 0, 3)
+		expectFile(mock, "report.csv", 8, 3)
+		expectFolder(mock, "report.csv.filepart", 0, 3)
+		expectFile(mock, "report.csv.filepart", 7, 3)
+		expectMoveFile(mock)
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT user_id, hash_id FROM files WHERE id = \\$1 FOR UPDATE").WithArgs(8).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "hash_id"}).AddRow(1, 48))
+		mock.ExpectExec("DELETE FROM files WHERE id = \\$1").WithArgs(8).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT hash_sha256, chunked FROM file_hashes WHERE id = \\$1 FOR UPDATE").WithArgs(48).
+			WillReturnRows(sqlmock.NewRows([]string{"hash_sha256", "chunked"}).AddRow("h48", false))
+		mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM files WHERE hash_id = \\$1").WithArgs(48).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectCommit()
+
+		require.NoError(t, client.PosixRename("/inbox/report.csv.filepart", "/inbox/report.csv"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("folder can't move into its own subfolder", func(t *testing.T) {
+		client, mock := newClient(t)
+		expectFolder(mock, "inbox", 3, 0)
+		expectFolder(mock, "2023", 4, 3)
+		expectFolder(mock, "inbox", 0, 4)
+		expectFile(mock, "inbox", 0, 4)
+		expectFolder(mock, "inbox", 3, 0)
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT name FROM folders WHERE id = \\$1 AND user_id = \\$2 FOR UPDATE").WithArgs(3, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("inbox"))
+		mock.ExpectQuery("WITH RECURSIVE ancestors").WithArgs(4, 3, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"found", "cycle"}).AddRow(true, true))
+		mock.ExpectRollback()
+
+		assert.ErrorIs(t, client.Rename("/inbox", "/inbox/2023/inbox"), os.ErrPermission)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
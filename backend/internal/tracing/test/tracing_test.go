@@ -0,0 +1,133 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"filevault/internal/config"
+	"filevault/internal/models"
+	"filevault/internal/services"
+	"filevault/internal/tracing"
+)
+
+func newRecorder(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func spansByName(recorder *tracetest.SpanRecorder) map[string]sdktrace.ReadOnlySpan {
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	return spans
+}
+
+func TestUploadContent_SpansNestUnderCaller(t *testing.T) {
+	recorder := newRecorder(t)
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT storage_quota_mb FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"storage_quota_mb"}).AddRow(10))
+	mock.ExpectQuery("SELECT id FROM file_hashes").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(fh.file_size\\), 0\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(0))
+	mock.ExpectQuery("INSERT INTO files").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "hash_id", "original_name", "display_name", "folder_id", "is_public", "download_count", "created_at", "updated_at"}).
+			AddRow(10, 1, 3, "hello.txt", "hello.txt", nil, false, 0, now, now))
+	mock.ExpectCommit()
+
+	ctx, parent := tracing.Start(context.Background(), "request")
+	_, err = services.NewFileService(db, t.TempDir()).UploadContent(ctx, 1, "hello.txt", []byte("hello"), models.FileUploadRequest{})
+	parent.End()
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	spans := spansByName(recorder)
+	upload, ok := spans["FileService.UploadContent"]
+	require.True(t, ok, "missing upload span")
+	assert.Equal(t, parent.SpanContext().SpanID(), upload.Parent().SpanID())
+
+	// Deduplicated uploads are checked against the quota too
+	for _, name := range []string{"hash", "dedup_lookup", "quota_check"} {
+		child, ok := spans[name]
+		require.True(t, ok, "missing %s span", name)
+		assert.Equal(t, upload.SpanContext().SpanID(), child.Parent().SpanID())
+	}
+}
+
+func TestUploadContent_QuotaRejectionMarksSpan(t *testing.T) {
+	recorder := newRecorder(t)
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT storage_quota_mb FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"storage_quota_mb"}).AddRow(1))
+	mock.ExpectQuery("SELECT id FROM file_hashes").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("INSERT INTO file_hashes").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(fh.file_size\\), 0\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(1024 * 1024))
+	mock.ExpectRollback()
+
+	_, err = services.NewFileService(db, t.TempDir()).UploadContent(context.Background(), 1, "big.bin", []byte("data"), models.FileUploadRequest{})
+	assert.ErrorIs(t, err, services.ErrQuotaExceeded)
+
+	quota, ok := spansByName(recorder)["quota_check"]
+	require.True(t, ok, "missing quota_check span")
+	assert.Equal(t, "Error", quota.Status().Code.String())
+}
+
+func TestGinMiddleware_ContinuesIncomingTrace(t *testing.T) {
+	recorder := newRecorder(t)
+	_, err := tracing.Setup(context.Background(), config.TracingConfig{})
+	require.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(otelgin.Middleware("filevault"))
+	router.GET("/api/files/:id", func(c *gin.Context) {
+		_, span := tracing.Start(c.Request.Context(), "FileService.GetFileByID")
+		span.End()
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/api/files/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := spansByName(recorder)
+	server, ok := spans["/api/files/:id"]
+	require.True(t, ok, "missing server span")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String())
+
+	service, ok := spans["FileService.GetFileByID"]
+	require.True(t, ok, "missing service span")
+	assert.Equal(t, server.SpanContext().SpanID(), service.Parent().SpanID())
+}
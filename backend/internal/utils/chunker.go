@@ -0,0 +1,79 @@
+package utils
+
+// Chunk sizes for content-defined chunking. Boundaries depend only on the
+// bytes around them, so an edit moves the boundaries of the chunks it touches
+// and leaves the rest of the file chunked exactly as before.
+const (
+	MinChunkSize = 16 << 10
+	AvgChunkSize = 64 << 10
+	MaxChunkSize = 256 << 10
+)
+
+// FastCDC normalised chunking: before the average size a boundary needs more
+// matching bits, after it fewer, which keeps chunk sizes close to the average.
+// The masks use the high bits of the fingerprint, which depend on the last
+// 64 bytes read.
+const (
+	chunkMaskSmall = uint64(1<<18-1) << (64 - 18)
+	chunkMaskLarge = uint64(1<<14-1) << (64 - 14)
+)
+
+// gear maps each byte to a random 64-bit value. It must never change, or
+// content chunked before and after the change would no longer share chunks.
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x66696c657661756c) // "filevaul"
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// SplitChunks cuts data into content-defined chunks between MinChunkSize and
+// MaxChunkSize bytes long, except that the last may be shorter. The chunks
+// share data's backing array.
+func SplitChunks(data []byte) [][]byte {
+	var chunks [][]byte
+	for len(data) > 0 {
+		n := chunkBoundary(data)
+		chunks = append(chunks, data[:n:n])
+		data = data[n:]
+	}
+	return chunks
+}
+
+// chunkBoundary returns the length of the chunk at the start of data
+func chunkBoundary(data []byte) int {
+	n := len(data)
+	if n <= MinChunkSize {
+		return n
+	}
+	if n > MaxChunkSize {
+		n = MaxChunkSize
+	}
+	normal := AvgChunkSize
+	if n < normal {
+		normal = n
+	}
+
+	var fp uint64
+	i := MinChunkSize
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&chunkMaskSmall == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&chunkMaskLarge == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
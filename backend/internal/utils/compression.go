@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Algorithms a blob can be stored with
+const (
+	CompressionNone = "none"
+	CompressionZstd = "zstd"
+)
+
+const (
+	// minCompressibleSize is the smallest blob worth compressing
+	minCompressibleSize = 512
+	// compressionSampleSize is how much of a blob of unknown type is
+	// test-compressed before compressing all of it
+	compressionSampleSize = 64 << 10
+	// maxCompressedRatio is the largest compressed to original size ratio
+	// still worth the cost of decompressing on every download
+	maxCompressedRatio = 0.9
+)
+
+// The encoder and decoder are safe for concurrent EncodeAll and DecodeAll
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// textMimePrefixes are types that nearly always compress well, so they skip
+// the sample
+var textMimePrefixes = []string{
+	"text/", "application/json", "application/xml", "application/javascript",
+	"application/x-ndjson", "application/sql", "image/svg+xml",
+}
+
+// compressedMimePrefixes are types that are already compressed, so they are
+// never worth trying
+var compressedMimePrefixes = []string{
+	"image/jpeg", "image/png", "image/gif", "image/webp", "image/avif", "image/heif",
+	"video/", "audio/",
+	"application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+	"application/x-7z-compressed", "application/vnd.rar", "application/x-rar-compressed",
+	"application/x-bzip2", "application/x-xz", "application/epub+zip",
+}
+
+func hasMimePrefix(mimeType string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(mimeType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// CompressBlob returns data as it should be stored and the algorithm used.
+// Data is compressed with algorithm only when its type, or a sample of it,
+// shows it compresses well; otherwise it is returned as is with
+// CompressionNone.
+func CompressBlob(data []byte, mimeType, algorithm string) ([]byte, string) {
+	if algorithm != CompressionZstd || len(data) < minCompressibleSize || hasMimePrefix(mimeType, compressedMimePrefixes) {
+		return data, CompressionNone
+	}
+
+	if !hasMimePrefix(mimeType, textMimePrefixes) && len(data) > compressionSampleSize {
+		sample := data[:compressionSampleSize]
+		if !worthCompressing(len(zstdEncoder.EncodeAll(sample, nil)), len(sample)) {
+			return data, CompressionNone
+		}
+	}
+
+	compressed := zstdEncoder.EncodeAll(data, make([]byte, 0, len(data)/2))
+	if !worthCompressing(len(compressed), len(data)) {
+		return data, CompressionNone
+	}
+	return compressed, CompressionZstd
+}
+
+func worthCompressing(compressed, original int) bool {
+	return float64(compressed) <= maxCompressedRatio*float64(original)
+}
+
+// DecompressBlob returns the original content of a blob stored with
+// algorithm
+func DecompressBlob(stored []byte, algorithm string) ([]byte, error) {
+	switch algorithm {
+	case CompressionNone, "":
+		return stored, nil
+	case CompressionZstd:
+		return zstdDecoder.DecodeAll(stored, nil)
+	}
+	return nil, fmt.Errorf("unknown blob compression %q", algorithm)
+}
+
+// NewBlobReader returns a reader of the original content of a blob stored
+// with algorithm, decompressing as it is read rather than all at once
+func NewBlobReader(stored []byte, algorithm string) (io.ReadCloser, error) {
+	switch algorithm {
+	case CompressionNone, "":
+		return io.NopCloser(bytes.NewReader(stored)), nil
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(bytes.NewReader(stored), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unknown blob compression %q", algorithm)
+}
@@ -1,10 +1,12 @@
 package utils
 
 import (
+	"crypto/md5"
 	"crypto/sha256"
 	"fmt"
 	"io"
 	"mime"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -117,6 +119,12 @@ func CalculateHashFromData(data []byte) (string, error) {
 	return fmt.Sprintf("%x", hash.Sum(nil)), nil
 }
 
+// CalculateMD5FromData calculates the MD5 hex digest of byte data; it is only
+// used for S3 ETags, never for deduplication
+func CalculateMD5FromData(data []byte) string {
+	return fmt.Sprintf("%x", md5.Sum(data))
+}
+
 // DetectMimeTypeFromData detects MIME type from byte data
 func DetectMimeTypeFromData(data []byte) string {
 	// Read first 512 bytes for MIME type detection
@@ -127,9 +135,13 @@ func DetectMimeTypeFromData(data []byte) string {
 
 	// Detect MIME type from content
 	kind, err := filetype.Match(head)
-	if err != nil {
-		// Fallback to http.DetectContentType
-		return "application/octet-stream"
+	if err != nil || kind == filetype.Unknown {
+		// Fallback to http.DetectContentType, which recognises text
+		mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+		if err != nil {
+			return "application/octet-stream"
+		}
+		return mediaType
 	}
 
 	return kind.MIME.Value
@@ -0,0 +1,375 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// MaxExtractedText caps the text indexed per blob. Well under the 1 MB a
+// Postgres tsvector can hold, and enough to find most documents by.
+const MaxExtractedText = 256 << 10
+
+// DocxMimeType is what Word documents are detected as
+const DocxMimeType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+
+// TextMimePrefixes are the types text is extracted from for search
+var TextMimePrefixes = []string{
+	"text/", "application/json", "application/xml", "application/javascript",
+	"application/x-ndjson", "application/sql", "application/pdf", DocxMimeType,
+}
+
+// maxDocxXML caps how much of a DOCX's document.xml is read, since a small
+// zip can inflate to gigabytes
+const maxDocxXML = 64 << 20
+
+// ErrNoText is returned for content that isn't a text format
+var ErrNoText = errors.New("no extractable text")
+
+// CanExtractText reports whether text is extracted from mimeType
+func CanExtractText(mimeType string) bool {
+	return hasMimePrefix(mimeType, TextMimePrefixes)
+}
+
+// ExtractText returns the searchable text of data, cleaned of control
+// characters and cut to MaxExtractedText bytes. Content stored without a
+// type is sniffed first.
+func ExtractText(data []byte, mimeType string) (string, error) {
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType = DetectMimeTypeFromData(data)
+	}
+
+	var text string
+	var err error
+	switch {
+	case !CanExtractText(mimeType):
+		return "", fmt.Errorf("%w: %s", ErrNoText, mimeType)
+	case strings.HasPrefix(mimeType, "application/pdf"):
+		text, err = extractPDFText(data)
+	case strings.HasPrefix(mimeType, DocxMimeType):
+		text, err = extractDocxText(data)
+	case strings.HasPrefix(mimeType, "text/html"):
+		text = extractHTMLText(data)
+	default:
+		text = string(data[:min(len(data), 4*MaxExtractedText)])
+	}
+	if err != nil {
+		return "", err
+	}
+	return cleanText(text), nil
+}
+
+// cleanText makes text valid UTF-8 without control characters other than
+// newlines and tabs, and cuts it to MaxExtractedText bytes on a rune boundary
+func cleanText(text string) string {
+	var b strings.Builder
+	for _, r := range strings.ToValidUTF8(text, " ") {
+		if b.Len()+utf8.RuneLen(r) > MaxExtractedText {
+			break
+		}
+		if r != '\n' && r != '\t' && (unicode.IsControl(r) || r == utf8.RuneError) {
+			r = ' '
+		}
+		b.WriteRune(r)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// extractHTMLText returns the text of an HTML document, without scripts and
+// styles
+func extractHTMLText(data []byte) string {
+	var b strings.Builder
+	tokenizer := html.NewTokenizer(bytes.NewReader(data))
+	skip := 0
+	for b.Len() < MaxExtractedText {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return b.String()
+		case html.StartTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == "script" || string(name) == "style" {
+				skip++
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "script", "style":
+				skip = max(0, skip-1)
+			case "p", "div", "li", "tr", "h1", "h2", "h3", "h4", "h5", "h6", "br":
+				b.WriteByte('\n')
+			}
+		case html.TextToken:
+			if skip == 0 {
+				b.Write(tokenizer.Text())
+				b.WriteByte(' ')
+			}
+		}
+	}
+	return b.String()
+}
+
+// extractDocxText returns the paragraphs of a Word document's body
+func extractDocxText(data []byte) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	for _, f := range archive.File {
+		if f.Name != "word/document.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+
+		var b strings.Builder
+		decoder := xml.NewDecoder(io.LimitReader(rc, maxDocxXML))
+		inText := false
+		for b.Len() < MaxExtractedText {
+			token, err := decoder.Token()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return "", err
+			}
+			switch t := token.(type) {
+			case xml.StartElement:
+				switch t.Name.Local {
+				case "t":
+					inText = true
+				case "tab":
+					b.WriteByte('\t')
+				case "br":
+					b.WriteByte('\n')
+				}
+			case xml.EndElement:
+				switch t.Name.Local {
+				case "t":
+					inText = false
+				case "p":
+					b.WriteByte('\n')
+				}
+			case xml.CharData:
+				if inText {
+					b.Write(t)
+				}
+			}
+		}
+		return b.String(), nil
+	}
+	return "", fmt.Errorf("%w: no word/document.xml", ErrNoText)
+}
+
+// extractPDFText returns the text shown by a PDF's content streams. Only
+// uncompressed and Flate streams are read, and font encodings are ignored,
+// so text in fonts with custom encodings comes out garbled or not at all; it
+// is best effort.
+func extractPDFText(data []byte) (string, error) {
+	if !bytes.HasPrefix(data, []byte("%PDF")) {
+		return "", fmt.Errorf("%w: not a PDF", ErrNoText)
+	}
+
+	var b strings.Builder
+	rest := data
+	for b.Len() < MaxExtractedText {
+		start := bytes.Index(rest, []byte("stream"))
+		if start < 0 {
+			break
+		}
+		body := rest[start+len("stream"):]
+		if bytes.HasPrefix(body, []byte("\r\n")) {
+			body = body[2:]
+		} else if bytes.HasPrefix(body, []byte("\n")) {
+			body = body[1:]
+		} else {
+			// "endstream", or the word inside other content
+			rest = body
+			continue
+		}
+		end := bytes.Index(body, []byte("endstream"))
+		if end < 0 {
+			break
+		}
+
+		// The stream's dictionary sits between its "obj" and "stream"
+		dict := rest[:start]
+		if i := bytes.LastIndex(dict, []byte("obj")); i >= 0 {
+			dict = dict[i:]
+		}
+		content := body[:end]
+		rest = body[end+len("endstream"):]
+
+		switch {
+		case bytes.Contains(dict, []byte("/FlateDecode")):
+			// A truncated stream still yields the text before the damage
+			r, err := zlib.NewReader(bytes.NewReader(content))
+			if err != nil {
+				continue
+			}
+			content, _ = io.ReadAll(io.LimitReader(r, 16<<20))
+			r.Close()
+		case bytes.Contains(dict, []byte("/Filter")):
+			continue
+		}
+		pdfContentText(content, &b)
+	}
+	if b.Len() == 0 {
+		return "", fmt.Errorf("%w: no readable text in PDF", ErrNoText)
+	}
+	return b.String(), nil
+}
+
+// pdfContentText writes the strings shown by the text operators of a content
+// stream
+func pdfContentText(content []byte, b *strings.Builder) {
+	var operands [][]byte
+	inText := false
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			s, n := pdfLiteralString(content[i:])
+			operands = append(operands, s)
+			i += n
+		case c == '<' && i+1 < len(content) && content[i+1] != '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return
+			}
+			operands = append(operands, pdfHexString(content[i+1:i+end]))
+			i += end + 1
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case c == '[' || c == ']':
+			// TJ arrays; their strings are collected as operands
+			i++
+		case c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c == '\'' || c == '"' || c == '*':
+			start := i
+			for i < len(content) && (content[i] >= 'A' && content[i] <= 'Z' || content[i] >= 'a' && content[i] <= 'z' ||
+				content[i] == '\'' || content[i] == '"' || content[i] == '*') {
+				i++
+			}
+			switch op := string(content[start:i]); op {
+			case "BT":
+				inText = true
+			case "ET":
+				inText = false
+				b.WriteByte('\n')
+			case "Tj", "TJ", "'", "\"":
+				if inText {
+					if op == "'" || op == "\"" {
+						b.WriteByte('\n')
+					}
+					for _, s := range operands {
+						b.WriteString(pdfDecodeString(s))
+					}
+					if op == "TJ" {
+						b.WriteByte(' ')
+					}
+				}
+			case "Td", "TD", "T*", "Tm":
+				if inText {
+					b.WriteByte('\n')
+				}
+			}
+			operands = operands[:0]
+		default:
+			i++
+		}
+	}
+}
+
+// pdfLiteralString decodes the (...) string at the start of s, returning it
+// and the bytes consumed
+func pdfLiteralString(s []byte) ([]byte, int) {
+	var out []byte
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch e := s[i]; e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r', 't', 'b', 'f':
+				out = append(out, ' ')
+			case '\r', '\n':
+				// Line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					v, n := 0, 0
+					for n < 3 && i+n < len(s) && s[i+n] >= '0' && s[i+n] <= '7' {
+						v = v*8 + int(s[i+n]-'0')
+						n++
+					}
+					out = append(out, byte(v))
+					i += n - 1
+				} else {
+					out = append(out, e)
+				}
+			}
+		case c == '(':
+			if depth > 0 {
+				out = append(out, c)
+			}
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return out, i + 1
+			}
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+	}
+	return out, len(s)
+}
+
+// pdfDecodeString decodes a PDF text string: UTF-16BE when it starts with a
+// byte order mark, otherwise one character per byte, which is right for
+// PDFDocEncoding and WinAnsi outside a few punctuation marks
+func pdfDecodeString(s []byte) string {
+	if len(s) >= 2 && s[0] == 0xfe && s[1] == 0xff {
+		units := make([]uint16, 0, len(s)/2)
+		for i := 2; i+1 < len(s); i += 2 {
+			units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	runes := make([]rune, len(s))
+	for i, c := range s {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+func pdfHexString(s []byte) []byte {
+	digits := bytes.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, hex.DecodedLen(len(digits)))
+	n, _ := hex.Decode(out, digits)
+	return out[:n]
+}
@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/webp"
+)
+
+// ThumbnailSizes are the longest side, in pixels, of each generated size.
+// Images are never scaled up, so a small image's thumbnails may be smaller.
+var ThumbnailSizes = map[string]int{
+	"small":  128,
+	"medium": 256,
+	"large":  512,
+}
+
+// DefaultThumbnailSize is served when a request names no size
+const DefaultThumbnailSize = "medium"
+
+// ThumbnailMimePrefixes are the types thumbnails are generated for: images
+// that decode in pure Go, and text, which gets a preview of its first page
+var ThumbnailMimePrefixes = []string{
+	"image/jpeg", "image/png", "image/gif", "image/webp",
+	"text/", "application/json", "application/xml", "application/javascript",
+	"application/x-ndjson", "application/sql",
+}
+
+// maxThumbnailPixels guards against images that are small on disk but would
+// take gigabytes to decode
+const maxThumbnailPixels = 50_000_000
+
+// Text previews show the start of the content on a page shaped like paper
+const (
+	textPreviewWidth  = 384
+	textPreviewHeight = 512
+	textPreviewMargin = 12
+	textPreviewBytes  = 64 << 10
+	textPreviewTab    = 4
+)
+
+// ErrImageTooLarge is returned for images with more than maxThumbnailPixels
+var ErrImageTooLarge = errors.New("image too large to thumbnail")
+
+// Thumbnail is one encoded size of a preview
+type Thumbnail struct {
+	Data     []byte
+	MimeType string
+	Width    int
+	Height   int
+}
+
+// CanThumbnail reports whether thumbnails are generated for mimeType
+func CanThumbnail(mimeType string) bool {
+	return hasMimePrefix(mimeType, ThumbnailMimePrefixes)
+}
+
+// GenerateThumbnails renders data, of type mimeType, at every size in
+// ThumbnailSizes. Opaque images are encoded as JPEG; images with
+// transparency and text previews as PNG.
+func GenerateThumbnails(data []byte, mimeType string) (map[string]Thumbnail, error) {
+	var src image.Image
+	var err error
+	switch {
+	case !CanThumbnail(mimeType):
+		return nil, fmt.Errorf("no thumbnails for %s", mimeType)
+	case strings.HasPrefix(mimeType, "image/"):
+		src, err = decodeImage(data, mimeType)
+	default:
+		src = renderTextPage(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	asJPEG := strings.HasPrefix(mimeType, "image/") && isOpaque(src)
+	thumbnails := make(map[string]Thumbnail, len(ThumbnailSizes))
+	for name, box := range ThumbnailSizes {
+		scaled := scaleToFit(src, box)
+		var buf bytes.Buffer
+		thumbnail := Thumbnail{Width: scaled.Bounds().Dx(), Height: scaled.Bounds().Dy()}
+		if asJPEG {
+			thumbnail.MimeType = "image/jpeg"
+			err = jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: 80})
+		} else {
+			thumbnail.MimeType = "image/png"
+			err = png.Encode(&buf, scaled)
+		}
+		if err != nil {
+			return nil, err
+		}
+		thumbnail.Data = buf.Bytes()
+		thumbnails[name] = thumbnail
+	}
+	return thumbnails, nil
+}
+
+// decodeImage decodes the first frame of an image after checking its
+// dimensions
+func decodeImage(data []byte, mimeType string) (image.Image, error) {
+	var decodeConfig func([]byte) (image.Config, error)
+	var decode func([]byte) (image.Image, error)
+	switch {
+	case strings.HasPrefix(mimeType, "image/jpeg"):
+		decodeConfig = func(b []byte) (image.Config, error) { return jpeg.DecodeConfig(bytes.NewReader(b)) }
+		decode = func(b []byte) (image.Image, error) { return jpeg.Decode(bytes.NewReader(b)) }
+	case strings.HasPrefix(mimeType, "image/png"):
+		decodeConfig = func(b []byte) (image.Config, error) { return png.DecodeConfig(bytes.NewReader(b)) }
+		decode = func(b []byte) (image.Image, error) { return png.Decode(bytes.NewReader(b)) }
+	case strings.HasPrefix(mimeType, "image/gif"):
+		decodeConfig = func(b []byte) (image.Config, error) { return gif.DecodeConfig(bytes.NewReader(b)) }
+		decode = func(b []byte) (image.Image, error) { return gif.Decode(bytes.NewReader(b)) }
+	case strings.HasPrefix(mimeType, "image/webp"):
+		decodeConfig = func(b []byte) (image.Config, error) { return webp.DecodeConfig(bytes.NewReader(b)) }
+		decode = func(b []byte) (image.Image, error) { return webp.Decode(bytes.NewReader(b)) }
+	default:
+		return nil, fmt.Errorf("no thumbnails for %s", mimeType)
+	}
+
+	config, err := decodeConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, fmt.Errorf("image has no pixels")
+	}
+	if config.Width*config.Height > maxThumbnailPixels {
+		return nil, fmt.Errorf("%w: %dx%d", ErrImageTooLarge, config.Width, config.Height)
+	}
+	return decode(data)
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// scaleToFit shrinks img to fit a box x box square, keeping its aspect ratio
+func scaleToFit(img image.Image, box int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w > box || h > box {
+		if w >= h {
+			w, h = box, max(1, h*box/w)
+		} else {
+			w, h = max(1, w*box/h), box
+		}
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+// renderTextPage draws the first lines of text in a fixed-width font on a
+// white page
+func renderTextPage(data []byte) image.Image {
+	page := image.NewRGBA(image.Rect(0, 0, textPreviewWidth, textPreviewHeight))
+	draw.Draw(page, page.Bounds(), image.White, image.Point{}, draw.Src)
+
+	face := basicfont.Face7x13
+	columns := (textPreviewWidth - 2*textPreviewMargin) / face.Advance
+	lineHeight := face.Height
+	drawer := &font.Drawer{Dst: page, Src: image.NewUniform(color.Gray{Y: 0x20}), Face: face}
+
+	if len(data) > textPreviewBytes {
+		data = data[:textPreviewBytes]
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 4096), textPreviewBytes)
+	y := textPreviewMargin + face.Ascent
+	for scanner.Scan() && y <= textPreviewHeight-textPreviewMargin {
+		drawer.Dot = fixed.P(textPreviewMargin, y)
+		drawer.DrawString(previewLine(scanner.Text(), columns))
+		y += lineHeight
+	}
+	return page
+}
+
+// previewLine expands tabs, replaces what the font can't draw and cuts the
+// line to columns characters
+func previewLine(line string, columns int) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range strings.TrimRight(line, "\r") {
+		if n >= columns {
+			break
+		}
+		switch {
+		case r == '\t':
+			for pad := textPreviewTab - n%textPreviewTab; pad > 0 && n < columns; pad-- {
+				b.WriteByte(' ')
+				n++
+			}
+			continue
+		case r == utf8.RuneError || r < ' ' || r > '~':
+			r = '?'
+		}
+		b.WriteRune(r)
+		n++
+	}
+	return b.String()
+}
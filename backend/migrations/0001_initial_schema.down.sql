@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS rate_limits;
+DROP TABLE IF EXISTS file_tags;
+DROP TABLE IF EXISTS folder_shares;
+DROP TABLE IF EXISTS file_shares;
+DROP TABLE IF EXISTS files;
+DROP TABLE IF EXISTS folders;
+DROP TABLE IF EXISTS file_hashes;
+DROP TABLE IF EXISTS users;
+DROP FUNCTION IF EXISTS update_updated_at_column();
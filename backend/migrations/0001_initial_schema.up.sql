@@ -1,3 +1,6 @@
+-- Baseline schema. Statements are idempotent so databases created by the
+-- old startup script can adopt versioned migrations without changes.
+
 -- Create users table
 CREATE TABLE IF NOT EXISTS users (
     id SERIAL PRIMARY KEY,
@@ -16,6 +19,7 @@ CREATE TABLE IF NOT EXISTS file_hashes (
     hash_sha256 VARCHAR(64) UNIQUE NOT NULL,
     file_size BIGINT NOT NULL,
     mime_type VARCHAR(100) NOT NULL,
+    file_data BYTEA NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
@@ -49,7 +53,7 @@ CREATE TABLE IF NOT EXISTS file_shares (
     id SERIAL PRIMARY KEY,
     file_id INTEGER REFERENCES files(id) ON DELETE CASCADE,
     shared_with_user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
-    permission VARCHAR(20) DEFAULT 'read', -- 'read', 'write'
+    permission VARCHAR(20) DEFAULT 'read',
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     UNIQUE(file_id, shared_with_user_id)
 );
@@ -59,7 +63,7 @@ CREATE TABLE IF NOT EXISTS folder_shares (
     id SERIAL PRIMARY KEY,
     folder_id INTEGER REFERENCES folders(id) ON DELETE CASCADE,
     shared_with_user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
-    permission VARCHAR(20) DEFAULT 'read', -- 'read', 'write'
+    permission VARCHAR(20) DEFAULT 'read',
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     UNIQUE(folder_id, shared_with_user_id)
 );
@@ -106,19 +110,24 @@ BEGIN
 END;
 $$ language 'plpgsql';
 
--- Create triggers for updated_at
-CREATE TRIGGER update_users_updated_at BEFORE UPDATE ON users
-    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+-- Create triggers for updated_at (drop if exists first)
+DO $$
+BEGIN
+    -- Drop triggers if they exist
+    DROP TRIGGER IF EXISTS update_users_updated_at ON users;
+    DROP TRIGGER IF EXISTS update_files_updated_at ON files;
+    DROP TRIGGER IF EXISTS update_folders_updated_at ON folders;
+
+    -- Create triggers
+    CREATE TRIGGER update_users_updated_at BEFORE UPDATE ON users
+        FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 
-CREATE TRIGGER update_files_updated_at BEFORE UPDATE ON files
-    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+    CREATE TRIGGER update_files_updated_at BEFORE UPDATE ON files
+        FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 
-CREATE TRIGGER update_folders_updated_at BEFORE UPDATE ON folders
-    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+    CREATE TRIGGER update_folders_updated_at BEFORE UPDATE ON folders
+        FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+END $$;
 
--- Insert default users
-INSERT INTO users (username, email, password_hash, is_admin, storage_quota_mb) 
-VALUES 
-	('testuser', 'testuser@filevault.com', '$2a$10$oGE2mo0kGCtngxrVErqeDue.DxR53URO2u.aoVZFL98nZBKRgN2ZO', false, 100),
-	('admin2', 'admin2@filevault.com', '$2a$10$ZBi9dWZ7pt2mj8VmAYtEkOSF2bBmnFk1e221v/liHPPh.NeBgDH5e', true, 1000)
-ON CONFLICT (username) DO NOTHING;
+-- Databases created before content moved into Postgres lack file_data
+ALTER TABLE file_hashes ADD COLUMN IF NOT EXISTS file_data BYTEA;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS s3_multipart_parts;
+DROP TABLE IF EXISTS s3_multipart_uploads;
+DROP TABLE IF EXISTS s3_access_keys;
+ALTER TABLE file_hashes DROP COLUMN IF EXISTS hash_md5;
@@ -0,0 +1,37 @@
+-- MD5 digests are only kept to serve S3-compatible ETags
+ALTER TABLE file_hashes ADD COLUMN IF NOT EXISTS hash_md5 VARCHAR(32);
+
+-- Create s3_access_keys table for the S3-compatible gateway
+CREATE TABLE IF NOT EXISTS s3_access_keys (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    access_key_id VARCHAR(32) UNIQUE NOT NULL,
+    secret_key VARCHAR(64) NOT NULL,
+    description VARCHAR(255) DEFAULT '',
+    last_used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create tables holding in-progress S3 multipart uploads
+CREATE TABLE IF NOT EXISTS s3_multipart_uploads (
+    id SERIAL PRIMARY KEY,
+    upload_id VARCHAR(64) UNIQUE NOT NULL,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    folder_id INTEGER REFERENCES folders(id) ON DELETE CASCADE,
+    object_key TEXT NOT NULL,
+    content_type VARCHAR(100) DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS s3_multipart_parts (
+    upload_id INTEGER REFERENCES s3_multipart_uploads(id) ON DELETE CASCADE,
+    part_number INTEGER NOT NULL,
+    etag VARCHAR(32) NOT NULL,
+    size BIGINT NOT NULL,
+    data BYTEA NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (upload_id, part_number)
+);
+
+CREATE INDEX IF NOT EXISTS idx_s3_access_keys_user_id ON s3_access_keys(user_id);
+CREATE INDEX IF NOT EXISTS idx_s3_multipart_uploads_user_folder ON s3_multipart_uploads(user_id, folder_id);
@@ -0,0 +1,12 @@
+-- Create ssh_keys table for SFTP public key authentication
+CREATE TABLE IF NOT EXISTS ssh_keys (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) DEFAULT '',
+    public_key TEXT NOT NULL,
+    fingerprint VARCHAR(64) UNIQUE NOT NULL,
+    last_used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_ssh_keys_user_id ON ssh_keys(user_id);
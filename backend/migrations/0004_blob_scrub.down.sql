@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_file_hashes_corrupt;
+DROP INDEX IF EXISTS idx_file_hashes_last_verified_at;
+ALTER TABLE file_hashes DROP COLUMN IF EXISTS corruption;
+ALTER TABLE file_hashes DROP COLUMN IF EXISTS corrupt_at;
+ALTER TABLE file_hashes DROP COLUMN IF EXISTS last_verified_at;
@@ -0,0 +1,7 @@
+-- Track when the scrubber last re-hashed each blob and whether it matched
+ALTER TABLE file_hashes ADD COLUMN IF NOT EXISTS last_verified_at TIMESTAMP;
+ALTER TABLE file_hashes ADD COLUMN IF NOT EXISTS corrupt_at TIMESTAMP;
+ALTER TABLE file_hashes ADD COLUMN IF NOT EXISTS corruption TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_file_hashes_last_verified_at ON file_hashes(last_verified_at NULLS FIRST);
+CREATE INDEX IF NOT EXISTS idx_file_hashes_corrupt ON file_hashes(id) WHERE corrupt_at IS NOT NULL;
@@ -0,0 +1,10 @@
+-- Compressed blobs would be unreadable without the compression column
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM file_hashes WHERE compression <> 'none') THEN
+        RAISE EXCEPTION 'file_hashes holds compressed blobs; they must be decompressed before reverting';
+    END IF;
+END $$;
+
+ALTER TABLE file_hashes DROP COLUMN IF EXISTS stored_size;
+ALTER TABLE file_hashes DROP COLUMN IF EXISTS compression;
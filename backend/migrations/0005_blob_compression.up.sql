@@ -0,0 +1,6 @@
+-- Blobs may be stored compressed; file_size stays the size of the original
+-- content and stored_size is what the blob takes up
+ALTER TABLE file_hashes ADD COLUMN IF NOT EXISTS compression VARCHAR(16) NOT NULL DEFAULT 'none';
+ALTER TABLE file_hashes ADD COLUMN IF NOT EXISTS stored_size BIGINT;
+UPDATE file_hashes SET stored_size = file_size WHERE stored_size IS NULL;
+ALTER TABLE file_hashes ALTER COLUMN stored_size SET NOT NULL;
@@ -0,0 +1,11 @@
+-- Chunked blobs would be unreadable without their manifests
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM file_hashes WHERE chunked) THEN
+        RAISE EXCEPTION 'file_hashes holds chunked blobs; they must be reassembled before reverting';
+    END IF;
+END $$;
+
+ALTER TABLE file_hashes DROP COLUMN IF EXISTS chunked;
+DROP TABLE IF EXISTS blob_chunks;
+DROP TABLE IF EXISTS chunks;
@@ -0,0 +1,26 @@
+-- Content-defined chunks shared between blobs. ref_count is the number of
+-- blob_chunks rows pointing at a chunk; a chunk goes when it reaches zero.
+CREATE TABLE IF NOT EXISTS chunks (
+    id SERIAL PRIMARY KEY,
+    hash_sha256 VARCHAR(64) UNIQUE NOT NULL,
+    size INTEGER NOT NULL,
+    stored_size INTEGER NOT NULL,
+    compression VARCHAR(16) NOT NULL DEFAULT 'none',
+    data BYTEA NOT NULL,
+    ref_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- The manifest of a chunked blob: its chunks in order
+CREATE TABLE IF NOT EXISTS blob_chunks (
+    hash_id INTEGER NOT NULL REFERENCES file_hashes(id) ON DELETE CASCADE,
+    seq INTEGER NOT NULL,
+    chunk_id INTEGER NOT NULL REFERENCES chunks(id),
+    PRIMARY KEY (hash_id, seq)
+);
+
+CREATE INDEX IF NOT EXISTS idx_blob_chunks_chunk_id ON blob_chunks(chunk_id);
+
+-- Chunked blobs keep no file_data of their own
+ALTER TABLE file_hashes ADD COLUMN IF NOT EXISTS chunked BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE file_hashes ALTER COLUMN file_data DROP NOT NULL;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_file_hashes_unthumbnailed;
+ALTER TABLE file_hashes DROP COLUMN IF EXISTS thumbnail_error;
+ALTER TABLE file_hashes DROP COLUMN IF EXISTS thumbnailed_at;
+DROP TABLE IF EXISTS thumbnails;
@@ -0,0 +1,19 @@
+-- Cached previews, keyed by content hash so every file with the same content
+-- shares them, and deleted with the blob
+CREATE TABLE IF NOT EXISTS thumbnails (
+    hash_sha256 VARCHAR(64) NOT NULL REFERENCES file_hashes(hash_sha256) ON DELETE CASCADE,
+    size VARCHAR(16) NOT NULL,
+    mime_type VARCHAR(100) NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    data BYTEA NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (hash_sha256, size)
+);
+
+-- When the generator last tried a blob, and why it failed if it did, so
+-- content that can't be previewed isn't retried every batch
+ALTER TABLE file_hashes ADD COLUMN IF NOT EXISTS thumbnailed_at TIMESTAMP;
+ALTER TABLE file_hashes ADD COLUMN IF NOT EXISTS thumbnail_error TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_file_hashes_unthumbnailed ON file_hashes(id) WHERE thumbnailed_at IS NULL;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_file_hashes_unindexed;
+ALTER TABLE file_hashes DROP COLUMN IF EXISTS index_error;
+ALTER TABLE file_hashes DROP COLUMN IF EXISTS indexed_at;
+DROP TABLE IF EXISTS content_index;
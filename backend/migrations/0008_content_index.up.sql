@@ -0,0 +1,17 @@
+-- Text extracted from stored content for full-text search, one row per blob
+-- so deduplicated files share it
+CREATE TABLE IF NOT EXISTS content_index (
+    hash_id INTEGER PRIMARY KEY REFERENCES file_hashes(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    search_vector TSVECTOR NOT NULL,
+    indexed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_content_index_search_vector ON content_index USING GIN(search_vector);
+
+-- When the indexer last tried a blob, and why it failed if it did, so
+-- content without text isn't retried every batch
+ALTER TABLE file_hashes ADD COLUMN IF NOT EXISTS indexed_at TIMESTAMP;
+ALTER TABLE file_hashes ADD COLUMN IF NOT EXISTS index_error TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_file_hashes_unindexed ON file_hashes(id) WHERE indexed_at IS NULL;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_folders_saved_search_id;
+ALTER TABLE folders DROP COLUMN IF EXISTS saved_search_id;
+DROP TABLE IF EXISTS saved_searches;
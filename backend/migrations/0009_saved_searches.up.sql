@@ -0,0 +1,21 @@
+-- Named file searches, kept as the filters of a FileSearchRequest and run
+-- again whenever they are opened
+CREATE TABLE IF NOT EXISTS saved_searches (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    request JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, name)
+);
+
+DROP TRIGGER IF EXISTS update_saved_searches_updated_at ON saved_searches;
+CREATE TRIGGER update_saved_searches_updated_at BEFORE UPDATE ON saved_searches
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- A smart folder is a folder standing for a saved search, so it sits in the
+-- folder tree and is shared like any other folder but holds no files
+ALTER TABLE folders ADD COLUMN IF NOT EXISTS saved_search_id INTEGER REFERENCES saved_searches(id) ON DELETE CASCADE;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_folders_saved_search_id ON folders(saved_search_id) WHERE saved_search_id IS NOT NULL;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS metadata_schemas;
+DROP INDEX IF EXISTS idx_files_metadata;
+ALTER TABLE files DROP COLUMN IF EXISTS metadata;
@@ -0,0 +1,20 @@
+-- Custom metadata on files, such as a project code or instrument, as a JSON
+-- object of scalar values
+ALTER TABLE files ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS idx_files_metadata ON files USING GIN (metadata);
+
+-- The fields a folder's files must have in their metadata. fields is a JSON
+-- array of {name, type, required, enum}; unless additional is set, fields
+-- not in it are rejected.
+CREATE TABLE IF NOT EXISTS metadata_schemas (
+    folder_id INTEGER PRIMARY KEY REFERENCES folders(id) ON DELETE CASCADE,
+    fields JSONB NOT NULL DEFAULT '[]',
+    additional BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+DROP TRIGGER IF EXISTS update_metadata_schemas_updated_at ON metadata_schemas;
+CREATE TRIGGER update_metadata_schemas_updated_at BEFORE UPDATE ON metadata_schemas
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
@@ -0,0 +1,19 @@
+-- Batches of file and folder operations. Each batch is one row, which tracks
+-- a background batch's progress and stays as the record of what it changed.
+CREATE TABLE IF NOT EXISTS bulk_operations (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    operations JSONB NOT NULL,
+    atomic BOOLEAN NOT NULL DEFAULT FALSE,
+    status VARCHAR(20) NOT NULL DEFAULT 'running',
+    total INTEGER NOT NULL,
+    done INTEGER NOT NULL DEFAULT 0,
+    succeeded INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    results JSONB,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_bulk_operations_user_id ON bulk_operations(user_id, created_at DESC);
@@ -0,0 +1,103 @@
+// Package migrations holds the versioned database schema and applies it.
+//
+// Each migration is a pair of files named NNNN_description.up.sql and
+// NNNN_description.down.sql, embedded into the binary at build time. Applied
+// versions are recorded in schema_migrations together with a checksum of the
+// up script, so edits to a migration that has already run are detected
+// instead of silently diverging between environments.
+package migrations
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+//go:embed *.sql seed/*.sql
+var files embed.FS
+
+// Migration is one numbered schema change
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Load returns the embedded migrations ordered by version
+func Load() ([]Migration, error) {
+	return LoadFS(files)
+}
+
+// LoadFS reads migrations from the top level of fsys, ordered by version.
+// Every version needs an up script; the down script is optional.
+func LoadFS(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			if path.Ext(entry.Name()) == ".sql" {
+				return nil, fmt.Errorf("migration %s is not named NNNN_description.up.sql or .down.sql", entry.Name())
+			}
+			continue
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d is used by both %q and %q", version, m.Name, match[2])
+		}
+
+		data, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+			sum := sha256.Sum256(data)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// DevSeed returns the SQL that loads demo accounts for local development.
+// It is kept apart from the numbered migrations so production databases
+// never receive it.
+func DevSeed() string {
+	data, _ := files.ReadFile("seed/dev.sql")
+	return string(data)
+}
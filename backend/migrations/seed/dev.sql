@@ -0,0 +1,7 @@
+-- Demo accounts for local development only (testuser / test123, admin2 / admin123).
+-- Applied when SEED_DEV_DATA=true or with `vaultctl migrate -seed`; never in production.
+INSERT INTO users (username, email, password_hash, is_admin, storage_quota_mb)
+VALUES
+    ('testuser', 'testuser@filevault.com', '$2a$10$oGE2mo0kGCtngxrVErqeDue.DxR53URO2u.aoVZFL98nZBKRgN2ZO', false, 100),
+    ('admin2', 'admin2@filevault.com', '$2a$10$ZBi9dWZ7pt2mj8VmAYtEkOSF2bBmnFk1e221v/liHPPh.NeBgDH5e', true, 1000)
+ON CONFLICT (username) DO NOTHING;
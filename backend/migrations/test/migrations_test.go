@@ -0,0 +1,120 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/migrations"
+)
+
+func TestLoadFS(t *testing.T) {
+	t.Run("orders by version and pairs up and down scripts", func(t *testing.T) {
+		all, err := migrations.LoadFS(fstest.MapFS{
+			"0010_add_index.up.sql":   {Data: []byte("CREATE INDEX i ON t(c);")},
+			"0002_create_t.up.sql":    {Data: []byte("CREATE TABLE t (c INT);")},
+			"0002_create_t.down.sql":  {Data: []byte("DROP TABLE t;")},
+			"README.md":               {Data: []byte("ignored")},
+			"seed/dev.sql":            {Data: []byte("INSERT INTO t VALUES (1);")},
+			"0010_add_index.down.sql": {Data: []byte("DROP INDEX i;")},
+		})
+		require.NoError(t, err)
+		require.Len(t, all, 2)
+
+		assert.Equal(t, 2, all[0].Version)
+		assert.Equal(t, "create_t", all[0].Name)
+		assert.Equal(t, "DROP TABLE t;", all[0].Down)
+		assert.Len(t, all[0].Checksum, 64)
+		assert.Equal(t, 10, all[1].Version)
+	})
+
+	t.Run("rejects a down script without an up script", func(t *testing.T) {
+		_, err := migrations.LoadFS(fstest.MapFS{
+			"0001_create_t.down.sql": {Data: []byte("DROP TABLE t;")},
+		})
+		assert.ErrorContains(t, err, "no up script")
+	})
+
+	t.Run("rejects two names for one version", func(t *testing.T) {
+		_, err := migrations.LoadFS(fstest.MapFS{
+			"0001_create_t.up.sql": {Data: []byte("CREATE TABLE t (c INT);")},
+			"0001_create_u.up.sql": {Data: []byte("CREATE TABLE u (c INT);")},
+		})
+		assert.ErrorContains(t, err, "version 1")
+	})
+
+	t.Run("rejects badly named sql files", func(t *testing.T) {
+		_, err := migrations.LoadFS(fstest.MapFS{
+			"create_t.sql": {Data: []byte("CREATE TABLE t (c INT);")},
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestLoad_EmbeddedMigrations(t *testing.T) {
+	all, err := migrations.Load()
+	require.NoError(t, err)
+	require.NotEmpty(t, all)
+
+	for i, m := range all {
+		assert.Equal(t, i+1, m.Version, "migration versions must be contiguous")
+		assert.NotEmpty(t, m.Down, "migration %d_%s has no down script", m.Version, m.Name)
+	}
+	assert.NotContains(t, all[0].Up, "INSERT INTO users", "seed data belongs in seed/dev.sql")
+	assert.Contains(t, migrations.DevSeed(), "INSERT INTO users")
+}
+
+func TestRunner_Up(t *testing.T) {
+	all, err := migrations.LoadFS(fstest.MapFS{
+		"0001_create_t.up.sql": {Data: []byte("CREATE TABLE t (c INT);")},
+		"0002_create_u.up.sql": {Data: []byte("CREATE TABLE u (c INT);")},
+	})
+	require.NoError(t, err)
+
+	t.Run("applies only pending migrations under the advisory lock", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectExec("SELECT pg_advisory_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT version, name, checksum, applied_at FROM schema_migrations").
+			WillReturnRows(sqlmock.NewRows([]string{"version", "name", "checksum", "applied_at"}).
+				AddRow(1, "create_t", all[0].Checksum, time.Now()))
+		mock.ExpectBegin()
+		mock.ExpectExec("CREATE TABLE u").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO schema_migrations").
+			WithArgs(2, "create_u", all[1].Checksum).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		applied, err := migrations.NewRunner(db, all).Up(context.Background())
+		require.NoError(t, err)
+		require.Len(t, applied, 1)
+		assert.Equal(t, 2, applied[0].Version)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("refuses to run when an applied migration was modified", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectExec("SELECT pg_advisory_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT version, name, checksum, applied_at FROM schema_migrations").
+			WillReturnRows(sqlmock.NewRows([]string{"version", "name", "checksum", "applied_at"}).
+				AddRow(1, "create_t", "0000", time.Now()))
+		mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		_, err = migrations.NewRunner(db, all).Up(context.Background())
+		assert.ErrorContains(t, err, "modified after it was applied")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
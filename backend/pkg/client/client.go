@@ -0,0 +1,201 @@
+// Package client is a Go client for the File Vault REST API. It is used by
+// the vault CLI and can be embedded in other tools that need to talk to a
+// vault server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"filevault/internal/models"
+)
+
+// Types returned by the API, re-exported so callers outside this module can
+// name them
+type (
+	File   = models.File
+	Folder = models.Folder
+	User   = models.UserResponse
+)
+
+// APIError is returned for any non-2xx response
+type APIError struct {
+	StatusCode int
+	Message    string
+	Details    []string
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+	if len(e.Details) > 0 {
+		msg += " (" + strings.Join(e.Details, "; ") + ")"
+	}
+	return msg
+}
+
+// IsNotFound reports whether err is an API error with status 404
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client
+
+	// MaxRetries is how many times a rate limited (429) request is retried
+	MaxRetries int
+}
+
+// New returns a client for the server at baseURL, e.g. http://localhost:8081
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 10 * time.Minute},
+		MaxRetries: 5,
+	}
+}
+
+// request describes one API call. body is a function so the request can be
+// rebuilt when it has to be retried.
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	body        func() (io.Reader, error)
+	contentType string
+}
+
+func jsonBody(v interface{}) (func() (io.Reader, error), error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return func() (io.Reader, error) { return bytes.NewReader(data), nil }, nil
+}
+
+// send performs the request, retrying with backoff while the server answers
+// 429, and returns the response for any 2xx status
+func (c *Client) send(ctx context.Context, req request) (*http.Response, error) {
+	target := c.BaseURL + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+
+	backoff := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		var body io.Reader
+		if req.body != nil {
+			var err error
+			if body, err = req.body(); err != nil {
+				return nil, err
+			}
+		}
+
+		httpReq, err := http.NewRequestWithContext(ctx, req.method, target, body)
+		if err != nil {
+			return nil, err
+		}
+		if req.contentType != "" {
+			httpReq.Header.Set("Content-Type", req.contentType)
+		}
+		if c.Token != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+c.Token)
+		}
+
+		resp, err := c.HTTPClient.Do(httpReq)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < c.MaxRetries {
+			resp.Body.Close()
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			backoff *= 2
+			continue
+		}
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			defer resp.Body.Close()
+			return nil, decodeError(resp)
+		}
+
+		return resp, nil
+	}
+}
+
+// do sends the request and decodes a JSON response into out, if given
+func (c *Client) do(ctx context.Context, req request, out interface{}) error {
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func decodeError(resp *http.Response) error {
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+
+	var body struct {
+		Error   string   `json:"error"`
+		Details []string `json:"details"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err := json.Unmarshal(data, &body); err == nil && body.Error != "" {
+		apiErr.Message = body.Error
+		apiErr.Details = body.Details
+	} else {
+		apiErr.Message = strings.TrimSpace(string(data))
+	}
+
+	return apiErr
+}
+
+type loginResponse struct {
+	Token string `json:"token"`
+	User  User   `json:"user"`
+}
+
+// Login authenticates and stores the returned token on the client
+func (c *Client) Login(ctx context.Context, username, password string) (*User, error) {
+	body, err := jsonBody(models.UserLoginRequest{Username: username, Password: password})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp loginResponse
+	err = c.do(ctx, request{method: http.MethodPost, path: "/api/auth/login", body: body, contentType: "application/json"}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Token = resp.Token
+	return &resp.User, nil
+}
+
+// Profile returns the logged in user, which also checks the token is valid
+func (c *Client) Profile(ctx context.Context) (*User, error) {
+	var user User
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/auth/profile"}, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
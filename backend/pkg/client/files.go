@@ -0,0 +1,277 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"filevault/internal/models"
+)
+
+// ErrNotSupported is returned for operations the server does not offer yet
+var ErrNotSupported = errors.New("not supported by server")
+
+// FileQuery filters a file listing; zero values are ignored
+type FileQuery struct {
+	Search   string
+	MimeType string
+	Tags     []string
+	FolderID *int
+	// Sort is a comma-separated list of sort keys such as "name" or "-size"
+	Sort   string
+	Cursor string
+	Page   int
+	Limit  int
+}
+
+func (q FileQuery) values() url.Values {
+	v := url.Values{}
+	if q.Search != "" {
+		v.Set("search", q.Search)
+	}
+	if q.MimeType != "" {
+		v.Set("mime_type", q.MimeType)
+	}
+	for _, tag := range q.Tags {
+		v.Add("tags", tag)
+	}
+	if q.FolderID != nil {
+		v.Set("folder_id", strconv.Itoa(*q.FolderID))
+	}
+	if q.Sort != "" {
+		v.Set("sort_by", q.Sort)
+	}
+	if q.Cursor != "" {
+		v.Set("cursor", q.Cursor)
+	}
+	if q.Page > 0 {
+		v.Set("page", strconv.Itoa(q.Page))
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return v
+}
+
+// FilePage is one page of a file listing
+type FilePage struct {
+	Files      []File `json:"files"`
+	Total      int    `json:"total"`
+	HasMore    bool   `json:"has_more"`
+	NextCursor string `json:"next_cursor"`
+}
+
+// ListFiles returns one page of the user's files
+func (c *Client) ListFiles(ctx context.Context, q FileQuery) ([]File, error) {
+	page, err := c.ListFilesPage(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return page.Files, nil
+}
+
+// ListFilesPage returns one page of the user's files with the total number
+// of matches and the cursor of the next page
+func (c *Client) ListFilesPage(ctx context.Context, q FileQuery) (*FilePage, error) {
+	var page FilePage
+	err := c.do(ctx, request{method: http.MethodGet, path: "/api/files", query: q.values()}, &page)
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// ListAllFiles follows pages until every file matching q has been fetched.
+// Pages are followed by cursor, so files added or deleted meanwhile don't
+// shift later pages.
+func (c *Client) ListAllFiles(ctx context.Context, q FileQuery) ([]File, error) {
+	if q.Limit <= 0 {
+		q.Limit = 100
+	}
+
+	var all []File
+	for {
+		page, err := c.ListFilesPage(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page.Files...)
+		if !page.HasMore || page.NextCursor == "" {
+			return all, nil
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+func (c *Client) GetFile(ctx context.Context, fileID int) (*File, error) {
+	var file File
+	err := c.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/api/files/%d", fileID)}, &file)
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// UploadOptions control where an upload lands and how it is shared
+type UploadOptions struct {
+	FolderID *int
+	IsPublic bool
+	Tags     []string
+}
+
+// UploadFile uploads a local file under its base name
+func (c *Client) UploadFile(ctx context.Context, localPath string, opts UploadOptions) (*File, error) {
+	name := filepath.Base(localPath)
+	return c.Upload(ctx, name, func() (io.ReadCloser, error) { return os.Open(localPath) }, opts)
+}
+
+// Upload streams the content returned by open as a file called name. open
+// may be called more than once if the request has to be retried.
+func (c *Client) Upload(ctx context.Context, name string, open func() (io.ReadCloser, error), opts UploadOptions) (*File, error) {
+	form := map[string][]string{}
+	if opts.FolderID != nil {
+		form["folder_id"] = []string{strconv.Itoa(*opts.FolderID)}
+	}
+	if opts.IsPublic {
+		form["is_public"] = []string{"true"}
+	}
+	if len(opts.Tags) > 0 {
+		form["tags"] = opts.Tags
+	}
+
+	boundary := multipart.NewWriter(nil).Boundary()
+	body := func() (io.Reader, error) {
+		content, err := open()
+		if err != nil {
+			return nil, err
+		}
+
+		pr, pw := io.Pipe()
+		go func() {
+			defer content.Close()
+			mw := multipart.NewWriter(pw)
+			mw.SetBoundary(boundary)
+			for field, values := range form {
+				for _, value := range values {
+					if err := mw.WriteField(field, value); err != nil {
+						pw.CloseWithError(err)
+						return
+					}
+				}
+			}
+			part, err := mw.CreateFormFile("files", name)
+			if err == nil {
+				_, err = io.Copy(part, content)
+			}
+			if err == nil {
+				err = mw.Close()
+			}
+			pw.CloseWithError(err)
+		}()
+		return pr, nil
+	}
+
+	var resp struct {
+		Files    []File   `json:"files"`
+		Warnings []string `json:"warnings"`
+	}
+	err := c.do(ctx, request{
+		method:      http.MethodPost,
+		path:        "/api/files/upload",
+		body:        body,
+		contentType: "multipart/form-data; boundary=" + boundary,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Files) == 0 {
+		return nil, &APIError{StatusCode: http.StatusBadRequest, Message: "upload returned no files", Details: resp.Warnings}
+	}
+
+	return &resp.Files[0], nil
+}
+
+// Download writes the content of a file to w
+func (c *Client) Download(ctx context.Context, fileID int, w io.Writer) error {
+	resp, err := c.send(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/api/files/%d/download", fileID)})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func (c *Client) DeleteFile(ctx context.Context, fileID int) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: fmt.Sprintf("/api/files/%d", fileID)}, nil)
+}
+
+// ShareFile sets whether a file is public and which users it is shared with
+func (c *Client) ShareFile(ctx context.Context, fileID int, isPublic bool, usernames []string) error {
+	body, err := jsonBody(map[string]interface{}{
+		"is_public":    isPublic,
+		"shared_users": usernames,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.do(ctx, request{
+		method:      http.MethodPut,
+		path:        fmt.Sprintf("/api/files/%d/share", fileID),
+		body:        body,
+		contentType: "application/json",
+	}, nil)
+}
+
+// MoveFile renames a file and places it in folderID, or outside any folder
+// when folderID is nil
+func (c *Client) MoveFile(ctx context.Context, fileID int, name string, folderID *int) error {
+	target := 0
+	if folderID != nil {
+		target = *folderID
+	}
+	body, err := jsonBody(models.UpdateFileRequest{DisplayName: &name, FolderID: &target})
+	if err != nil {
+		return err
+	}
+
+	return c.do(ctx, request{
+		method:      http.MethodPatch,
+		path:        fmt.Sprintf("/api/files/%d", fileID),
+		body:        body,
+		contentType: "application/json",
+	}, nil)
+}
+
+// CopyFile copies a file into folderID, or outside any folder when folderID
+// is nil, under name. The copy shares the original's content, so nothing is
+// uploaded.
+func (c *Client) CopyFile(ctx context.Context, fileID int, name string, folderID *int) (*File, error) {
+	body, err := jsonBody(models.CopyFileRequest{FolderID: folderID, DisplayName: name})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		File File `json:"file"`
+	}
+	err = c.do(ctx, request{
+		method:      http.MethodPost,
+		path:        fmt.Sprintf("/api/files/%d/copy", fileID),
+		body:        body,
+		contentType: "application/json",
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.File, nil
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"filevault/internal/models"
+)
+
+// ListFolders returns every folder the user owns; use parent IDs to build the tree
+func (c *Client) ListFolders(ctx context.Context) ([]Folder, error) {
+	var resp struct {
+		Folders []Folder `json:"folders"`
+	}
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/folders"}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Folders, nil
+}
+
+func (c *Client) CreateFolder(ctx context.Context, name string, parentID *int) (*Folder, error) {
+	body, err := jsonBody(models.CreateFolderRequest{Name: name, ParentID: parentID})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Folder Folder `json:"folder"`
+	}
+	err = c.do(ctx, request{method: http.MethodPost, path: "/api/folders", body: body, contentType: "application/json"}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Folder, nil
+}
+
+// MoveFolder renames a folder and places it under parentID, or at the top
+// level when parentID is nil
+func (c *Client) MoveFolder(ctx context.Context, folder *Folder, name string, parentID *int) (*Folder, error) {
+	isPublic := folder.IsPublic
+	body, err := jsonBody(models.UpdateFolderRequest{Name: &name, ParentID: parentID, IsPublic: &isPublic})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Folder Folder `json:"folder"`
+	}
+	err = c.do(ctx, request{
+		method:      http.MethodPut,
+		path:        fmt.Sprintf("/api/folders/%d", folder.ID),
+		body:        body,
+		contentType: "application/json",
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Folder, nil
+}
+
+// DeleteFolder removes an empty folder
+func (c *Client) DeleteFolder(ctx context.Context, folderID int) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: fmt.Sprintf("/api/folders/%d", folderID)}, nil)
+}
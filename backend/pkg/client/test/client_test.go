@@ -0,0 +1,136 @@
+package test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"filevault/pkg/client"
+)
+
+func TestClient_RetriesRateLimitedRequests(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			io.WriteString(w, `{"error":"Rate limit exceeded"}`)
+			return
+		}
+		assert.Equal(t, "Bearer token123", r.Header.Get("Authorization"))
+		io.WriteString(w, `{"id":1,"username":"testuser"}`)
+	}))
+	defer server.Close()
+
+	c := client.New(server.URL)
+	c.Token = "token123"
+
+	user, err := c.Profile(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "testuser", user.Username)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestClient_DecodesAPIErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"error":"File not found"}`)
+	}))
+	defer server.Close()
+
+	_, err := client.New(server.URL).GetFile(context.Background(), 42)
+	require.Error(t, err)
+	assert.True(t, client.IsNotFound(err))
+	assert.Contains(t, err.Error(), "File not found")
+}
+
+func TestClient_UploadFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+		assert.Equal(t, "7", r.FormValue("folder_id"))
+		assert.Equal(t, []string{"a", "b"}, r.MultipartForm.Value["tags"])
+
+		file, header, err := r.FormFile("files")
+		require.NoError(t, err)
+		data, _ := io.ReadAll(file)
+		assert.Equal(t, "report.txt", header.Filename)
+		assert.Equal(t, "hello", string(data))
+
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"files":[{"id":5,"display_name":"report.txt"}],"count":1}`)
+	}))
+	defer server.Close()
+
+	local := filepath.Join(t.TempDir(), "report.txt")
+	require.NoError(t, os.WriteFile(local, []byte("hello"), 0644))
+
+	folderID := 7
+	file, err := client.New(server.URL).UploadFile(context.Background(), local, client.UploadOptions{
+		FolderID: &folderID,
+		Tags:     []string{"a", "b"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 5, file.ID)
+}
+
+func TestClient_MoveFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPatch, r.Method)
+		assert.Equal(t, "/api/files/5", r.URL.Path)
+		body, _ := io.ReadAll(r.Body)
+		// No folder is the top level, which the API calls folder 0
+		assert.JSONEq(t, `{"display_name":"final.txt","folder_id":0}`, string(body))
+		io.WriteString(w, `{"message":"File updated successfully","file":{"id":5}}`)
+	}))
+	defer server.Close()
+
+	require.NoError(t, client.New(server.URL).MoveFile(context.Background(), 5, "final.txt", nil))
+}
+
+func TestClient_ListAllFilesFollowsCursors(t *testing.T) {
+	var cursors []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cursor := r.URL.Query().Get("cursor")
+		cursors = append(cursors, cursor)
+		assert.Empty(t, r.URL.Query().Get("page"))
+		if cursor == "" {
+			io.WriteString(w, `{"files":[{"id":1},{"id":2}],"total":3,"has_more":true,"next_cursor":"abc"}`)
+			return
+		}
+		io.WriteString(w, `{"files":[{"id":3}],"total":3,"has_more":false}`)
+	}))
+	defer server.Close()
+
+	files, err := client.New(server.URL).ListAllFiles(context.Background(), client.FileQuery{Limit: 2})
+	require.NoError(t, err)
+	assert.Len(t, files, 3)
+	assert.Equal(t, []string{"", "abc"}, cursors)
+}
+
+func TestTree_Lookup(t *testing.T) {
+	docs, reports := 1, 2
+	tree := client.NewTree([]client.Folder{
+		{ID: docs, Name: "docs"},
+		{ID: reports, Name: "reports", ParentID: &docs},
+		{ID: 3, Name: "photos"},
+	})
+
+	folderID, ok := tree.Lookup("/docs/reports/")
+	require.True(t, ok)
+	assert.Equal(t, reports, *folderID)
+	assert.Equal(t, "/docs/reports", tree.Path(folderID))
+
+	root, ok := tree.Lookup("/")
+	assert.True(t, ok)
+	assert.Nil(t, root)
+
+	_, ok = tree.Lookup("/docs/missing")
+	assert.False(t, ok)
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"path"
+	"strings"
+)
+
+// Tree indexes a user's folders by path so slash-separated remote paths like
+// /reports/2024 can be resolved to folder IDs
+type Tree struct {
+	folders  map[int]*Folder
+	children map[int][]*Folder // top-level folders are stored under 0
+}
+
+// Tree fetches the user's folders and indexes them
+func (c *Client) Tree(ctx context.Context) (*Tree, error) {
+	folders, err := c.ListFolders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewTree(folders), nil
+}
+
+func NewTree(folders []Folder) *Tree {
+	t := &Tree{folders: map[int]*Folder{}, children: map[int][]*Folder{}}
+	for i := range folders {
+		t.add(&folders[i])
+	}
+	return t
+}
+
+func (t *Tree) add(folder *Folder) {
+	t.folders[folder.ID] = folder
+	parent := 0
+	if folder.ParentID != nil {
+		parent = *folder.ParentID
+	}
+	t.children[parent] = append(t.children[parent], folder)
+}
+
+// SplitPath cleans a remote path and returns its segments; the root has none
+func SplitPath(p string) []string {
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+func (t *Tree) Folder(folderID int) *Folder {
+	return t.folders[folderID]
+}
+
+// Children returns the folders directly inside parentID, or the top-level
+// folders when parentID is nil
+func (t *Tree) Children(parentID *int) []*Folder {
+	if parentID == nil {
+		return t.children[0]
+	}
+	return t.children[*parentID]
+}
+
+// Child returns the folder called name inside parentID
+func (t *Tree) Child(parentID *int, name string) *Folder {
+	for _, folder := range t.Children(parentID) {
+		if folder.Name == name {
+			return folder
+		}
+	}
+	return nil
+}
+
+// Lookup resolves a remote path to a folder ID. The root resolves to nil;
+// ok is false when some segment does not exist.
+func (t *Tree) Lookup(p string) (folderID *int, ok bool) {
+	for _, name := range SplitPath(p) {
+		folder := t.Child(folderID, name)
+		if folder == nil {
+			return nil, false
+		}
+		id := folder.ID
+		folderID = &id
+	}
+	return folderID, true
+}
+
+// Path returns the remote path of a folder, or "/" for nil
+func (t *Tree) Path(folderID *int) string {
+	var names []string
+	for folderID != nil {
+		folder, ok := t.folders[*folderID]
+		if !ok {
+			break
+		}
+		names = append([]string{folder.Name}, names...)
+		folderID = folder.ParentID
+	}
+	return "/" + strings.Join(names, "/")
+}
+
+// MkdirAll creates any missing folders along p and returns the ID of the last
+// one. New folders are added to the tree.
+func (c *Client) MkdirAll(ctx context.Context, t *Tree, p string) (*int, error) {
+	var folderID *int
+	for _, name := range SplitPath(p) {
+		folder := t.Child(folderID, name)
+		if folder == nil {
+			created, err := c.CreateFolder(ctx, name, folderID)
+			if err != nil {
+				return nil, err
+			}
+			t.add(created)
+			folder = created
+		}
+		id := folder.ID
+		folderID = &id
+	}
+	return folderID, nil
+}
+
+// FolderFiles lists the files directly inside a folder, or the files outside
+// any folder when folderID is nil. Newer files come first.
+func (c *Client) FolderFiles(ctx context.Context, folderID *int) ([]File, error) {
+	files, err := c.ListAllFiles(ctx, FileQuery{FolderID: folderID})
+	if err != nil || folderID != nil {
+		return files, err
+	}
+
+	// The API has no filter for files outside folders
+	var root []File
+	for _, file := range files {
+		if file.FolderID == nil {
+			root = append(root, file)
+		}
+	}
+	return root, nil
+}
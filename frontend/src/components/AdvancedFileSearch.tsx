@@ -94,7 +94,9 @@ const AdvancedFileSearch: React.FC<AdvancedFileSearchProps> = ({
     { value: 'created_at', label: 'Upload Date' },
     { value: 'file_size', label: 'File Size' },
     { value: 'download_count', label: 'Downloads' },
-    { value: 'original_name', label: 'Name' }
+    { value: 'original_name', label: 'Name' },
+    { value: 'type', label: 'Type' },
+    { value: 'updated', label: 'Last Updated' }
   ];
 
   return (
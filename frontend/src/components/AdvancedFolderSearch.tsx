@@ -3,7 +3,7 @@ import { Search, Filter, X, Calendar, Folder, HardDrive } from 'lucide-react';
 
 interface FolderSearchRequest {
   search?: string;
-  sort_by?: 'name' | 'created_at' | 'file_count';
+  sort_by?: 'name' | 'created_at' | 'file_count' | 'size';
   sort_order?: 'asc' | 'desc';
   is_public?: boolean;
 }
@@ -51,7 +51,8 @@ const AdvancedFolderSearch: React.FC<AdvancedFolderSearchProps> = ({
   const sortOptions = [
     { value: 'name', label: 'Name' },
     { value: 'created_at', label: 'Creation Date' },
-    { value: 'file_count', label: 'File Count' }
+    { value: 'file_count', label: 'File Count' },
+    { value: 'size', label: 'Size' }
   ];
 
   return (
@@ -101,7 +102,7 @@ const AdvancedFolderSearch: React.FC<AdvancedFolderSearchProps> = ({
               </label>
               <select
                 value={searchRequest.sort_by || 'created_at'}
-                onChange={(e) => handleInputChange('sort_by', e.target.value as 'name' | 'created_at' | 'file_count')}
+                onChange={(e) => handleInputChange('sort_by', e.target.value as 'name' | 'created_at' | 'file_count' | 'size')}
                 className="w-full px-3 py-2 border border-gray-300 rounded-md focus:ring-2 focus:ring-blue-500 focus:border-transparent"
               >
                 {sortOptions.map(option => (
@@ -157,7 +157,15 @@ const FileList: React.FC<FileListProps> = ({ files, onDownload, onDelete, onPrev
                           </div>
                         </div>
                       </div>
-              
+
+                      {file.snippet && (
+                        <p
+                          className="mb-4 text-sm text-muted-foreground line-clamp-2 [&_mark]:bg-yellow-100 [&_mark]:text-foreground"
+                          // The server HTML-escapes snippets and only adds <mark> tags
+                          dangerouslySetInnerHTML={{ __html: file.snippet }}
+                        />
+                      )}
+
                       {file.tags && file.tags.length > 0 && (
                         <div className="flex flex-wrap gap-2 mb-4">
                           {file.tags.map((tag, index) => (
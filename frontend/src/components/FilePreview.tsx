@@ -17,33 +17,65 @@ interface FilePreviewProps {
   onClose: () => void;
 }
 
+// Types the server renders thumbnails for
+const THUMBNAIL_IMAGE_TYPES = ['image/jpeg', 'image/png', 'image/gif', 'image/webp'];
+const THUMBNAIL_TEXT_TYPES = ['text/', 'application/json', 'application/xml', 'application/javascript', 'application/x-ndjson', 'application/sql'];
+
 const FilePreview: React.FC<FilePreviewProps> = ({ file, onClose }) => {
   const [previewUrl, setPreviewUrl] = useState<string | null>(null);
   const [isLoading, setIsLoading] = useState(true);
   const [error, setError] = useState<string | null>(null);
   const [showPreview, setShowPreview] = useState(true);
 
+  const isImage = file.mime_type?.startsWith('image/');
+  const isPdf = file.mime_type === 'application/pdf';
+  const isText = !!file.mime_type && THUMBNAIL_TEXT_TYPES.some((prefix) => file.mime_type!.startsWith(prefix));
+  const hasThumbnail = !!file.mime_type && (THUMBNAIL_IMAGE_TYPES.includes(file.mime_type) || isText);
+  const canPreview = isImage || isPdf || isText;
+
   useEffect(() => {
+    let url: string | null = null;
+    let cancelled = false;
+
     const loadPreview = async () => {
       try {
         setIsLoading(true);
         setError(null);
 
-        // For images and PDFs, create a preview URL
-        if (file.mime_type?.startsWith('image/') || file.mime_type === 'application/pdf') {
+        let blob: Blob | null = null;
+        if (hasThumbnail) {
+          // Thumbnails are generated in the background; until then images
+          // fall back to the original
+          try {
+            const response = await fileAPI.getThumbnail(file.id, 'large');
+            blob = response.data;
+          } catch (err: any) {
+            if (err.response?.status !== 404) {
+              throw err;
+            }
+          }
+        }
+        if (!blob && (isImage || isPdf)) {
           const response = await fileAPI.downloadFile(file.id);
-          const blob = new Blob([response.data], { type: file.mime_type });
-          const url = URL.createObjectURL(blob);
+          blob = new Blob([response.data], { type: file.mime_type });
+        }
+
+        if (blob && !cancelled) {
+          url = URL.createObjectURL(blob);
           setPreviewUrl(url);
-        } else {
+        } else if (!cancelled) {
           // For other file types, we'll show a placeholder
           setPreviewUrl(null);
         }
       } catch (err: any) {
-        setError('Failed to load preview');
+        if (!cancelled) {
+          setError('Failed to load preview');
+        }
         console.error('Preview error:', err);
       } finally {
-        setIsLoading(false);
+        if (!cancelled) {
+          setIsLoading(false);
+        }
       }
     };
 
@@ -51,11 +83,12 @@ const FilePreview: React.FC<FilePreviewProps> = ({ file, onClose }) => {
 
     // Cleanup
     return () => {
-      if (previewUrl) {
-        URL.revokeObjectURL(previewUrl);
+      cancelled = true;
+      if (url) {
+        URL.revokeObjectURL(url);
       }
     };
-  }, [file.id, file.mime_type, previewUrl]);
+  }, [file.id, file.mime_type]);
 
   const getFileIcon = () => {
     if (file.mime_type?.startsWith('image/')) return <Image size={48} className="text-blue-500" />;
@@ -78,10 +111,6 @@ const FilePreview: React.FC<FilePreviewProps> = ({ file, onClose }) => {
     await downloadFile(file.id, file.original_name);
   };
 
-  const canPreview = file.mime_type?.startsWith('image/') || file.mime_type === 'application/pdf';
-  const isImage = file.mime_type?.startsWith('image/');
-  const isPdf = file.mime_type === 'application/pdf';
-
   return (
     <div className="fixed inset-0 bg-black/80 backdrop-blur-sm flex items-center justify-center z-50 p-4">
       <div className="bg-white rounded-xl shadow-2xl max-w-6xl max-h-[95vh] w-full overflow-hidden">
@@ -173,7 +202,7 @@ const FilePreview: React.FC<FilePreviewProps> = ({ file, onClose }) => {
                   <div>
                     <label className="text-sm font-medium text-gray-600">Preview</label>
                     <p className="text-sm text-gray-900">
-                      {isImage ? 'Image preview available' : 'PDF preview available'}
+                      {isImage ? 'Image preview available' : isPdf ? 'PDF preview available' : 'First page preview available'}
                     </p>
                   </div>
                 )}
@@ -210,7 +239,7 @@ const FilePreview: React.FC<FilePreviewProps> = ({ file, onClose }) => {
 
             {!isLoading && !error && showPreview && previewUrl && (
               <div className="h-full flex items-center justify-center">
-                {isImage ? (
+                {isImage || isText ? (
                   <img
                     src={previewUrl}
                     alt={file.original_name}
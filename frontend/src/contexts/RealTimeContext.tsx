@@ -47,6 +47,7 @@ export const RealTimeProvider: React.FC<RealTimeProviderProps> = ({ children })
   const handleRealTimeMessage = (message: any) => {
     switch (message.type) {
       case 'file_uploaded':
+      case 'file_copied':
         // Invalidate file queries to refresh the file list
         queryClient.invalidateQueries({ queryKey: ['files'] });
         queryClient.invalidateQueries({ queryKey: ['userStats'] });
@@ -69,6 +70,31 @@ export const RealTimeProvider: React.FC<RealTimeProviderProps> = ({ children })
         queryClient.invalidateQueries({ queryKey: ['fileStats'] });
         break;
 
+      case 'file_updated':
+        // A renamed or moved file shows elsewhere in the file list
+        queryClient.invalidateQueries({ queryKey: ['files'] });
+        break;
+
+      case 'tags_updated':
+        // Tags show on files and drive tag filters and facets
+        queryClient.invalidateQueries({ queryKey: ['files'] });
+        queryClient.invalidateQueries({ queryKey: ['tags'] });
+        break;
+
+      case 'bulk_progress':
+        queryClient.invalidateQueries({ queryKey: ['bulk', message.data?.job_id] });
+        break;
+
+      case 'bulk_completed':
+        // A batch may have changed any of the user's files and folders
+        queryClient.invalidateQueries({ queryKey: ['bulk'] });
+        queryClient.invalidateQueries({ queryKey: ['files'] });
+        queryClient.invalidateQueries({ queryKey: ['folders'] });
+        queryClient.invalidateQueries({ queryKey: ['tags'] });
+        queryClient.invalidateQueries({ queryKey: ['userStats'] });
+        queryClient.invalidateQueries({ queryKey: ['storageStats'] });
+        break;
+
       case 'folder_created':
       case 'folder_updated':
       case 'folder_deleted':
@@ -1,5 +1,5 @@
 import axios, { AxiosResponse } from 'axios';
-import { User, File, StorageStats, FileSearchRequest, FileUploadRequest, AuthResponse, Folder, FolderCreateRequest, FolderUpdateRequest, FolderStats } from '../types';
+import { User, File, StorageStats, FileSearchRequest, FacetValue, FileUploadRequest, AuthResponse, Folder, FolderCreateRequest, FolderUpdateRequest, FolderStats, SavedSearch, SavedSearchRequest, TagCount, FileMetadata, MetadataField, MetadataSchema, BulkOperation, BulkJob } from '../types';
 
 const API_BASE_URL = process.env.REACT_APP_API_URL || 'https://secure-file-vault-backend-6wqo.onrender.com';
 
@@ -75,7 +75,7 @@ export const fileAPI = {
     });
   },
 
-  getFiles: (searchRequest: FileSearchRequest = {}): Promise<AxiosResponse<{ files: File[]; total: number; page: number; limit: number }>> => {
+  getFiles: (searchRequest: FileSearchRequest = {}): Promise<AxiosResponse<{ files: File[]; total: number; total_pages: number; has_more: boolean; next_cursor?: string; page: number; limit: number; facets?: Record<string, FacetValue[]> }>> => {
     const params = new URLSearchParams();
     Object.entries(searchRequest).forEach(([key, value]) => {
       if (value !== undefined && value !== null && value !== '') {
@@ -98,9 +98,19 @@ export const fileAPI = {
   deleteFile: (fileId: number): Promise<AxiosResponse<{ message: string }>> =>
     api.delete(`/api/files/${fileId}`),
 
+  // folder_id 0 moves the file out of any folder
+  updateFile: (fileId: number, changes: { display_name?: string; folder_id?: number }): Promise<AxiosResponse<{ message: string; file: File }>> =>
+    api.patch(`/api/files/${fileId}`, changes),
+
+  copyFile: (fileId: number, target: { folder_id?: number; display_name?: string } = {}): Promise<AxiosResponse<{ message: string; file: File }>> =>
+    api.post(`/api/files/${fileId}/copy`, target),
+
   downloadFile: (fileId: number): Promise<AxiosResponse<Blob>> =>
     api.get(`/api/files/${fileId}/download`, { responseType: 'blob' }),
 
+  getThumbnail: (fileId: number, size: 'small' | 'medium' | 'large' = 'medium'): Promise<AxiosResponse<Blob>> =>
+    api.get(`/api/files/${fileId}/thumbnail`, { params: { size }, responseType: 'blob' }),
+
   getFileStats: (): Promise<AxiosResponse<{ total_files: number; total_downloads: number; total_size_bytes: number; files: File[] }>> =>
     api.get('/api/admin/files/stats'),
 
@@ -143,6 +153,72 @@ export const folderAPI = {
     api.put(`/api/folders/${folderId}/share`, { username, permission, is_public: isPublic }),
 };
 
+export const tagAPI = {
+  // Without a prefix every tag is listed, most used first
+  getTags: (prefix?: string, limit?: number): Promise<AxiosResponse<{ tags: TagCount[]; total: number }>> =>
+    api.get('/api/tags', { params: { prefix, limit } }),
+
+  updateFileTags: (fileId: number, add: string[], remove: string[] = []): Promise<AxiosResponse<{ file_id: number; tags: string[] }>> =>
+    api.patch(`/api/files/${fileId}/tags`, { add, remove }),
+
+  bulkUpdateTags: (fileIds: number[], add: string[], remove: string[] = []): Promise<AxiosResponse<{ message: string; files: number }>> =>
+    api.post('/api/tags/bulk', { file_ids: fileIds, add, remove }),
+
+  renameTag: (from: string, to: string): Promise<AxiosResponse<{ message: string; files: number }>> =>
+    api.post('/api/tags/rename', { from, to }),
+
+  mergeTags: (tags: string[], into: string): Promise<AxiosResponse<{ message: string; files: number }>> =>
+    api.post('/api/tags/merge', { tags, into }),
+
+  deleteTag: (tag: string): Promise<AxiosResponse<{ message: string; files: number }>> =>
+    api.delete('/api/tags', { params: { tag } }),
+};
+
+export const metadataAPI = {
+  getFileMetadata: (fileId: number): Promise<AxiosResponse<{ file_id: number; metadata: FileMetadata }>> =>
+    api.get(`/api/files/${fileId}/metadata`),
+
+  replaceFileMetadata: (fileId: number, metadata: FileMetadata): Promise<AxiosResponse<{ file_id: number; metadata: FileMetadata }>> =>
+    api.put(`/api/files/${fileId}/metadata`, metadata),
+
+  // A null value removes the field
+  updateFileMetadata: (fileId: number, changes: Record<string, string | number | boolean | null>): Promise<AxiosResponse<{ file_id: number; metadata: FileMetadata }>> =>
+    api.patch(`/api/files/${fileId}/metadata`, changes),
+
+  getFolderSchema: (folderId: number): Promise<AxiosResponse<MetadataSchema>> =>
+    api.get(`/api/folders/${folderId}/metadata-schema`),
+};
+
+export const bulkAPI = {
+  // Answers 200 with every result, or 202 for a batch running in the background
+  runBulk: (operations: BulkOperation[], options: { atomic?: boolean; async?: boolean } = {}): Promise<AxiosResponse<BulkJob>> =>
+    api.post('/api/bulk', { operations, ...options }),
+
+  getBulk: (jobId: number): Promise<AxiosResponse<BulkJob>> =>
+    api.get(`/api/bulk/${jobId}`),
+
+  getBulkList: (limit?: number): Promise<AxiosResponse<{ operations: BulkJob[]; total: number }>> =>
+    api.get('/api/bulk', { params: { limit } }),
+};
+
+export const savedSearchAPI = {
+  getSavedSearches: (): Promise<AxiosResponse<{ searches: SavedSearch[]; total: number }>> =>
+    api.get('/api/searches'),
+
+  createSavedSearch: (searchData: SavedSearchRequest & { name: string }): Promise<AxiosResponse<{ message: string; search: SavedSearch }>> =>
+    api.post('/api/searches', searchData),
+
+  updateSavedSearch: (searchId: number, searchData: SavedSearchRequest): Promise<AxiosResponse<{ message: string; search: SavedSearch }>> =>
+    api.put(`/api/searches/${searchId}`, searchData),
+
+  deleteSavedSearch: (searchId: number): Promise<AxiosResponse<{ message: string }>> =>
+    api.delete(`/api/searches/${searchId}`),
+
+  // Lists the files a saved search, or the smart folder for it, matches now
+  runSavedSearch: (searchId: number, params: Pick<FileSearchRequest, 'page' | 'limit' | 'cursor' | 'facets' | 'sort_by' | 'sort_order'> = {}): Promise<AxiosResponse<{ files: File[]; total: number; total_pages: number; has_more: boolean; next_cursor?: string; page: number; limit: number; facets?: Record<string, FacetValue[]> }>> =>
+    api.get(`/api/searches/${searchId}/files`, { params: { ...params, facets: params.facets?.join(',') } }),
+};
+
 export const adminAPI = {
   getAllFiles: (params: any): Promise<AxiosResponse<{ files: File[]; total: number; page: number; limit: number }>> =>
     api.get('/api/admin/files', { params }),
@@ -170,6 +246,15 @@ export const adminAPI = {
 
   getRecentActivity: (params: any): Promise<AxiosResponse<{ activity: any[] }>> =>
     api.get('/api/admin/activity', { params }),
+
+  setMetadataSchema: (folderId: number, fields: MetadataField[], additional = false): Promise<AxiosResponse<MetadataSchema>> =>
+    api.put(`/api/admin/folders/${folderId}/metadata-schema`, { fields, additional }),
+
+  deleteMetadataSchema: (folderId: number): Promise<AxiosResponse<{ message: string }>> =>
+    api.delete(`/api/admin/folders/${folderId}/metadata-schema`),
+
+  getBulkOperations: (params: { user_id?: number; limit?: number } = {}): Promise<AxiosResponse<{ operations: BulkJob[]; total: number }>> =>
+    api.get('/api/admin/bulk', { params }),
 };
 
 export default api;
\ No newline at end of file
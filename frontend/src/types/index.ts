@@ -25,8 +25,10 @@ export interface File {
   username?: string;
   folder_name?: string;
   tags?: string[];
+  metadata?: FileMetadata;
   reference_count?: number;
   is_duplicate?: boolean;
+  snippet?: string;
 }
 
 export interface Folder {
@@ -40,6 +42,7 @@ export interface Folder {
   file_count?: number;
   folder_size?: number;
   subfolder_count?: number;
+  saved_search_id?: number;
 }
 
 export interface FolderCreateRequest {
@@ -81,13 +84,121 @@ export interface FileSearchRequest {
   max_size?: string;
   start_date?: string;
   end_date?: string;
+  date_range?: string;
   tags?: string[];
   uploader?: string;
+  username?: string;
   page?: number;
   limit?: number;
   folder_id?: number | null;
   sort_by?: string;
   sort_order?: 'asc' | 'desc';
+  cursor?: string;
+  facets?: string[];
+  // Conditions such as "project=AX-12", "samples>=20" or "instrument~mass"
+  metadata?: string[];
+}
+
+export type FileMetadata = Record<string, string | number | boolean>;
+
+export interface MetadataField {
+  name: string;
+  type: 'string' | 'number' | 'integer' | 'boolean' | 'date';
+  required?: boolean;
+  enum?: (string | number | boolean)[];
+}
+
+export interface MetadataSchema {
+  folder_id: number;
+  fields: MetadataField[];
+  additional: boolean;
+  updated_at: string;
+}
+
+export type BulkAction = 'delete' | 'move' | 'tag' | 'share' | 'public' | 'private';
+
+export interface BulkOperation {
+  action: BulkAction;
+  file_ids?: number[];
+  folder_ids?: number[];
+  folder_id?: number; // move: 0 for outside any folder or the top level
+  add?: string[];
+  remove?: string[];
+  username?: string;
+  permission?: 'read' | 'write' | 'admin';
+}
+
+export interface BulkItemResult {
+  operation: number;
+  action: BulkAction;
+  type: 'file' | 'folder';
+  id: number;
+  status: 'ok' | 'failed' | 'rolled_back' | 'skipped';
+  error?: string;
+}
+
+export interface BulkJob {
+  id: number;
+  user_id: number;
+  username?: string;
+  operations: BulkOperation[];
+  atomic: boolean;
+  status: 'running' | 'completed' | 'partial' | 'failed';
+  total: number;
+  done: number;
+  succeeded: number;
+  failed: number;
+  error?: string;
+  results?: BulkItemResult[];
+  created_at: string;
+  finished_at?: string;
+}
+
+export interface TagCount {
+  tag: string;
+  count: number;
+}
+
+// The filters and sort a saved search keeps, as the API names them
+export interface SavedSearchFilters {
+  query?: string;
+  mime_type?: string;
+  min_size?: number;
+  max_size?: number;
+  start_date?: string;
+  end_date?: string;
+  date_range?: string;
+  tags?: string[];
+  uploader?: string;
+  username?: string;
+  folder_id?: number;
+  metadata?: string[];
+  sort_by?: string;
+  sort_order?: 'asc' | 'desc';
+}
+
+export interface SavedSearch {
+  id: number;
+  user_id: number;
+  name: string;
+  request: SavedSearchFilters;
+  folder_id?: number;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface SavedSearchRequest {
+  name?: string;
+  request?: SavedSearchFilters;
+  smart_folder?: boolean;
+  parent_id?: number;
+}
+
+export interface FacetValue {
+  value: string;
+  label?: string;
+  count: number;
+  filter?: Record<string, string>;
 }
 
 export interface FileUploadRequest {